@@ -6,12 +6,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/thesunnysky/codis/pkg/models"
 	"github.com/thesunnysky/codis/pkg/topom"
 	"github.com/thesunnysky/codis/pkg/utils"
+	"github.com/thesunnysky/codis/pkg/utils/bytesize"
 	"github.com/thesunnysky/codis/pkg/utils/log"
 	"github.com/thesunnysky/codis/pkg/utils/math2"
 )
@@ -75,6 +78,13 @@ func (t *cmdDashboard) Main(d map[string]interface{}) {
 	case d["--sentinel-resync"].(bool):
 		t.handleSentinelCommand(d)
 
+	case d["--acl-list"].(bool):
+		fallthrough
+	case d["--acl-update"] != nil:
+		fallthrough
+	case d["--acl-resync"].(bool):
+		t.handleACLCommand(d)
+
 	case d["--sync-action"].(bool):
 		t.handleSyncActionCommand(d)
 
@@ -670,6 +680,58 @@ func (t *cmdDashboard) handleSentinelCommand(d map[string]interface{}) {
 	}
 }
 
+func (t *cmdDashboard) handleACLCommand(d map[string]interface{}) {
+	c := t.newTopomClient()
+
+	switch {
+
+	case d["--acl-list"].(bool):
+
+		log.Debugf("call rpc acl to dashboard %s", t.addr)
+		acl, err := c.ACL()
+		if err != nil {
+			log.PanicErrorf(err, "call rpc acl to dashboard %s failed", t.addr)
+		}
+		log.Debugf("call rpc acl OK")
+
+		b, err := json.MarshalIndent(acl, "", "    ")
+		if err != nil {
+			log.PanicErrorf(err, "json marshal failed")
+		}
+		fmt.Println(string(b))
+
+	case d["--acl-update"] != nil:
+
+		b, err := ioutil.ReadFile(utils.ArgumentMust(d, "--acl-update"))
+		if err != nil {
+			log.PanicErrorf(err, "load acl from file failed")
+		}
+
+		acl := &models.ACL{}
+		if err := json.Unmarshal(b, acl); err != nil {
+			log.PanicErrorf(err, "decode acl from json failed")
+		}
+		if err := acl.Validate(); err != nil {
+			log.PanicErrorf(err, "invalid acl")
+		}
+
+		log.Debugf("call rpc update-acl to dashboard %s", t.addr)
+		if err := c.UpdateACL(acl); err != nil {
+			log.PanicErrorf(err, "call rpc update-acl to dashboard %s failed", t.addr)
+		}
+		log.Debugf("call rpc update-acl OK")
+
+	case d["--acl-resync"].(bool):
+
+		log.Debugf("call rpc resync-acl to dashboard %s", t.addr)
+		if err := c.ResyncACL(); err != nil {
+			log.PanicErrorf(err, "call rpc resync-acl to dashboard %s failed", t.addr)
+		}
+		log.Debugf("call rpc resync-acl OK")
+
+	}
+}
+
 func (t *cmdDashboard) handleSyncActionCommand(d map[string]interface{}) {
 	c := t.newTopomClient()
 
@@ -725,6 +787,16 @@ func (t *cmdDashboard) handleSlotActionCommand(d map[string]interface{}) {
 		}
 		log.Debugf("call rpc remove-slot-action OK")
 
+	case d["--rollback"].(bool):
+
+		sid := utils.ArgumentIntegerMust(d, "--sid")
+
+		log.Debugf("call rpc rollback-slot-action to dashboard %s", t.addr)
+		if err := c.SlotRollbackAction(sid); err != nil {
+			log.PanicErrorf(err, "call rpc rollback-slot-action to dashboard %s failed", t.addr)
+		}
+		log.Debugf("call rpc rollback-slot-action OK")
+
 	case d["--create-some"].(bool):
 
 		src := utils.ArgumentIntegerMust(d, "--gid-from")
@@ -769,10 +841,81 @@ func (t *cmdDashboard) handleSlotActionCommand(d map[string]interface{}) {
 		}
 		log.Debugf("call rpc slot-action-disabled OK")
 
+	case d["--verify"].(bool):
+
+		sid := utils.ArgumentIntegerMust(d, "--sid")
+
+		log.Debugf("call rpc verify-slot-action to dashboard %s", t.addr)
+		report, err := c.SlotActionVerify(sid)
+		if err != nil {
+			log.PanicErrorf(err, "call rpc verify-slot-action to dashboard %s failed", t.addr)
+		}
+		log.Debugf("call rpc verify-slot-action OK")
+
+		fmt.Printf("slot-[%04d] source = %s, target = %s, sampled = %d\n", report.Id, report.Source, report.Target, report.Sampled)
+		for _, x := range report.DBs {
+			fmt.Printf("db-[%d] source = %d, target = %d, expected = %d\n", x.DB, x.Source, x.Target, x.Expected)
+		}
+		for _, m := range report.Mismatches {
+			fmt.Println("mismatch:", m)
+		}
+		if report.OK() {
+			fmt.Println("OK")
+		}
+
+	case d["--resume"].(bool):
+
+		sid := utils.ArgumentIntegerMust(d, "--sid")
+		force := d["--force"].(bool)
+
+		log.Debugf("call rpc resume-slot-action to dashboard %s", t.addr)
+		if err := c.SlotActionResume(sid, force); err != nil {
+			log.PanicErrorf(err, "call rpc resume-slot-action to dashboard %s failed", t.addr)
+		}
+		log.Debugf("call rpc resume-slot-action OK")
+
+	case d["--progress"].(bool):
+
+		log.Debugf("call rpc stats to dashboard %s", t.addr)
+		s, err := c.Stats()
+		if err != nil {
+			log.PanicErrorf(err, "call rpc stats to dashboard %s failed", t.addr)
+		}
+		log.Debugf("call rpc stats OK")
+
+		printSlotActionProgress(s.SlotAction.Progress.Plan)
+
+	}
+}
+
+func formatETA(eta int64) string {
+	if eta < 0 {
+		return "unknown"
+	}
+	return (time.Duration(eta) * time.Second).String()
+}
+
+func printSlotActionProgress(plan *topom.PlanProgress) {
+	if plan == nil {
+		fmt.Println("no slot actions")
+		return
+	}
+	fmt.Printf("plan: slots = %d (finished = %d, migrating = %d, pending = %d, failed = %d), moved = %d keys/%s, remains = %d keys, rate = %.1f keys/s %s/s, eta = %s\n",
+		plan.Total, plan.Finished, plan.Migrating, plan.Pending, plan.Failed,
+		plan.Moved, bytesize.Int64(plan.Bytes).HumanString(), plan.Remains,
+		plan.Rate, bytesize.Int64(plan.BytesRate).HumanString(), formatETA(plan.ETA))
+	for _, x := range plan.Slots {
+		fmt.Printf("slot-[%04d] db = %d, moved = %d keys/%s, remains = %d keys, rate = %.1f keys/s %s/s, eta = %s\n",
+			x.Id, x.DB, x.Moved, bytesize.Int64(x.Bytes).HumanString(), x.Remains,
+			x.Rate, bytesize.Int64(x.BytesRate).HumanString(), formatETA(x.ETA))
 	}
 }
 
 func (t *cmdDashboard) handleSlotRebalance(d map[string]interface{}) {
+	if d["--by-maxmemory"].(bool) || d["--weights"] != nil {
+		t.handleSlotRebalanceWeighted(d)
+		return
+	}
 	c := t.newTopomClient()
 
 	confirm := d["--confirm"].(bool)
@@ -787,26 +930,80 @@ func (t *cmdDashboard) handleSlotRebalance(d map[string]interface{}) {
 	if len(plans) == 0 {
 		fmt.Println("nothing changes")
 	} else {
-		var slotIds = make([]int, 0, len(plans))
-		for sid := range plans {
-			slotIds = append(slotIds, sid)
-		}
-		sort.Ints(slotIds)
-
-		var gid, beg, end = -1, 0, -1
-		for _, sid := range slotIds {
-			if beg <= end {
-				if sid == end+1 && plans[sid] == gid {
-					end = sid
-					continue
-				}
-				fmt.Printf("[%04d,%04d] => %d\n", beg, end, gid)
-			}
-			beg, end, gid = sid, sid, plans[sid]
-		}
+		printRebalancePlans(plans)
+		fmt.Println("done")
+	}
+}
+
+func printRebalancePlans(plans map[int]int) {
+	var slotIds = make([]int, 0, len(plans))
+	for sid := range plans {
+		slotIds = append(slotIds, sid)
+	}
+	sort.Ints(slotIds)
+
+	var gid, beg, end = -1, 0, -1
+	for _, sid := range slotIds {
 		if beg <= end {
+			if sid == end+1 && plans[sid] == gid {
+				end = sid
+				continue
+			}
 			fmt.Printf("[%04d,%04d] => %d\n", beg, end, gid)
 		}
+		beg, end, gid = sid, sid, plans[sid]
+	}
+	if beg <= end {
+		fmt.Printf("[%04d,%04d] => %d\n", beg, end, gid)
+	}
+}
+
+func (t *cmdDashboard) handleSlotRebalanceWeighted(d map[string]interface{}) {
+	c := t.newTopomClient()
+
+	confirm := d["--confirm"].(bool)
+
+	opts := &topom.RebalanceOptions{Mode: topom.RebalanceByMaxMemory}
+	if d["--weights"] != nil {
+		b, err := ioutil.ReadFile(utils.ArgumentMust(d, "--weights"))
+		if err != nil {
+			log.PanicErrorf(err, "load weights from file failed")
+		}
+		opts.Mode = topom.RebalanceByCapacity
+		if err := json.Unmarshal(b, &opts.Weights); err != nil {
+			log.PanicErrorf(err, "decode weights from json failed")
+		}
+	}
+	if s, ok := utils.Argument(d, "--band"); ok {
+		band, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.PanicErrorf(err, "parse --band failed")
+		}
+		opts.Band = band
+	}
+	if err := opts.Validate(); err != nil {
+		log.PanicErrorf(err, "invalid rebalance options")
+	}
+
+	log.Debugf("call rpc slot-rebalance-weighted to dashboard %s", t.addr)
+	report, err := c.SlotsRebalanceWeighted(opts, confirm)
+	if err != nil {
+		log.PanicErrorf(err, "call rpc slot-rebalance-weighted to dashboard %s failed", t.addr)
+	}
+	log.Debugf("call rpc slot-rebalance-weighted OK")
+
+	if len(report.Plans) == 0 {
+		fmt.Println("nothing changes")
+	} else {
+		printRebalancePlans(report.Plans)
+	}
+	fmt.Printf("mode = %s, band = %.2f, unit = %s, moved = %d\n", report.Mode, report.Band, report.Unit, report.Moved)
+	for _, g := range report.Groups {
+		fmt.Printf("group-[%d] weight = %d, slots = %d => %d (+%d/-%d), size = %d => %d, target = %d, utilization = %.2f\n",
+			g.Id, g.Weight, g.Slots, g.ProjectedSlots, g.SlotsIn, g.SlotsOut,
+			g.Size, g.ProjectedSize, g.TargetSize, g.Utilization)
+	}
+	if len(report.Plans) != 0 {
 		fmt.Println("done")
 	}
 }
@@ -19,6 +19,12 @@ Usage:
 	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --fillslots=FILE [--locked]
 	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --reset-stats
 	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --forcegc
+	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --ratelimit-list
+	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --ratelimit-update=FILE
+	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --mirror-list
+	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --mirror-update=FILE
+	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --bigkeys-list
+	codis-admin [-v] --proxy=ADDR [--auth=AUTH]  --bigkeys-reset
 	codis-admin [-v] --dashboard=ADDR           [config|model|stats|slots|group|proxy]
 	codis-admin [-v] --dashboard=ADDR            --shutdown
 	codis-admin [-v] --dashboard=ADDR            --reload
@@ -44,14 +50,22 @@ Usage:
 	codis-admin [-v] --dashboard=ADDR            --sync-action    --remove --addr=ADDR
 	codis-admin [-v] --dashboard=ADDR            --slot-action    --create --sid=ID --gid=ID
 	codis-admin [-v] --dashboard=ADDR            --slot-action    --remove --sid=ID
+	codis-admin [-v] --dashboard=ADDR            --slot-action    --rollback --sid=ID
 	codis-admin [-v] --dashboard=ADDR            --slot-action    --create-some  --gid-from=ID --gid-to=ID --num-slots=N
 	codis-admin [-v] --dashboard=ADDR            --slot-action    --create-range --beg=ID --end=ID --gid=ID
 	codis-admin [-v] --dashboard=ADDR            --slot-action    --interval=VALUE
 	codis-admin [-v] --dashboard=ADDR            --slot-action    --disabled=VALUE
+	codis-admin [-v] --dashboard=ADDR            --slot-action    --progress
+	codis-admin [-v] --dashboard=ADDR            --slot-action    --verify --sid=ID
+	codis-admin [-v] --dashboard=ADDR            --slot-action    --resume --sid=ID [--force]
 	codis-admin [-v] --dashboard=ADDR            --rebalance     [--confirm]
+	codis-admin [-v] --dashboard=ADDR            --rebalance     (--by-maxmemory|--weights=FILE) [--band=RATIO] [--confirm]
 	codis-admin [-v] --dashboard=ADDR            --sentinel-add   --addr=ADDR
 	codis-admin [-v] --dashboard=ADDR            --sentinel-del   --addr=ADDR [--force]
 	codis-admin [-v] --dashboard=ADDR            --sentinel-resync
+	codis-admin [-v] --dashboard=ADDR            --acl-list
+	codis-admin [-v] --dashboard=ADDR            --acl-update=FILE
+	codis-admin [-v] --dashboard=ADDR            --acl-resync
 	codis-admin [-v] --remove-lock               --product=NAME (--zookeeper=ADDR [--zookeeper-auth=USR:PWD]|--etcd=ADDR [--etcd-auth=USR:PWD]|--filesystem=ROOT)
 	codis-admin [-v] --config-dump               --product=NAME (--zookeeper=ADDR [--zookeeper-auth=USR:PWD]|--etcd=ADDR [--etcd-auth=USR:PWD]|--filesystem=ROOT) [-1]
 	codis-admin [-v] --config-convert=FILE
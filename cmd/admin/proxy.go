@@ -39,6 +39,18 @@ func (t *cmdProxy) Main(d map[string]interface{}) {
 		t.handleResetStats(d)
 	case d["--forcegc"].(bool):
 		t.handleForceGC(d)
+	case d["--ratelimit-list"].(bool):
+		t.handleRateLimitList(d)
+	case d["--ratelimit-update"] != nil:
+		t.handleRateLimitUpdate(d)
+	case d["--mirror-list"].(bool):
+		t.handleMirrorList(d)
+	case d["--mirror-update"] != nil:
+		t.handleMirrorUpdate(d)
+	case d["--bigkeys-list"].(bool):
+		t.handleBigKeysList(d)
+	case d["--bigkeys-reset"].(bool):
+		t.handleBigKeysReset(d)
 	}
 }
 
@@ -187,6 +199,113 @@ func (t *cmdProxy) handleForceGC(d map[string]interface{}) {
 	log.Debugf("call rpc forcegc OK")
 }
 
+func (t *cmdProxy) handleRateLimitList(d map[string]interface{}) {
+	c := t.newProxyClient(true)
+
+	log.Debugf("call rpc ratelimit to proxy %s", t.addr)
+	limits, err := c.RateLimits()
+	if err != nil {
+		log.PanicErrorf(err, "call rpc ratelimit to proxy %s failed", t.addr)
+	}
+	log.Debugf("call rpc ratelimit OK")
+
+	b, err := json.MarshalIndent(limits, "", "    ")
+	if err != nil {
+		log.PanicErrorf(err, "json marshal failed")
+	}
+	fmt.Println(string(b))
+}
+
+func (t *cmdProxy) handleRateLimitUpdate(d map[string]interface{}) {
+	c := t.newProxyClient(true)
+
+	b, err := ioutil.ReadFile(utils.ArgumentMust(d, "--ratelimit-update"))
+	if err != nil {
+		log.PanicErrorf(err, "load rate limits from file failed")
+	}
+
+	limits := &proxy.RateLimits{}
+	if err := json.Unmarshal(b, limits); err != nil {
+		log.PanicErrorf(err, "decode rate limits from json failed")
+	}
+	if err := limits.Validate(); err != nil {
+		log.PanicErrorf(err, "invalid rate limits")
+	}
+
+	log.Debugf("call rpc setratelimit to proxy %s", t.addr)
+	if err := c.SetRateLimits(limits); err != nil {
+		log.PanicErrorf(err, "call rpc setratelimit to proxy %s failed", t.addr)
+	}
+	log.Debugf("call rpc setratelimit OK")
+}
+
+func (t *cmdProxy) handleMirrorList(d map[string]interface{}) {
+	c := t.newProxyClient(true)
+
+	log.Debugf("call rpc mirror to proxy %s", t.addr)
+	m, err := c.Mirror()
+	if err != nil {
+		log.PanicErrorf(err, "call rpc mirror to proxy %s failed", t.addr)
+	}
+	log.Debugf("call rpc mirror OK")
+
+	b, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		log.PanicErrorf(err, "json marshal failed")
+	}
+	fmt.Println(string(b))
+}
+
+func (t *cmdProxy) handleMirrorUpdate(d map[string]interface{}) {
+	c := t.newProxyClient(true)
+
+	b, err := ioutil.ReadFile(utils.ArgumentMust(d, "--mirror-update"))
+	if err != nil {
+		log.PanicErrorf(err, "load mirror from file failed")
+	}
+
+	m := &proxy.Mirror{}
+	if err := json.Unmarshal(b, m); err != nil {
+		log.PanicErrorf(err, "decode mirror from json failed")
+	}
+	if err := m.Validate(); err != nil {
+		log.PanicErrorf(err, "invalid mirror")
+	}
+
+	log.Debugf("call rpc setmirror to proxy %s", t.addr)
+	if err := c.SetMirror(m); err != nil {
+		log.PanicErrorf(err, "call rpc setmirror to proxy %s failed", t.addr)
+	}
+	log.Debugf("call rpc setmirror OK")
+}
+
+func (t *cmdProxy) handleBigKeysList(d map[string]interface{}) {
+	c := t.newProxyClient(true)
+
+	log.Debugf("call rpc bigkeys to proxy %s", t.addr)
+	events, err := c.BigKeys()
+	if err != nil {
+		log.PanicErrorf(err, "call rpc bigkeys to proxy %s failed", t.addr)
+	}
+	log.Debugf("call rpc bigkeys OK")
+
+	b, err := json.MarshalIndent(events, "", "    ")
+	if err != nil {
+		log.PanicErrorf(err, "json marshal failed")
+	}
+	fmt.Println(string(b))
+}
+
+func (t *cmdProxy) handleBigKeysReset(d map[string]interface{}) {
+	c := t.newProxyClient(true)
+
+	log.Debugf("call rpc resetbigkeys to proxy %s", t.addr)
+	if err := c.ResetBigKeys(); err != nil {
+		log.PanicErrorf(err, "call rpc resetbigkeys to proxy %s failed", t.addr)
+	}
+	log.Debugf("call rpc resetbigkeys OK")
+}
+
 func (t *cmdProxy) handleShutdown(d map[string]interface{}) {
 	c := t.newProxyClient(true)
 
@@ -597,6 +597,8 @@ dashboard.controller('MainCodisCtrl', ['$scope', '$http', '$uibModal', '$timeout
             $scope.chart_ops.series[0].data = [];
             $scope.slots_action_interval = "NA";
             $scope.slots_action_disabled = "NA";
+            $scope.slots_action_schedule = {};
+            $scope.slots_action_plan = null;
             $scope.slots_action_failed = false;
             $scope.slots_action_remain = 0;
             $scope.sentinel_servers = [];
@@ -670,6 +672,27 @@ dashboard.controller('MainCodisCtrl', ['$scope', '$http', '$uibModal', '$timeout
             $scope.slots_action_interval = codis_stats.slot_action.interval;
             $scope.slots_action_disabled = codis_stats.slot_action.disabled;
             $scope.slots_action_progress = codis_stats.slot_action.progress.status;
+            var plan = codis_stats.slot_action.progress.plan;
+            if (plan) {
+                $scope.slots_action_plan = {
+                    total: plan.total,
+                    finished: plan.finished,
+                    migrating: plan.migrating,
+                    failed: plan.failed,
+                    rate: Math.round(plan.rate) + " keys/s, " + humanSize(Math.round(plan.bytes_rate)) + "/s",
+                    eta: plan.eta < 0 ? "unknown" : plan.eta + "s",
+                };
+            } else {
+                $scope.slots_action_plan = null;
+            }
+            var schedule = codis_stats.slot_action.schedule || {};
+            $scope.slots_action_schedule = {
+                windows: schedule.windows,
+                in_window: schedule.in_window,
+                bandwidth: schedule.bandwidth ? humanSize(schedule.bandwidth) + "/s" : "unlimited",
+                migrated: humanSize(schedule.migrated || 0),
+                paused: schedule.paused,
+            };
             $scope.sentinel_servers = merge($scope.sentinel_servers, sentinel.servers);
             $scope.sentinel_out_of_sync = sentinel.out_of_sync;
 
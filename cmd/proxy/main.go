@@ -230,6 +230,18 @@ Options:
 		log.Warnf("[%p] proxy receive signal = '%v'", s, sig)
 	}()
 
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGHUP)
+
+		for range c {
+			log.Warnf("[%p] proxy receive signal = 'SIGHUP', reload tls certificates", s)
+			if err := s.ReloadTLS(); err != nil {
+				log.WarnErrorf(err, "[%p] reload tls certificates failed", s)
+			}
+		}
+	}()
+
 	switch {
 	case dashboard != "":
 		go AutoOnlineWithDashboard(s, dashboard)
@@ -0,0 +1,140 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+)
+
+//ACL中命令的分类，Allow/Deny中以'@'开头的规则表示一类命令，否则表示命令名
+const (
+	ACLCategoryAll   = "all"
+	ACLCategoryRead  = "read"
+	ACLCategoryWrite = "write"
+	ACLCategoryAdmin = "admin"
+)
+
+//default用户保留给session_auth使用，也就是AUTH <password>
+const ACLDefaultUser = "default"
+
+type ACL struct {
+	Users []*ACLUser `json:"users,omitempty"`
+}
+
+type ACLUser struct {
+	Name string `json:"name"`
+
+	//明文的密码只在更新的时候使用，dashboard保存之前替换成加盐的摘要，查询的时候两者都不返回
+	Password     string `json:"password,omitempty"`
+	PasswordHash string `json:"password_hash,omitempty"`
+
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	KeyPrefixes []string `json:"key_prefixes,omitempty"`
+
+	ReadOnly bool `json:"read_only"`
+}
+
+func (p *ACL) Encode() []byte {
+	return jsonEncode(p)
+}
+
+func (p *ACL) GetUser(name string) *ACLUser {
+	for _, u := range p.Users {
+		if u != nil && u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+//返回去掉了密码和摘要的副本
+func (p *ACL) Redact() *ACL {
+	var acl = &ACL{}
+	for _, u := range p.Users {
+		x := *u
+		x.Password, x.PasswordHash = "", ""
+		acl.Users = append(acl.Users, &x)
+	}
+	return acl
+}
+
+const aclPasswordHashPrefix = "sha256$"
+
+//摘要的格式为"sha256$<salt>$<sha256(salt+password)>"，salt和摘要都是十六进制
+func HashACLPassword(password string) string {
+	var salt = make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return hashACLPassword(hex.EncodeToString(salt), password)
+}
+
+func hashACLPassword(salt, password string) string {
+	var sum = sha256.Sum256([]byte(salt + password))
+	return aclPasswordHashPrefix + salt + "$" + hex.EncodeToString(sum[:])
+}
+
+func parseACLPasswordHash(hash string) (string, bool) {
+	if !strings.HasPrefix(hash, aclPasswordHashPrefix) {
+		return "", false
+	}
+	var p = strings.Split(hash[len(aclPasswordHashPrefix):], "$")
+	if len(p) != 2 || p[0] == "" || len(p[1]) != sha256.Size*2 {
+		return "", false
+	}
+	return p[0], true
+}
+
+func CheckACLPassword(hash, password string) bool {
+	salt, ok := parseACLPasswordHash(hash)
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(hashACLPassword(salt, password))) == 1
+}
+
+func (p *ACL) Validate() error {
+	var names = make(map[string]bool)
+	for _, u := range p.Users {
+		switch {
+		case u == nil:
+			return errors.New("invalid acl user = nil")
+		case u.Name == "" || strings.ContainsAny(u.Name, " \t\r\n"):
+			return errors.Errorf("invalid acl user name = '%s'", u.Name)
+		case u.Name == ACLDefaultUser:
+			return errors.Errorf("acl user name '%s' is reserved", u.Name)
+		case names[u.Name]:
+			return errors.Errorf("acl user-[%s] already exists", u.Name)
+		}
+		names[u.Name] = true
+
+		if u.PasswordHash != "" {
+			if _, ok := parseACLPasswordHash(u.PasswordHash); !ok {
+				return errors.Errorf("acl user-[%s] has invalid password hash", u.Name)
+			}
+		}
+
+		for _, rule := range append(append([]string{}, u.Allow...), u.Deny...) {
+			switch {
+			case rule == "":
+				return errors.Errorf("acl user-[%s] has empty rule", u.Name)
+			case strings.HasPrefix(rule, "@"):
+				switch strings.ToLower(rule[1:]) {
+				case ACLCategoryAll, ACLCategoryRead, ACLCategoryWrite, ACLCategoryAdmin:
+				default:
+					return errors.Errorf("acl user-[%s] has invalid category '%s'", u.Name, rule)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -11,4 +11,7 @@ const (
 	ActionMigrating = "migrating"
 	ActionFinished  = "finished"
 	ActionSyncing   = "syncing"
+
+	//slot迁移之后校验失败，保持迁移中的路由直到人工处理
+	ActionVerifyFailed = "verifying-failed"
 )
@@ -12,6 +12,8 @@ type Proxy struct {
 	ProtoType string `json:"proto_type"`
 	ProxyAddr string `json:"proxy_addr"`
 
+	ProxyTLSAddr string `json:"proxy_tls_addr,omitempty"`
+
 	JodisPath string `json:"jodis_path,omitempty"`
 
 	ProductName string `json:"product_name"`
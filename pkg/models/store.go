@@ -58,6 +58,10 @@ func SentinelPath(product string) string {
 	return filepath.Join(CodisDir, product, "sentinel")
 }
 
+func ACLPath(product string) string {
+	return filepath.Join(CodisDir, product, "acl")
+}
+
 func LoadTopom(client Client, product string, must bool) (*Topom, error) {
 	b, err := client.Read(LockPath(product), must)
 	if err != nil || b == nil {
@@ -115,6 +119,10 @@ func (s *Store) SentinelPath() string {
 	return SentinelPath(s.product)
 }
 
+func (s *Store) ACLPath() string {
+	return ACLPath(s.product)
+}
+
 func (s *Store) Acquire(topom *Topom) error {
 	return s.client.Create(s.LockPath(), topom.Encode())
 }
@@ -255,6 +263,22 @@ func (s *Store) UpdateSentinel(p *Sentinel) error {
 	return s.client.Update(s.SentinelPath(), p.Encode())
 }
 
+func (s *Store) LoadACL(must bool) (*ACL, error) {
+	b, err := s.client.Read(s.ACLPath(), must)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	p := &ACL{}
+	if err := jsonDecode(p, b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *Store) UpdateACL(p *ACL) error {
+	return s.client.Update(s.ACLPath(), p.Encode())
+}
+
 func ValidateProduct(name string) error {
 	if regexp.MustCompile(`^\w[\w\.\-]*$`).MatchString(name) {
 		return nil
@@ -0,0 +1,256 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+)
+
+//ACL的实现：
+//1. ACL规则保存在coordinator的/codis3/{product}/acl中，由dashboard推送给所有的proxy，不需要重启proxy；
+//2. 客户端通过AUTH <user> <password>或者HELLO ... AUTH <user> <password>登录，AUTH <password>仍然使用session_auth，
+//   相当于没有任何限制的default用户；
+//3. session只记录登录的用户名，每个请求都使用Router中最新的规则检查，用户被删除之后只能执行连接相关的命令；
+//4. 命令分为read/write/admin三类，用户可以配置允许/禁止的类别（@read）或者命令，只读模式只能执行read类的命令；
+//5. 配置了key前缀的用户，命令中所有的key都必须以其中一个前缀开头，KEYS/SCAN/SCRIPT等无法检查key的命令属于admin类；
+//   SORT的BY/GET/STORE以及GEORADIUS的STORE/STOREDIST中的key（或者pattern）同样需要检查；
+//6. 密码只保存加盐的摘要（见models.ACLUser），登录的时候用常数时间比较。
+
+type aclCategory uint
+
+const (
+	aclCategoryRead aclCategory = 1 << iota
+	aclCategoryWrite
+	aclCategoryAdmin
+
+	aclCategoryAll = aclCategoryRead | aclCategoryWrite | aclCategoryAdmin
+)
+
+//连接相关的命令，所有的用户都可以执行
+var aclConnCommands = map[string]bool{
+	"QUIT": true, "AUTH": true, "HELLO": true, "PING": true, "ECHO": true, "SELECT": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true, "UNWATCH": true,
+	"CODIS.CONSISTENCY": true,
+}
+
+var aclAdminCommands = map[string]bool{
+	"KEYS": true, "SCAN": true, "SCRIPT": true, "PFDEBUG": true, "PFSELFTEST": true,
+	"SLOTSINFO": true, "SLOTSSCAN": true, "SLOTSMAPPING": true, "SLOTSHASHKEY": true, "SLOTSRESTORE": true,
+	"SLOWLOG": true,
+}
+
+//会影响其他客户端的命令，虽然不修改数据也按照write类处理
+var aclWriteCommands = map[string]bool{
+	"PUBLISH": true,
+}
+
+//参数中没有key的命令，不需要检查key前缀
+var aclNoKeyCommands = map[string]bool{
+	"COMMAND": true, "INFO": true, "ROLE": true, "SLOWLOG": true,
+	"PUBLISH": true, "PUBSUB": true,
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "UNSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+}
+
+//连接相关、参数中没有key以及管理类的命令都不是针对某个key的访问，热点key统计和流量镜像会跳过这些命令
+func isKeylessCommand(opstr string) bool {
+	return aclConnCommands[opstr] || aclNoKeyCommands[opstr] || aclAdminCommands[opstr]
+}
+
+func getACLCategory(opstr string, flag OpFlag) aclCategory {
+	switch {
+	case aclAdminCommands[opstr]:
+		return aclCategoryAdmin
+	case aclWriteCommands[opstr] || !flag.IsReadOnly():
+		return aclCategoryWrite
+	default:
+		return aclCategoryRead
+	}
+}
+
+type aclRules struct {
+	categories aclCategory
+	commands   map[string]bool
+}
+
+func newACLRules(rules []string) aclRules {
+	var p = aclRules{commands: make(map[string]bool)}
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule, "@") {
+			p.commands[strings.ToUpper(rule)] = true
+			continue
+		}
+		switch strings.ToLower(rule[1:]) {
+		case models.ACLCategoryAll:
+			p.categories |= aclCategoryAll
+		case models.ACLCategoryRead:
+			p.categories |= aclCategoryRead
+		case models.ACLCategoryWrite:
+			p.categories |= aclCategoryWrite
+		case models.ACLCategoryAdmin:
+			p.categories |= aclCategoryAdmin
+		}
+	}
+	return p
+}
+
+func (p *aclRules) isEmpty() bool {
+	return p.categories == 0 && len(p.commands) == 0
+}
+
+func (p *aclRules) match(opstr string, category aclCategory) bool {
+	return (p.categories&category) != 0 || p.commands[opstr]
+}
+
+type aclUser struct {
+	name     string
+	password string //加盐的摘要
+
+	allow aclRules
+	deny  aclRules
+
+	prefixes [][]byte
+	readonly bool
+}
+
+func newACLUser(u *models.ACLUser) *aclUser {
+	p := &aclUser{
+		name: u.Name, password: u.PasswordHash,
+		allow: newACLRules(u.Allow),
+		deny:  newACLRules(u.Deny),
+
+		readonly: u.ReadOnly,
+	}
+	for _, prefix := range u.KeyPrefixes {
+		p.prefixes = append(p.prefixes, []byte(prefix))
+	}
+	return p
+}
+
+func (u *aclUser) isCommandAllowed(opstr string, flag OpFlag) bool {
+	if aclConnCommands[opstr] {
+		return true
+	}
+	var category = getACLCategory(opstr, flag)
+	switch {
+	case u.readonly && category != aclCategoryRead:
+		return false
+	case !u.allow.isEmpty() && !u.allow.match(opstr, category):
+		return false
+	case u.deny.match(opstr, category):
+		return false
+	}
+	return true
+}
+
+func (u *aclUser) isKeyAllowed(key []byte) bool {
+	if len(u.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range u.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//检查用户是否可以执行这个请求，返回nil表示允许执行
+func (u *aclUser) check(r *Request) *redis.Resp {
+	if !u.isCommandAllowed(r.OpStr, r.OpFlag) {
+		return redis.NewErrorf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(r.OpStr))
+	}
+	if len(u.prefixes) == 0 || aclConnCommands[r.OpStr] || aclNoKeyCommands[r.OpStr] {
+		return nil
+	}
+	for _, key := range append(getHashKeys(r.Multi, r.OpStr), getACLExtraKeys(r.Multi, r.OpStr)...) {
+		if !u.isKeyAllowed(key) {
+			return redis.NewErrorf("NOPERM this user has no permissions to access one of the keys used as arguments")
+		}
+	}
+	return nil
+}
+
+//getHashKeys只返回第一个key，这里返回选项中的其他key；SORT的BY/GET是pattern，
+//'*'只能出现在前缀之后，所以pattern以允许的前缀开头就可以保证展开之后的key也满足
+func getACLExtraKeys(multi []*redis.Resp, opstr string) [][]byte {
+	var options map[string]bool
+	var start int
+	switch opstr {
+	case "SORT":
+		options, start = map[string]bool{"BY": true, "GET": true, "STORE": true}, 2
+	case "GEORADIUS":
+		options, start = map[string]bool{"STORE": true, "STOREDIST": true}, 6
+	case "GEORADIUSBYMEMBER":
+		options, start = map[string]bool{"STORE": true, "STOREDIST": true}, 5
+	default:
+		return nil
+	}
+	var keys [][]byte
+	for i := start; i < len(multi)-1; i++ {
+		var opt = strings.ToUpper(string(multi[i].Value))
+		switch {
+		case opstr == "SORT" && opt == "LIMIT":
+			i += 2
+			continue
+		case !options[opt]:
+			continue
+		}
+		i++
+		var arg = multi[i].Value
+		switch {
+		case opt == "GET" && string(arg) == "#":
+		case opt == "BY" && strings.ToUpper(string(arg)) == "NOSORT":
+		default:
+			keys = append(keys, arg)
+		}
+	}
+	return keys
+}
+
+func (s *Router) SetACL(acl *models.ACL) {
+	var users = make(map[string]*aclUser, len(acl.Users))
+	for _, u := range acl.Users {
+		users[u.Name] = newACLUser(u)
+	}
+	s.acl.Lock()
+	defer s.acl.Unlock()
+	s.acl.users = users
+}
+
+func (s *Router) getACLUser(name string) *aclUser {
+	s.acl.RLock()
+	defer s.acl.RUnlock()
+	return s.acl.users[name]
+}
+
+//default用户使用session_auth认证，其他用户使用ACL中的密码认证
+func (s *Session) authenticate(d *Router, username, password string) bool {
+	if username == models.ACLDefaultUser {
+		if s.config.SessionAuth == "" {
+			return true
+		}
+		return subtle.ConstantTimeCompare([]byte(s.config.SessionAuth), []byte(password)) == 1
+	}
+	u := d.getACLUser(username)
+	return u != nil && models.CheckACLPassword(u.password, password)
+}
+
+func (s *Session) checkACL(r *Request, d *Router) *redis.Resp {
+	if s.user == "" {
+		return nil
+	}
+	u := d.getACLUser(s.user)
+	if u == nil {
+		if aclConnCommands[r.OpStr] {
+			return nil
+		}
+		return redis.NewErrorf("NOPERM user '%s' has been removed", s.user)
+	}
+	return u.check(r)
+}
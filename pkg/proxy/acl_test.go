@@ -0,0 +1,93 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func newACLRequest(args ...string) *Request {
+	var multi = make([]*redis.Resp, len(args))
+	for i, arg := range args {
+		multi[i] = redis.NewBulkBytes([]byte(arg))
+	}
+	opstr, flag, err := getOpInfo(multi)
+	assert.MustNoError(err)
+	return &Request{Multi: multi, OpStr: opstr, OpFlag: flag}
+}
+
+func TestACLCommands(t *testing.T) {
+	u := newACLUser(&models.ACLUser{Name: "u", Allow: []string{"@read", "set"}, Deny: []string{"hgetall"}})
+	assert.Must(u.check(newACLRequest("GET", "a")) == nil)
+	assert.Must(u.check(newACLRequest("set", "a", "b")) == nil)
+	assert.Must(u.check(newACLRequest("DEL", "a")) != nil)
+	assert.Must(u.check(newACLRequest("HGETALL", "a")) != nil)
+	assert.Must(u.check(newACLRequest("KEYS", "*")) != nil)
+	assert.Must(u.check(newACLRequest("PING")) == nil)
+
+	u = newACLUser(&models.ACLUser{Name: "u", Deny: []string{"@admin"}, ReadOnly: true})
+	assert.Must(u.check(newACLRequest("GET", "a")) == nil)
+	assert.Must(u.check(newACLRequest("SET", "a", "b")) != nil)
+	assert.Must(u.check(newACLRequest("SCRIPT", "FLUSH")) != nil)
+	assert.Must(u.check(newACLRequest("MULTI")) == nil)
+}
+
+func TestACLKeyPrefixes(t *testing.T) {
+	u := newACLUser(&models.ACLUser{Name: "u", KeyPrefixes: []string{"t1:", "t2:"}})
+	assert.Must(u.check(newACLRequest("GET", "t1:a")) == nil)
+	assert.Must(u.check(newACLRequest("GET", "t3:a")) != nil)
+	assert.Must(u.check(newACLRequest("MSET", "t1:a", "x", "t2:b", "y")) == nil)
+	assert.Must(u.check(newACLRequest("MSET", "t1:a", "t3:x", "t3:b", "y")) != nil)
+	assert.Must(u.check(newACLRequest("EVAL", "return 1", "1", "t1:a", "t3:argv")) == nil)
+	assert.Must(u.check(newACLRequest("EVAL", "return 1", "1", "t3:a")) != nil)
+	assert.Must(u.check(newACLRequest("PUBLISH", "ch", "msg")) == nil)
+
+	//PUBLISH属于write类
+	u = newACLUser(&models.ACLUser{Name: "u", ReadOnly: true})
+	assert.Must(u.check(newACLRequest("PUBLISH", "ch", "msg")) != nil)
+	assert.Must(u.check(newACLRequest("SUBSCRIBE", "ch")) == nil)
+}
+
+func TestACLValidate(t *testing.T) {
+	var acl = &models.ACL{Users: []*models.ACLUser{{Name: "u"}, {Name: "u"}}}
+	assert.Must(acl.Validate() != nil)
+	acl = &models.ACL{Users: []*models.ACLUser{{Name: models.ACLDefaultUser}}}
+	assert.Must(acl.Validate() != nil)
+	acl = &models.ACL{Users: []*models.ACLUser{{Name: "u", Allow: []string{"@unknown"}}}}
+	assert.Must(acl.Validate() != nil)
+	acl = &models.ACL{Users: []*models.ACLUser{{Name: "u", Allow: []string{"@READ", "get"}}}}
+	assert.MustNoError(acl.Validate())
+}
+
+func TestACLExtraKeys(t *testing.T) {
+	u := newACLUser(&models.ACLUser{Name: "u", KeyPrefixes: []string{"t1:"}})
+	assert.Must(u.check(newACLRequest("SORT", "t1:a", "LIMIT", "0", "10", "BY", "t1:w_*", "GET", "#", "GET", "t1:o_*->f")) == nil)
+	assert.Must(u.check(newACLRequest("SORT", "t1:a", "BY", "nosort", "STORE", "t1:b")) == nil)
+	assert.Must(u.check(newACLRequest("SORT", "t1:a", "BY", "t2:w_*")) != nil)
+	assert.Must(u.check(newACLRequest("SORT", "t1:a", "GET", "*")) != nil)
+	assert.Must(u.check(newACLRequest("SORT", "t1:a", "STORE", "t2:b")) != nil)
+
+	assert.Must(u.check(newACLRequest("GEORADIUS", "t1:a", "15", "37", "200", "km", "STORE", "t1:b")) == nil)
+	assert.Must(u.check(newACLRequest("GEORADIUS", "t1:a", "15", "37", "200", "km", "STOREDIST", "t2:b")) != nil)
+	assert.Must(u.check(newACLRequest("GEORADIUSBYMEMBER", "t1:a", "STORE", "200", "km")) == nil)
+	assert.Must(u.check(newACLRequest("GEORADIUSBYMEMBER", "t1:a", "m", "200", "km", "STORE", "t2:b")) != nil)
+}
+
+func TestACLPassword(t *testing.T) {
+	hash := models.HashACLPassword("secret")
+	assert.Must(hash != models.HashACLPassword("secret"))
+	assert.Must(models.CheckACLPassword(hash, "secret"))
+	assert.Must(!models.CheckACLPassword(hash, "Secret"))
+	assert.Must(!models.CheckACLPassword("secret", "secret"))
+
+	var acl = &models.ACL{Users: []*models.ACLUser{{Name: "u", PasswordHash: hash}}}
+	assert.MustNoError(acl.Validate())
+	assert.Must(acl.Redact().Users[0].PasswordHash == "" && acl.Users[0].PasswordHash == hash)
+	acl.Users[0].PasswordHash = "secret"
+	assert.Must(acl.Validate() != nil)
+}
@@ -20,7 +20,6 @@ import (
 	"fmt"
 	"net"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -29,6 +28,7 @@ import (
 	"github.com/thesunnysky/codis/pkg/utils/log"
 	"github.com/thesunnysky/codis/pkg/utils/math2"
 	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+	"github.com/thesunnysky/codis/pkg/utils/timesize"
 )
 
 const (
@@ -50,14 +50,41 @@ type BackendConn struct {
 
 	closed atomic2.Bool
 	config *Config
+	tls    *tlsConfigs
+	//超过proxy_max_response_bytes的返回记录在所属Router的大key日志中
+	bigkeys *bigKeyLogger
 
 	database int
+
+	//请求写入backend到收到返回的耗时
+	latency *histogram
+
+	//熔断器
+	breaker *circuitBreaker
+
+	//session独占的连接（MULTI/EXEC事务）不能透明地重连，否则backend上WATCH的状态会丢失；
+	//建立过的连接断开之后broken为true，之后所有的请求都返回EXECABORT
+	pinned bool
+	broken atomic2.Bool
+
+	//Abort之后需要立即断开正在使用的连接
+	aborted atomic2.Bool
+	sock    struct {
+		sync.Mutex
+		conn *redis.Conn
+	}
 }
 
 func NewBackendConn(addr string, database int, config *Config) *BackendConn {
+	return newBackendConn(addr, database, config, nil, nil, false)
+}
+
+func newBackendConn(addr string, database int, config *Config, tls *tlsConfigs, bigkeys *bigKeyLogger, pinned bool) *BackendConn {
 	bc := &BackendConn{
-		addr: addr, config: config, database: database,
+		addr: addr, config: config, tls: tls, bigkeys: bigkeys, database: database, pinned: pinned,
 	}
+	bc.latency = getBackendLatency(addr)
+	bc.breaker = getCircuitBreaker(addr, config)
 	bc.input = make(chan *Request, 1024)
 	bc.retry.delay = &DelayExp2{
 		Min: 50, Max: 5000,
@@ -76,14 +103,32 @@ func (bc *BackendConn) Addr() string {
 func (bc *BackendConn) Close() {
 	bc.stop.Do(func() {
 		close(bc.input)
+		putCircuitBreaker(bc.breaker)
+		putBackendLatency(bc.addr)
 	})
 	bc.closed.Set(true)
 }
 
+//关闭BackendConn并立即断开当前的连接，已经发送到backend的请求都会返回错误，
+//用于取消阻塞在backend上的请求（比如BLPOP）
+func (bc *BackendConn) Abort() {
+	bc.aborted.Set(true)
+	bc.Close()
+	bc.sock.Lock()
+	if c := bc.sock.conn; c != nil {
+		c.Close()
+	}
+	bc.sock.Unlock()
+}
+
 func (bc *BackendConn) IsConnected() bool {
 	return bc.state.Int64() == stateConnected
 }
 
+func (bc *BackendConn) IsBroken() bool {
+	return bc.broken.IsTrue()
+}
+
 //请求放入BackendConn等待处理。如果request的sync.WaitGroup不为空，就加一，然后判断加一之后的值，如果加一之后couter为0，
 //那么所有阻塞在counter上的goroutine都会得到释放
 //将请求直接存入到BackendConn的chan *Request中，等待后续被取出并进行处理。
@@ -126,16 +171,7 @@ func (bc *BackendConn) KeepAlive() bool {
 				case resp.IsError():
 					return fmt.Errorf("bad info resp: %s", resp.Value)
 				case resp.IsBulkBytes():
-					var info = make(map[string]string)
-					for _, line := range strings.Split(string(resp.Value), "\n") {
-						kv := strings.SplitN(line, ":", 2)
-						if len(kv) != 2 {
-							continue
-						}
-						if key := strings.TrimSpace(kv[0]); key != "" {
-							info[key] = strings.TrimSpace(kv[1])
-						}
-					}
+					var info = parseInfo(resp.Value)
 					if info["master_link_status"] == "down" {
 						return nil
 					}
@@ -172,17 +208,17 @@ func init() {
 
 func (bc *BackendConn) newBackendReader(round int, config *Config) (*redis.Conn, chan<- *Request, error) {
 	//创建与redis的conn
-	c, err := redis.DialTimeout(bc.addr, time.Second*5,
-		config.BackendRecvBufsize.AsInt(),
-		config.BackendSendBufsize.AsInt())
+	c, err := dialBackend(bc.addr, config, bc.tls)
 	if err != nil {
 		return nil, nil, err
 	}
 	c.ReaderTimeout = config.BackendRecvTimeout.Duration()
 	c.WriterTimeout = config.BackendSendTimeout.Duration()
 	c.SetKeepAlivePeriod(config.BackendKeepAlivePeriod.Duration())
+	c.MaxBytes = config.ProxyMaxResponseBytes.Int64()
+	c.MaxArrayLen = int64(config.ProxyMaxResponseElements)
 
-	if err := bc.verifyAuth(c, config.ProductAuth); err != nil {
+	if err := verifyAuth(c, config.ProductAuth); err != nil {
 		c.Close()
 		return nil, nil, err
 	}
@@ -200,7 +236,7 @@ func (bc *BackendConn) newBackendReader(round int, config *Config) (*redis.Conn,
 	return c, tasks, nil
 }
 
-func (bc *BackendConn) verifyAuth(c *redis.Conn, auth string) error {
+func verifyAuth(c *redis.Conn, auth string) error {
 	if auth == "" {
 		return nil
 	}
@@ -260,6 +296,14 @@ func (bc *BackendConn) selectDatabase(c *redis.Conn, database int) error {
 
 func (bc *BackendConn) setResponse(r *Request, resp *redis.Resp, err error) error {
 	r.Resp, r.Err = resp, err
+	r.RecvNano = time.Now().UnixNano()
+	switch err {
+	case ErrRequestIsBroken, ErrBackendConnAborted:
+	default:
+		var slow = bc.breaker != nil && r.SendNano != 0 && bc.breaker.timeout > 0 &&
+			r.RecvNano-r.SendNano >= bc.breaker.timeout
+		bc.breaker.Record(err != nil || slow, r.RecvNano)
+	}
 	//对应的是Request对应的slot的group，表明当前slot处理的request done了一个
 	if r.Group != nil {
 		r.Group.Done()
@@ -272,14 +316,23 @@ func (bc *BackendConn) setResponse(r *Request, resp *redis.Resp, err error) erro
 }
 
 var (
-	ErrBackendConnReset = errors.New("backend conn reset")
-	ErrRequestIsBroken  = errors.New("request is broken")
+	ErrBackendConnReset   = errors.New("backend conn reset")
+	ErrBackendConnAborted = errors.New("backend conn aborted")
+	ErrRequestIsBroken    = errors.New("request is broken")
 )
 
+var RespPinnedConnBroken = redis.NewErrorf("EXECABORT Transaction discarded because the connection to backend was lost")
+
 func (bc *BackendConn) run() {
 	log.Warnf("backend conn [%p] to %s, db-%d start service",
 		bc, bc.addr, bc.database)
 	for round := 0; bc.closed.IsFalse(); round++ {
+		if bc.broken.IsTrue() {
+			for r := range bc.input {
+				bc.setResponse(r, RespPinnedConnBroken, nil)
+			}
+			break
+		}
 		log.Warnf("backend conn [%p] to %s, db-%d round-[%d]",
 			bc, bc.addr, bc.database, round)
 		//启动BackendConn的loopWriter()
@@ -291,6 +344,14 @@ func (bc *BackendConn) run() {
 		bc, bc.addr, bc.database)
 }
 
+//独占的连接读取失败之后标记为broken，必须在返回错误之前设置，保证session看到错误的时候后面的请求不会再发出去
+func (bc *BackendConn) setFailure(r *Request, err error) error {
+	if bc.pinned {
+		bc.broken.Set(true)
+	}
+	return bc.setResponse(r, nil, fmt.Errorf("backend conn failure, %s", err))
+}
+
 var (
 	errRespMasterDown = []byte("MASTERDOWN")
 	errRespLoading    = []byte("LOADING")
@@ -309,13 +370,21 @@ func (bc *BackendConn) loopReader(tasks <-chan *Request, c *redis.Conn, round in
 	}()
 	//遍历tasks，此时的r是所有的请求
 	for r := range tasks {
+		for range r.Pipeline {
+			if _, err := c.Decode(); err != nil && !redis.IsLimitError(err) {
+				return bc.setFailure(r, err)
+			}
+		}
 		//从redis.Conn中解码得到处理结果,Decode()将获取的是所有conn处理的命令的请求结果（单条命令或者
 		//multi）,循环的调用c.Decode()方法将依次的取出它所处理的命令的结果
 		//? 如何保证在读取数据的时候所有命令都已经处理完成了呢？
 		resp, err := c.Decode()
+		if limit, ok := err.(*redis.LimitError); ok {
+			resp, err = bc.handleBigResponse(r, limit), nil
+		}
 		//error
 		if err != nil {
-			return bc.setResponse(r, nil, fmt.Errorf("backend conn failure, %s", err))
+			return bc.setFailure(r, err)
 		}
 		//error
 		if resp != nil && resp.IsError() {
@@ -332,6 +401,7 @@ func (bc *BackendConn) loopReader(tasks <-chan *Request, c *redis.Conn, round in
 				}
 			}
 		}
+		bc.latency.Record((time.Now().UnixNano() - r.SendNano) / 1e3)
 		//Set the "Response" of Request to Request.Resp
 		bc.setResponse(r, resp, nil)
 	}
@@ -381,8 +451,25 @@ func (bc *BackendConn) loopWriter(round int) (err error) {
 	}
 	defer close(tasks)
 
+	bc.sock.Lock()
+	if bc.aborted.IsTrue() {
+		c.Close()
+	}
+	bc.sock.conn = c
+	bc.sock.Unlock()
+
+	defer func() {
+		bc.sock.Lock()
+		bc.sock.conn = nil
+		bc.sock.Unlock()
+	}()
+
 	defer bc.state.Set(0)
 
+	if bc.pinned {
+		defer bc.broken.Set(true)
+	}
+
 	bc.state.Set(stateConnected)
 	bc.retry.fails = 0
 	bc.retry.delay.Reset()
@@ -397,6 +484,20 @@ func (bc *BackendConn) loopWriter(round int) (err error) {
 			bc.setResponse(r, nil, ErrRequestIsBroken)
 			continue
 		}
+		if bc.aborted.IsTrue() {
+			bc.setResponse(r, nil, ErrBackendConnAborted)
+			continue
+		}
+		if bc.broken.IsTrue() {
+			bc.setResponse(r, RespPinnedConnBroken, nil)
+			continue
+		}
+		r.SendNano, r.Backend = time.Now().UnixNano(), bc.addr
+		for _, multi := range r.Pipeline {
+			if err := p.EncodeMultiBulk(multi); err != nil {
+				return bc.setResponse(r, nil, fmt.Errorf("backend conn failure, %s", err))
+			}
+		}
 		//encode request,将所有的request一次性encode完
 		if err := p.EncodeMultiBulk(r.Multi); err != nil {
 			return bc.setResponse(r, nil, fmt.Errorf("backend conn failure, %s", err))
@@ -421,6 +522,10 @@ type sharedBackendConn struct {
 
 	//所属的pool
 	owner *sharedBackendConnPool
+	//和BackendConn共享的熔断器
+	breaker *circuitBreaker
+	//replica的健康状态和延迟，只有replica连接池中的才有
+	health *replicaHealth
 	//一个conn对一个database
 	conns [][]*BackendConn
 
@@ -440,13 +545,17 @@ func newSharedBackendConn(addr string, pool *sharedBackendConnPool) *sharedBacke
 		host: []byte(host), port: []byte(port),
 	}
 	s.owner = pool
+	s.breaker = getCircuitBreaker(addr, pool.config)
+	if pool.probe {
+		s.health = getReplicaHealth(addr, pool.config)
+	}
 	s.conns = make([][]*BackendConn, pool.config.BackendNumberDatabases)
 	//range用一个参数遍历二维切片，datebase是0到15
 	for database := range s.conns {
 		//len和cap都默认为1的一维切片
 		parallel := make([]*BackendConn, pool.parallel)
 		for i := range parallel {
-			parallel[i] = NewBackendConn(addr, database, pool.config)
+			parallel[i] = newBackendConn(addr, database, pool.config, pool.tls, pool.bigkeys, false)
 		}
 		s.conns[database] = parallel
 	}
@@ -485,6 +594,11 @@ func (s *sharedBackendConn) Release() {
 		}
 	}
 	delete(s.owner.pool, s.addr)
+
+	s.owner.closeBlocking(s.addr)
+
+	putCircuitBreaker(s.breaker)
+	putReplicaHealth(s.health)
 }
 
 func (s *sharedBackendConn) Retain() *sharedBackendConn {
@@ -503,6 +617,7 @@ func (s *sharedBackendConn) KeepAlive() {
 	if s == nil {
 		return
 	}
+	s.health.probe(s)
 	for _, parallel := range s.conns {
 		for _, bc := range parallel {
 			bc.KeepAlive()
@@ -540,12 +655,24 @@ func (s *sharedBackendConn) BackendConn(database int32, seed uint, must bool) *B
 
 //后端的共享连接池，保存了proxy到后端redis-server之间的Conn
 type sharedBackendConnPool struct {
-	config   *Config
+	config  *Config
+	tls     *tlsConfigs
+	bigkeys *bigKeyLogger
+
 	//对同一个addr的conn的副本数，对一个addr的conn数量不止1个
 	parallel int
 
 	//key:codis-server的addr， value:sharedBackendConn
 	pool map[string]*sharedBackendConn
+
+	//是否探测backend的延迟和复制状态，用于replica的选择
+	probe bool
+
+	//阻塞命令（BLPOP等）使用的独占连接，和共享的连接分开；归还之后按照addr缓存起来复用
+	blocking struct {
+		sync.Mutex
+		idle map[string][]*BackendConn
+	}
 }
 
 func newSharedBackendConnPool(config *Config, parallel int) *sharedBackendConnPool {
@@ -553,6 +680,7 @@ func newSharedBackendConnPool(config *Config, parallel int) *sharedBackendConnPo
 		config: config, parallel: math2.MaxInt(1, parallel),
 	}
 	p.pool = make(map[string]*sharedBackendConn)
+	p.blocking.idle = make(map[string][]*BackendConn)
 	return p
 }
 
@@ -560,6 +688,69 @@ func (p *sharedBackendConnPool) KeepAlive() {
 	for _, bc := range p.pool {
 		bc.KeepAlive()
 	}
+	p.blocking.Lock()
+	defer p.blocking.Unlock()
+	for addr, list := range p.blocking.idle {
+		if p.pool[addr] == nil {
+			p.closeBlockingLocked(addr)
+			continue
+		}
+		for _, bc := range list {
+			bc.KeepAlive()
+		}
+	}
+}
+
+const maxIdleBlockingConns = 16
+
+//从blocking pool中取一个连接到addr的独占BackendConn，没有空闲的就新建一个
+func (p *sharedBackendConnPool) GetBlocking(addr string, database int32) *BackendConn {
+	p.blocking.Lock()
+	defer p.blocking.Unlock()
+	var list = p.blocking.idle[addr]
+	for i := len(list) - 1; i >= 0; i-- {
+		if bc := list[i]; bc.database == int(database) {
+			p.blocking.idle[addr] = append(list[:i], list[i+1:]...)
+			return bc
+		}
+	}
+	//阻塞命令的返回时间取决于命令本身的timeout，不能使用backend_recv_timeout
+	config := *p.config
+	if config.BackendRecvTimeout != 0 {
+		config.BackendRecvTimeout += timesize.Duration(blockingRoundTimeout)
+	}
+	config.BackendMaxPipeline = 1
+	//阻塞命令的耗时不能反映backend的状态，不参与熔断
+	config.BackendBreakerErrorRatio = 0
+	return newBackendConn(addr, int(database), &config, p.tls, p.bigkeys, false)
+}
+
+//归还GetBlocking取得的BackendConn，必须在上面的请求都返回之后调用
+func (p *sharedBackendConnPool) PutBlocking(bc *BackendConn) {
+	if bc.closed.IsTrue() {
+		return
+	}
+	p.blocking.Lock()
+	defer p.blocking.Unlock()
+	var list = p.blocking.idle[bc.addr]
+	if len(list) >= maxIdleBlockingConns {
+		bc.Close()
+		return
+	}
+	p.blocking.idle[bc.addr] = append(list, bc)
+}
+
+func (p *sharedBackendConnPool) closeBlocking(addr string) {
+	p.blocking.Lock()
+	defer p.blocking.Unlock()
+	p.closeBlockingLocked(addr)
+}
+
+func (p *sharedBackendConnPool) closeBlockingLocked(addr string) {
+	for _, bc := range p.blocking.idle[addr] {
+		bc.Close()
+	}
+	delete(p.blocking.idle, addr)
 }
 
 func (p *sharedBackendConnPool) Get(addr string) *sharedBackendConn {
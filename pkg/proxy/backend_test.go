@@ -80,3 +80,133 @@ func TestBackend(t *testing.T) {
 		assert.Must(string(r.Resp.Value) == strconv.Itoa(i))
 	}
 }
+
+func TestBackendPipeline(t *testing.T) {
+	config := NewDefaultConfig()
+	config.BackendMaxPipeline = 0
+	config.BackendSendTimeout.Set(time.Second)
+	config.BackendRecvTimeout.Set(time.Minute)
+
+	conn, bc := newConnPair(config)
+	defer bc.Close()
+
+	r := &Request{Batch: &sync.WaitGroup{}}
+	r.Pipeline = [][]*redis.Resp{
+		{redis.NewBulkBytes([]byte("MULTI"))},
+		{redis.NewBulkBytes([]byte("INCR")), redis.NewBulkBytes([]byte("a"))},
+	}
+	r.Multi = []*redis.Resp{redis.NewBulkBytes([]byte("EXEC"))}
+
+	go func() {
+		defer conn.Close()
+		for i := 0; i < 3; i++ {
+			multi, err := conn.DecodeMultiBulk()
+			assert.MustNoError(err)
+			resp := redis.NewString(multi[0].Value)
+			assert.MustNoError(conn.Encode(resp, true))
+		}
+	}()
+
+	bc.PushBack(r)
+	r.Batch.Wait()
+	assert.MustNoError(r.Err)
+	assert.Must(r.Resp != nil && string(r.Resp.Value) == "EXEC")
+}
+
+func TestBackendAbort(t *testing.T) {
+	config := NewDefaultConfig()
+	config.BackendRecvTimeout.Set(time.Minute)
+
+	conn, bc := newConnPair(config)
+	defer conn.Close()
+
+	r := &Request{Batch: &sync.WaitGroup{}}
+	r.Multi = []*redis.Resp{
+		redis.NewBulkBytes([]byte("BLPOP")),
+		redis.NewBulkBytes([]byte("a")),
+		redis.NewBulkBytes([]byte("0")),
+	}
+	bc.PushBack(r)
+
+	_, err := conn.DecodeMultiBulk()
+	assert.MustNoError(err)
+
+	bc.Abort()
+	r.Batch.Wait()
+	assert.Must(r.Err != nil)
+
+	_, err = conn.Decode()
+	assert.Must(err != nil)
+}
+
+func TestBackendSlowBreaker(t *testing.T) {
+	config := NewDefaultConfig()
+	config.BackendRecvTimeout.Set(time.Minute)
+	config.BackendBreakerErrorRatio = 0.5
+	config.BackendBreakerTimeout.Set(time.Millisecond * 100)
+
+	conn, bc := newConnPair(config)
+	defer bc.Close()
+
+	go func() {
+		defer conn.Close()
+		for {
+			if _, err := conn.DecodeMultiBulk(); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond * 300)
+			assert.MustNoError(conn.Encode(redis.NewString([]byte("OK")), true))
+		}
+	}()
+
+	//一个很慢的请求（比如大key的DEL）不能打开熔断器
+	r := newTestRequest("DEL", "key")
+	r.Batch = &sync.WaitGroup{}
+	bc.PushBack(r)
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 100)
+		bc.KeepAlive()
+		assert.Must(!bc.breaker.IsOpen())
+	}
+	r.Batch.Wait()
+	assert.MustNoError(r.Err)
+	assert.Must(!bc.breaker.IsOpen() && bc.breaker.Allow())
+}
+
+func TestBackendPinnedBroken(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	defer l.Close()
+
+	config := NewDefaultConfig()
+	config.BackendRecvTimeout.Set(time.Minute)
+	bc := newBackendConn(l.Addr().String(), 0, config, nil, nil, true)
+	defer bc.Close()
+
+	c, err := l.Accept()
+	assert.MustNoError(err)
+	conn := redis.NewConn(c, 1024, 1024)
+
+	r := &Request{Batch: &sync.WaitGroup{}}
+	r.Multi = []*redis.Resp{redis.NewBulkBytes([]byte("WATCH")), redis.NewBulkBytes([]byte("a"))}
+	bc.PushBack(r)
+	_, err = conn.DecodeMultiBulk()
+	assert.MustNoError(err)
+	assert.MustNoError(conn.Encode(redis.NewString([]byte("OK")), true))
+	r.Batch.Wait()
+	assert.MustNoError(r.Err)
+
+	//连接断开的时候已经发出的请求返回错误，之后不会重连，后面的请求都返回EXECABORT
+	conn.Close()
+	r = &Request{Batch: &sync.WaitGroup{}}
+	r.Multi = []*redis.Resp{redis.NewBulkBytes([]byte("GET")), redis.NewBulkBytes([]byte("a"))}
+	bc.PushBack(r)
+	r.Batch.Wait()
+	assert.Must(r.Err != nil && bc.IsBroken())
+
+	r = &Request{Batch: &sync.WaitGroup{}}
+	r.Multi = []*redis.Resp{redis.NewBulkBytes([]byte("EXEC"))}
+	bc.PushBack(r)
+	r.Batch.Wait()
+	assert.Must(r.Err == nil && r.Resp == RespPinnedConnBroken)
+}
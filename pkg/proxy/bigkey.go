@@ -0,0 +1,138 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+)
+
+//大key保护的实现：
+//1. session和backend连接的decoder分别设置了请求和返回的大小限制，超过限制的内容在decoder中直接跳过，不会读到内存中；
+//2. 超过限制的请求直接返回错误，不会发送到backend；超过限制的返回被替换成错误返回给客户端，backend连接不受影响；
+//3. 每次超过限制都记录一个大key事件（命令、key、slot、大小），最近的proxy_bigkey_log_max_len个事件可以通过admin api查询；
+//4. 每个Router有自己的事件日志，由Router的session以及BackendConn记录。
+
+const bigKeyMaxString = 128
+
+const (
+	BigKeyRequest  = "request"
+	BigKeyResponse = "response"
+)
+
+type BigKeyEvent struct {
+	Id       int64  `json:"id"`
+	UnixTime int64  `json:"unixtime"`
+	Type     string `json:"type"`
+
+	Cmd  string `json:"cmd"`
+	Key  string `json:"key"`
+	Slot int    `json:"slot"`
+
+	Size  int64 `json:"size"`
+	Count int64 `json:"count"`
+
+	RemoteAddr  string `json:"remote_addr,omitempty"`
+	BackendAddr string `json:"backend_addr,omitempty"`
+}
+
+type bigKeyLogger struct {
+	mu sync.Mutex
+
+	maxLen int
+
+	id     int64
+	events []*BigKeyEvent
+	next   int
+}
+
+func (l *bigKeyLogger) push(e *BigKeyEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Id, l.id = l.id, l.id+1
+	if len(l.events) < l.maxLen {
+		l.events = append(l.events, e)
+	} else {
+		l.events[l.next] = e
+	}
+	l.next = (l.next + 1) % l.maxLen
+}
+
+//按照从新到旧的顺序返回所有的事件
+func (l *bigKeyLogger) Get() []*BigKeyEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events = make([]*BigKeyEvent, 0, len(l.events))
+	for i := 1; i <= len(l.events); i++ {
+		events = append(events, l.events[(l.next-i+len(l.events))%len(l.events)])
+	}
+	return events
+}
+
+func (l *bigKeyLogger) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events, l.next = nil, 0
+}
+
+//没有配置的时候返回nil
+func newBigKeyLoggerFromConfig(config *Config) *bigKeyLogger {
+	if config.ProxyBigKeyLogMaxLen <= 0 {
+		return nil
+	}
+	return &bigKeyLogger{maxLen: config.ProxyBigKeyLogMaxLen}
+}
+
+var bigkeystats struct {
+	requests  atomic2.Int64
+	responses atomic2.Int64
+}
+
+func BigKeyRequests() int64 {
+	return bigkeystats.requests.Int64()
+}
+
+func BigKeyResponses() int64 {
+	return bigkeystats.responses.Int64()
+}
+
+func recordBigKey(l *bigKeyLogger, e *BigKeyEvent, r *Request, limit *redis.LimitError) {
+	e.UnixTime = time.Now().Unix()
+	e.Cmd, e.Slot = r.OpStr, -1
+	e.Size, e.Count = limit.Size, limit.Count
+	if len(r.Multi) >= 2 {
+		var key = r.Multi[1].Value
+		if e.Type == BigKeyResponse {
+			key = getHashKey(r.Multi, r.OpStr)
+		}
+		if key != nil {
+			e.Slot = int(Hash(key) % MaxSlotNum)
+		}
+		if len(key) > bigKeyMaxString {
+			key = key[:bigKeyMaxString]
+		}
+		e.Key = string(key)
+	}
+	if l != nil {
+		l.push(e)
+	}
+}
+
+func (s *Session) handleBigRequest(r *Request, d *Router, limit *redis.LimitError) {
+	if len(r.Multi) != 0 {
+		r.OpStr, _, _ = getOpInfo(r.Multi)
+	}
+	bigkeystats.requests.Incr()
+	recordBigKey(d.bigkeys, &BigKeyEvent{Type: BigKeyRequest, RemoteAddr: s.Conn.RemoteAddr()}, r, limit)
+	r.Resp = redis.NewErrorf("ERR request is too large, size = %d, count = %d", limit.Size, limit.Count)
+}
+
+func (bc *BackendConn) handleBigResponse(r *Request, limit *redis.LimitError) *redis.Resp {
+	bigkeystats.responses.Incr()
+	recordBigKey(bc.bigkeys, &BigKeyEvent{Type: BigKeyResponse, BackendAddr: bc.addr}, r, limit)
+	return redis.NewErrorf("ERR response is too large, size = %d, count = %d", limit.Size, limit.Count)
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestBigKeyLog(t *testing.T) {
+	config := NewDefaultConfig()
+	config.ProxyBigKeyLogMaxLen = 2
+	d1 := NewRouter(config)
+	defer d1.Close()
+
+	config = NewDefaultConfig()
+	config.ProxyBigKeyLogMaxLen = 0
+	d2 := NewRouter(config)
+	defer d2.Close()
+	assert.Must(d2.bigkeys == nil)
+
+	//每个Router的事件记录在自己的日志中，只保留最新的proxy_bigkey_log_max_len个
+	var limit = &redis.LimitError{Size: 1024, Count: 3}
+	for _, key := range []string{"a", "b", "c"} {
+		r := newTestRequest("SET", key, "v")
+		recordBigKey(d1.bigkeys, &BigKeyEvent{Type: BigKeyRequest}, r, limit)
+		recordBigKey(d2.bigkeys, &BigKeyEvent{Type: BigKeyRequest}, r, limit)
+	}
+	events := d1.bigkeys.Get()
+	assert.Must(len(events) == 2 && events[0].Key == "c" && events[1].Key == "b")
+	assert.Must(events[0].Size == 1024 && events[0].Slot == int(Hash([]byte("c"))%MaxSlotNum))
+
+	d1.bigkeys.Reset()
+	assert.Must(len(d1.bigkeys.Get()) == 0)
+}
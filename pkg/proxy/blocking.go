@@ -0,0 +1,273 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+)
+
+//BLPOP/BRPOP/BRPOPLPUSH的实现：
+//1. 命令的keys必须属于同一个slot，session从Router的blocking pool中取一个连接到slot当前master的独占BackendConn，
+//   阻塞的命令不会占用共享的BackendConn，也就不会阻塞其他session的请求；
+//2. 命令被拆成若干个timeout不超过blockingRoundTimeout的命令依次执行，每一轮都重新按照slot转发，
+//   这样slot迁移或者主从切换之后，阻塞的命令最多在一轮之后就会转到新的backend上；
+//3. 总的timeout不会超过session_recv_timeout，保证在session因为读超时被关闭之前返回；session_recv_timeout
+//   不足一轮的时候不能阻塞，只执行一次非阻塞的LPOP/RPOP/RPOPLPUSH；
+//4. 阻塞的命令返回之前，session不会处理后面的请求，pipeline中的命令仍然按照顺序执行；
+//5. 客户端断开之后，session不会再发起新的一轮，等待正在执行的一轮返回，已经被BLPOP/BRPOP pop出来的元素重新push回
+//   原来的key（BRPOPLPUSH的元素已经在目标key中，不会丢失）；超过两轮的时间还没有返回的独占BackendConn会被Abort。
+//6. 分轮执行的代价：每一轮结束之后客户端在redis中重新排到等待队列的末尾，和其他阻塞在同一个key上的客户端之间
+//   不再保证先到先得（FIFO）；两轮之间的push要等到下一轮的命令到达backend才会被pop；slot迁移或者主从切换之后，
+//   push到新backend的元素最多要等正在执行的一轮（blockingRoundTimeout）超时之后才会被取到。
+
+const blockingRoundTimeout = time.Second
+
+var ErrBlockingCanceled = errors.New("blocking request canceled")
+
+type blocking struct {
+	mu     sync.Mutex
+	closed bool
+	conns  map[*BackendConn]bool
+
+	//正在执行的阻塞命令，只在loopReader中调用Add和Wait
+	wait sync.WaitGroup
+}
+
+func (b *blocking) add(bc *BackendConn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	if b.conns == nil {
+		b.conns = make(map[*BackendConn]bool)
+	}
+	b.conns[bc] = true
+	return true
+}
+
+func (b *blocking) remove(bc *BackendConn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.conns, bc)
+	return !b.closed
+}
+
+func (b *blocking) isClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *blocking) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	if len(b.conns) != 0 {
+		time.AfterFunc(blockingRoundTimeout*2, b.abort)
+	}
+}
+
+func (b *blocking) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for bc := range b.conns {
+		bc.Abort()
+	}
+	b.conns = nil
+}
+
+func (s *Session) handleRequestBlocking(r *Request, d *Router) error {
+	var nblks = len(r.Multi) - 1
+	switch {
+	case nblks < 2 || (r.OpStr == "BRPOPLPUSH" && nblks != 3):
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for '%s' command", r.OpStr)
+		return nil
+	}
+	switch timeout, err := redis.Btoi64(r.Multi[nblks].Value); {
+	case err != nil:
+		r.Resp = redis.NewErrorf("ERR timeout is not an integer or out of range")
+		return nil
+	case timeout < 0:
+		r.Resp = redis.NewErrorf("ERR timeout is negative")
+		return nil
+	default:
+		var keys = getHashKeys(r.Multi, r.OpStr)
+		var slot, ok = getHashSlot(keys)
+		if !ok {
+			r.Resp = RespCrossSlot
+			return nil
+		}
+		var deadline = s.blockingDeadline(time.Duration(timeout) * time.Second)
+
+		r.Batch.Add(1)
+		s.blocking.wait.Add(1)
+		go func() {
+			defer s.blocking.wait.Done()
+			defer r.Batch.Done()
+			r.Resp, r.Err = s.loopBlocking(r, d, slot, keys, deadline)
+		}()
+		return nil
+	}
+}
+
+func (s *Session) blockingDeadline(timeout time.Duration) time.Time {
+	var now = time.Now()
+	var deadline time.Time
+	if timeout != 0 {
+		deadline = now.Add(timeout)
+	}
+	if d := s.config.SessionRecvTimeout.Duration(); d != 0 {
+		//留出一轮的时间，不足一轮的时候deadline就是现在
+		limit := now
+		if d > blockingRoundTimeout {
+			limit = now.Add(d - blockingRoundTimeout)
+		}
+		if deadline.IsZero() || deadline.After(limit) {
+			deadline = limit
+		}
+	}
+	return deadline
+}
+
+func (s *Session) loopBlocking(r *Request, d *Router, slot int, keys [][]byte, deadline time.Time) (*redis.Resp, error) {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return s.forwardNonBlocking(r, d, slot, keys)
+	}
+	var multi = make([]*redis.Resp, len(r.Multi))
+	copy(multi, r.Multi)
+	for {
+		var round = blockingRoundTimeout
+		if !deadline.IsZero() {
+			if remain := deadline.Sub(time.Now()); remain < round {
+				round = remain
+			}
+		}
+		var secs = int64(round / time.Second)
+		if secs < 1 {
+			secs = 1
+		}
+		multi[len(multi)-1] = redis.NewBulkBytes([]byte(strconv.FormatInt(secs, 10)))
+
+		resp, err := s.forwardBlocking(newBlockingRequest(r, multi), d, slot, keys)
+		if err != nil {
+			return nil, err
+		}
+		if s.blocking.isClosed() {
+			s.restoreBlocking(r, resp, d)
+			return nil, ErrBlockingCanceled
+		}
+		if !isBlockingTimeout(resp) {
+			return resp, nil
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return resp, nil
+		}
+	}
+}
+
+func newBlockingRequest(r *Request, multi []*redis.Resp) *Request {
+	m := &Request{}
+	m.Multi = multi
+	m.Batch = &sync.WaitGroup{}
+	m.OpStr, m.OpFlag, _ = getOpInfo(multi)
+	m.Database = r.Database
+	m.UnixNano = r.UnixNano
+	m.Broken = r.Broken
+	return m
+}
+
+//不阻塞的版本，BLPOP/BRPOP按照顺序对每个key执行LPOP/RPOP，返回第一个pop出来的元素
+func (s *Session) forwardNonBlocking(r *Request, d *Router, slot int, keys [][]byte) (*redis.Resp, error) {
+	if r.OpStr == "BRPOPLPUSH" {
+		multi := []*redis.Resp{redis.NewBulkBytes([]byte("RPOPLPUSH")), r.Multi[1], r.Multi[2]}
+		return s.forwardBlocking(newBlockingRequest(r, multi), d, slot, keys)
+	}
+	var pop = []byte("LPOP")
+	if r.OpStr == "BRPOP" {
+		pop = []byte("RPOP")
+	}
+	for _, key := range r.Multi[1 : len(r.Multi)-1] {
+		multi := []*redis.Resp{redis.NewBulkBytes(pop), key}
+		resp, err := s.forwardBlocking(newBlockingRequest(r, multi), d, slot, [][]byte{key.Value})
+		switch {
+		case err != nil:
+			return nil, err
+		case resp.IsError():
+			return resp, nil
+		case resp.Value != nil:
+			return redis.NewArray([]*redis.Resp{key, resp}), nil
+		}
+	}
+	return redis.NewArray(nil), nil
+}
+
+//session关闭之后，把已经pop出来但是无法返回给客户端的元素push回原来的key
+func (s *Session) restoreBlocking(r *Request, resp *redis.Resp, d *Router) {
+	if r.OpStr == "BRPOPLPUSH" || resp == nil || !resp.IsArray() || len(resp.Array) != 2 {
+		return
+	}
+	var push = []byte("LPUSH")
+	if r.OpStr == "BRPOP" {
+		push = []byte("RPUSH")
+	}
+	m := newBlockingRequest(r, []*redis.Resp{redis.NewBulkBytes(push), resp.Array[0], resp.Array[1]})
+	if err := d.dispatch(m); err != nil {
+		log.WarnErrorf(err, "session [%p] restore %s failed", s, m.OpStr)
+		return
+	}
+	m.Batch.Wait()
+	switch {
+	case m.Err != nil:
+		log.WarnErrorf(m.Err, "session [%p] restore %s failed", s, m.OpStr)
+	case m.Resp != nil && m.Resp.IsError():
+		log.Warnf("session [%p] restore %s failed, %s", s, m.OpStr, m.Resp.Value)
+	}
+}
+
+func (s *Session) forwardBlocking(m *Request, d *Router, slot int, keys [][]byte) (*redis.Resp, error) {
+	bc, err := d.getBlockingConn(slot, m.Database)
+	if err != nil {
+		return nil, err
+	}
+	if !s.blocking.add(bc) {
+		bc.Close()
+		return nil, ErrBlockingCanceled
+	}
+	if err = d.dispatchPinned(m, slot, keys, bc); err == nil {
+		m.Batch.Wait()
+		err = m.Err
+	}
+	//被Abort或者出错的连接不能再放回pool中
+	if s.blocking.remove(bc) && err == nil {
+		d.putBlockingConn(bc)
+	} else {
+		bc.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.Resp, nil
+}
+
+func isBlockingTimeout(resp *redis.Resp) bool {
+	switch {
+	case resp == nil:
+		return false
+	case resp.IsArray():
+		return resp.Array == nil
+	case resp.IsBulkBytes():
+		return resp.Value == nil
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+	"github.com/thesunnysky/codis/pkg/utils/timesize"
+)
+
+//模拟codis-server的list，只支持BLPOP/LPOP/LPUSH，其他命令都返回OK
+type listServer struct {
+	sync.Mutex
+	l     net.Listener
+	lists map[string][]string
+}
+
+func newListServer() *listServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	s := &listServer{l: l, lists: make(map[string][]string)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(redis.NewConn(c, 1024, 1024))
+		}
+	}()
+	return s
+}
+
+func (s *listServer) Addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *listServer) Close() {
+	s.l.Close()
+}
+
+func (s *listServer) Push(key string, value string) {
+	s.Lock()
+	defer s.Unlock()
+	s.lists[key] = append([]string{value}, s.lists[key]...)
+}
+
+func (s *listServer) Len(key string) int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.lists[key])
+}
+
+func (s *listServer) pop(key string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	var list = s.lists[key]
+	if len(list) == 0 {
+		return "", false
+	}
+	s.lists[key] = list[1:]
+	return list[0], true
+}
+
+func (s *listServer) serve(c *redis.Conn) {
+	defer c.Close()
+	for {
+		resp, err := c.Decode()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, x := range resp.Array {
+			args = append(args, string(x.Value))
+		}
+		var reply = redis.NewString([]byte("OK"))
+		switch strings.ToUpper(args[0]) {
+		case "LPOP":
+			reply = redis.NewBulkBytes(nil)
+			if v, ok := s.pop(args[1]); ok {
+				reply = redis.NewBulkBytes([]byte(v))
+			}
+		case "LPUSH":
+			s.Push(args[1], args[2])
+			reply = redis.NewInt([]byte(strconv.Itoa(s.Len(args[1]))))
+		case "BLPOP":
+			secs, _ := strconv.Atoi(args[len(args)-1])
+			reply = redis.NewArray(nil)
+			for deadline := time.Now().Add(time.Duration(secs) * time.Second); time.Now().Before(deadline); {
+				if v, ok := s.pop(args[1]); ok {
+					reply = redis.NewArray([]*redis.Resp{
+						redis.NewBulkBytes([]byte(args[1])),
+						redis.NewBulkBytes([]byte(v)),
+					})
+					break
+				}
+				time.Sleep(time.Millisecond * 10)
+			}
+		}
+		if err := c.Encode(reply, true); err != nil {
+			return
+		}
+	}
+}
+
+func newBlockingSession(config *Config, addr string) (*Session, *Router, *Request, int) {
+	d := NewRouter(config)
+	r := newTestRequest("BLPOP", "list", "0")
+	r.Batch = &sync.WaitGroup{}
+	var slot = int(Hash([]byte("list")) % MaxSlotNum)
+	assert.MustNoError(d.FillSlot(&models.Slot{Id: slot, BackendAddr: addr}))
+	return &Session{config: config}, d, r, slot
+}
+
+func TestBlockingDeadline(t *testing.T) {
+	config := NewDefaultConfig()
+	s := &Session{config: config}
+
+	config.SessionRecvTimeout = timesize.Duration(time.Second * 10)
+	deadline := s.blockingDeadline(0)
+	assert.Must(deadline.After(time.Now().Add(time.Second * 8)))
+	deadline = s.blockingDeadline(time.Second * 2)
+	assert.Must(deadline.Before(time.Now().Add(time.Second * 2)))
+
+	//不足一轮的时候不能在过去
+	config.SessionRecvTimeout = timesize.Duration(time.Millisecond * 500)
+	deadline = s.blockingDeadline(0)
+	assert.Must(!deadline.Before(time.Now().Add(-time.Second)) && !deadline.After(time.Now()))
+
+	config.SessionRecvTimeout = timesize.Duration(0)
+	assert.Must(s.blockingDeadline(0).IsZero())
+}
+
+func TestBlockingNonBlocking(t *testing.T) {
+	server := newListServer()
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.SessionRecvTimeout = timesize.Duration(time.Millisecond * 500)
+	s, d, r, slot := newBlockingSession(config, server.Addr())
+	defer d.Close()
+
+	var start = time.Now()
+	resp, err := s.loopBlocking(r, d, slot, [][]byte{[]byte("list")}, s.blockingDeadline(0))
+	assert.MustNoError(err)
+	assert.Must(isBlockingTimeout(resp) && time.Since(start) < time.Second)
+
+	server.Push("list", "a")
+	resp, err = s.loopBlocking(r, d, slot, [][]byte{[]byte("list")}, s.blockingDeadline(0))
+	assert.MustNoError(err)
+	assert.Must(len(resp.Array) == 2 && string(resp.Array[1].Value) == "a")
+	assert.Must(server.Len("list") == 0)
+}
+
+func TestBlockingRestore(t *testing.T) {
+	server := newListServer()
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.SessionRecvTimeout = timesize.Duration(0)
+	s, d, r, slot := newBlockingSession(config, server.Addr())
+	defer d.Close()
+
+	var errs = make(chan error, 1)
+	go func() {
+		_, err := s.loopBlocking(r, d, slot, [][]byte{[]byte("list")}, time.Time{})
+		errs <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+
+	//session关闭之后正在执行的一轮pop出来的元素被重新push回去
+	s.blocking.Close()
+	server.Push("list", "a")
+	select {
+	case err := <-errs:
+		assert.Must(err == ErrBlockingCanceled)
+	case <-time.After(time.Second * 5):
+		assert.Must(false)
+	}
+	assert.Must(server.Len("list") == 1)
+}
@@ -0,0 +1,237 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+)
+
+//backend熔断的实现：
+//1. 每个backend地址一个熔断器，同一个地址的所有BackendConn共享（阻塞命令使用的独占连接除外）；
+//2. 在setResponse中统计请求的结果，连接失败、读写超时以及耗时超过backend_breaker_timeout的请求都算作失败，
+//   统计窗口内请求数不少于min_requests并且失败比例达到error_ratio的时候熔断器打开；
+//3. 单个请求慢不会直接打开熔断器，慢的EVAL、大key的DEL以及迁移中的SLOTSMGRTTAGONE都是正常的操作；
+//4. 熔断器打开的时候，请求直接返回错误，没有标记为master only的只读请求会尝试发往replica group；
+//5. 打开open_period之后进入半开状态，放过一个请求作为探测，成功就关闭熔断器，失败就重新打开。
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = []string{"closed", "open", "half-open"}
+
+const breakerBuckets = 10
+
+var ErrBackendCircuitOpen = errors.New("backend circuit breaker is open")
+
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	addr  string
+	state atomic2.Int64
+	//打开或者开始半开探测的时间
+	since int64
+
+	ratio       float64
+	minRequests int64
+	timeout     int64
+	openPeriod  int64
+	bucketNano  int64
+
+	buckets [breakerBuckets]struct {
+		epoch int64
+		total int64
+		fails int64
+	}
+
+	opens    atomic2.Int64
+	rejected atomic2.Int64
+
+	//引用这个熔断器的BackendConn和sharedBackendConn的数量，由breakers的锁保护
+	refs int
+}
+
+func newCircuitBreaker(addr string, config *Config) *circuitBreaker {
+	b := &circuitBreaker{addr: addr}
+	b.ratio = config.BackendBreakerErrorRatio
+	b.minRequests = int64(config.BackendBreakerMinRequests)
+	b.timeout = int64(config.BackendBreakerTimeout.Duration())
+	b.openPeriod = int64(config.BackendBreakerOpenPeriod.Duration())
+	b.bucketNano = int64(config.BackendBreakerWindow.Duration()) / breakerBuckets
+	if b.bucketNano <= 0 {
+		b.bucketNano = int64(time.Second)
+	}
+	return b
+}
+
+func (b *circuitBreaker) IsOpen() bool {
+	return b != nil && b.state.Int64() == breakerOpen
+}
+
+//检查是否可以向backend发送请求，半开状态下同时只放过一个探测请求
+func (b *circuitBreaker) Allow() bool {
+	if b == nil || b.state.Int64() == breakerClosed {
+		return true
+	}
+	var now = time.Now().UnixNano()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state.Int64() {
+	case breakerOpen:
+		if now-b.since < b.openPeriod {
+			b.rejected.Incr()
+			return false
+		}
+		b.state.Set(breakerHalfOpen)
+		b.since = now
+		log.Warnf("backend %s circuit breaker half-open", b.addr)
+	case breakerHalfOpen:
+		//探测请求可能被丢弃了，超时之后再放过一个
+		if b.timeout <= 0 || now-b.since < b.timeout {
+			b.rejected.Incr()
+			return false
+		}
+		b.since = now
+	}
+	return true
+}
+
+func (b *circuitBreaker) Record(fail bool, now int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state.Int64() {
+	case breakerOpen:
+		return
+	case breakerHalfOpen:
+		if fail {
+			b.open(now, "probe failed")
+		} else {
+			b.close()
+		}
+		return
+	}
+
+	var epoch = now / b.bucketNano
+	x := &b.buckets[epoch%breakerBuckets]
+	if x.epoch != epoch {
+		x.epoch, x.total, x.fails = epoch, 0, 0
+	}
+	x.total++
+	if !fail {
+		return
+	}
+	x.fails++
+
+	var total, fails int64
+	for i := range b.buckets {
+		if x := &b.buckets[i]; x.epoch > epoch-breakerBuckets {
+			total, fails = total+x.total, fails+x.fails
+		}
+	}
+	if total >= b.minRequests && float64(fails) >= float64(total)*b.ratio {
+		b.open(now, "too many failures")
+	}
+}
+
+func (b *circuitBreaker) open(now int64, reason string) {
+	b.state.Set(breakerOpen)
+	b.since = now
+	b.opens.Incr()
+	log.Warnf("backend %s circuit breaker open, caused by '%s'", b.addr, reason)
+}
+
+func (b *circuitBreaker) close() {
+	b.state.Set(breakerClosed)
+	for i := range b.buckets {
+		b.buckets[i].epoch = 0
+	}
+	log.Warnf("backend %s circuit breaker closed", b.addr)
+}
+
+var breakers struct {
+	sync.RWMutex
+
+	m map[string]*circuitBreaker
+}
+
+func init() {
+	breakers.m = make(map[string]*circuitBreaker)
+}
+
+//backend_breaker_error_ratio为0的时候不启用熔断
+func getCircuitBreaker(addr string, config *Config) *circuitBreaker {
+	if config.BackendBreakerErrorRatio <= 0 {
+		return nil
+	}
+	breakers.Lock()
+	defer breakers.Unlock()
+	b := breakers.m[addr]
+	if b == nil {
+		b = newCircuitBreaker(addr, config)
+		breakers.m[addr] = b
+	}
+	b.refs++
+	return b
+}
+
+//没有引用之后从breakers中删除，避免已经下线的backend一直留在统计中
+func putCircuitBreaker(b *circuitBreaker) {
+	if b == nil {
+		return
+	}
+	breakers.Lock()
+	defer breakers.Unlock()
+	if b.refs--; b.refs == 0 && breakers.m[b.addr] == b {
+		delete(breakers.m, b.addr)
+	}
+}
+
+type BreakerStats struct {
+	Addr     string `json:"addr"`
+	State    string `json:"state"`
+	Opens    int64  `json:"opens"`
+	Rejected int64  `json:"rejected"`
+}
+
+type sliceBreakerStats []*BreakerStats
+
+func (s sliceBreakerStats) Len() int {
+	return len(s)
+}
+
+func (s sliceBreakerStats) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s sliceBreakerStats) Less(i, j int) bool {
+	return s[i].Addr < s[j].Addr
+}
+
+func GetBreakerStatsAll() []*BreakerStats {
+	var all = make([]*BreakerStats, 0, 16)
+	breakers.RLock()
+	for addr, b := range breakers.m {
+		all = append(all, &BreakerStats{
+			Addr:     addr,
+			State:    breakerStateNames[b.state.Int64()],
+			Opens:    b.opens.Int64(),
+			Rejected: b.rejected.Int64(),
+		})
+	}
+	breakers.RUnlock()
+	sort.Sort(sliceBreakerStats(all))
+	return all
+}
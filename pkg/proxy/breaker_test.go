@@ -0,0 +1,94 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+	"github.com/thesunnysky/codis/pkg/utils/timesize"
+)
+
+func newTestBreaker() *circuitBreaker {
+	config := NewDefaultConfig()
+	config.BackendBreakerErrorRatio = 0.5
+	config.BackendBreakerMinRequests = 10
+	config.BackendBreakerWindow = timesize.Duration(time.Second * 10)
+	config.BackendBreakerTimeout = timesize.Duration(time.Second)
+	config.BackendBreakerOpenPeriod = timesize.Duration(time.Millisecond * 100)
+	return newCircuitBreaker("127.0.0.1:6379", config)
+}
+
+func TestCircuitBreakerOpen(t *testing.T) {
+	b := newTestBreaker()
+	var now = time.Now().UnixNano()
+	for i := 0; i < 4; i++ {
+		b.Record(true, now)
+		b.Record(false, now)
+	}
+	assert.Must(b.state.Int64() == breakerClosed)
+	b.Record(true, now)
+	assert.Must(b.state.Int64() == breakerClosed)
+	b.Record(true, now)
+	assert.Must(b.IsOpen() && !b.Allow())
+	assert.Must(b.rejected.Int64() == 1)
+
+	//窗口之外的失败不再统计
+	b = newTestBreaker()
+	for i := 0; i < 9; i++ {
+		b.Record(true, now)
+	}
+	now += int64(time.Second * 11)
+	b.Record(true, now)
+	assert.Must(b.state.Int64() == breakerClosed)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := newTestBreaker()
+	b.open(time.Now().UnixNano(), "test")
+	assert.Must(b.IsOpen() && !b.Allow())
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Must(b.Allow())
+	assert.Must(b.state.Int64() == breakerHalfOpen && !b.Allow())
+	b.Record(true, time.Now().UnixNano())
+	assert.Must(b.IsOpen())
+
+	time.Sleep(time.Millisecond * 100)
+	assert.Must(b.Allow())
+	b.Record(false, time.Now().UnixNano())
+	assert.Must(b.state.Int64() == breakerClosed && b.Allow())
+	assert.Must(b.opens.Int64() == 2)
+}
+
+func TestCircuitBreakerFallback(t *testing.T) {
+	master := newTestReplica("127.0.0.1:6379", 0)
+	master.health = nil
+	master.breaker = newTestBreaker()
+
+	replica := newTestReplica("127.0.0.1:6380", time.Millisecond)
+	s := &Slot{replicaGroups: [][]*sharedBackendConn{{replica}}, primaryOnly: true}
+	s.backend.bc = master
+
+	//backend_primary_only的时候只读请求只在熔断器打开之后才降级到replica
+	var d forwardHelper
+	r := &Request{}
+	bc, err := d.forward2(s, r)
+	assert.MustNoError(err)
+	assert.Must(bc.addr == master.addr)
+
+	master.breaker.open(time.Now().UnixNano(), "test")
+	assert.Must(master.breaker.IsOpen())
+	bc, err = d.forward2(s, r)
+	assert.MustNoError(err)
+	assert.Must(bc.addr == replica.addr)
+
+	//标记为master only的请求不能降级到replica
+	for _, flag := range []OpFlag{FlagMasterOnly, FlagWrite} {
+		r := &Request{OpFlag: flag}
+		_, err := d.forward2(s, r)
+		assert.Must(err == ErrBackendCircuitOpen)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+)
+
+//proxy读缓存的实现：
+//1. 只缓存匹配proxy_cache_key_prefixes的key的GET/HGET/HGETALL的返回，同一个key的所有返回放在一个item中，
+//   所有的item按照LRU淘汰，总大小不超过proxy_cache_max_bytes，每个item在proxy_cache_ttl之后过期；
+//2. 这个proxy转发写命令的时候，在发送之前和收到返回之后都会删除涉及的key，MULTI/EXEC中排队的写命令在排队的时候
+//   和EXEC收到返回之后删除，其他proxy的写入只能依赖ttl；
+//3. 读请求发送之前记录key对应的版本号，删除key的时候版本号加一，收到返回之后如果版本号变化了就不再写入缓存，
+//   避免写入之前已经发出的读请求把旧的值重新写回缓存；
+//4. fillSlot改变了slot的backend之后，slot的epoch加一，这个slot中旧的item都会失效。
+
+const (
+	cacheVersionSize = 4096
+	cacheItemSize    = 128
+)
+
+type cacheItem struct {
+	key    string
+	slot   int
+	epoch  int64
+	expire int64
+	size   int
+
+	resps map[string]*redis.Resp
+	elem  *list.Element
+}
+
+type readCache struct {
+	mu sync.Mutex
+
+	prefixes [][]byte
+	maxBytes int
+	ttl      int64
+
+	items map[string]*cacheItem
+	lru   *list.List
+	bytes int
+
+	versions [cacheVersionSize]uint32
+	epochs   [MaxSlotNum]atomic2.Int64
+
+	hits   atomic2.Int64
+	misses atomic2.Int64
+}
+
+func newReadCache(config *Config) *readCache {
+	c := &readCache{
+		maxBytes: config.ProxyCacheMaxBytes.AsInt(),
+		ttl:      int64(config.ProxyCacheTTL.Duration()),
+	}
+	for _, prefix := range strings.Split(config.ProxyCacheKeyPrefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			c.prefixes = append(c.prefixes, []byte(prefix))
+		}
+	}
+	if len(c.prefixes) == 0 || c.maxBytes <= 0 || c.ttl <= 0 {
+		return nil
+	}
+	c.items = make(map[string]*cacheItem)
+	c.lru = list.New()
+	return c
+}
+
+//发送到backend的请求，收到返回之后根据write写入缓存或者再删除一次key
+type cacheTicket struct {
+	cache *readCache
+	write bool
+	keys  [][]byte
+
+	database int32
+	sub      string
+	slot     int
+	epoch    int64
+	version  uint32
+}
+
+func (c *readCache) match(key []byte) bool {
+	for _, prefix := range c.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func cacheItemKey(database int32, key []byte) string {
+	return strconv.Itoa(int(database)) + ":" + string(key)
+}
+
+func cacheVersionIndex(database int32, key []byte) uint32 {
+	return (crc32.ChecksumIEEE(key) + uint32(database)) % cacheVersionSize
+}
+
+//只读的请求命中缓存的时候直接返回，写请求删除涉及的key
+func (c *readCache) lookup(r *Request) (*redis.Resp, *cacheTicket) {
+	if c == nil {
+		return nil, nil
+	}
+	if !r.IsReadOnly() {
+		var keys [][]byte
+		for _, key := range getHashKeys(r.Multi, r.OpStr) {
+			if c.match(key) {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			return nil, nil
+		}
+		c.invalidate(r.Database, keys)
+		return nil, &cacheTicket{cache: c, write: true, keys: keys, database: r.Database}
+	}
+
+	var sub string
+	switch r.OpStr {
+	case "GET", "HGETALL":
+		if len(r.Multi) != 2 {
+			return nil, nil
+		}
+		sub = r.OpStr
+	case "HGET":
+		if len(r.Multi) != 3 {
+			return nil, nil
+		}
+		sub = "HGET:" + string(r.Multi[2].Value)
+	default:
+		return nil, nil
+	}
+	var key = r.Multi[1].Value
+	if !c.match(key) {
+		return nil, nil
+	}
+	var slot = int(Hash(key) % MaxSlotNum)
+	var epoch = c.epochs[slot].Int64()
+	var now = time.Now().UnixNano()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item := c.items[cacheItemKey(r.Database, key)]; item != nil {
+		switch {
+		case item.epoch != epoch || item.expire <= now:
+			c.remove(item)
+		case item.resps[sub] != nil:
+			c.lru.MoveToFront(item.elem)
+			c.hits.Incr()
+			return item.resps[sub], nil
+		}
+	}
+	c.misses.Incr()
+	return nil, &cacheTicket{
+		cache: c, keys: [][]byte{key}, database: r.Database,
+		sub: sub, slot: slot, epoch: epoch,
+		version: c.versions[cacheVersionIndex(r.Database, key)],
+	}
+}
+
+func (c *readCache) invalidate(database int32, keys [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		c.versions[cacheVersionIndex(database, key)]++
+		if item := c.items[cacheItemKey(database, key)]; item != nil {
+			c.remove(item)
+		}
+	}
+}
+
+func (c *readCache) remove(item *cacheItem) {
+	c.lru.Remove(item.elem)
+	delete(c.items, item.key)
+	c.bytes -= item.size
+}
+
+//合并写请求的ticket，用于MULTI/EXEC事务中排队的写命令
+func (t *cacheTicket) merge(x *cacheTicket) *cacheTicket {
+	switch {
+	case x == nil || !x.write:
+		return t
+	case t == nil:
+		return x
+	}
+	t.keys = append(t.keys, x.keys...)
+	return t
+}
+
+func (t *cacheTicket) done(resp *redis.Resp) {
+	if t.write {
+		t.cache.invalidate(t.database, t.keys)
+	} else if resp != nil && !resp.IsError() {
+		t.cache.fill(t, resp)
+	}
+}
+
+func (c *readCache) fill(t *cacheTicket, resp *redis.Resp) {
+	var key = t.keys[0]
+	var size = respSize(resp)
+	if size > c.maxBytes/4 {
+		return
+	}
+	var now = time.Now().UnixNano()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.versions[cacheVersionIndex(t.database, key)] != t.version {
+		return
+	}
+	if t.epoch != c.epochs[t.slot].Int64() {
+		return
+	}
+	var k = cacheItemKey(t.database, key)
+	item := c.items[k]
+	if item != nil && (item.epoch != t.epoch || item.expire <= now) {
+		c.remove(item)
+		item = nil
+	}
+	if item == nil {
+		item = &cacheItem{
+			key: k, slot: t.slot, epoch: t.epoch, expire: now + c.ttl,
+			size:  len(k) + cacheItemSize,
+			resps: make(map[string]*redis.Resp),
+		}
+		item.elem = c.lru.PushFront(item)
+		c.items[k] = item
+		c.bytes += item.size
+	} else {
+		c.lru.MoveToFront(item.elem)
+	}
+	if old := item.resps[t.sub]; old != nil {
+		item.size -= respSize(old)
+		c.bytes -= respSize(old)
+	}
+	item.resps[t.sub] = resp
+	item.size += size
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back().Value.(*cacheItem))
+	}
+}
+
+//slot的backend变化之后，这个slot中已经缓存的item都会失效，在下次访问或者被淘汰的时候删除
+func (c *readCache) evictSlot(slot int) {
+	if c == nil {
+		return
+	}
+	c.epochs[slot].Incr()
+}
+
+func respSize(resp *redis.Resp) int {
+	var size = 16 + len(resp.Value)
+	for _, x := range resp.Array {
+		size += respSize(x)
+	}
+	return size
+}
+
+type CacheStats struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+	Items    int     `json:"items"`
+	Bytes    int     `json:"bytes"`
+}
+
+func (c *readCache) Stats() *CacheStats {
+	if c == nil {
+		return nil
+	}
+	stats := &CacheStats{Hits: c.hits.Int64(), Misses: c.misses.Int64()}
+	if total := stats.Hits + stats.Misses; total != 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	c.mu.Lock()
+	stats.Items, stats.Bytes = len(c.items), c.bytes
+	c.mu.Unlock()
+	return stats
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func newTestReadCache() *readCache {
+	config := NewDefaultConfig()
+	config.ProxyCacheKeyPrefixes = "c:, h:"
+	config.ProxyCacheMaxBytes = 4096
+	config.ProxyCacheTTL.Set(time.Minute)
+	return newReadCache(config)
+}
+
+func TestReadCacheLookup(t *testing.T) {
+	assert.Must(newReadCache(NewDefaultConfig()) == nil)
+
+	c := newTestReadCache()
+	var value = redis.NewBulkBytes([]byte("v"))
+
+	resp, ticket := c.lookup(newTestRequest("GET", "c:1"))
+	assert.Must(resp == nil && ticket != nil)
+	ticket.done(value)
+	resp, ticket = c.lookup(newTestRequest("GET", "c:1"))
+	assert.Must(resp == value && ticket == nil)
+
+	resp, ticket = c.lookup(newTestRequest("GET", "x:1"))
+	assert.Must(resp == nil && ticket == nil)
+
+	_, ticket = c.lookup(newTestRequest("HGET", "h:1", "f"))
+	ticket.done(value)
+	_, ticket = c.lookup(newTestRequest("HGETALL", "h:1"))
+	ticket.done(redis.NewArray([]*redis.Resp{value, value}))
+	resp, _ = c.lookup(newTestRequest("HGET", "h:1", "f"))
+	assert.Must(resp == value)
+	resp, _ = c.lookup(newTestRequest("HGET", "h:1", "g"))
+	assert.Must(resp == nil)
+	assert.Must(c.Stats().Items == 2 && c.Stats().Hits == 2)
+
+	_, ticket = c.lookup(newTestRequest("HSET", "h:1", "f", "w"))
+	assert.Must(ticket != nil && ticket.write)
+	resp, _ = c.lookup(newTestRequest("HGETALL", "h:1"))
+	assert.Must(resp == nil)
+}
+
+func TestReadCacheInvalidate(t *testing.T) {
+	c := newTestReadCache()
+	var value = redis.NewBulkBytes([]byte("v"))
+
+	//写请求之前发出的读请求不能写入缓存
+	_, read := c.lookup(newTestRequest("GET", "c:1"))
+	_, write := c.lookup(newTestRequest("SET", "c:1", "w"))
+	read.done(value)
+	write.done(redis.NewString([]byte("OK")))
+	resp, read := c.lookup(newTestRequest("GET", "c:1"))
+	assert.Must(resp == nil)
+
+	read.done(value)
+	c.evictSlot(int(Hash([]byte("c:1")) % MaxSlotNum))
+	resp, _ = c.lookup(newTestRequest("GET", "c:1"))
+	assert.Must(resp == nil && c.Stats().Items == 0)
+
+	for i := 0; i < 100; i++ {
+		_, ticket := c.lookup(newTestRequest("GET", "c:"+strconv.Itoa(i)))
+		ticket.done(value)
+	}
+	assert.Must(c.Stats().Bytes <= 4096)
+	resp, _ = c.lookup(newTestRequest("GET", "c:99"))
+	assert.Must(resp == value)
+	resp, _ = c.lookup(newTestRequest("GET", "c:0"))
+	assert.Must(resp == nil)
+}
+
+func TestReadCacheTransaction(t *testing.T) {
+	c := newTestReadCache()
+	var value = redis.NewBulkBytes([]byte("v"))
+	_, ticket := c.lookup(newTestRequest("GET", "c:1"))
+	ticket.done(value)
+
+	d := &Router{cache: c}
+	s := &Session{}
+	s.tx = transaction{multi: true, slot: int(Hash([]byte("c:1")) % MaxSlotNum), bc: &BackendConn{}}
+
+	assert.MustNoError(s.queueTransaction(newTestRequest("GET", "c:1"), d))
+	assert.Must(s.tx.cache == nil)
+	assert.MustNoError(s.queueTransaction(newTestRequest("SET", "c:1", "w"), d))
+	assert.MustNoError(s.queueTransaction(newTestRequest("HSET", "c:{c:1}", "f", "w"), d))
+	assert.Must(s.tx.cache != nil && len(s.tx.cache.keys) == 2)
+
+	//排队的时候已经删除了缓存，EXEC返回之后再删除一次
+	resp, read := c.lookup(newTestRequest("GET", "c:1"))
+	assert.Must(resp == nil)
+	read.done(value)
+	s.tx.cache.done(redis.NewArray(nil))
+	resp, _ = c.lookup(newTestRequest("GET", "c:1"))
+	assert.Must(resp == nil)
+}
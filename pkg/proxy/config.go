@@ -30,6 +30,8 @@ product_auth = ""
 #      codis-proxy and codis-server.
 #   2. session_auth is different from product_auth, it requires clients
 #      to issue AUTH <PASSWORD> before processing any other commands.
+#   3. ACL users pushed by codis-dashboard (codis-admin --acl-update) can
+#      login with AUTH <USER> <PASSWORD>, session_auth is the default user.
 session_auth = ""
 
 # Set bind address for admin(rpc), tcp only.
@@ -39,6 +41,18 @@ admin_addr = "0.0.0.0:11080"
 proto_type = "tcp4"
 proxy_addr = "0.0.0.0:19000"
 
+# Set bind address for TLS clients, tcp only, plaintext proxy_addr keeps working. (empty to disable)
+#   1. proxy_tls_cert & proxy_tls_key are PEM files, they will be reloaded on SIGHUP.
+#   2. If proxy_tls_client_ca is set, clients must present certificates signed by it (mutual TLS).
+#   3. If proxy_tls_client_cert_user = true, the common name of client certificate is used as
+#      the ACL user of the session, and clients don't need to issue AUTH anymore. Certificates with
+#      an empty common name or an unknown ACL user still need AUTH.
+proxy_tls_addr = ""
+proxy_tls_cert = ""
+proxy_tls_key = ""
+proxy_tls_client_ca = ""
+proxy_tls_client_cert_user = false
+
 # Set jodis address & session timeout
 #   1. jodis_name is short for jodis_coordinator_name, only accept "zookeeper" & "etcd".
 #   2. jodis_addr is short for jodis_coordinator_addr
@@ -66,6 +80,51 @@ proxy_max_offheap_size = "1024mb"
 # Set heap placeholder to reduce GC frequency.
 proxy_heap_placeholder = "256mb"
 
+# Emulate RENAME/RENAMENX/MSETNX when keys belong to different slots. Default is false, proxy will reply CROSSSLOT errors.
+# The emulation is NOT atomic: RENAME is done by DUMP/RESTORE/DEL, MSETNX locks all keys, checks and then sets them.
+proxy_cross_slot_emulation = false
+
+# Set hot key detection, keys of requests are sampled by proxy. (0 to disable)
+#   1. proxy_hotkey_top_k is the number of hot keys tracked and reported in stats.
+#   2. proxy_hotkey_sample_rate is the probability of sampling a key, 1 means every key.
+#   3. Counters are halved every proxy_hotkey_decay_period, so hot keys reflect the recent traffic.
+proxy_hotkey_top_k = 32
+proxy_hotkey_sample_rate = 0.01
+proxy_hotkey_decay_period = "10s"
+
+# Set slow log, requests slower than proxy_slowlog_slower_than are recorded. (0 to disable)
+#   1. proxy_slowlog_max_len is the number of the most recent slow requests kept by proxy.
+#   2. Slow log can be queried by 'SLOWLOG GET/LEN/RESET' or the admin api.
+proxy_slowlog_slower_than = "10ms"
+proxy_slowlog_max_len = 128
+
+# Set request rate limits in requests and bytes per second. (0 to disable)
+#   1. proxy_ratelimit_ops/bytes limit all the requests of proxy, proxy_ratelimit_session_ops/bytes limit each session.
+#   2. Limits per source CIDR and per user can be set at runtime through the admin api.
+#   3. Requests over the limits are delayed up to proxy_ratelimit_max_delay, or rejected with an error. (0 to reject immediately)
+proxy_ratelimit_ops = 0
+proxy_ratelimit_bytes = "0"
+proxy_ratelimit_session_ops = 0
+proxy_ratelimit_session_bytes = "0"
+proxy_ratelimit_max_delay = "100ms"
+
+# Set proxy read cache for GET/HGET/HGETALL. (empty prefixes to disable)
+#   1. Only keys matching proxy_cache_key_prefixes (comma separated) are cached, in a LRU of proxy_cache_max_bytes.
+#   2. Cached keys are invalidated by writes through this proxy, writes through other proxies are visible after proxy_cache_ttl.
+proxy_cache_key_prefixes = ""
+proxy_cache_max_bytes = "64mb"
+proxy_cache_ttl = "1s"
+
+# Set limits of requests & responses to protect proxy from big keys. (0 to disable)
+#   1. Requests or responses over the limits are skipped without buffering, and answered with an error.
+#   2. Size is the total length of bulk strings, elements is the length of the largest array of a request or response.
+#   3. Violations are recorded as big-key events, proxy keeps the latest proxy_bigkey_log_max_len events.
+proxy_max_request_bytes = "0"
+proxy_max_request_elements = 0
+proxy_max_response_bytes = "0"
+proxy_max_response_elements = 0
+proxy_bigkey_log_max_len = 128
+
 # Proxy will ping backend redis (and clear 'MASTERDOWN' state) in a predefined interval. (0 to disable)
 backend_ping_period = "5s"
 
@@ -93,8 +152,42 @@ backend_keepalive_period = "75s"
 # Set number of databases of backend.
 backend_number_databases = 16
 
+# Set backend circuit breaker. (0 to disable)
+#   1. Breaker of a backend opens if failures (errors & timeouts) reach backend_breaker_error_ratio
+#      of at least backend_breaker_min_requests requests in backend_breaker_window.
+#   2. Request slower than backend_breaker_timeout is counted as a failure, a single slow request never opens it.
+#   3. While open, requests fail immediately, read-only requests fall back to replica groups if possible,
+#      even if backend_primary_only is true.
+#   4. After backend_breaker_open_period, one request is sent as a probe, breaker closes if it succeeds.
+backend_breaker_error_ratio = 0.0
+backend_breaker_min_requests = 20
+backend_breaker_window = "10s"
+backend_breaker_timeout = "5s"
+backend_breaker_open_period = "5s"
+
+# Set replica selection inside a replica group.
+#   1. Proxy probes replicas with 'INFO replication' every backend_ping_period and tracks the round trip time.
+#   2. Replicas with master_link_status down, or master_last_io_seconds_ago over backend_replica_max_lag are skipped. (0 to disable)
+#   3. Reads go to the replica with the lowest latency, replicas within backend_replica_latency_tolerance of it share the load.
+backend_replica_max_lag = "30s"
+backend_replica_latency_tolerance = "1ms"
+
+# Set TLS to backend codis-server. (false to disable)
+#   1. backend_tls_ca is used to verify certificates of codis-server, system roots are used if empty.
+#   2. backend_tls_cert & backend_tls_key are optional client certificates for mutual TLS.
+#   3. All files will be reloaded on SIGHUP, only new connections will use them.
+#   4. codis-dashboard still connects to codis-server in plaintext.
+backend_tls = false
+backend_tls_ca = ""
+backend_tls_cert = ""
+backend_tls_key = ""
+backend_tls_skip_verify = false
+
 # If there is no request from client for a long time, the connection will be closed. (0 to disable)
 # Set session recv buffer size & timeout.
+# BLPOP/BRPOP/BRPOPLPUSH return nil about 1s before session_recv_timeout expires. They are executed in
+# rounds of at most 1s, so waiting clients are not served in FIFO order, and after slots are moved or
+# masters are switched, a pushed element may wait up to 1s before it is delivered.
 session_recv_bufsize = "128kb"
 session_recv_timeout = "30m"
 
@@ -112,6 +205,25 @@ session_keepalive_period = "75s"
 # Set session to be sensitive to failures. Default is false, instead of closing socket, proxy will send an error response to client.
 session_break_on_failure = false
 
+# Set read-your-writes consistency for replica reads. Default is false, sessions may read stale data from replicas.
+#   1. After a session writes a slot, its reads of the slot go to master for session_read_your_writes_window.
+#   2. Sessions can switch the mode by 'CODIS.CONSISTENCY EVENTUAL|READ-YOUR-WRITES'.
+session_read_your_writes = false
+session_read_your_writes_window = "1s"
+
+# Set traffic mirroring, requests will be duplicated to mirror_addr asynchronously. (empty to disable)
+#   1. mirror_addr can be codis-proxy of another product or a plain redis.
+#   2. mirror_mode = "writes" mirrors write commands only, "all" mirrors all commands with keys.
+#   3. mirror_key_prefixes is a comma separated list, a request is mirrored only if all of its keys match.
+#   4. Requests are dropped if mirror_queue_size requests are waiting for each of mirror_parallel connections.
+#   5. Mirroring can be changed at runtime by codis-admin --mirror-update.
+mirror_addr = ""
+mirror_auth = ""
+mirror_mode = "writes"
+mirror_key_prefixes = ""
+mirror_parallel = 4
+mirror_queue_size = 4096
+
 # Set metrics server (such as http://localhost:28000), proxy will report json formatted metrics to specified server in a predefined period.
 metrics_report_server = ""
 metrics_report_period = "1s"
@@ -134,6 +246,12 @@ type Config struct {
 	ProxyAddr string `toml:"proxy_addr" json:"proxy_addr"`
 	AdminAddr string `toml:"admin_addr" json:"admin_addr"`
 
+	ProxyTLSAddr           string `toml:"proxy_tls_addr" json:"proxy_tls_addr"`
+	ProxyTLSCert           string `toml:"proxy_tls_cert" json:"proxy_tls_cert"`
+	ProxyTLSKey            string `toml:"proxy_tls_key" json:"-"`
+	ProxyTLSClientCA       string `toml:"proxy_tls_client_ca" json:"proxy_tls_client_ca"`
+	ProxyTLSClientCertUser bool   `toml:"proxy_tls_client_cert_user" json:"proxy_tls_client_cert_user"`
+
 	HostProxy string `toml:"-" json:"-"`
 	HostAdmin string `toml:"-" json:"-"`
 
@@ -152,6 +270,31 @@ type Config struct {
 	ProxyMaxOffheapBytes bytesize.Int64 `toml:"proxy_max_offheap_size" json:"proxy_max_offheap_size"`
 	ProxyHeapPlaceholder bytesize.Int64 `toml:"proxy_heap_placeholder" json:"proxy_heap_placeholder"`
 
+	ProxyCrossSlotEmulation bool `toml:"proxy_cross_slot_emulation" json:"proxy_cross_slot_emulation"`
+
+	ProxyHotKeyTopK        int               `toml:"proxy_hotkey_top_k" json:"proxy_hotkey_top_k"`
+	ProxyHotKeySampleRate  float64           `toml:"proxy_hotkey_sample_rate" json:"proxy_hotkey_sample_rate"`
+	ProxyHotKeyDecayPeriod timesize.Duration `toml:"proxy_hotkey_decay_period" json:"proxy_hotkey_decay_period"`
+
+	ProxySlowLogSlowerThan timesize.Duration `toml:"proxy_slowlog_slower_than" json:"proxy_slowlog_slower_than"`
+	ProxySlowLogMaxLen     int               `toml:"proxy_slowlog_max_len" json:"proxy_slowlog_max_len"`
+
+	ProxyRateLimitOps          int64             `toml:"proxy_ratelimit_ops" json:"proxy_ratelimit_ops"`
+	ProxyRateLimitBytes        bytesize.Int64    `toml:"proxy_ratelimit_bytes" json:"proxy_ratelimit_bytes"`
+	ProxyRateLimitSessionOps   int64             `toml:"proxy_ratelimit_session_ops" json:"proxy_ratelimit_session_ops"`
+	ProxyRateLimitSessionBytes bytesize.Int64    `toml:"proxy_ratelimit_session_bytes" json:"proxy_ratelimit_session_bytes"`
+	ProxyRateLimitMaxDelay     timesize.Duration `toml:"proxy_ratelimit_max_delay" json:"proxy_ratelimit_max_delay"`
+
+	ProxyCacheKeyPrefixes string            `toml:"proxy_cache_key_prefixes" json:"proxy_cache_key_prefixes"`
+	ProxyCacheMaxBytes    bytesize.Int64    `toml:"proxy_cache_max_bytes" json:"proxy_cache_max_bytes"`
+	ProxyCacheTTL         timesize.Duration `toml:"proxy_cache_ttl" json:"proxy_cache_ttl"`
+
+	ProxyMaxRequestBytes     bytesize.Int64 `toml:"proxy_max_request_bytes" json:"proxy_max_request_bytes"`
+	ProxyMaxRequestElements  int            `toml:"proxy_max_request_elements" json:"proxy_max_request_elements"`
+	ProxyMaxResponseBytes    bytesize.Int64 `toml:"proxy_max_response_bytes" json:"proxy_max_response_bytes"`
+	ProxyMaxResponseElements int            `toml:"proxy_max_response_elements" json:"proxy_max_response_elements"`
+	ProxyBigKeyLogMaxLen     int            `toml:"proxy_bigkey_log_max_len" json:"proxy_bigkey_log_max_len"`
+
 	BackendPingPeriod      timesize.Duration `toml:"backend_ping_period" json:"backend_ping_period"`
 	BackendRecvBufsize     bytesize.Int64    `toml:"backend_recv_bufsize" json:"backend_recv_bufsize"`
 	BackendRecvTimeout     timesize.Duration `toml:"backend_recv_timeout" json:"backend_recv_timeout"`
@@ -164,6 +307,21 @@ type Config struct {
 	BackendKeepAlivePeriod timesize.Duration `toml:"backend_keepalive_period" json:"backend_keepalive_period"`
 	BackendNumberDatabases int32             `toml:"backend_number_databases" json:"backend_number_databases"`
 
+	BackendBreakerErrorRatio  float64           `toml:"backend_breaker_error_ratio" json:"backend_breaker_error_ratio"`
+	BackendBreakerMinRequests int               `toml:"backend_breaker_min_requests" json:"backend_breaker_min_requests"`
+	BackendBreakerWindow      timesize.Duration `toml:"backend_breaker_window" json:"backend_breaker_window"`
+	BackendBreakerTimeout     timesize.Duration `toml:"backend_breaker_timeout" json:"backend_breaker_timeout"`
+	BackendBreakerOpenPeriod  timesize.Duration `toml:"backend_breaker_open_period" json:"backend_breaker_open_period"`
+
+	BackendReplicaMaxLag           timesize.Duration `toml:"backend_replica_max_lag" json:"backend_replica_max_lag"`
+	BackendReplicaLatencyTolerance timesize.Duration `toml:"backend_replica_latency_tolerance" json:"backend_replica_latency_tolerance"`
+
+	BackendTLS           bool   `toml:"backend_tls" json:"backend_tls"`
+	BackendTLSCA         string `toml:"backend_tls_ca" json:"backend_tls_ca"`
+	BackendTLSCert       string `toml:"backend_tls_cert" json:"backend_tls_cert"`
+	BackendTLSKey        string `toml:"backend_tls_key" json:"-"`
+	BackendTLSSkipVerify bool   `toml:"backend_tls_skip_verify" json:"backend_tls_skip_verify"`
+
 	SessionRecvBufsize     bytesize.Int64    `toml:"session_recv_bufsize" json:"session_recv_bufsize"`
 	SessionRecvTimeout     timesize.Duration `toml:"session_recv_timeout" json:"session_recv_timeout"`
 	SessionSendBufsize     bytesize.Int64    `toml:"session_send_bufsize" json:"session_send_bufsize"`
@@ -172,6 +330,16 @@ type Config struct {
 	SessionKeepAlivePeriod timesize.Duration `toml:"session_keepalive_period" json:"session_keepalive_period"`
 	SessionBreakOnFailure  bool              `toml:"session_break_on_failure" json:"session_break_on_failure"`
 
+	SessionReadYourWrites       bool              `toml:"session_read_your_writes" json:"session_read_your_writes"`
+	SessionReadYourWritesWindow timesize.Duration `toml:"session_read_your_writes_window" json:"session_read_your_writes_window"`
+
+	MirrorAddr        string `toml:"mirror_addr" json:"mirror_addr"`
+	MirrorAuth        string `toml:"mirror_auth" json:"-"`
+	MirrorMode        string `toml:"mirror_mode" json:"mirror_mode"`
+	MirrorKeyPrefixes string `toml:"mirror_key_prefixes" json:"mirror_key_prefixes"`
+	MirrorParallel    int    `toml:"mirror_parallel" json:"mirror_parallel"`
+	MirrorQueueSize   int    `toml:"mirror_queue_size" json:"mirror_queue_size"`
+
 	MetricsReportServer           string            `toml:"metrics_report_server" json:"metrics_report_server"`
 	MetricsReportPeriod           timesize.Duration `toml:"metrics_report_period" json:"metrics_report_period"`
 	MetricsReportInfluxdbServer   string            `toml:"metrics_report_influxdb_server" json:"metrics_report_influxdb_server"`
@@ -221,6 +389,14 @@ func (c *Config) Validate() error {
 	if c.AdminAddr == "" {
 		return errors.New("invalid admin_addr")
 	}
+	if c.ProxyTLSAddr != "" {
+		if c.ProxyTLSCert == "" || c.ProxyTLSKey == "" {
+			return errors.New("invalid proxy_tls_cert or proxy_tls_key")
+		}
+	}
+	if c.ProxyTLSClientCertUser && c.ProxyTLSClientCA == "" {
+		return errors.New("invalid proxy_tls_client_ca")
+	}
 	if c.JodisName != "" {
 		if c.JodisAddr == "" {
 			return errors.New("invalid jodis_addr")
@@ -244,6 +420,57 @@ func (c *Config) Validate() error {
 	if d := c.ProxyHeapPlaceholder; d < 0 || d > MaxInt {
 		return errors.New("invalid proxy_heap_placeholder")
 	}
+	if c.ProxyHotKeyTopK < 0 {
+		return errors.New("invalid proxy_hotkey_top_k")
+	}
+	if c.ProxyHotKeySampleRate < 0 || c.ProxyHotKeySampleRate > 1 {
+		return errors.New("invalid proxy_hotkey_sample_rate")
+	}
+	if c.ProxyHotKeyDecayPeriod < 0 {
+		return errors.New("invalid proxy_hotkey_decay_period")
+	}
+	if c.ProxySlowLogSlowerThan < 0 {
+		return errors.New("invalid proxy_slowlog_slower_than")
+	}
+	if c.ProxySlowLogMaxLen < 0 {
+		return errors.New("invalid proxy_slowlog_max_len")
+	}
+	if c.ProxyRateLimitOps < 0 {
+		return errors.New("invalid proxy_ratelimit_ops")
+	}
+	if c.ProxyRateLimitBytes < 0 {
+		return errors.New("invalid proxy_ratelimit_bytes")
+	}
+	if c.ProxyRateLimitSessionOps < 0 {
+		return errors.New("invalid proxy_ratelimit_session_ops")
+	}
+	if c.ProxyRateLimitSessionBytes < 0 {
+		return errors.New("invalid proxy_ratelimit_session_bytes")
+	}
+	if c.ProxyRateLimitMaxDelay < 0 {
+		return errors.New("invalid proxy_ratelimit_max_delay")
+	}
+	if d := c.ProxyCacheMaxBytes; d < 0 || d > MaxInt {
+		return errors.New("invalid proxy_cache_max_bytes")
+	}
+	if c.ProxyCacheTTL < 0 {
+		return errors.New("invalid proxy_cache_ttl")
+	}
+	if c.ProxyMaxRequestBytes < 0 {
+		return errors.New("invalid proxy_max_request_bytes")
+	}
+	if c.ProxyMaxRequestElements < 0 {
+		return errors.New("invalid proxy_max_request_elements")
+	}
+	if c.ProxyMaxResponseBytes < 0 {
+		return errors.New("invalid proxy_max_response_bytes")
+	}
+	if c.ProxyMaxResponseElements < 0 {
+		return errors.New("invalid proxy_max_response_elements")
+	}
+	if c.ProxyBigKeyLogMaxLen < 0 {
+		return errors.New("invalid proxy_bigkey_log_max_len")
+	}
 	if c.BackendPingPeriod < 0 {
 		return errors.New("invalid backend_ping_period")
 	}
@@ -275,6 +502,30 @@ func (c *Config) Validate() error {
 	if c.BackendNumberDatabases < 1 {
 		return errors.New("invalid backend_number_databases")
 	}
+	if c.BackendBreakerErrorRatio < 0 || c.BackendBreakerErrorRatio > 1 {
+		return errors.New("invalid backend_breaker_error_ratio")
+	}
+	if c.BackendBreakerMinRequests < 0 {
+		return errors.New("invalid backend_breaker_min_requests")
+	}
+	if c.BackendBreakerWindow < 0 {
+		return errors.New("invalid backend_breaker_window")
+	}
+	if c.BackendBreakerTimeout < 0 {
+		return errors.New("invalid backend_breaker_timeout")
+	}
+	if c.BackendBreakerOpenPeriod < 0 {
+		return errors.New("invalid backend_breaker_open_period")
+	}
+	if c.BackendReplicaMaxLag < 0 {
+		return errors.New("invalid backend_replica_max_lag")
+	}
+	if c.BackendReplicaLatencyTolerance < 0 {
+		return errors.New("invalid backend_replica_latency_tolerance")
+	}
+	if (c.BackendTLSCert == "") != (c.BackendTLSKey == "") {
+		return errors.New("invalid backend_tls_cert or backend_tls_key")
+	}
 
 	if d := c.SessionRecvBufsize; d < 0 || d > MaxInt {
 		return errors.New("invalid session_recv_bufsize")
@@ -294,6 +545,21 @@ func (c *Config) Validate() error {
 	if c.SessionKeepAlivePeriod < 0 {
 		return errors.New("invalid session_keepalive_period")
 	}
+	if c.SessionReadYourWritesWindow < 0 {
+		return errors.New("invalid session_read_your_writes_window")
+	}
+
+	switch c.MirrorMode {
+	case MirrorModeWrites, MirrorModeAll:
+	default:
+		return errors.New("invalid mirror_mode")
+	}
+	if c.MirrorParallel < 0 {
+		return errors.New("invalid mirror_parallel")
+	}
+	if c.MirrorQueueSize < 0 {
+		return errors.New("invalid mirror_queue_size")
+	}
 
 	if c.MetricsReportPeriod < 0 {
 		return errors.New("invalid metrics_report_period")
@@ -0,0 +1,96 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"strings"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+)
+
+//read-your-writes的实现：
+//1. session默认使用session_read_your_writes配置的模式，可以通过CODIS.CONSISTENCY命令切换；
+//2. read-your-writes模式下，session每次写入一个slot的时候记录一个截止时间，
+//   在session_read_your_writes_window之内这个session对同一个slot的读请求都加上FlagMasterOnly，由forward2发往master；
+//3. 只影响发往replica group的读请求，其他session以及截止时间之后的读请求仍然可以读replica。
+
+const (
+	ConsistencyEventual       = "EVENTUAL"
+	ConsistencyReadYourWrites = "READ-YOUR-WRITES"
+)
+
+//截止时间记录的slot超过这个数量之后，在写入的时候清理已经过期的记录
+const consistencyMaxSlots = 128
+
+type consistency struct {
+	readYourWrites bool
+
+	deadline map[uint32]int64
+}
+
+func (c *consistency) mode() string {
+	if c.readYourWrites {
+		return ConsistencyReadYourWrites
+	}
+	return ConsistencyEventual
+}
+
+//在handleRequest中分发请求之前调用，MGET等命令拆分出来的子请求会继承FlagMasterOnly
+func (s *Session) checkConsistency(r *Request) {
+	var c = &s.consistency
+	if !c.readYourWrites || r.IsBlocking() {
+		return
+	}
+	var keys = getHashKeys(r.Multi, r.OpStr)
+	if len(keys) == 0 {
+		return
+	}
+	var now = r.UnixNano
+	if r.IsReadOnly() {
+		for _, key := range keys {
+			if c.deadline[Hash(key)%MaxSlotNum] > now {
+				r.OpFlag |= FlagMasterOnly
+				return
+			}
+		}
+		return
+	}
+	if c.deadline == nil {
+		c.deadline = make(map[uint32]int64)
+	}
+	if len(c.deadline) >= consistencyMaxSlots {
+		for slot, deadline := range c.deadline {
+			if deadline <= now {
+				delete(c.deadline, slot)
+			}
+		}
+	}
+	var deadline = now + s.config.SessionReadYourWritesWindow.Int64()
+	for _, key := range keys {
+		c.deadline[Hash(key)%MaxSlotNum] = deadline
+	}
+}
+
+//CODIS.CONSISTENCY [EVENTUAL|READ-YOUR-WRITES]，没有参数的时候返回当前的模式
+func (s *Session) handleConsistency(r *Request) error {
+	var c = &s.consistency
+	switch len(r.Multi) {
+	case 1:
+		r.Resp = redis.NewString([]byte(c.mode()))
+	case 2:
+		switch strings.ToUpper(string(r.Multi[1].Value)) {
+		case ConsistencyEventual:
+			c.readYourWrites, c.deadline = false, nil
+		case ConsistencyReadYourWrites:
+			c.readYourWrites = true
+		default:
+			r.Resp = redis.NewErrorf("ERR invalid consistency mode '%s'", r.Multi[1].Value)
+			return nil
+		}
+		r.Resp = RespOK
+	default:
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for '%s' command", r.OpStr)
+	}
+	return nil
+}
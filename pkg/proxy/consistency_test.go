@@ -0,0 +1,50 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+	"github.com/thesunnysky/codis/pkg/utils/timesize"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	config := NewDefaultConfig()
+	config.SessionReadYourWritesWindow = timesize.Duration(time.Second)
+	s := &Session{config: config}
+
+	var now = time.Now().UnixNano()
+	var check = func(delay time.Duration, args ...string) bool {
+		r := newTestRequest(args...)
+		r.UnixNano = now + int64(delay)
+		s.checkConsistency(r)
+		return r.IsMasterOnly()
+	}
+
+	check(0, "SET", "a", "1")
+	assert.Must(!check(0, "GET", "a"))
+
+	r := newTestRequest("CODIS.CONSISTENCY", "read-your-writes")
+	assert.Must(r.OpStr == "CODIS.CONSISTENCY" && r.IsReadOnly())
+	assert.MustNoError(s.handleConsistency(r))
+	assert.Must(r.Resp == RespOK && s.consistency.mode() == ConsistencyReadYourWrites)
+
+	assert.Must(!check(0, "GET", "a"))
+	check(0, "MSET", "a", "1", "{b}x", "2")
+	assert.Must(check(0, "GET", "a"))
+	assert.Must(check(time.Millisecond*500, "MGET", "c", "{b}y"))
+	assert.Must(!check(0, "GET", "c"))
+	assert.Must(!check(time.Second, "GET", "a"))
+
+	r = newTestRequest("CODIS.CONSISTENCY", "eventual")
+	assert.MustNoError(s.handleConsistency(r))
+	assert.Must(r.Resp == RespOK && s.consistency.deadline == nil)
+	assert.Must(!check(0, "GET", "a"))
+
+	r = newTestRequest("CODIS.CONSISTENCY", "strong")
+	assert.MustNoError(s.handleConsistency(r))
+	assert.Must(r.Resp.IsError())
+}
@@ -0,0 +1,288 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+)
+
+//RENAME/RENAMENX/MSETNX的实现：
+//1. 所有的keys属于同一个slot的时候，直接转发到backend执行，和redis的语义完全一致；
+//2. keys属于不同slot的时候，只有打开了proxy_cross_slot_emulation才会由proxy模拟执行，否则返回CROSSSLOT错误；
+//3. 模拟执行不是原子的：RENAME通过DUMP/PTTL/RESTORE/DEL完成，中间可能被其他客户端的写入打断；
+//   MSETNX先给所有的key加锁（和key属于同一个slot的锁key），再检查所有的key都不存在，最后再逐个SET，
+//   锁只对经过proxy模拟执行的MSETNX有效，其他命令仍然可以在检查和SET之间修改这些key。
+
+const (
+	msetnxLockSuffix  = ":codis-msetnx-lock"
+	msetnxLockTimeout = time.Second * 5
+)
+
+const msetnxUnlockScript = `if redis.call('get', KEYS[1]) == ARGV[1] then return redis.call('del', KEYS[1]) end return 0`
+
+var errRespBusyKey = []byte("BUSYKEY")
+
+func (s *Session) handleRequestRename(r *Request, d *Router) error {
+	if len(r.Multi) != 3 {
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for '%s' command", r.OpStr)
+		return nil
+	}
+	switch _, ok := getHashSlot(getHashKeys(r.Multi, r.OpStr)); {
+	case ok:
+		return d.dispatch(r)
+	case !s.config.ProxyCrossSlotEmulation:
+		r.Resp = RespCrossSlot
+		return nil
+	}
+	s.emulate(r, func() (*redis.Resp, error) {
+		return s.emulateRename(r, d)
+	})
+	return nil
+}
+
+func (s *Session) handleRequestMSetNX(r *Request, d *Router) error {
+	var nblks = len(r.Multi) - 1
+	if nblks == 0 || nblks%2 != 0 {
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for 'MSETNX' command")
+		return nil
+	}
+	switch _, ok := getHashSlot(getHashKeys(r.Multi, r.OpStr)); {
+	case ok:
+		return d.dispatch(r)
+	case !s.config.ProxyCrossSlotEmulation:
+		r.Resp = RespCrossSlot
+		return nil
+	}
+	s.emulate(r, func() (*redis.Resp, error) {
+		return s.emulateMSetNX(r, d)
+	})
+	return nil
+}
+
+func (s *Session) emulate(r *Request, fn func() (*redis.Resp, error)) {
+	r.Batch.Add(1)
+	go func() {
+		defer r.Batch.Done()
+		r.Resp, r.Err = fn()
+	}()
+}
+
+func (s *Session) emulateRename(r *Request, d *Router) (*redis.Resp, error) {
+	var src, dst = r.Multi[1].Value, r.Multi[2].Value
+
+	replies, err := s.dispatchAndWait(r, d,
+		newMultiBulk([]byte("DUMP"), src),
+		newMultiBulk([]byte("PTTL"), src),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var dump, pttl = replies[0], replies[1]
+	switch {
+	case dump.IsError():
+		return dump, nil
+	case pttl.IsError():
+		return pttl, nil
+	case dump.Value == nil:
+		return redis.NewErrorf("ERR no such key"), nil
+	}
+	ttl, err := redis.Btoi64(pttl.Value)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("bad pttl resp: %s", pttl.Value)
+	case ttl == -2:
+		return redis.NewErrorf("ERR no such key"), nil
+	case ttl < 0:
+		ttl = 0
+	}
+
+	var restore = newMultiBulk([]byte("RESTORE"), dst, []byte(strconv.FormatInt(ttl, 10)), dump.Value)
+	if r.OpStr == "RENAME" {
+		restore = append(restore, redis.NewBulkBytes([]byte("REPLACE")))
+	}
+	if replies, err = s.dispatchAndWait(r, d, restore); err != nil {
+		return nil, err
+	}
+	if resp := replies[0]; resp.IsError() {
+		if r.OpStr == "RENAMENX" && bytes.HasPrefix(resp.Value, errRespBusyKey) {
+			return redis.NewInt([]byte("0")), nil
+		}
+		return resp, nil
+	}
+
+	if replies, err = s.dispatchAndWait(r, d, newMultiBulk([]byte("DEL"), src)); err != nil {
+		return nil, err
+	}
+	if resp := replies[0]; resp.IsError() {
+		return resp, nil
+	}
+	if r.OpStr == "RENAMENX" {
+		return redis.NewInt([]byte("1")), nil
+	}
+	return RespOK, nil
+}
+
+func (s *Session) emulateMSetNX(r *Request, d *Router) (*redis.Resp, error) {
+	//重复的key只保留最后一个value，和MSET的语义一致
+	var keys [][]byte
+	var values = make(map[string][]byte)
+	for i := 1; i < len(r.Multi); i += 2 {
+		var key = r.Multi[i].Value
+		if _, ok := values[string(key)]; !ok {
+			keys = append(keys, key)
+		}
+		values[string(key)] = r.Multi[i+1].Value
+	}
+
+	var token = []byte(fmt.Sprintf("%p-%d", s, time.Now().UnixNano()))
+	var ttl = []byte(strconv.FormatInt(int64(msetnxLockTimeout/time.Millisecond), 10))
+
+	var locks [][]byte
+	var multis [][]*redis.Resp
+	for _, key := range keys {
+		lock := getLockKey(key)
+		if lock == nil {
+			return redis.NewErrorf("ERR can't find a lock key in the same slot of key '%s'", key), nil
+		}
+		locks = append(locks, lock)
+		multis = append(multis, newMultiBulk([]byte("SET"), lock, token, []byte("NX"), []byte("PX"), ttl))
+	}
+	replies, err := s.dispatchAndWait(r, d, multis...)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked [][]byte
+	for i, resp := range replies {
+		if resp.IsString() {
+			locked = append(locked, locks[i])
+		}
+	}
+	defer s.unlockKeys(r, d, locked, token)
+
+	for _, resp := range replies {
+		switch {
+		case resp.IsError():
+			return resp, nil
+		case !resp.IsString():
+			//其他的MSETNX正在修改这个key
+			return redis.NewInt([]byte("0")), nil
+		}
+	}
+
+	multis = multis[:0]
+	for _, key := range keys {
+		multis = append(multis, newMultiBulk([]byte("EXISTS"), key))
+	}
+	if replies, err = s.dispatchAndWait(r, d, multis...); err != nil {
+		return nil, err
+	}
+	for _, resp := range replies {
+		switch {
+		case resp.IsError():
+			return resp, nil
+		case !resp.IsInt() || len(resp.Value) != 1:
+			return nil, fmt.Errorf("bad exists resp: %s value.len = %d", resp.Type, len(resp.Value))
+		case resp.Value[0] != '0':
+			return redis.NewInt([]byte("0")), nil
+		}
+	}
+
+	multis = multis[:0]
+	for _, key := range keys {
+		multis = append(multis, newMultiBulk([]byte("SET"), key, values[string(key)]))
+	}
+	if replies, err = s.dispatchAndWait(r, d, multis...); err != nil {
+		return nil, err
+	}
+	for _, resp := range replies {
+		if resp.IsError() {
+			return resp, nil
+		}
+	}
+	return redis.NewInt([]byte("1")), nil
+}
+
+func (s *Session) unlockKeys(r *Request, d *Router, locks [][]byte, token []byte) {
+	var multis [][]*redis.Resp
+	for _, lock := range locks {
+		multis = append(multis, newMultiBulk([]byte("EVAL"), []byte(msetnxUnlockScript), []byte("1"), lock, token))
+	}
+	if len(multis) != 0 {
+		s.dispatchAndWait(r, d, multis...)
+	}
+}
+
+//锁key必须和key属于同一个slot，才能和key一起迁移
+func getLockKey(key []byte) []byte {
+	var slot = Hash(key) % MaxSlotNum
+	var candidates = [][]byte{
+		append(append([]byte(nil), key...), msetnxLockSuffix...),
+		[]byte(fmt.Sprintf("{%s}%s", key, msetnxLockSuffix)),
+	}
+	for _, lock := range candidates {
+		if Hash(lock)%MaxSlotNum == slot {
+			return lock
+		}
+	}
+	//key中包含'}'但是没有hash tag的时候，只能通过增加后缀找到一个同slot的锁key
+	for i := 0; i < MaxSlotNum*64; i++ {
+		lock := []byte(fmt.Sprintf("%s%s:%d", key, msetnxLockSuffix, i))
+		if Hash(lock)%MaxSlotNum == slot {
+			return lock
+		}
+	}
+	return nil
+}
+
+func newMultiBulk(args ...[]byte) []*redis.Resp {
+	var multi = make([]*redis.Resp, len(args))
+	for i, arg := range args {
+		multi[i] = redis.NewBulkBytes(arg)
+	}
+	return multi
+}
+
+//并行地执行多个命令，等待所有的命令都返回之后再返回结果；
+//模拟的命令需要读到最新的数据（DUMP、PTTL、EXISTS），所有的子请求都只能发往master
+func (s *Session) dispatchAndWait(r *Request, d *Router, multis ...[]*redis.Resp) ([]*redis.Resp, error) {
+	var batch = &sync.WaitGroup{}
+	var sub = make([]Request, len(multis))
+	for i, multi := range multis {
+		opstr, flag, err := getOpInfo(multi)
+		if err != nil {
+			return nil, err
+		}
+		x := &sub[i]
+		x.Multi = multi
+		x.Batch = batch
+		x.OpStr, x.OpFlag = opstr, flag|FlagMasterOnly
+		x.Database = r.Database
+		x.UnixNano = r.UnixNano
+		x.Broken = r.Broken
+		if err := d.dispatch(x); err != nil {
+			batch.Wait()
+			return nil, err
+		}
+	}
+	batch.Wait()
+
+	var replies = make([]*redis.Resp, len(sub))
+	for i := range sub {
+		if err := sub[i].Err; err != nil {
+			return nil, err
+		}
+		if sub[i].Resp == nil {
+			return nil, ErrRespIsRequired
+		}
+		replies[i] = sub[i].Resp
+	}
+	return replies, nil
+}
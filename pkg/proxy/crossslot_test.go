@@ -0,0 +1,392 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+//模拟codis-server的string、DUMP/RESTORE以及脚本相关的命令，其他命令都返回OK；
+//fail中的命令（"SET"或者"SET key"）直接返回错误
+type memServer struct {
+	sync.Mutex
+	l       net.Listener
+	values  map[string]string
+	ttls    map[string]int64
+	scripts map[string]string
+	fail    map[string]string
+	cmds    []string
+}
+
+func newMemServer() *memServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	s := &memServer{l: l}
+	s.values = make(map[string]string)
+	s.ttls = make(map[string]int64)
+	s.scripts = make(map[string]string)
+	s.fail = make(map[string]string)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(redis.NewConn(c, 1024, 1024))
+		}
+	}()
+	return s
+}
+
+func (s *memServer) Addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *memServer) Close() {
+	s.l.Close()
+}
+
+func (s *memServer) Set(key, value string) {
+	s.Lock()
+	defer s.Unlock()
+	s.values[key] = value
+}
+
+func (s *memServer) Get(key string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *memServer) SetTTL(key string, ttl int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.ttls[key] = ttl
+}
+
+func (s *memServer) TTL(key string) int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.ttls[key]
+}
+
+func (s *memServer) Fail(cmd, msg string) {
+	s.Lock()
+	defer s.Unlock()
+	if msg == "" {
+		delete(s.fail, cmd)
+	} else {
+		s.fail[cmd] = msg
+	}
+}
+
+//模拟slot迁移到了一个没有加载过脚本的group
+func (s *memServer) FlushScripts() {
+	s.Lock()
+	defer s.Unlock()
+	s.scripts = make(map[string]string)
+}
+
+//返回并清空收到的命令，建立连接时的SELECT以及keepalive的PING除外
+func (s *memServer) Commands() []string {
+	s.Lock()
+	defer s.Unlock()
+	var cmds = s.cmds
+	s.cmds = nil
+	return cmds
+}
+
+func (s *memServer) serve(c *redis.Conn) {
+	defer c.Close()
+	for {
+		resp, err := c.Decode()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, x := range resp.Array {
+			args = append(args, string(x.Value))
+		}
+		args[0] = strings.ToUpper(args[0])
+		s.Lock()
+		if args[0] != "SELECT" && args[0] != "PING" {
+			s.cmds = append(s.cmds, strings.Join(args, " "))
+		}
+		reply := s.exec(args)
+		s.Unlock()
+		if err := c.Encode(reply, true); err != nil {
+			return
+		}
+	}
+}
+
+func (s *memServer) exec(args []string) *redis.Resp {
+	var name = args[0]
+	if len(args) >= 2 {
+		if msg, ok := s.fail[name+" "+args[1]]; ok {
+			return redis.NewErrorf("%s", msg)
+		}
+	}
+	if msg, ok := s.fail[name]; ok {
+		return redis.NewErrorf("%s", msg)
+	}
+	var count = func(keys []string) *redis.Resp {
+		var n int
+		for _, key := range keys {
+			if _, ok := s.values[key]; ok {
+				n++
+			}
+		}
+		return redis.NewInt([]byte(strconv.Itoa(n)))
+	}
+	switch name {
+	case "GET":
+		if v, ok := s.values[args[1]]; ok {
+			return redis.NewBulkBytes([]byte(v))
+		}
+		return redis.NewBulkBytes(nil)
+	case "SET":
+		for _, opt := range args[3:] {
+			if _, ok := s.values[args[1]]; ok && strings.ToUpper(opt) == "NX" {
+				return redis.NewBulkBytes(nil)
+			}
+		}
+		s.values[args[1]] = args[2]
+	case "EXISTS":
+		return count(args[1:])
+	case "DEL":
+		reply := count(args[1:])
+		for _, key := range args[1:] {
+			delete(s.values, key)
+			delete(s.ttls, key)
+		}
+		return reply
+	case "DUMP":
+		if v, ok := s.values[args[1]]; ok {
+			return redis.NewBulkBytes([]byte("dump:" + v))
+		}
+		return redis.NewBulkBytes(nil)
+	case "PTTL":
+		var ttl int64 = -2
+		if _, ok := s.values[args[1]]; ok {
+			ttl = -1
+			if x, ok := s.ttls[args[1]]; ok {
+				ttl = x
+			}
+		}
+		return redis.NewInt([]byte(strconv.FormatInt(ttl, 10)))
+	case "RESTORE":
+		var replace = len(args) > 4 && strings.ToUpper(args[4]) == "REPLACE"
+		if _, ok := s.values[args[1]]; ok && !replace {
+			return redis.NewErrorf("BUSYKEY Target key name already exists.")
+		}
+		if !strings.HasPrefix(args[3], "dump:") {
+			return redis.NewErrorf("ERR DUMP payload version or checksum are wrong")
+		}
+		s.values[args[1]] = strings.TrimPrefix(args[3], "dump:")
+		delete(s.ttls, args[1])
+		if ttl, _ := strconv.ParseInt(args[2], 10, 64); ttl > 0 {
+			s.ttls[args[1]] = ttl
+		}
+	case "EVAL":
+		sum := sha1.Sum([]byte(args[1]))
+		s.scripts[hex.EncodeToString(sum[:])] = args[1]
+		return s.eval(args[1], args[2:])
+	case "EVALSHA":
+		if script, ok := s.scripts[strings.ToLower(args[1])]; ok {
+			return s.eval(script, args[2:])
+		}
+		return redis.NewErrorf("NOSCRIPT No matching script. Please use EVAL.")
+	case "SCRIPT":
+		if strings.ToUpper(args[1]) == "LOAD" {
+			sum := sha1.Sum([]byte(args[2]))
+			s.scripts[hex.EncodeToString(sum[:])] = args[2]
+			return redis.NewBulkBytes([]byte(hex.EncodeToString(sum[:])))
+		}
+	}
+	return redis.NewString([]byte("OK"))
+}
+
+//只支持MSETNX的解锁脚本，其他脚本返回脚本本身
+func (s *memServer) eval(script string, args []string) *redis.Resp {
+	if script != msetnxUnlockScript {
+		return redis.NewBulkBytes([]byte(script))
+	}
+	if v, ok := s.values[args[1]]; ok && v == args[2] {
+		delete(s.values, args[1])
+		return redis.NewInt([]byte("1"))
+	}
+	return redis.NewInt([]byte("0"))
+}
+
+//所有的slot都在同一个backend上
+func newMemRouter(config *Config, addr string) *Router {
+	d := NewRouter(config)
+	for i := 0; i < MaxSlotNum; i++ {
+		assert.MustNoError(d.FillSlot(&models.Slot{Id: i, BackendAddr: addr}))
+	}
+	return d
+}
+
+func newMemSession(config *Config) *Session {
+	c, _ := net.Pipe()
+	return newSession(redis.NewConn(c, 1024, 1024), config)
+}
+
+//和loopReader/loopWriter一样处理一个请求，返回给客户端的结果
+func runMemRequest(s *Session, d *Router, args ...string) *redis.Resp {
+	r := &Request{Batch: &sync.WaitGroup{}}
+	for _, arg := range args {
+		r.Multi = append(r.Multi, redis.NewBulkBytes([]byte(arg)))
+	}
+	assert.MustNoError(s.handleRequest(r, d))
+	resp, err := s.handleResponse(r)
+	assert.MustNoError(err)
+	return resp
+}
+
+func TestGetLockKey(t *testing.T) {
+	for _, key := range []string{"abc", "{tag}abc", "a{b", "a}b", "{}abc", ""} {
+		lock := getLockKey([]byte(key))
+		assert.Must(lock != nil)
+		assert.Must(string(lock) != key)
+		assert.Must(Hash(lock)%MaxSlotNum == Hash([]byte(key))%MaxSlotNum)
+	}
+	assert.Must(string(getLockKey([]byte("{tag}abc"))) == "{tag}abc"+msetnxLockSuffix)
+	assert.Must(string(getLockKey([]byte("abc"))) == "{abc}"+msetnxLockSuffix)
+}
+
+func TestCrossSlotRename(t *testing.T) {
+	server := newMemServer()
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	d := newMemRouter(config, server.Addr())
+	defer d.Close()
+	s := newMemSession(config)
+	defer s.Conn.Close()
+
+	server.Set("a", "1")
+	resp := runMemRequest(s, d, "RENAME", "a", "b")
+	assert.Must(resp.IsError() && string(resp.Value) == string(RespCrossSlot.Value))
+
+	//同一个slot的key直接转发
+	resp = runMemRequest(s, d, "RENAME", "{a}", "{a}x")
+	assert.Must(resp.IsString())
+	assert.Must(len(server.Commands()) == 1)
+	server.Set("a", "1")
+
+	config.ProxyCrossSlotEmulation = true
+	server.SetTTL("a", 5000)
+	resp = runMemRequest(s, d, "RENAME", "a", "b")
+	assert.Must(resp.IsString() && string(resp.Value) == "OK")
+	v, ok := server.Get("b")
+	assert.Must(ok && v == "1" && server.TTL("b") == 5000)
+	_, ok = server.Get("a")
+	assert.Must(!ok)
+	var cmds = server.Commands()
+	assert.Must(len(cmds) == 4 && cmds[2] == "RESTORE b 5000 dump:1 REPLACE" && cmds[3] == "DEL a")
+
+	resp = runMemRequest(s, d, "RENAME", "a", "b")
+	assert.Must(resp.IsError() && string(resp.Value) == "ERR no such key")
+
+	//RENAMENX的目标key已经存在的时候返回0，源key保持不变
+	server.Set("c", "2")
+	resp = runMemRequest(s, d, "RENAMENX", "c", "b")
+	assert.Must(resp.IsInt() && string(resp.Value) == "0")
+	v, _ = server.Get("b")
+	assert.Must(v == "1")
+	_, ok = server.Get("c")
+	assert.Must(ok)
+
+	resp = runMemRequest(s, d, "RENAMENX", "c", "e")
+	assert.Must(resp.IsInt() && string(resp.Value) == "1")
+	server.Set("c", "3")
+
+	//RESTORE失败的时候不能删除源key
+	server.Fail("RESTORE", "ERR restore failed")
+	resp = runMemRequest(s, d, "RENAME", "c", "f")
+	assert.Must(resp.IsError() && string(resp.Value) == "ERR restore failed")
+	v, _ = server.Get("c")
+	assert.Must(v == "3")
+	_, ok = server.Get("f")
+	assert.Must(!ok)
+	server.Fail("RESTORE", "")
+
+	//DEL失败的时候返回错误，目标key已经写入
+	server.Fail("DEL", "ERR del failed")
+	resp = runMemRequest(s, d, "RENAME", "c", "f")
+	assert.Must(resp.IsError() && string(resp.Value) == "ERR del failed")
+	v, _ = server.Get("f")
+	assert.Must(v == "3")
+}
+
+func TestCrossSlotMSetNX(t *testing.T) {
+	server := newMemServer()
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	config.ProxyCrossSlotEmulation = true
+	d := newMemRouter(config, server.Addr())
+	defer d.Close()
+	s := newMemSession(config)
+	defer s.Conn.Close()
+
+	var noLocks = func(keys ...string) bool {
+		for _, key := range keys {
+			if _, ok := server.Get(string(getLockKey([]byte(key)))); ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	resp := runMemRequest(s, d, "MSETNX", "a", "1", "b", "2", "a", "3")
+	assert.Must(resp.IsInt() && string(resp.Value) == "1")
+	v1, _ := server.Get("a")
+	v2, _ := server.Get("b")
+	assert.Must(v1 == "3" && v2 == "2" && noLocks("a", "b"))
+
+	//有一个key已经存在的时候不修改任何key
+	resp = runMemRequest(s, d, "MSETNX", "c", "1", "b", "3")
+	assert.Must(resp.IsInt() && string(resp.Value) == "0")
+	_, ok := server.Get("c")
+	v2, _ = server.Get("b")
+	assert.Must(!ok && v2 == "2" && noLocks("b", "c"))
+
+	//其他的MSETNX持有锁的时候返回0，释放已经拿到的锁，不能释放别人的锁
+	var lock = string(getLockKey([]byte("p")))
+	server.Set(lock, "other")
+	resp = runMemRequest(s, d, "MSETNX", "p", "1", "q", "2")
+	assert.Must(resp.IsInt() && string(resp.Value) == "0")
+	_, ok = server.Get("q")
+	assert.Must(!ok && noLocks("q"))
+	v, _ := server.Get(lock)
+	assert.Must(v == "other")
+	server.Commands()
+
+	//SET失败的时候返回错误，并且释放所有的锁
+	server.Fail("SET q", "ERR set failed")
+	resp = runMemRequest(s, d, "MSETNX", "r", "1", "q", "2")
+	assert.Must(resp.IsError() && string(resp.Value) == "ERR set failed")
+	assert.Must(noLocks("q", "r"))
+	var unlocks int
+	for _, cmd := range server.Commands() {
+		if strings.HasPrefix(cmd, "EVAL ") {
+			unlocks++
+		}
+	}
+	assert.Must(unlocks == 2)
+}
@@ -22,11 +22,13 @@ import (
 type forwardMethod interface {
 	GetId() int
 	Forward(s *Slot, r *Request, hkey []byte) error
+	ForwardPinned(s *Slot, r *Request, keys [][]byte, bc *BackendConn) error
 }
 
 var (
-	ErrSlotIsNotReady = errors.New("slot is not ready, may be offline")
-	ErrRespIsRequired = errors.New("resp is required")
+	ErrSlotIsNotReady     = errors.New("slot is not ready, may be offline")
+	ErrSlotBackendChanged = errors.New("slot backend has been changed")
+	ErrRespIsRequired     = errors.New("resp is required")
 )
 
 type forwardSync struct {
@@ -64,9 +66,46 @@ func (d *forwardSync) process(s *Slot, r *Request, hkey []byte) (*BackendConn, e
 			return nil, err
 		}
 	}
+	bc, err := d.forward2(s, r)
+	if err != nil {
+		return nil, err
+	}
 	r.Group = &s.refs
 	r.Group.Add(1)
-	return d.forward2(s, r), nil
+	return bc, nil
+}
+
+//ForwardPinned将请求发往session独占的BackendConn（比如MULTI/EXEC事务），如果slot正在迁移，需要先保证keys都已经迁移到了目标group
+func (d *forwardSync) ForwardPinned(s *Slot, r *Request, keys [][]byte, bc *BackendConn) error {
+	s.lock.RLock()
+	err := d.processPinned(s, r, keys, bc)
+	s.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	bc.PushBack(r)
+	return nil
+}
+
+func (d *forwardSync) processPinned(s *Slot, r *Request, keys [][]byte, bc *BackendConn) error {
+	if err := d.checkPinned(s, bc); err != nil {
+		return err
+	}
+	if s.migrate.bc != nil {
+		for _, hkey := range keys {
+			if err := d.slotsmgrt(s, hkey, r.Database, r.Seed16()); err != nil {
+				log.Debugf("slot-%04d migrate from = %s to %s failed: hash key = '%s', database = %d, error = %s",
+					s.id, s.migrate.bc.Addr(), s.backend.bc.Addr(), hkey, r.Database, err)
+				return err
+			}
+		}
+	}
+	//阻塞的命令不能持有slot的引用，否则fillSlot会一直等到命令超时
+	if !r.IsBlocking() {
+		r.Group = &s.refs
+		r.Group.Add(1)
+	}
+	return nil
 }
 
 type forwardSemiAsync struct {
@@ -98,16 +137,7 @@ func (d *forwardSemiAsync) Forward(s *Slot, r *Request, hkey []byte) error {
 			return nil
 		}
 
-		var delay time.Duration
-		switch {
-		case loop < 5:
-			delay = 0
-		case loop < 20:
-			delay = time.Millisecond * time.Duration(loop)
-		default:
-			delay = time.Millisecond * 20
-		}
-		time.Sleep(delay)
+		time.Sleep(d.retryDelay(loop))
 
 		if r.IsBroken() {
 			return ErrRequestIsBroken
@@ -116,6 +146,17 @@ func (d *forwardSemiAsync) Forward(s *Slot, r *Request, hkey []byte) error {
 	}
 }
 
+func (d *forwardSemiAsync) retryDelay(loop int) time.Duration {
+	switch {
+	case loop < 5:
+		return 0
+	case loop < 20:
+		return time.Millisecond * time.Duration(loop)
+	default:
+		return time.Millisecond * 20
+	}
+}
+
 func (d *forwardSemiAsync) process(s *Slot, r *Request, hkey []byte) (_ *BackendConn, retry bool, _ error) {
 	if s.backend.bc == nil {
 		log.Debugf("slot-%04d is not ready: hash key = '%s'",
@@ -138,16 +179,86 @@ func (d *forwardSemiAsync) process(s *Slot, r *Request, hkey []byte) (_ *Backend
 			return nil, true, nil
 		}
 	}
+	//获取slot对应的BackendConn
+	bc, err := d.forward2(s, r)
+	if err != nil {
+		return nil, false, err
+	}
 	r.Group = &s.refs
 	//Request.Group = Slot.Group,表明当前的slot正在处理的Request+1
 	r.Group.Add(1)
-	//获取slot对应的BackendConn
-	return d.forward2(s, r), false, nil
+	return bc, false, nil
+}
+
+//异步迁移时不能强制迁移单个key，只能等待keys全部被迁移到目标group之后再发往独占的BackendConn
+func (d *forwardSemiAsync) ForwardPinned(s *Slot, r *Request, keys [][]byte, bc *BackendConn) error {
+	var loop int
+	for {
+		s.lock.RLock()
+		retry, err := d.processPinned(s, r, keys, bc)
+		s.lock.RUnlock()
+
+		switch {
+		case err != nil:
+			return err
+		case !retry:
+			bc.PushBack(r)
+			return nil
+		}
+
+		time.Sleep(d.retryDelay(loop))
+
+		if r.IsBroken() {
+			return ErrRequestIsBroken
+		}
+		loop += 1
+	}
+}
+
+func (d *forwardSemiAsync) processPinned(s *Slot, r *Request, keys [][]byte, bc *BackendConn) (retry bool, _ error) {
+	if err := d.checkPinned(s, bc); err != nil {
+		return false, err
+	}
+	if s.migrate.bc != nil {
+		for _, hkey := range keys {
+			multi := []*redis.Resp{
+				redis.NewBulkBytes([]byte("EXISTS")),
+				redis.NewBulkBytes(hkey),
+			}
+			_, moved, err := d.slotsmgrtExecWrapper(s, hkey, r.Database, r.Seed16(), multi)
+			switch {
+			case err != nil:
+				log.Debugf("slot-%04d migrate from = %s to %s failed: hash key = '%s', error = %s",
+					s.id, s.migrate.bc.Addr(), s.backend.bc.Addr(), hkey, err)
+				return false, err
+			case !moved:
+				return true, nil
+			}
+		}
+	}
+	if !r.IsBlocking() {
+		r.Group = &s.refs
+		r.Group.Add(1)
+	}
+	return false, nil
 }
 
 type forwardHelper struct {
 }
 
+func (d *forwardHelper) checkPinned(s *Slot, bc *BackendConn) error {
+	switch {
+	case s.backend.bc == nil:
+		log.Debugf("slot-%04d is not ready", s.id)
+		return ErrSlotIsNotReady
+	case s.backend.bc.Addr() != bc.Addr():
+		return ErrSlotBackendChanged
+	case !s.backend.bc.breaker.Allow():
+		return ErrBackendCircuitOpen
+	}
+	return nil
+}
+
 func (d *forwardHelper) slotsmgrt(s *Slot, hkey []byte, database int32, seed uint) error {
 	m := &Request{}
 	m.Multi = []*redis.Resp{
@@ -231,19 +342,35 @@ func (d *forwardHelper) slotsmgrtExecWrapper(s *Slot, hkey []byte, database int3
 
 //无论是forwardSync还是forwardSemiAsync，在process的过程中，
 //都要调用的forward2方法来从Slot获取真正处理redis请求的BackendConn
-func (d *forwardHelper) forward2(s *Slot, r *Request) *BackendConn {
+//
+//master的熔断器打开的时候，只读请求即使在backend_primary_only的时候也降级到replica group；
+//剩下的请求直接返回错误，标记为master only的只读请求（SCAN的游标、WATCH、read-your-writes等）不能发往replica
+func (d *forwardHelper) forward2(s *Slot, r *Request) (*BackendConn, error) {
 	var database, seed = r.Database, r.Seed16()
-	if s.migrate.bc == nil && !r.IsMasterOnly() && len(s.replicaGroups) != 0 {
-		for _, group := range s.replicaGroups {
-			var i = seed
-			for range group {
-				i = (i + 1) % uint(len(group))
-				if bc := group[i].BackendConn(database, seed, false); bc != nil {
-					return bc
-				}
+	var replica = s.migrate.bc == nil && !r.IsMasterOnly()
+	if replica && !s.primaryOnly {
+		if bc := d.forwardReplica(s, database, seed, r.UnixNano); bc != nil {
+			return bc, nil
+		}
+	}
+	if !s.backend.bc.breaker.Allow() {
+		if replica && s.primaryOnly {
+			if bc := d.forwardReplica(s, database, seed, r.UnixNano); bc != nil {
+				return bc, nil
 			}
 		}
+		return nil, ErrBackendCircuitOpen
 	}
 	//从sharedBackendConn中取出一个BackendConn（sharedBackendConn中储存了BackendConn组成的二维切片）
-	return s.backend.bc.BackendConn(database, seed, true)
+	return s.backend.bc.BackendConn(database, seed, true), nil
+}
+
+//按照dashboard给出的顺序依次尝试每个replica group，在group内部选择延迟最低的健康的replica
+func (d *forwardHelper) forwardReplica(s *Slot, database int32, seed uint, now int64) *BackendConn {
+	for _, group := range s.replicaGroups {
+		if bc := pickReplica(group, database, seed, now); bc != nil {
+			return bc
+		}
+	}
+	return nil
 }
@@ -0,0 +1,182 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"sort"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+)
+
+//延迟直方图的实现：
+//1. 和HDR Histogram类似，按照微秒计数，2的每个幂次之间再均分成8个桶，相对误差不超过12.5%，最大记录约71分钟；
+//2. 每个命令以及每个backend各有一个直方图，命令的耗时和opStats一样从Request.UnixNano开始计算，
+//   backend的耗时是请求写入backend到收到返回的时间；
+//3. stats中只包含计数不为0的桶（桶的上界以及计数），dashboard将所有proxy的桶合并之后再计算整个集群的百分位；
+//4. 每个直方图有两个窗口（每个一分钟）轮流使用，进入新的窗口的时候清空更早的那个，stats只包含最近一到两分钟的计数，
+//   百分位反映的是最近的延迟而不是启动以来的累计。
+
+const (
+	histogramSubBits = 3
+	histogramMaxBits = 32
+
+	histogramSubCount = 1 << histogramSubBits
+	histogramBuckets  = (histogramMaxBits - histogramSubBits + 1) << histogramSubBits
+
+	histogramWindow = int64(time.Minute)
+)
+
+func bitLen(x uint64) int {
+	var n int
+	for ; x != 0; x >>= 1 {
+		n++
+	}
+	return n
+}
+
+func histogramIndex(usecs int64) int {
+	switch {
+	case usecs < 0:
+		usecs = 0
+	case usecs >= 1<<histogramMaxBits:
+		usecs = 1<<histogramMaxBits - 1
+	}
+	if usecs < histogramSubCount {
+		return int(usecs)
+	}
+	var n = bitLen(uint64(usecs)) - 1 - histogramSubBits
+	return (n+1)<<histogramSubBits + int(usecs>>uint(n)) - histogramSubCount
+}
+
+//桶中最大的值，百分位使用桶的上界，结果不会比真实的值小
+func histogramUpperBound(index int) int64 {
+	if index < histogramSubCount {
+		return int64(index)
+	}
+	var n = uint(index>>histogramSubBits - 1)
+	var m = int64(index&(histogramSubCount-1) + histogramSubCount)
+	return (m+1)<<n - 1
+}
+
+type histogram struct {
+	//当前窗口的编号，counts[epoch&1]是当前窗口，另一个是上一个窗口
+	epoch  atomic2.Int64
+	counts [2][histogramBuckets]atomic2.Int64
+}
+
+func (h *histogram) Record(usecs int64) {
+	h.record(usecs, time.Now().UnixNano())
+}
+
+func (h *histogram) record(usecs int64, now int64) {
+	var epoch = h.rotate(now)
+	h.counts[epoch&1][histogramIndex(usecs)].Incr()
+}
+
+//切换到now所在的窗口，清空已经过期的窗口，返回当前窗口的编号
+func (h *histogram) rotate(now int64) int64 {
+	var epoch = now / histogramWindow
+	for {
+		var last = h.epoch.Int64()
+		if epoch <= last {
+			return last
+		}
+		if h.epoch.CompareAndSwap(last, epoch) {
+			h.clear(int(epoch & 1))
+			if epoch-last > 1 {
+				h.clear(int(epoch+1) & 1)
+			}
+			return epoch
+		}
+	}
+}
+
+func (h *histogram) clear(i int) {
+	for j := range h.counts[i] {
+		h.counts[i][j].Set(0)
+	}
+}
+
+func (h *histogram) Reset() {
+	h.clear(0)
+	h.clear(1)
+}
+
+func (h *histogram) Histogram() *Histogram {
+	return h.snapshot(time.Now().UnixNano())
+}
+
+func (h *histogram) snapshot(now int64) *Histogram {
+	h.rotate(now)
+	var buckets [][2]int64
+	for i := 0; i < histogramBuckets; i++ {
+		if n := h.counts[0][i].Int64() + h.counts[1][i].Int64(); n != 0 {
+			buckets = append(buckets, [2]int64{histogramUpperBound(i), n})
+		}
+	}
+	return NewHistogram(buckets)
+}
+
+type Histogram struct {
+	Count int64 `json:"count"`
+	P50   int64 `json:"p50"`
+	P90   int64 `json:"p90"`
+	P99   int64 `json:"p99"`
+	P999  int64 `json:"p999"`
+	Max   int64 `json:"max"`
+
+	//按照上界排序的[上界（微秒）, 计数]
+	Buckets [][2]int64 `json:"buckets,omitempty"`
+}
+
+func NewHistogram(buckets [][2]int64) *Histogram {
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i][0] < buckets[j][0]
+	})
+	h := &Histogram{Buckets: buckets}
+	for _, b := range buckets {
+		h.Count += b[1]
+	}
+	if len(buckets) != 0 {
+		h.Max = buckets[len(buckets)-1][0]
+	}
+	h.P50 = h.Percentile(0.50)
+	h.P90 = h.Percentile(0.90)
+	h.P99 = h.Percentile(0.99)
+	h.P999 = h.Percentile(0.999)
+	return h
+}
+
+func (h *Histogram) Percentile(q float64) int64 {
+	var rank = int64(q*float64(h.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var sum int64
+	for _, b := range h.Buckets {
+		if sum += b[1]; sum >= rank {
+			return b[0]
+		}
+	}
+	return h.Max
+}
+
+//合并多个直方图，用于汇总所有proxy的结果
+func MergeHistograms(list ...*Histogram) *Histogram {
+	var counts = make(map[int64]int64)
+	for _, h := range list {
+		if h == nil {
+			continue
+		}
+		for _, b := range h.Buckets {
+			counts[b[0]] += b[1]
+		}
+	}
+	var buckets = make([][2]int64, 0, len(counts))
+	for v, n := range counts {
+		buckets = append(buckets, [2]int64{v, n})
+	}
+	return NewHistogram(buckets)
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestHistogramIndex(t *testing.T) {
+	var last = -1
+	for v := int64(0); v < 1<<20; v++ {
+		i := histogramIndex(v)
+		assert.Must(i == last || i == last+1)
+		assert.Must(v <= histogramUpperBound(i))
+		if i != 0 {
+			assert.Must(v > histogramUpperBound(i-1))
+		}
+		last = i
+	}
+	assert.Must(histogramIndex(-1) == 0)
+	assert.Must(histogramIndex(1<<62) == histogramBuckets-1)
+	assert.Must(histogramUpperBound(histogramBuckets-1) == 1<<histogramMaxBits-1)
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	h := &histogram{}
+	for v := int64(1); v <= 1000; v++ {
+		h.Record(v)
+	}
+	x := h.Histogram()
+	assert.Must(x.Count == 1000)
+	assert.Must(x.P50 >= 500 && x.P50 < 500*9/8)
+	assert.Must(x.P99 >= 990 && x.P99 < 990*9/8)
+	assert.Must(x.Max >= 1000 && x.Max < 1000*9/8)
+
+	m := MergeHistograms(x, x, nil)
+	assert.Must(m.Count == 2000)
+	assert.Must(m.P50 == x.P50 && m.P99 == x.P99 && m.Max == x.Max)
+
+	assert.Must(MergeHistograms().P99 == 0)
+}
+
+func TestHistogramWindow(t *testing.T) {
+	h := &histogram{}
+	var now = histogramWindow * 100
+	h.record(1000, now)
+	h.record(1000, now+histogramWindow/2)
+	assert.Must(h.snapshot(now+histogramWindow/2).Count == 2)
+
+	//上一个窗口的计数仍然保留
+	h.record(10, now+histogramWindow)
+	x := h.snapshot(now + histogramWindow)
+	assert.Must(x.Count == 3 && x.P50 >= 1000)
+
+	h.record(10, now+histogramWindow*2)
+	x = h.snapshot(now + histogramWindow*2)
+	assert.Must(x.Count == 2 && x.Max < 1000)
+
+	assert.Must(h.snapshot(now+histogramWindow*4).Count == 0)
+
+	h.record(10, now+histogramWindow*4)
+	h.Reset()
+	assert.Must(h.snapshot(now+histogramWindow*4).Count == 0)
+}
+
+func TestBackendLatencyRelease(t *testing.T) {
+	const addr = "backend-latency"
+	h1 := getBackendLatency(addr)
+	h2 := getBackendLatency(addr)
+	assert.Must(h1 == h2)
+
+	putBackendLatency(addr)
+	assert.Must(backendstats.latency[addr] != nil)
+	putBackendLatency(addr)
+	assert.Must(backendstats.latency[addr] == nil)
+}
@@ -0,0 +1,268 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+//热点key的统计：
+//1. session按照proxy_hotkey_sample_rate对请求中的key进行采样，采样的key计入count-min sketch，
+//   sketch估计的次数和一个大小为proxy_hotkey_top_k的小顶堆一起维护当前最热的key；
+//2. 每隔proxy_hotkey_decay_period，sketch和堆中的计数都减半，所以统计结果反映的是最近一段时间的访问；
+//3. 上报的次数是采样次数除以采样率之后的估计值，dashboard会把所有proxy的结果按照key汇总；
+//4. 每个Router有自己的统计，没有key或者不是针对某个key访问的命令不参与统计。
+
+const (
+	hotKeySketchDepth = 4
+	hotKeySketchWidth = 4096
+	hotKeyMaxLength   = 256
+
+	//减半这么多次之后所有的计数都是0
+	hotKeyMaxDecays = 32
+)
+
+type HotKey struct {
+	Key   string `json:"key"`
+	Slot  int    `json:"slot"`
+	Count int64  `json:"count"`
+
+	GroupId     int    `json:"group_id,omitempty"`
+	BackendAddr string `json:"backend_addr,omitempty"`
+}
+
+type countMinSketch struct {
+	rows [hotKeySketchDepth][]uint32
+}
+
+func newCountMinSketch() *countMinSketch {
+	s := &countMinSketch{}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, hotKeySketchWidth)
+	}
+	return s
+}
+
+func (s *countMinSketch) add(key []byte) uint32 {
+	h := fnv.New64a()
+	h.Write(key)
+	var sum = h.Sum64()
+	var h1, h2 = uint32(sum), uint32(sum >> 32)
+	var min uint32
+	for i := range s.rows {
+		row := s.rows[i]
+		j := (h1 + uint32(i)*h2) % uint32(len(row))
+		if row[j] != ^uint32(0) {
+			row[j]++
+		}
+		if i == 0 || row[j] < min {
+			min = row[j]
+		}
+	}
+	return min
+}
+
+func (s *countMinSketch) decay() {
+	for i := range s.rows {
+		row := s.rows[i]
+		for j := range row {
+			row[j] >>= 1
+		}
+	}
+}
+
+type hotKeyItem struct {
+	key   string
+	slot  int
+	count uint32
+	index int
+}
+
+type hotKeyHeap []*hotKeyItem
+
+func (h hotKeyHeap) Len() int {
+	return len(h)
+}
+
+func (h hotKeyHeap) Less(i, j int) bool {
+	return h[i].count < h[j].count
+}
+
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	item := x.(*hotKeyItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+type hotKeyTracker struct {
+	mu sync.Mutex
+
+	topk   int
+	rate   float64
+	period time.Duration
+
+	sketch *countMinSketch
+	items  hotKeyHeap
+	index  map[string]*hotKeyItem
+	decay  time.Time
+}
+
+func newHotKeyTracker(topk int, rate float64, period time.Duration) *hotKeyTracker {
+	return &hotKeyTracker{
+		topk: topk, rate: rate, period: period,
+		sketch: newCountMinSketch(),
+		index:  make(map[string]*hotKeyItem),
+		decay:  time.Now(),
+	}
+}
+
+func (t *hotKeyTracker) sample() bool {
+	return t.rate >= 1 || rand.Float64() < t.rate
+}
+
+func (t *hotKeyTracker) add(key []byte) {
+	var slot = int(Hash(key) % MaxSlotNum)
+	if len(key) > hotKeyMaxLength {
+		key = key[:hotKeyMaxLength]
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.decayIfNeeded(time.Now())
+
+	count := t.sketch.add(key)
+	if item := t.index[string(key)]; item != nil {
+		item.count = count
+		heap.Fix(&t.items, item.index)
+		return
+	}
+	switch {
+	case len(t.items) < t.topk:
+		item := &hotKeyItem{key: string(key), slot: slot, count: count}
+		heap.Push(&t.items, item)
+		t.index[item.key] = item
+	case count > t.items[0].count:
+		item := t.items[0]
+		delete(t.index, item.key)
+		item.key, item.slot, item.count = string(key), slot, count
+		t.index[item.key] = item
+		heap.Fix(&t.items, 0)
+	}
+}
+
+//所有计数同时减半，不会改变堆中元素的顺序；堆为空的时候sketch也要减半
+func (t *hotKeyTracker) decayIfNeeded(now time.Time) {
+	if t.period <= 0 || now.Sub(t.decay) < t.period {
+		return
+	}
+	var n = now.Sub(t.decay) / t.period
+	t.decay = t.decay.Add(n * t.period)
+	if n >= hotKeyMaxDecays {
+		t.sketch = newCountMinSketch()
+		t.items = t.items[:0]
+		t.index = make(map[string]*hotKeyItem)
+		return
+	}
+	for ; n != 0; n-- {
+		t.sketch.decay()
+		var items = t.items[:0]
+		for _, item := range t.items {
+			if item.count >>= 1; item.count != 0 {
+				item.index = len(items)
+				items = append(items, item)
+			} else {
+				delete(t.index, item.key)
+			}
+		}
+		t.items = items
+		heap.Init(&t.items)
+	}
+}
+
+func (t *hotKeyTracker) Reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sketch = newCountMinSketch()
+	t.items = nil
+	t.index = make(map[string]*hotKeyItem)
+	t.decay = time.Now()
+}
+
+func (t *hotKeyTracker) HotKeys() []*HotKey {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.decayIfNeeded(time.Now())
+
+	var keys = make([]*HotKey, 0, len(t.items))
+	for _, item := range t.items {
+		keys = append(keys, &HotKey{
+			Key:   item.key,
+			Slot:  item.slot,
+			Count: int64(float64(item.count)/t.rate + 0.5),
+		})
+	}
+	sort.Sort(sliceHotKeys(keys))
+	return keys
+}
+
+type sliceHotKeys []*HotKey
+
+func (s sliceHotKeys) Len() int {
+	return len(s)
+}
+
+func (s sliceHotKeys) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s sliceHotKeys) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Key < s[j].Key
+}
+
+//没有配置的时候返回nil
+func newHotKeyTrackerFromConfig(config *Config) *hotKeyTracker {
+	if config.ProxyHotKeyTopK <= 0 || config.ProxyHotKeySampleRate <= 0 {
+		return nil
+	}
+	return newHotKeyTracker(config.ProxyHotKeyTopK,
+		config.ProxyHotKeySampleRate, config.ProxyHotKeyDecayPeriod.Duration())
+}
+
+func (s *Session) sampleHotKeys(r *Request, d *Router) {
+	t := d.hotkeys
+	if t == nil || isKeylessCommand(r.OpStr) {
+		return
+	}
+	for _, key := range getHashKeys(r.Multi, r.OpStr) {
+		if t.sample() {
+			t.add(key)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestHotKeyTracker(t *testing.T) {
+	tracker := newHotKeyTracker(3, 1, 0)
+	for i := 0; i < 10; i++ {
+		for j := 0; j <= i; j++ {
+			tracker.add([]byte(fmt.Sprintf("key-%d", i)))
+		}
+	}
+	keys := tracker.HotKeys()
+	assert.Must(len(keys) == 3)
+	for i, k := range keys {
+		assert.Must(k.Key == fmt.Sprintf("key-%d", 9-i))
+		assert.Must(k.Count == int64(10-i))
+		assert.Must(k.Slot == int(Hash([]byte(k.Key))%MaxSlotNum))
+	}
+}
+
+func TestHotKeyTrackerDecay(t *testing.T) {
+	tracker := newHotKeyTracker(4, 0.5, time.Minute)
+	for i := 0; i < 8; i++ {
+		tracker.add([]byte("hot"))
+	}
+	tracker.add([]byte("cold"))
+
+	keys := tracker.HotKeys()
+	assert.Must(len(keys) == 2)
+	assert.Must(keys[0].Key == "hot" && keys[0].Count == 16)
+	assert.Must(keys[1].Key == "cold" && keys[1].Count == 2)
+
+	tracker.mu.Lock()
+	tracker.decayIfNeeded(tracker.decay.Add(time.Minute))
+	tracker.mu.Unlock()
+
+	keys = tracker.HotKeys()
+	assert.Must(len(keys) == 1)
+	assert.Must(keys[0].Key == "hot" && keys[0].Count == 8)
+}
+
+func TestHotKeyTrackerDecayEmpty(t *testing.T) {
+	tracker := newHotKeyTracker(4, 1, time.Minute)
+	tracker.add([]byte("key"))
+
+	//堆已经为空之后sketch也要继续减半
+	tracker.mu.Lock()
+	tracker.decayIfNeeded(tracker.decay.Add(time.Minute))
+	assert.Must(len(tracker.items) == 0)
+	tracker.decayIfNeeded(tracker.decay.Add(time.Minute))
+	tracker.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		tracker.add([]byte("key"))
+	}
+	keys := tracker.HotKeys()
+	assert.Must(len(keys) == 1 && keys[0].Count == 3)
+
+	tracker.mu.Lock()
+	tracker.decayIfNeeded(tracker.decay.Add(time.Minute * hotKeyMaxDecays))
+	tracker.mu.Unlock()
+	assert.Must(len(tracker.HotKeys()) == 0)
+	tracker.add([]byte("key"))
+	assert.Must(tracker.HotKeys()[0].Count == 1)
+}
+
+func TestHotKeySample(t *testing.T) {
+	config := NewDefaultConfig()
+	config.ProxyHotKeyTopK = 4
+	config.ProxyHotKeySampleRate = 1
+	d := NewRouter(config)
+	s := &Session{}
+
+	s.sampleHotKeys(newTestRequest("PING", "hello"), d)
+	s.sampleHotKeys(newTestRequest("PUBLISH", "channel", "hello"), d)
+	s.sampleHotKeys(newTestRequest("SCAN", "0"), d)
+	s.sampleHotKeys(newTestRequest("GET", "key"), d)
+	keys := d.hotkeys.HotKeys()
+	assert.Must(len(keys) == 1 && keys[0].Key == "key")
+
+	d.hotkeys.Reset()
+	assert.Must(len(d.hotkeys.HotKeys()) == 0)
+
+	config.ProxyHotKeyTopK = 0
+	assert.Must(NewRouter(config).hotkeys == nil)
+}
@@ -24,6 +24,8 @@ func init() {
 			charmap[i] = c - 'a' + 'A'
 		case c == ':':
 			charmap[i] = ':'
+		case c == '.':
+			charmap[i] = '.'
 		}
 	}
 }
@@ -39,6 +41,10 @@ func (f OpFlag) IsReadOnly() bool {
 	return (f & mask) == 0
 }
 
+func (f OpFlag) IsBlocking() bool {
+	return (f & FlagBlocking) != 0
+}
+
 func (f OpFlag) IsMasterOnly() bool {
 	const mask = FlagWrite | FlagMayWrite | FlagMasterOnly
 	return (f & mask) != 0
@@ -54,6 +60,7 @@ const (
 	FlagMasterOnly
 	FlagMayWrite
 	FlagNotAllow
+	FlagBlocking
 )
 
 var opTable = make(map[string]OpInfo, 256)
@@ -69,11 +76,12 @@ func init() {
 		{"BITFIELD", FlagWrite},
 		{"BITOP", FlagWrite | FlagNotAllow},
 		{"BITPOS", 0},
-		{"BLPOP", FlagWrite | FlagNotAllow},
-		{"BRPOP", FlagWrite | FlagNotAllow},
-		{"BRPOPLPUSH", FlagWrite | FlagNotAllow},
+		{"BLPOP", FlagWrite | FlagBlocking},
+		{"BRPOP", FlagWrite | FlagBlocking},
+		{"BRPOPLPUSH", FlagWrite | FlagBlocking},
 		{"CLIENT", FlagNotAllow},
 		{"CLUSTER", FlagNotAllow},
+		{"CODIS.CONSISTENCY", 0},
 		{"COMMAND", 0},
 		{"CONFIG", FlagNotAllow},
 		{"DBSIZE", FlagNotAllow},
@@ -81,12 +89,12 @@ func init() {
 		{"DECR", FlagWrite},
 		{"DECRBY", FlagWrite},
 		{"DEL", FlagWrite},
-		{"DISCARD", FlagNotAllow},
+		{"DISCARD", 0},
 		{"DUMP", 0},
 		{"ECHO", 0},
 		{"EVAL", FlagWrite},
 		{"EVALSHA", FlagWrite},
-		{"EXEC", FlagNotAllow},
+		{"EXEC", FlagWrite},
 		{"EXISTS", 0},
 		{"EXPIRE", FlagWrite},
 		{"EXPIREAT", FlagWrite},
@@ -103,6 +111,7 @@ func init() {
 		{"GETRANGE", 0},
 		{"GETSET", FlagWrite},
 		{"HDEL", FlagWrite},
+		{"HELLO", 0},
 		{"HEXISTS", 0},
 		{"HGET", 0},
 		{"HGETALL", 0},
@@ -122,7 +131,7 @@ func init() {
 		{"INCRBY", FlagWrite},
 		{"INCRBYFLOAT", FlagWrite},
 		{"INFO", 0},
-		{"KEYS", FlagNotAllow},
+		{"KEYS", FlagMasterOnly},
 		{"LASTSAVE", FlagNotAllow},
 		{"LATENCY", FlagNotAllow},
 		{"LINDEX", 0},
@@ -140,8 +149,8 @@ func init() {
 		{"MONITOR", FlagNotAllow},
 		{"MOVE", FlagWrite | FlagNotAllow},
 		{"MSET", FlagWrite},
-		{"MSETNX", FlagWrite | FlagNotAllow},
-		{"MULTI", FlagNotAllow},
+		{"MSETNX", FlagWrite},
+		{"MULTI", 0},
 		{"OBJECT", FlagNotAllow},
 		{"PERSIST", FlagWrite},
 		{"PEXPIRE", FlagWrite},
@@ -154,18 +163,18 @@ func init() {
 		{"PING", 0},
 		{"POST", FlagNotAllow},
 		{"PSETEX", FlagWrite},
-		{"PSUBSCRIBE", FlagNotAllow},
+		{"PSUBSCRIBE", 0},
 		{"PSYNC", FlagNotAllow},
 		{"PTTL", 0},
-		{"PUBLISH", FlagNotAllow},
+		{"PUBLISH", FlagMasterOnly},
 		{"PUBSUB", 0},
-		{"PUNSUBSCRIBE", FlagNotAllow},
+		{"PUNSUBSCRIBE", 0},
 		{"QUIT", 0},
 		{"RANDOMKEY", FlagNotAllow},
 		{"READONLY", FlagNotAllow},
 		{"READWRITE", FlagNotAllow},
-		{"RENAME", FlagWrite | FlagNotAllow},
-		{"RENAMENX", FlagWrite | FlagNotAllow},
+		{"RENAME", FlagWrite},
+		{"RENAMENX", FlagWrite},
 		{"REPLCONF", FlagNotAllow},
 		{"RESTORE", FlagWrite | FlagNotAllow},
 		{"RESTORE-ASKING", FlagWrite | FlagNotAllow},
@@ -176,9 +185,9 @@ func init() {
 		{"RPUSHX", FlagWrite},
 		{"SADD", FlagWrite},
 		{"SAVE", FlagNotAllow},
-		{"SCAN", FlagMasterOnly | FlagNotAllow},
+		{"SCAN", FlagMasterOnly},
 		{"SCARD", 0},
-		{"SCRIPT", FlagNotAllow},
+		{"SCRIPT", FlagMasterOnly},
 		{"SDIFF", 0},
 		{"SDIFFSTORE", FlagWrite},
 		{"SELECT", 0},
@@ -214,7 +223,7 @@ func init() {
 		{"SLOTSRESTORE-ASYNC-AUTH", FlagWrite | FlagNotAllow},
 		{"SLOTSRESTORE-ASYNC-ACK", FlagWrite | FlagNotAllow},
 		{"SLOTSSCAN", FlagMasterOnly},
-		{"SLOWLOG", FlagNotAllow},
+		{"SLOWLOG", 0},
 		{"SMEMBERS", 0},
 		{"SMOVE", FlagWrite},
 		{"SORT", FlagWrite},
@@ -223,7 +232,7 @@ func init() {
 		{"SREM", FlagWrite},
 		{"SSCAN", FlagMasterOnly},
 		{"STRLEN", 0},
-		{"SUBSCRIBE", FlagNotAllow},
+		{"SUBSCRIBE", 0},
 		{"SUBSTR", 0},
 		{"SUNION", 0},
 		{"SUNIONSTORE", FlagWrite},
@@ -232,10 +241,10 @@ func init() {
 		{"TOUCH", FlagWrite},
 		{"TTL", 0},
 		{"TYPE", 0},
-		{"UNSUBSCRIBE", FlagNotAllow},
-		{"UNWATCH", FlagNotAllow},
+		{"UNSUBSCRIBE", 0},
+		{"UNWATCH", 0},
 		{"WAIT", FlagNotAllow},
-		{"WATCH", FlagNotAllow},
+		{"WATCH", FlagMasterOnly},
 		{"ZADD", FlagWrite},
 		{"ZCARD", 0},
 		{"ZCOUNT", 0},
@@ -318,3 +327,60 @@ func getHashKey(multi []*redis.Resp, opstr string) []byte {
 	}
 	return nil
 }
+
+func getHashKeys(multi []*redis.Resp, opstr string) [][]byte {
+	var keys [][]byte
+	switch opstr {
+	case "MGET", "DEL", "EXISTS", "TOUCH", "UNLINK", "WATCH",
+		"SDIFF", "SDIFFSTORE", "SINTER", "SINTERSTORE", "SUNION", "SUNIONSTORE",
+		"PFCOUNT", "PFMERGE", "RENAME", "RENAMENX", "RPOPLPUSH":
+		for i := 1; i < len(multi); i++ {
+			keys = append(keys, multi[i].Value)
+		}
+	case "SMOVE", "BRPOPLPUSH":
+		for i := 1; i < len(multi) && i <= 2; i++ {
+			keys = append(keys, multi[i].Value)
+		}
+	case "BLPOP", "BRPOP":
+		for i := 1; i < len(multi)-1; i++ {
+			keys = append(keys, multi[i].Value)
+		}
+	case "MSET", "MSETNX":
+		for i := 1; i < len(multi); i += 2 {
+			keys = append(keys, multi[i].Value)
+		}
+	case "ZINTERSTORE", "ZUNIONSTORE", "EVAL", "EVALSHA":
+		if opstr == "ZINTERSTORE" || opstr == "ZUNIONSTORE" {
+			if len(multi) > 1 {
+				keys = append(keys, multi[1].Value)
+			}
+		}
+		if len(multi) <= 2 {
+			break
+		}
+		if n, err := redis.Btoi64(multi[2].Value); err == nil && n > 0 {
+			for i := 3; i < len(multi) && i < 3+int(n); i++ {
+				keys = append(keys, multi[i].Value)
+			}
+		}
+	default:
+		if key := getHashKey(multi, opstr); key != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func getHashSlot(keys [][]byte) (slot int, ok bool) {
+	slot = -1
+	for _, key := range keys {
+		id := int(Hash(key) % MaxSlotNum)
+		switch {
+		case slot < 0:
+			slot = id
+		case slot != id:
+			return -1, false
+		}
+	}
+	return slot, true
+}
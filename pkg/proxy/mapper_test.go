@@ -4,6 +4,7 @@
 package proxy
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/thesunnysky/codis/pkg/proxy/redis"
@@ -163,3 +164,45 @@ func TestHashSlot(t *testing.T) {
 		assert.Must(i == j)
 	}
 }
+
+func TestGetHashKeys(t *testing.T) {
+	makeMulti := func(args ...string) []*redis.Resp {
+		var multi []*redis.Resp
+		for _, s := range args {
+			multi = append(multi, redis.NewBulkBytes([]byte(s)))
+		}
+		return multi
+	}
+	var m = map[string][]string{
+		"GET a":                    {"a"},
+		"MGET a b c":               {"a", "b", "c"},
+		"MSET a 1 b 2":             {"a", "b"},
+		"BLPOP a b 10":             {"a", "b"},
+		"SMOVE a b m":              {"a", "b"},
+		"ZUNIONSTORE d 2 a b":      {"d", "a", "b"},
+		"EVAL script 2 a b argv":   {"a", "b"},
+		"EVALSHA sha 0 argv argv2": nil,
+		"PING":                     nil,
+	}
+	for k, v := range m {
+		var multi = makeMulti(strings.Split(k, " ")...)
+		opstr, _, err := getOpInfo(multi)
+		assert.MustNoError(err)
+		keys := getHashKeys(multi, opstr)
+		assert.Must(len(keys) == len(v))
+		for i := range keys {
+			assert.Must(string(keys[i]) == v[i])
+		}
+	}
+}
+
+func TestGetHashSlot(t *testing.T) {
+	slot, ok := getHashSlot(nil)
+	assert.Must(ok && slot == -1)
+
+	slot, ok = getHashSlot([][]byte{[]byte("{abc}1"), []byte("{abc}2")})
+	assert.Must(ok && slot == int(Hash([]byte("abc"))%MaxSlotNum))
+
+	_, ok = getHashSlot([][]byte{[]byte("{abc}1"), []byte("{abd}2")})
+	assert.Must(!ok)
+}
@@ -82,7 +82,7 @@ func (p *Proxy) startMetricsInfluxdb() {
 			return errors.Trace(err)
 		}
 		model := p.Model()
-		stats := p.Stats(StatsRuntime)
+		stats := p.Stats(StatsRuntime | StatsCmds)
 
 		tags := map[string]string{
 			"token":        model.Token,
@@ -107,11 +107,32 @@ func (p *Proxy) startMetricsInfluxdb() {
 			"runtime_num_cgo_call":     stats.Runtime.NumCgoCall,
 			"runtime_num_mem_offheap":  stats.Runtime.MemOffheap,
 		}
-		p, err := influxdbClient.NewPoint("codis_usage", tags, fields, time.Now())
+		now := time.Now()
+		p, err := influxdbClient.NewPoint("codis_usage", tags, fields, now)
 		if err != nil {
 			return errors.Trace(err)
 		}
 		b.AddPoint(p)
+
+		for _, cmd := range stats.Ops.Cmd {
+			if cmd.Latency == nil {
+				continue
+			}
+			p, err := influxdbClient.NewPoint("codis_cmd_latency",
+				latencyTags(tags, "opstr", cmd.OpStr), latencyFields(cmd.Latency), now)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			b.AddPoint(p)
+		}
+		for _, backend := range stats.Backend.Latency {
+			p, err := influxdbClient.NewPoint("codis_backend_latency",
+				latencyTags(tags, "backend_addr", backend.Addr), latencyFields(backend.Latency), now)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			b.AddPoint(p)
+		}
 		return c.Write(b)
 	}, func() error {
 		return c.Close()
@@ -139,7 +160,7 @@ func (p *Proxy) startMetricsStatsd() {
 
 	p.startMetricsReporter(period, func() error {
 		model := p.Model()
-		stats := p.Stats(StatsRuntime)
+		stats := p.Stats(StatsRuntime | StatsCmds)
 
 		segs := []string{
 			prefix, model.ProductName,
@@ -166,9 +187,40 @@ func (p *Proxy) startMetricsStatsd() {
 		for key, value := range fields {
 			c.Gauge(strings.Join(append(segs, key), "."), value)
 		}
+		for _, cmd := range stats.Ops.Cmd {
+			if cmd.Latency == nil {
+				continue
+			}
+			for key, value := range latencyFields(cmd.Latency) {
+				c.Gauge(strings.Join(append(segs, "cmd_latency", cmd.OpStr, key), "."), value)
+			}
+		}
+		for _, backend := range stats.Backend.Latency {
+			for key, value := range latencyFields(backend.Latency) {
+				c.Gauge(strings.Join(append(segs, "backend_latency", replacer.Replace(backend.Addr), key), "."), value)
+			}
+		}
 		return nil
 	}, func() error {
 		c.Close()
 		return nil
 	})
 }
+
+func latencyTags(tags map[string]string, key, value string) map[string]string {
+	var m = make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		m[k] = v
+	}
+	m[key] = value
+	return m
+}
+
+func latencyFields(h *Histogram) map[string]interface{} {
+	return map[string]interface{}{
+		"count":     h.Count,
+		"p50_usecs": h.P50, "p90_usecs": h.P90,
+		"p99_usecs": h.P99, "p999_usecs": h.P999,
+		"max_usecs": h.Max,
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/math2"
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+)
+
+//流量镜像的实现：
+//1. 镜像的目标是另一个codis集群的proxy或者普通的redis，可以只镜像写命令，也可以镜像所有带key的命令，
+//   设置了key前缀的时候，只有所有的key都匹配某个前缀的请求才会被镜像；
+//2. session在handleRequest中决定请求是否镜像以及由哪个worker发送，在loopWriter中拿到返回结果之后，
+//   把请求和结果非阻塞地放入worker的队列，队列满了就丢弃，不会增加请求的耗时；
+//3. 按照第一个key的hash选择worker，同一个key的请求保持原来的顺序，每个worker使用一个连接批量pipeline发送；
+//4. 镜像的返回和原始的返回不一致的时候计入mismatched，队列满或者连接失败丢弃的请求计入dropped；
+//   MULTI/EXEC事务中排队的命令在EXEC成功之后按照EXEC返回的数组逐个镜像，事务没有执行的时候不镜像；
+//5. 初始的配置来自配置文件，运行时可以通过admin api修改，修改之后会重新创建所有的worker。
+
+const (
+	MirrorModeWrites = "writes"
+	MirrorModeAll    = "all"
+)
+
+type Mirror struct {
+	Addr     string   `json:"addr"`
+	Auth     string   `json:"auth,omitempty"`
+	Mode     string   `json:"mode"`
+	Prefixes []string `json:"prefixes,omitempty"`
+
+	Parallel  int `json:"parallel"`
+	QueueSize int `json:"queue_size"`
+}
+
+func newMirror(config *Config) *Mirror {
+	m := &Mirror{
+		Addr: config.MirrorAddr, Auth: config.MirrorAuth, Mode: config.MirrorMode,
+		Parallel: config.MirrorParallel, QueueSize: config.MirrorQueueSize,
+	}
+	for _, prefix := range strings.Split(config.MirrorKeyPrefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			m.Prefixes = append(m.Prefixes, prefix)
+		}
+	}
+	return m
+}
+
+func (m *Mirror) Validate() error {
+	switch m.Mode {
+	case MirrorModeWrites, MirrorModeAll:
+	default:
+		return errors.Errorf("invalid mirror mode = %s", m.Mode)
+	}
+	if m.Parallel < 0 {
+		return errors.New("invalid mirror parallel")
+	}
+	if m.QueueSize < 0 {
+		return errors.New("invalid mirror queue_size")
+	}
+	return nil
+}
+
+type mirrorRequest struct {
+	database int32
+	multi    []*redis.Resp
+	resp     *redis.Resp
+}
+
+type mirrorWorker struct {
+	input chan *mirrorRequest
+	quit  chan struct{}
+
+	addr string
+	auth string
+}
+
+const (
+	mirrorMaxBatch   = 256
+	mirrorRetryDelay = time.Second
+)
+
+var mirrorstats struct {
+	sent       atomic2.Int64
+	dropped    atomic2.Int64
+	mismatched atomic2.Int64
+}
+
+func MirrorSent() int64 {
+	return mirrorstats.sent.Int64()
+}
+
+func MirrorDropped() int64 {
+	return mirrorstats.dropped.Int64()
+}
+
+func MirrorMismatched() int64 {
+	return mirrorstats.mismatched.Int64()
+}
+
+func (w *mirrorWorker) push(r *Request, resp *redis.Resp) {
+	select {
+	case w.input <- &mirrorRequest{r.Database, r.Multi, resp}:
+	default:
+		mirrorstats.dropped.Incr()
+	}
+}
+
+//MULTI/EXEC事务中排队的需要镜像的命令，index是命令在EXEC返回的数组中的位置
+type mirrorQueued struct {
+	worker *mirrorWorker
+	index  int
+	r      *Request
+}
+
+//EXEC的返回是数组的时候事务已经执行了，按照每个命令自己的返回镜像；返回null（WATCH的key被修改）或者错误的时候
+//事务没有执行，不需要镜像；没有拿到返回的时候无法判断事务是否执行，计入dropped
+func pushMirrorQueue(queue []*mirrorQueued, resp *redis.Resp, err error) {
+	switch {
+	case len(queue) == 0:
+	case err != nil:
+		mirrorstats.dropped.Add(int64(len(queue)))
+	case resp != nil && resp.IsArray():
+		for _, m := range queue {
+			if m.index < len(resp.Array) {
+				m.worker.push(m.r, resp.Array[m.index])
+			}
+		}
+	}
+}
+
+func (w *mirrorWorker) run(config *Config) {
+	var c *redis.Conn
+	var database int32
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+	var batch []*mirrorRequest
+	for {
+		select {
+		case <-w.quit:
+			return
+		case m := <-w.input:
+			batch = append(batch[:0], m)
+		}
+		for len(batch) < mirrorMaxBatch && len(w.input) != 0 {
+			batch = append(batch, <-w.input)
+		}
+		if c == nil {
+			var err error
+			if c, err = w.dial(config); err != nil {
+				log.WarnErrorf(err, "mirror to %s failed", w.addr)
+				mirrorstats.dropped.Add(int64(len(batch)))
+				select {
+				case <-w.quit:
+					return
+				case <-time.After(mirrorRetryDelay):
+				}
+				continue
+			}
+			database = 0
+		}
+		var err error
+		if database, err = w.send(c, database, batch); err != nil {
+			log.WarnErrorf(err, "mirror to %s failed", w.addr)
+			c.Close()
+			c = nil
+		}
+	}
+}
+
+func (w *mirrorWorker) dial(config *Config) (*redis.Conn, error) {
+	c, err := redis.DialTimeout(w.addr, time.Second*5,
+		config.BackendRecvBufsize.AsInt(),
+		config.BackendSendBufsize.AsInt())
+	if err != nil {
+		return nil, err
+	}
+	c.ReaderTimeout = config.BackendRecvTimeout.Duration()
+	c.WriterTimeout = config.BackendSendTimeout.Duration()
+	if err := verifyAuth(c, w.auth); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+//切换db的SELECT和请求一起pipeline发送，返回之后按照同样的顺序解码
+func (w *mirrorWorker) send(c *redis.Conn, database int32, batch []*mirrorRequest) (int32, error) {
+	var selects = make([]bool, len(batch))
+	p := c.FlushEncoder()
+	for i, m := range batch {
+		if m.database != database {
+			multi := []*redis.Resp{
+				redis.NewBulkBytes([]byte("SELECT")),
+				redis.NewBulkBytes([]byte(strconv.Itoa(int(m.database)))),
+			}
+			if err := p.EncodeMultiBulk(multi); err != nil {
+				mirrorstats.dropped.Add(int64(len(batch)))
+				return database, err
+			}
+			selects[i], database = true, m.database
+		}
+		if err := p.EncodeMultiBulk(m.multi); err != nil {
+			mirrorstats.dropped.Add(int64(len(batch)))
+			return database, err
+		}
+	}
+	if err := p.Flush(true); err != nil {
+		mirrorstats.dropped.Add(int64(len(batch)))
+		return database, err
+	}
+	for i, m := range batch {
+		if selects[i] {
+			if _, err := c.Decode(); err != nil {
+				mirrorstats.dropped.Add(int64(len(batch) - i))
+				return database, err
+			}
+		}
+		resp, err := c.Decode()
+		if err != nil {
+			mirrorstats.dropped.Add(int64(len(batch) - i))
+			return database, err
+		}
+		mirrorstats.sent.Incr()
+		if !equalResp(m.resp, resp) {
+			mirrorstats.mismatched.Incr()
+		}
+	}
+	return database, nil
+}
+
+func equalResp(a, b *redis.Resp) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || !bytes.Equal(a.Value, b.Value) || len(a.Array) != len(b.Array) {
+		return false
+	}
+	for i := range a.Array {
+		if !equalResp(a.Array[i], b.Array[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type mirrorTarget struct {
+	mirror   *Mirror
+	writes   bool
+	prefixes [][]byte
+	workers  []*mirrorWorker
+}
+
+func newMirrorTarget(m *Mirror, config *Config) *mirrorTarget {
+	if m.Addr == "" {
+		return nil
+	}
+	t := &mirrorTarget{mirror: m, writes: m.Mode == MirrorModeWrites}
+	for _, prefix := range m.Prefixes {
+		t.prefixes = append(t.prefixes, []byte(prefix))
+	}
+	//没有设置的时候使用配置文件中的值
+	var parallel, queueSize = m.Parallel, m.QueueSize
+	if parallel == 0 {
+		parallel = config.MirrorParallel
+	}
+	if queueSize == 0 {
+		queueSize = math2.MaxInt(1, config.MirrorQueueSize)
+	}
+	for i := math2.MaxInt(1, parallel); i != 0; i-- {
+		w := &mirrorWorker{
+			input: make(chan *mirrorRequest, queueSize),
+			quit:  make(chan struct{}),
+			addr:  m.Addr, auth: m.Auth,
+		}
+		go w.run(config)
+		t.workers = append(t.workers, w)
+	}
+	return t
+}
+
+func (t *mirrorTarget) close() {
+	if t == nil {
+		return
+	}
+	for _, w := range t.workers {
+		close(w.quit)
+	}
+}
+
+func (t *mirrorTarget) match(key []byte) bool {
+	if len(t.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range t.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//选择发送镜像请求的worker，不需要镜像的请求返回nil
+func (t *mirrorTarget) pick(r *Request) *mirrorWorker {
+	if t == nil || (t.writes && r.IsReadOnly()) || r.IsBlocking() || isTransactionOp(r.OpStr) {
+		return nil
+	}
+	if isKeylessCommand(r.OpStr) {
+		return nil
+	}
+	var keys = getHashKeys(r.Multi, r.OpStr)
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if !t.match(key) {
+			return nil
+		}
+	}
+	return t.workers[Hash(keys[0])%uint32(len(t.workers))]
+}
+
+func (s *Router) SetMirror(m *Mirror) {
+	t := newMirrorTarget(m, s.config)
+	s.mirror.Lock()
+	defer s.mirror.Unlock()
+	s.mirror.target.close()
+	s.mirror.config, s.mirror.target = m, t
+}
+
+func (s *Router) GetMirror() *Mirror {
+	s.mirror.RLock()
+	defer s.mirror.RUnlock()
+	return s.mirror.config
+}
+
+func (s *Router) pickMirror(r *Request) *mirrorWorker {
+	s.mirror.RLock()
+	defer s.mirror.RUnlock()
+	return s.mirror.target.pick(r)
+}
+
+func (s *Router) closeMirror() {
+	s.mirror.Lock()
+	defer s.mirror.Unlock()
+	s.mirror.target.close()
+	s.mirror.target = nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func newMirrorRequest(args ...string) *Request {
+	r := &Request{}
+	for _, arg := range args {
+		r.Multi = append(r.Multi, redis.NewBulkBytes([]byte(arg)))
+	}
+	opstr, flag, err := getOpInfo(r.Multi)
+	assert.MustNoError(err)
+	r.OpStr, r.OpFlag = opstr, flag
+	return r
+}
+
+func TestMirrorPick(t *testing.T) {
+	config := NewDefaultConfig()
+	config.MirrorParallel = 2
+	m := &Mirror{Addr: "127.0.0.1:0", Mode: MirrorModeWrites, Prefixes: []string{"a:", "b:"}}
+	x := newMirrorTarget(m, config)
+	defer x.close()
+	assert.Must(len(x.workers) == 2)
+
+	assert.Must(x.pick(newMirrorRequest("SET", "a:1", "v")) != nil)
+	assert.Must(x.pick(newMirrorRequest("MSET", "a:1", "v", "b:1", "v")) != nil)
+	assert.Must(x.pick(newMirrorRequest("MSET", "a:1", "v", "c:1", "v")) == nil)
+	assert.Must(x.pick(newMirrorRequest("GET", "a:1")) == nil)
+	assert.Must(x.pick(newMirrorRequest("PING")) == nil)
+
+	m = &Mirror{Addr: "127.0.0.1:0", Mode: MirrorModeAll}
+	x = newMirrorTarget(m, config)
+	defer x.close()
+	assert.Must(x.pick(newMirrorRequest("GET", "c:1")) != nil)
+	assert.Must(x.pick(newMirrorRequest("BLPOP", "c:1", "0")) == nil)
+	assert.Must(x.pick(newMirrorRequest("KEYS", "*")) == nil)
+	assert.Must(x.pick(newMirrorRequest("SET", "{a:1}x", "v")) == x.pick(newMirrorRequest("DEL", "a:1")))
+
+	assert.Must(newMirrorTarget(&Mirror{Mode: MirrorModeAll}, config) == nil)
+}
+
+func TestMirrorWorker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	defer l.Close()
+
+	config := NewDefaultConfig()
+	config.MirrorParallel = 1
+	x := newMirrorTarget(&Mirror{Addr: l.Addr().String(), Mode: MirrorModeWrites}, config)
+	defer x.close()
+
+	var sent, mismatched = MirrorSent(), MirrorMismatched()
+
+	r1 := newMirrorRequest("SET", "a", "1")
+	r1.Database = 1
+	x.pick(r1).push(r1, redis.NewString([]byte("OK")))
+	r2 := newMirrorRequest("INCR", "a")
+	r2.Database = 1
+	x.pick(r2).push(r2, redis.NewInt([]byte("2")))
+
+	//worker在收到第一个请求之后才会建立连接
+	c, err := l.Accept()
+	assert.MustNoError(err)
+	conn := redis.NewConn(c, 1024, 1024)
+	defer conn.Close()
+
+	var expect = [][]string{{"SELECT", "1"}, {"SET", "a", "1"}, {"INCR", "a"}}
+	var replies = []*redis.Resp{
+		redis.NewString([]byte("OK")), redis.NewString([]byte("OK")), redis.NewInt([]byte("3")),
+	}
+	for i, args := range expect {
+		resp, err := conn.Decode()
+		assert.MustNoError(err)
+		assert.Must(len(resp.Array) == len(args))
+		for j, arg := range args {
+			assert.Must(string(resp.Array[j].Value) == arg)
+		}
+		assert.MustNoError(conn.Encode(replies[i], true))
+	}
+	for i := 0; i < 100 && MirrorSent() != sent+2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Must(MirrorSent() == sent+2)
+	assert.Must(MirrorMismatched() == mismatched+1)
+}
+
+func TestMirrorQueue(t *testing.T) {
+	w := &mirrorWorker{input: make(chan *mirrorRequest, 16)}
+	var queue = []*mirrorQueued{
+		{worker: w, index: 0, r: newMirrorRequest("SET", "a", "1")},
+		{worker: w, index: 2, r: newMirrorRequest("INCR", "a")},
+	}
+	var exec = redis.NewArray([]*redis.Resp{
+		redis.NewString([]byte("OK")), redis.NewBulkBytes([]byte("1")), redis.NewInt([]byte("2")),
+	})
+	pushMirrorQueue(queue, exec, nil)
+	assert.Must(len(w.input) == 2)
+	m := <-w.input
+	assert.Must(string(m.multi[0].Value) == "SET" && m.resp == exec.Array[0])
+	m = <-w.input
+	assert.Must(string(m.multi[0].Value) == "INCR" && m.resp == exec.Array[2])
+
+	//事务没有执行的时候不镜像，无法判断的时候计入dropped
+	var dropped = MirrorDropped()
+	pushMirrorQueue(queue, redis.NewArray(nil), nil)
+	pushMirrorQueue(queue, RespExecAbort, nil)
+	assert.Must(len(w.input) == 0 && MirrorDropped() == dropped)
+	pushMirrorQueue(queue, nil, ErrBackendConnReset)
+	assert.Must(len(w.input) == 0 && MirrorDropped() == dropped+2)
+
+	x := &mirrorTarget{workers: []*mirrorWorker{w}}
+	assert.Must(x.pick(newMirrorRequest("WATCH", "a")) == nil)
+}
@@ -0,0 +1,174 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"strconv"
+
+	"github.com/thesunnysky/codis/pkg/utils"
+	"github.com/thesunnysky/codis/pkg/utils/prometheus"
+)
+
+type BackendConnStats struct {
+	Addr    string `json:"addr"`
+	GroupId int    `json:"group_id"`
+	Primary bool   `json:"primary"`
+
+	Connected    int `json:"connected"`
+	DataStale    int `json:"data_stale"`
+	Disconnected int `json:"disconnected"`
+}
+
+//统计所有backend连接的状态，replica所属的group在proxy中是未知的，GroupId为0
+func (s *Router) BackendConnStats() []*BackendConnStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var groups = make(map[string]int)
+	for i := range s.slots {
+		if m := &s.slots[i]; m.backend.bc != nil {
+			groups[m.backend.bc.Addr()] = m.backend.id
+		}
+	}
+	var all []*BackendConnStats
+	for _, p := range []*sharedBackendConnPool{s.pool.primary, s.pool.replica} {
+		for addr, bc := range p.pool {
+			x := &BackendConnStats{Addr: addr, Primary: p == s.pool.primary}
+			if x.Primary {
+				x.GroupId = groups[addr]
+			}
+			for _, parallel := range bc.conns {
+				for _, c := range parallel {
+					switch c.state.Int64() {
+					case stateConnected:
+						x.Connected++
+					case stateDataStale:
+						x.DataStale++
+					default:
+						x.Disconnected++
+					}
+				}
+			}
+			all = append(all, x)
+		}
+	}
+	return all
+}
+
+//prometheus的/metrics接口，所有的metric都带有product和proxy token两个label
+func (s *Proxy) Metrics() []byte {
+	var (
+		model = s.Model()
+		stats = s.Stats(StatsCmds)
+		w     = prometheus.NewWriter()
+	)
+	var labels = func(pairs ...string) []string {
+		return append([]string{"product", model.ProductName, "token", model.Token}, pairs...)
+	}
+
+	w.Gauge("codis_proxy_info", "Information of codis-proxy, the value is always 1.").Add(1,
+		labels("proxy_addr", model.ProxyAddr, "admin_addr", model.AdminAddr,
+			"hostname", model.Hostname, "version", utils.Version)...)
+	w.Gauge("codis_proxy_online", "Whether proxy is online.").Add(prometheus.Bool(stats.Online), labels()...)
+	w.Gauge("codis_proxy_closed", "Whether proxy is closed.").Add(prometheus.Bool(stats.Closed), labels()...)
+
+	w.Counter("codis_proxy_ops_total", "Total number of requests.").Add(float64(stats.Ops.Total), labels()...)
+	w.Counter("codis_proxy_ops_fails_total", "Total number of failed requests.").Add(float64(stats.Ops.Fails), labels()...)
+	w.Counter("codis_proxy_ops_redis_errors_total", "Total number of error replies from backends.").Add(float64(stats.Ops.Redis.Errors), labels()...)
+	w.Gauge("codis_proxy_ops_qps", "Requests per second.").Add(float64(stats.Ops.QPS), labels()...)
+
+	for _, cmd := range stats.Ops.Cmd {
+		w.Counter("codis_proxy_cmd_calls_total", "Total number of requests by command.").Add(float64(cmd.Calls), labels("opstr", cmd.OpStr)...)
+		w.Counter("codis_proxy_cmd_fails_total", "Total number of failed requests by command.").Add(float64(cmd.Fails), labels("opstr", cmd.OpStr)...)
+		w.Counter("codis_proxy_cmd_redis_errors_total", "Total number of error replies from backends by command.").Add(float64(cmd.RedisErrType), labels("opstr", cmd.OpStr)...)
+		if cmd.Latency != nil {
+			f := w.Summary("codis_proxy_cmd_latency_microseconds", "Latency of requests by command.")
+			addLatencySummary(f, cmd.Latency, float64(cmd.Usecs), float64(cmd.Calls), labels("opstr", cmd.OpStr))
+		}
+	}
+
+	w.Counter("codis_proxy_ratelimit_delayed_total", "Total number of requests delayed by rate limits.").Add(float64(stats.RateLimit.Delayed), labels()...)
+	w.Counter("codis_proxy_ratelimit_rejected_total", "Total number of requests rejected by rate limits.").Add(float64(stats.RateLimit.Rejected), labels()...)
+
+	w.Counter("codis_proxy_bigkey_requests_total", "Total number of requests rejected by size limits.").Add(float64(stats.BigKeys.Requests), labels()...)
+	w.Counter("codis_proxy_bigkey_responses_total", "Total number of responses dropped by size limits.").Add(float64(stats.BigKeys.Responses), labels()...)
+
+	if x := stats.Cache; x != nil {
+		w.Counter("codis_proxy_cache_hits_total", "Total number of read cache hits.").Add(float64(x.Hits), labels()...)
+		w.Counter("codis_proxy_cache_misses_total", "Total number of read cache misses.").Add(float64(x.Misses), labels()...)
+		w.Gauge("codis_proxy_cache_items", "Number of keys in read cache.").Add(float64(x.Items), labels()...)
+		w.Gauge("codis_proxy_cache_bytes", "Estimated size of read cache.").Add(float64(x.Bytes), labels()...)
+	}
+
+	w.Counter("codis_proxy_mirror_sent_total", "Total number of requests mirrored.").Add(float64(stats.Mirror.Sent), labels()...)
+	w.Counter("codis_proxy_mirror_dropped_total", "Total number of requests dropped by mirroring.").Add(float64(stats.Mirror.Dropped), labels()...)
+	w.Counter("codis_proxy_mirror_mismatched_total", "Total number of mirrored requests with mismatched responses.").Add(float64(stats.Mirror.Mismatched), labels()...)
+
+	w.Counter("codis_proxy_sessions_total", "Total number of sessions.").Add(float64(stats.Sessions.Total), labels()...)
+	w.Gauge("codis_proxy_sessions_alive", "Number of alive sessions.").Add(float64(stats.Sessions.Alive), labels()...)
+	w.Gauge("codis_proxy_cpu_usage", "CPU usage of proxy.").Add(stats.Rusage.CPU, labels()...)
+	w.Gauge("codis_proxy_memory_bytes", "Memory usage of proxy.").Add(float64(stats.Rusage.Mem), labels()...)
+
+	for _, x := range s.router.BackendConnStats() {
+		var role = "replica"
+		if x.Primary {
+			role = "primary"
+		}
+		var pairs = []string{"addr", x.Addr, "group_id", strconv.Itoa(x.GroupId), "role", role}
+		f := w.Gauge("codis_proxy_backend_conns", "Number of backend connections by state.")
+		f.Add(float64(x.Connected), labels(append(pairs, "state", "connected")...)...)
+		f.Add(float64(x.DataStale), labels(append(pairs, "state", "data_stale")...)...)
+		f.Add(float64(x.Disconnected), labels(append(pairs, "state", "disconnected")...)...)
+	}
+	for _, x := range stats.Backend.Breakers {
+		for _, state := range breakerStateNames {
+			w.Gauge("codis_proxy_backend_breaker_state", "State of backend circuit breakers.").Add(prometheus.Bool(x.State == state), labels("addr", x.Addr, "state", state)...)
+		}
+		w.Counter("codis_proxy_backend_breaker_opens_total", "Total number of times backend circuit breakers opened.").Add(float64(x.Opens), labels("addr", x.Addr)...)
+		w.Counter("codis_proxy_backend_breaker_rejected_total", "Total number of requests rejected by backend circuit breakers.").Add(float64(x.Rejected), labels("addr", x.Addr)...)
+	}
+	for _, x := range stats.Backend.Replicas {
+		w.Gauge("codis_proxy_replica_healthy", "Whether replica is healthy for reads.").Add(prometheus.Bool(x.Healthy), labels("addr", x.Addr)...)
+		w.Gauge("codis_proxy_replica_rtt_microseconds", "Round trip time of replica probes.").Add(float64(x.RTT), labels("addr", x.Addr)...)
+		w.Gauge("codis_proxy_replica_lag_seconds", "Seconds since replica's last interaction with master.").Add(float64(x.Lag), labels("addr", x.Addr)...)
+	}
+	for _, x := range stats.Backend.Latency {
+		f := w.Summary("codis_proxy_backend_latency_microseconds", "Latency of backends.")
+		addLatencySummary(f, x.Latency, -1, -1, labels("addr", x.Addr))
+	}
+
+	var migrating, locked int
+	for _, m := range s.Slots() {
+		if m.MigrateFrom != "" {
+			migrating++
+		}
+		if m.Locked {
+			locked++
+		}
+	}
+	w.Gauge("codis_proxy_slots_migrating", "Number of slots being migrated.").Add(float64(migrating), labels()...)
+	w.Gauge("codis_proxy_slots_locked", "Number of slots being locked.").Add(float64(locked), labels()...)
+
+	w.Gauge("codis_proxy_sentinel_servers", "Number of sentinels watched by proxy.").Add(float64(len(stats.Sentinels.Servers)), labels()...)
+	w.Gauge("codis_proxy_sentinel_switched", "Whether masters have been switched by sentinels.").Add(prometheus.Bool(stats.Sentinels.Switched), labels()...)
+	for gid, addr := range stats.Sentinels.Masters {
+		w.Gauge("codis_proxy_sentinel_master", "Masters reported by sentinels, the value is always 1.").Add(1, labels("group_id", gid, "addr", addr)...)
+	}
+	return w.Bytes()
+}
+
+//分位数来自最近的窗口，_sum和_count必须是累计的计数器，没有累计值的时候（小于0）只输出分位数
+func addLatencySummary(f *prometheus.Family, h *Histogram, sum, count float64, labels []string) {
+	for _, q := range []struct {
+		quantile string
+		value    int64
+	}{
+		{"0.5", h.P50}, {"0.9", h.P90}, {"0.99", h.P99}, {"0.999", h.P999},
+	} {
+		f.Add(float64(q.value), append(labels[:len(labels):len(labels)], "quantile", q.quantile)...)
+	}
+	if sum >= 0 && count >= 0 {
+		f.AddSuffix("_sum", sum, labels...)
+		f.AddSuffix("_count", count, labels...)
+	}
+}
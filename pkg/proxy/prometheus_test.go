@@ -0,0 +1,33 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+	"github.com/thesunnysky/codis/pkg/utils/prometheus"
+)
+
+func TestLatencySummary(t *testing.T) {
+	//窗口内只有3个请求，_count必须使用累计的调用次数，和_sum保持一致
+	var h = &Histogram{Count: 3, P50: 10, P90: 20, P99: 30, P999: 40}
+	w := prometheus.NewWriter()
+	addLatencySummary(w.Summary("cmd", ""), h, 5000, 100, []string{"opstr", "GET"})
+	addLatencySummary(w.Summary("backend", ""), h, -1, -1, []string{"addr", "a"})
+
+	var expect = "# TYPE cmd summary\n" +
+		"cmd{opstr=\"GET\",quantile=\"0.5\"} 10\n" +
+		"cmd{opstr=\"GET\",quantile=\"0.9\"} 20\n" +
+		"cmd{opstr=\"GET\",quantile=\"0.99\"} 30\n" +
+		"cmd{opstr=\"GET\",quantile=\"0.999\"} 40\n" +
+		"cmd_sum{opstr=\"GET\"} 5000\n" +
+		"cmd_count{opstr=\"GET\"} 100\n" +
+		"# TYPE backend summary\n" +
+		"backend{addr=\"a\",quantile=\"0.5\"} 10\n" +
+		"backend{addr=\"a\",quantile=\"0.9\"} 20\n" +
+		"backend{addr=\"a\",quantile=\"0.99\"} 30\n" +
+		"backend{addr=\"a\",quantile=\"0.999\"} 40\n"
+	assert.Must(string(w.Bytes()) == expect)
+}
@@ -52,6 +52,8 @@ type Proxy struct {
 
 	//监听proxy的19000端口的Listener，也就是proxy实际工作的端口
 	lproxy net.Listener
+	//TLS端口的Listener，没有配置proxy_tls_addr的时候为nil
+	ltls net.Listener
 	//监听proxy_admin的11080端口的Listener，也就是codis集群和proxy进行交互的端口
 	ladmin net.Listener
 
@@ -69,6 +71,8 @@ type Proxy struct {
 	}
 	//java客户端Jodis与codis集群交互，就是通过下面的struct，里面存储了zkClient以及"/jodis/codis-wujiang/proxy-token"这个路径
 	jodis *Jodis
+
+	tls *tlsConfigs
 }
 
 var ErrClosedProxy = errors.New("use of closed proxy")
@@ -85,10 +89,16 @@ func New(config *Config) (*Proxy, error) {
 	s := &Proxy{}
 	s.config = config
 	s.exit.C = make(chan struct{})
+	//证书在proxy启动的时候加载，SIGHUP的时候重新加载，Router以及BackendConn共享同一个tlsConfigs
+	if p, err := newTLSConfigs(config); err != nil {
+		return nil, err
+	} else {
+		s.tls = p
+	}
 	//设置路由
 	//通过config new一个Router，这一步只是初始化了Router中的两个sharedBackendConnPool的结构，
 	//也就是map[string]*sharedBackendConn
-	s.router = NewRouter(config)
+	s.router = newRouter(config, s.tls)
 	s.ignore = make([]byte, config.ProxyHeapPlaceholder.Int64())
 
 	s.model = &models.Proxy{
@@ -141,6 +151,21 @@ func (s *Proxy) setup(config *Config) error {
 		s.model.ProxyAddr = x
 	}
 
+	if config.ProxyTLSAddr != "" {
+		proto := "tcp"
+		if l, err := net.Listen(proto, config.ProxyTLSAddr); err != nil {
+			return errors.Trace(err)
+		} else {
+			s.ltls = l
+
+			x, err := utils.ReplaceUnspecifiedIP(proto, l.Addr().String(), config.HostProxy)
+			if err != nil {
+				return err
+			}
+			s.model.ProxyTLSAddr = x
+		}
+	}
+
 	proto = "tcp"
 	if l, err := net.Listen(proto, config.AdminAddr); err != nil {
 		return errors.Trace(err)
@@ -218,6 +243,9 @@ func (s *Proxy) Close() error {
 	if s.lproxy != nil {
 		s.lproxy.Close()
 	}
+	if s.ltls != nil {
+		s.ltls.Close()
+	}
 	if s.router != nil {
 		s.router.Close()
 	}
@@ -290,6 +318,48 @@ func (s *Proxy) FillSlots(slots []*models.Slot) error {
 	return nil
 }
 
+func (s *Proxy) SlowLog() []*SlowLogEntry {
+	if l := s.router.slowlog; l != nil {
+		return l.Get(-1)
+	}
+	return nil
+}
+
+func (s *Proxy) ResetSlowLog() {
+	if l := s.router.slowlog; l != nil {
+		l.Reset()
+	}
+}
+
+func (s *Proxy) BigKeys() []*BigKeyEvent {
+	if l := s.router.bigkeys; l != nil {
+		return l.Get()
+	}
+	return nil
+}
+
+func (s *Proxy) ResetBigKeys() {
+	if l := s.router.bigkeys; l != nil {
+		l.Reset()
+	}
+}
+
+//重置全局的统计以及这个proxy的热点key
+func (s *Proxy) ResetStats() {
+	ResetStats()
+	s.router.hotkeys.Reset()
+}
+
+func (s *Proxy) HotKeys() []*HotKey {
+	var keys = s.router.hotkeys.HotKeys()
+	for _, k := range keys {
+		if m := s.router.GetSlot(k.Slot); m != nil {
+			k.GroupId, k.BackendAddr = m.BackendAddrGroupId, m.BackendAddr
+		}
+	}
+	return keys
+}
+
 func (s *Proxy) SwitchMasters(masters map[int]string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -326,6 +396,63 @@ func (s *Proxy) SetSentinels(servers []string) error {
 	return nil
 }
 
+func (s *Proxy) SetACL(acl *models.ACL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosedProxy
+	}
+	if err := acl.Validate(); err != nil {
+		return err
+	}
+	log.Warnf("[%p] set acl, users = %d", s, len(acl.Users))
+
+	s.router.SetACL(acl)
+	return nil
+}
+
+func (s *Proxy) RateLimits() *RateLimits {
+	return s.router.GetRateLimits()
+}
+
+func (s *Proxy) SetRateLimits(limits *RateLimits) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosedProxy
+	}
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	log.Warnf("[%p] set rate limits, proxy = %+v, session = %+v, cidrs = %d, users = %d",
+		s, limits.Proxy, limits.Session, len(limits.CIDRs), len(limits.Users))
+
+	s.router.SetRateLimits(limits)
+	return nil
+}
+
+//返回的配置中不包含auth
+func (s *Proxy) Mirror() *Mirror {
+	m := *s.router.GetMirror()
+	m.Auth = ""
+	return &m
+}
+
+func (s *Proxy) SetMirror(m *Mirror) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosedProxy
+	}
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	log.Warnf("[%p] set mirror, addr = %s, mode = %s, prefixes = %v", s, m.Addr, m.Mode, m.Prefixes)
+
+	s.router.SetMirror(m)
+	return nil
+}
+
 func (s *Proxy) RewatchSentinels() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -449,6 +576,23 @@ func (s *Proxy) serveProxy() {
 		}
 	}(s.lproxy)
 
+	if s.ltls != nil {
+		log.Warnf("[%p] proxy start tls service on %s", s, s.ltls.Addr())
+
+		go func(l net.Listener) (err error) {
+			defer func() {
+				eh <- err
+			}()
+			for {
+				c, err := s.acceptConn(l)
+				if err != nil {
+					return err
+				}
+				go s.newTLSSession(c)
+			}
+		}(s.ltls)
+	}
+
 	if d := s.config.BackendPingPeriod.Duration(); d != 0 {
 		go s.keepAlive(d)
 	}
@@ -519,6 +663,8 @@ type Stats struct {
 		} `json:"redis"`
 		QPS int64      `json:"qps"`
 		Cmd []*OpStats `json:"cmd,omitempty"`
+
+		HotKeys []*HotKey `json:"hotkeys,omitempty"`
 	} `json:"ops"`
 
 	Sessions struct {
@@ -526,6 +672,25 @@ type Stats struct {
 		Alive int64 `json:"alive"`
 	} `json:"sessions"`
 
+	RateLimit struct {
+		Delayed  int64 `json:"delayed"`
+		Rejected int64 `json:"rejected"`
+	} `json:"ratelimit"`
+
+	BigKeys struct {
+		Requests  int64 `json:"requests"`
+		Responses int64 `json:"responses"`
+	} `json:"bigkeys"`
+
+	Cache *CacheStats `json:"cache,omitempty"`
+
+	Mirror struct {
+		Addr       string `json:"addr,omitempty"`
+		Sent       int64  `json:"sent"`
+		Dropped    int64  `json:"dropped"`
+		Mismatched int64  `json:"mismatched"`
+	} `json:"mirror"`
+
 	Rusage struct {
 		Now string       `json:"now"`
 		CPU float64      `json:"cpu"`
@@ -534,7 +699,10 @@ type Stats struct {
 	} `json:"rusage"`
 
 	Backend struct {
-		PrimaryOnly bool `json:"primary_only"`
+		PrimaryOnly bool            `json:"primary_only"`
+		Latency     []*BackendStats `json:"latency,omitempty"`
+		Breakers    []*BreakerStats `json:"breakers,omitempty"`
+		Replicas    []*ReplicaStats `json:"replicas,omitempty"`
 	} `json:"backend"`
 
 	Runtime *RuntimeStats `json:"runtime,omitempty"`
@@ -579,6 +747,7 @@ const (
 	StatsCmds = StatsFlags(1 << iota)
 	StatsSlots
 	StatsRuntime
+	StatsHotKeys
 
 	StatsFull = StatsFlags(^uint32(0))
 )
@@ -622,10 +791,26 @@ func (s *Proxy) Stats(flags StatsFlags) *Stats {
 	if flags.HasBit(StatsCmds) {
 		stats.Ops.Cmd = GetOpStatsAll()
 	}
+	if flags.HasBit(StatsHotKeys) {
+		stats.Ops.HotKeys = s.HotKeys()
+	}
 
 	stats.Sessions.Total = SessionsTotal()
 	stats.Sessions.Alive = SessionsAlive()
 
+	stats.RateLimit.Delayed = RateLimitDelayed()
+	stats.RateLimit.Rejected = RateLimitRejected()
+
+	stats.BigKeys.Requests = BigKeyRequests()
+	stats.BigKeys.Responses = BigKeyResponses()
+
+	stats.Cache = s.router.cache.Stats()
+
+	stats.Mirror.Addr = s.router.GetMirror().Addr
+	stats.Mirror.Sent = MirrorSent()
+	stats.Mirror.Dropped = MirrorDropped()
+	stats.Mirror.Mismatched = MirrorMismatched()
+
 	if u := GetSysUsage(); u != nil {
 		stats.Rusage.Now = u.Now.String()
 		stats.Rusage.CPU = u.CPU
@@ -634,6 +819,11 @@ func (s *Proxy) Stats(flags StatsFlags) *Stats {
 	}
 
 	stats.Backend.PrimaryOnly = s.Config().BackendPrimaryOnly
+	if flags.HasBit(StatsCmds) {
+		stats.Backend.Latency = GetBackendStatsAll()
+	}
+	stats.Backend.Breakers = GetBreakerStatsAll()
+	stats.Backend.Replicas = GetReplicaStatsAll()
 
 	if flags.HasBit(StatsRuntime) {
 		var r runtime.MemStats
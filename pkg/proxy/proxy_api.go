@@ -19,6 +19,7 @@ import (
 	"github.com/thesunnysky/codis/pkg/models"
 	"github.com/thesunnysky/codis/pkg/utils/errors"
 	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/prometheus"
 	"github.com/thesunnysky/codis/pkg/utils/rpc"
 )
 
@@ -61,6 +62,8 @@ func newApiServer(p *Proxy) http.Handler {
 		http.DefaultServeMux.ServeHTTP(w, req)
 	})
 
+	r.Get("/metrics", api.Metrics)
+
 	r.Group("/proxy", func(r martini.Router) {
 		r.Get("", api.Overview)
 		r.Get("/model", api.Model)
@@ -73,6 +76,11 @@ func newApiServer(p *Proxy) http.Handler {
 		r.Get("/stats/:xauth", api.Stats)
 		r.Get("/stats/:xauth/:flags", api.Stats)
 		r.Get("/slots/:xauth", api.Slots)
+		r.Get("/hotkeys/:xauth", api.HotKeys)
+		r.Get("/slowlog/:xauth", api.SlowLog)
+		r.Put("/slowlog/reset/:xauth", api.ResetSlowLog)
+		r.Get("/bigkeys/:xauth", api.BigKeys)
+		r.Put("/bigkeys/reset/:xauth", api.ResetBigKeys)
 		r.Put("/start/:xauth", api.Start)
 		r.Put("/stats/reset/:xauth", api.ResetStats)
 		r.Put("/forcegc/:xauth", api.ForceGC)
@@ -82,6 +90,11 @@ func newApiServer(p *Proxy) http.Handler {
 		r.Put("/fillslots/:xauth", binding.Json([]*models.Slot{}), api.FillSlots)
 		r.Put("/sentinels/:xauth", binding.Json(models.Sentinel{}), api.SetSentinels)
 		r.Put("/sentinels/:xauth/rewatch", api.RewatchSentinels)
+		r.Put("/acl/:xauth", binding.Json(models.ACL{}), api.SetACL)
+		r.Get("/ratelimit/:xauth", api.RateLimits)
+		r.Put("/ratelimit/:xauth", binding.Json(RateLimits{}), api.SetRateLimits)
+		r.Get("/mirror/:xauth", api.Mirror)
+		r.Put("/mirror/:xauth", binding.Json(Mirror{}), api.SetMirror)
 	})
 
 	m.MapTo(r, (*martini.Routes)(nil))
@@ -89,6 +102,11 @@ func newApiServer(p *Proxy) http.Handler {
 	return m
 }
 
+func (s *apiServer) Metrics(w http.ResponseWriter) (int, string) {
+	w.Header().Set("Content-Type", prometheus.ContentType)
+	return http.StatusOK, string(s.proxy.Metrics())
+}
+
 func (s *apiServer) verifyXAuth(params martini.Params) error {
 	if s.proxy.IsClosed() {
 		return ErrClosedProxy
@@ -151,6 +169,48 @@ func (s *apiServer) Slots(params martini.Params) (int, string) {
 	}
 }
 
+func (s *apiServer) HotKeys(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson(s.proxy.HotKeys())
+	}
+}
+
+func (s *apiServer) SlowLog(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson(s.proxy.SlowLog())
+	}
+}
+
+func (s *apiServer) ResetSlowLog(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		s.proxy.ResetSlowLog()
+		return rpc.ApiResponseJson("OK")
+	}
+}
+
+func (s *apiServer) BigKeys(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson(s.proxy.BigKeys())
+	}
+}
+
+func (s *apiServer) ResetBigKeys(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		s.proxy.ResetBigKeys()
+		return rpc.ApiResponseJson("OK")
+	}
+}
+
 func (s *apiServer) Start(params martini.Params) (int, string) {
 	if err := s.verifyXAuth(params); err != nil {
 		return rpc.ApiResponseError(err)
@@ -166,7 +226,7 @@ func (s *apiServer) ResetStats(params martini.Params) (int, string) {
 	if err := s.verifyXAuth(params); err != nil {
 		return rpc.ApiResponseError(err)
 	} else {
-		ResetStats()
+		s.proxy.ResetStats()
 		return rpc.ApiResponseJson("OK")
 	}
 }
@@ -239,6 +299,52 @@ func (s *apiServer) RewatchSentinels(params martini.Params) (int, string) {
 	return rpc.ApiResponseJson("OK")
 }
 
+func (s *apiServer) SetACL(acl models.ACL, params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if err := s.proxy.SetACL(&acl); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	return rpc.ApiResponseJson("OK")
+}
+
+func (s *apiServer) RateLimits(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson(s.proxy.RateLimits())
+	}
+}
+
+func (s *apiServer) SetRateLimits(limits RateLimits, params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if err := s.proxy.SetRateLimits(&limits); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	return rpc.ApiResponseJson("OK")
+}
+
+func (s *apiServer) Mirror(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson(s.proxy.Mirror())
+	}
+}
+
+func (s *apiServer) SetMirror(m Mirror, params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if err := s.proxy.SetMirror(&m); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	return rpc.ApiResponseJson("OK")
+}
+
 type ApiClient struct {
 	addr  string
 	xauth string
@@ -307,6 +413,43 @@ func (c *ApiClient) Slots() ([]*models.Slot, error) {
 	return slots, nil
 }
 
+func (c *ApiClient) HotKeys() ([]*HotKey, error) {
+	url := c.encodeURL("/api/proxy/hotkeys/%s", c.xauth)
+	keys := []*HotKey{}
+	if err := rpc.ApiGetJson(url, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (c *ApiClient) SlowLog() ([]*SlowLogEntry, error) {
+	url := c.encodeURL("/api/proxy/slowlog/%s", c.xauth)
+	entries := []*SlowLogEntry{}
+	if err := rpc.ApiGetJson(url, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *ApiClient) ResetSlowLog() error {
+	url := c.encodeURL("/api/proxy/slowlog/reset/%s", c.xauth)
+	return rpc.ApiPutJson(url, nil, nil)
+}
+
+func (c *ApiClient) BigKeys() ([]*BigKeyEvent, error) {
+	url := c.encodeURL("/api/proxy/bigkeys/%s", c.xauth)
+	events := []*BigKeyEvent{}
+	if err := rpc.ApiGetJson(url, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (c *ApiClient) ResetBigKeys() error {
+	url := c.encodeURL("/api/proxy/bigkeys/reset/%s", c.xauth)
+	return rpc.ApiPutJson(url, nil, nil)
+}
+
 func (c *ApiClient) ResetStats() error {
 	url := c.encodeURL("/api/proxy/stats/reset/%s", c.xauth)
 	return rpc.ApiPutJson(url, nil, nil)
@@ -347,3 +490,36 @@ func (c *ApiClient) RewatchSentinels() error {
 	url := c.encodeURL("/api/proxy/sentinels/%s/rewatch", c.xauth)
 	return rpc.ApiPutJson(url, nil, nil)
 }
+
+func (c *ApiClient) SetACL(acl *models.ACL) error {
+	url := c.encodeURL("/api/proxy/acl/%s", c.xauth)
+	return rpc.ApiPutJson(url, acl, nil)
+}
+
+func (c *ApiClient) RateLimits() (*RateLimits, error) {
+	url := c.encodeURL("/api/proxy/ratelimit/%s", c.xauth)
+	limits := &RateLimits{}
+	if err := rpc.ApiGetJson(url, limits); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+func (c *ApiClient) SetRateLimits(limits *RateLimits) error {
+	url := c.encodeURL("/api/proxy/ratelimit/%s", c.xauth)
+	return rpc.ApiPutJson(url, limits, nil)
+}
+
+func (c *ApiClient) Mirror() (*Mirror, error) {
+	url := c.encodeURL("/api/proxy/mirror/%s", c.xauth)
+	m := &Mirror{}
+	if err := rpc.ApiGetJson(url, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *ApiClient) SetMirror(m *Mirror) error {
+	url := c.encodeURL("/api/proxy/mirror/%s", c.xauth)
+	return rpc.ApiPutJson(url, m, nil)
+}
@@ -0,0 +1,382 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+)
+
+//Pub/Sub的实现：
+//1. PUBLISH按照channel的hash转发到对应slot的master，和普通的带key的命令一样；
+//2. session进入subscribe模式之后，为每一个group的master建立一个独占的连接，并在所有的master上执行(P)SUBSCRIBE，
+//   这样无论channel被PUBLISH到哪个group，消息都会被汇总到session的tasks中返回给客户端；
+//3. (P)SUBSCRIBE/(P)UNSUBSCRIBE的确认信息由proxy自己生成，backend返回的确认信息会被丢弃；
+//4. slot的backend发生变化（比如Router.SwitchMasters）之后，Router.epoch会增加，session会重新连接新的master并重新订阅；
+//5. 连接master的时候不持有pubsub的锁，避免master不可达的时候阻塞消息的分发，连接建立之后再在锁内加入subs并订阅。
+
+func (s *Session) handlePubSub(r *Request, d *Router) error {
+	var names [][]byte
+	for _, m := range r.Multi[1:] {
+		names = append(names, m.Value)
+	}
+	switch r.OpStr {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(names) == 0 {
+			r.Resp = redis.NewErrorf("ERR wrong number of arguments for '%s' command", r.OpStr)
+			return nil
+		}
+		if s.pubsub == nil {
+			s.pubsub = newPubSub(s.tasks, d, s.config, s.resp3)
+			s.Conn.ReaderTimeout = 0
+		}
+		s.replyPubSub(r, s.pubsub.Subscribe(r.OpStr == "PSUBSCRIBE", names))
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		if s.pubsub == nil {
+			r.Resp = newPubSubReply(strings.ToLower(r.OpStr), nil, 0)
+			return nil
+		}
+		s.replyPubSub(r, s.pubsub.Unsubscribe(r.OpStr == "PUNSUBSCRIBE", names))
+		if s.pubsub.Count() == 0 {
+			s.closePubSub()
+		}
+	case "PING":
+		var value []byte
+		if len(names) != 0 {
+			value = names[0]
+		}
+		r.Resp = redis.NewArray([]*redis.Resp{
+			redis.NewBulkBytes([]byte("pong")),
+			redis.NewBulkBytes(value),
+		})
+	default:
+		r.Resp = redis.NewErrorf("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+	}
+	return nil
+}
+
+//一个请求对应多个返回结果的时候，除了最后一个，其余的都先放入tasks中，r会在handleRequest返回之后紧接着被放入tasks
+func (s *Session) replyPubSub(r *Request, replies []*redis.Resp) {
+	for _, resp := range replies[:len(replies)-1] {
+		s.pubsub.push(resp)
+	}
+	r.Resp = replies[len(replies)-1]
+}
+
+func (s *Session) closePubSub() {
+	if s.pubsub == nil {
+		return
+	}
+	s.pubsub.Close()
+	s.pubsub = nil
+	s.Conn.ReaderTimeout = s.config.SessionRecvTimeout.Duration()
+}
+
+func newPubSubReply(kind string, name []byte, count int) *redis.Resp {
+	return redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes([]byte(kind)),
+		redis.NewBulkBytes(name),
+		redis.NewInt([]byte(strconv.Itoa(count))),
+	})
+}
+
+type pubsub struct {
+	mu      sync.Mutex
+	syncing sync.Mutex
+
+	tasks  *RequestChan
+	router *Router
+	config *Config
+	resp3  bool
+
+	channels map[string]bool
+	patterns map[string]bool
+
+	subs  map[string]*subscriber
+	epoch int64
+	dirty bool
+
+	exit chan struct{}
+	wait sync.WaitGroup
+}
+
+type subscriber struct {
+	addr   string
+	conn   *redis.Conn
+	broken atomic2.Bool
+}
+
+func newPubSub(tasks *RequestChan, d *Router, config *Config, resp3 bool) *pubsub {
+	p := &pubsub{
+		tasks: tasks, router: d, config: config, resp3: resp3,
+	}
+	p.channels = make(map[string]bool)
+	p.patterns = make(map[string]bool)
+	p.subs = make(map[string]*subscriber)
+	p.exit = make(chan struct{})
+	p.dirty = true
+
+	p.wait.Add(1)
+	go p.loopRefresh()
+	return p
+}
+
+func (p *pubsub) Count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.channels) + len(p.patterns)
+}
+
+func (p *pubsub) Subscribe(pattern bool, names [][]byte) []*redis.Resp {
+	defer p.resync()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var kind, set = "subscribe", p.channels
+	if pattern {
+		kind, set = "psubscribe", p.patterns
+	}
+	var replies []*redis.Resp
+	for _, name := range names {
+		set[string(name)] = true
+		replies = append(replies, newPubSubReply(kind, name, len(p.channels)+len(p.patterns)))
+	}
+	p.sendAll(kind, names)
+	return replies
+}
+
+func (p *pubsub) Unsubscribe(pattern bool, names [][]byte) []*redis.Resp {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var kind, set = "unsubscribe", p.channels
+	if pattern {
+		kind, set = "punsubscribe", p.patterns
+	}
+	if len(names) == 0 {
+		var all []string
+		for name := range set {
+			all = append(all, name)
+		}
+		sort.Strings(all)
+		for _, name := range all {
+			names = append(names, []byte(name))
+		}
+	}
+	if len(names) == 0 {
+		return []*redis.Resp{newPubSubReply(kind, nil, len(p.channels)+len(p.patterns))}
+	}
+	var replies []*redis.Resp
+	for _, name := range names {
+		delete(set, string(name))
+		replies = append(replies, newPubSubReply(kind, name, len(p.channels)+len(p.patterns)))
+	}
+	p.sendAll(kind, names)
+	return replies
+}
+
+func (p *pubsub) Close() {
+	p.mu.Lock()
+	close(p.exit)
+	for addr, sub := range p.subs {
+		sub.conn.Close()
+		delete(p.subs, addr)
+	}
+	p.mu.Unlock()
+	p.wait.Wait()
+}
+
+func (p *pubsub) push(resp *redis.Resp) {
+	r := &Request{Resp: resp, Resp3: p.resp3}
+	r.Batch = &sync.WaitGroup{}
+	r.UnixNano = time.Now().UnixNano()
+	p.tasks.PushBack(r)
+}
+
+func (p *pubsub) sendAll(kind string, names [][]byte) {
+	for _, sub := range p.subs {
+		p.send(sub, kind, names)
+	}
+}
+
+func (p *pubsub) send(sub *subscriber, kind string, names [][]byte) {
+	if len(names) == 0 || sub.broken.IsTrue() {
+		return
+	}
+	var multi = make([]*redis.Resp, 0, len(names)+1)
+	multi = append(multi, redis.NewBulkBytes([]byte(kind)))
+	for _, name := range names {
+		multi = append(multi, redis.NewBulkBytes(name))
+	}
+	if err := sub.conn.EncodeMultiBulk(multi, true); err != nil {
+		log.WarnErrorf(err, "pubsub [%p] to %s, send %s failed", p, sub.addr, kind)
+		sub.broken.Set(true)
+		sub.conn.Close()
+	}
+}
+
+func (p *pubsub) loopRefresh() {
+	defer p.wait.Done()
+	var ticker = time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.exit:
+			return
+		case <-ticker.C:
+			p.resync()
+		}
+	}
+}
+
+//多个resync（Subscribe和loopRefresh）串行执行，Subscribe返回的时候已经订阅了所有的master
+func (p *pubsub) resync() {
+	p.syncing.Lock()
+	defer p.syncing.Unlock()
+
+	var addrs = p.prepare()
+	if len(addrs) == 0 {
+		return
+	}
+	var conns = make(map[string]*redis.Conn)
+	for _, addr := range addrs {
+		c, err := p.dial(addr)
+		if err != nil {
+			log.WarnErrorf(err, "pubsub [%p] connect to %s failed", p, addr)
+			continue
+		}
+		conns[addr] = c
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(conns) != len(addrs) {
+		p.dirty = true
+	}
+	for addr, c := range conns {
+		select {
+		case <-p.exit:
+			c.Close()
+		default:
+			p.subs[addr] = p.attach(addr, c)
+		}
+	}
+}
+
+//关闭已经失效的连接，返回需要建立连接的master
+func (p *pubsub) prepare() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.exit:
+		return nil
+	default:
+	}
+	if epoch := p.router.Epoch(); epoch != p.epoch {
+		p.epoch, p.dirty = epoch, true
+	}
+	for _, sub := range p.subs {
+		if sub.broken.IsTrue() {
+			p.dirty = true
+		}
+	}
+	if !p.dirty {
+		return nil
+	}
+	p.dirty = false
+
+	var addrs = p.router.getBackendAddrs()
+	for addr, sub := range p.subs {
+		if !addrs[addr] || sub.broken.IsTrue() {
+			sub.conn.Close()
+			delete(p.subs, addr)
+		}
+	}
+	var missing []string
+	for addr := range addrs {
+		if p.subs[addr] == nil {
+			missing = append(missing, addr)
+		}
+	}
+	return missing
+}
+
+func (p *pubsub) dial(addr string) (*redis.Conn, error) {
+	c, err := dialBackend(addr, p.config, p.router.tls)
+	if err != nil {
+		return nil, err
+	}
+	c.WriterTimeout = p.config.BackendSendTimeout.Duration()
+	c.SetKeepAlivePeriod(p.config.BackendKeepAlivePeriod.Duration())
+
+	if err := verifyAuth(c, p.config.ProductAuth); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+//在锁内调用，订阅当前所有的channel和pattern
+func (p *pubsub) attach(addr string, c *redis.Conn) *subscriber {
+	sub := &subscriber{addr: addr, conn: c}
+
+	var channels, patterns [][]byte
+	for name := range p.channels {
+		channels = append(channels, []byte(name))
+	}
+	for name := range p.patterns {
+		patterns = append(patterns, []byte(name))
+	}
+	p.send(sub, "subscribe", channels)
+	p.send(sub, "psubscribe", patterns)
+
+	p.wait.Add(1)
+	go p.loopSubscriber(sub)
+	return sub
+}
+
+func (p *pubsub) loopSubscriber(sub *subscriber) (err error) {
+	defer func() {
+		sub.broken.Set(true)
+		sub.conn.Close()
+		select {
+		case <-p.exit:
+		default:
+			log.WarnErrorf(err, "pubsub [%p] to %s, subscriber exit", p, sub.addr)
+		}
+		p.wait.Done()
+	}()
+	for {
+		resp, err := sub.conn.Decode()
+		if err != nil {
+			return err
+		}
+		if p.isWanted(resp) {
+			p.push(resp)
+		}
+	}
+}
+
+func (p *pubsub) isWanted(resp *redis.Resp) bool {
+	if resp == nil || !resp.IsArray() || len(resp.Array) < 3 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch kind := string(resp.Array[0].Value); {
+	case kind == "message" && len(resp.Array) == 3:
+		return p.channels[string(resp.Array[1].Value)]
+	case kind == "pmessage" && len(resp.Array) == 4:
+		return p.patterns[string(resp.Array[1].Value)]
+	}
+	return false
+}
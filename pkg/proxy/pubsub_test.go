@@ -0,0 +1,194 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+//模拟codis-server，记录每个连接订阅的channel，其他命令都返回OK
+type pubsubServer struct {
+	sync.Mutex
+	l    net.Listener
+	subs map[*redis.Conn]map[string]bool
+}
+
+func newPubSubServer() *pubsubServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.MustNoError(err)
+	s := &pubsubServer{l: l, subs: make(map[*redis.Conn]map[string]bool)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(redis.NewConn(c, 1024, 1024))
+		}
+	}()
+	return s
+}
+
+func (s *pubsubServer) Addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *pubsubServer) serve(c *redis.Conn) {
+	defer func() {
+		s.Lock()
+		delete(s.subs, c)
+		s.Unlock()
+		c.Close()
+	}()
+	for {
+		resp, err := c.Decode()
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(string(resp.Array[0].Value)) {
+		case "SUBSCRIBE":
+			s.Lock()
+			if s.subs[c] == nil {
+				s.subs[c] = make(map[string]bool)
+			}
+			for _, x := range resp.Array[1:] {
+				s.subs[c][string(x.Value)] = true
+			}
+			s.Unlock()
+		case "UNSUBSCRIBE":
+			s.Lock()
+			for _, x := range resp.Array[1:] {
+				delete(s.subs[c], string(x.Value))
+			}
+			s.Unlock()
+		default:
+			c.Encode(redis.NewString([]byte("OK")), true)
+		}
+	}
+}
+
+//返回订阅了channel的连接的数量
+func (s *pubsubServer) Subscribers(channel string) int {
+	s.Lock()
+	defer s.Unlock()
+	var n int
+	for _, set := range s.subs {
+		if set[channel] {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *pubsubServer) Publish(channel, message string) {
+	s.Lock()
+	defer s.Unlock()
+	for c, set := range s.subs {
+		if set[channel] {
+			c.Encode(redis.NewArray([]*redis.Resp{
+				redis.NewBulkBytes([]byte("message")),
+				redis.NewBulkBytes([]byte(channel)),
+				redis.NewBulkBytes([]byte(message)),
+			}), true)
+		}
+	}
+}
+
+//断开所有的订阅连接
+func (s *pubsubServer) Kick() {
+	s.Lock()
+	defer s.Unlock()
+	for c := range s.subs {
+		c.Close()
+	}
+}
+
+func (s *pubsubServer) Close() {
+	s.l.Close()
+	s.Kick()
+}
+
+func waitPubSub(cond func() bool) {
+	for i := 0; i < 500 && !cond(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Must(cond())
+}
+
+func popPubSubMessage(tasks *RequestChan) string {
+	var ch = make(chan *Request, 1)
+	go func() {
+		r, _ := tasks.PopFront()
+		ch <- r
+	}()
+	select {
+	case r := <-ch:
+		assert.Must(r != nil && len(r.Resp.Array) == 3)
+		return string(r.Resp.Array[2].Value)
+	case <-time.After(time.Second * 5):
+		assert.Must(false)
+	}
+	return ""
+}
+
+func TestPubSubResync(t *testing.T) {
+	s1, s2, s3 := newPubSubServer(), newPubSubServer(), newPubSubServer()
+	defer s1.Close()
+	defer s2.Close()
+	defer s3.Close()
+
+	config := NewDefaultConfig()
+	d := NewRouter(config)
+	defer d.Close()
+	assert.MustNoError(d.FillSlot(&models.Slot{Id: 0, BackendAddr: s1.Addr()}))
+	assert.MustNoError(d.FillSlot(&models.Slot{Id: 1, BackendAddr: s2.Addr()}))
+
+	tasks := NewRequestChanBuffer(16)
+	p := newPubSub(tasks, d, config, false)
+	defer p.Close()
+
+	//Subscribe返回的时候已经连接了所有的master
+	replies := p.Subscribe(false, [][]byte{[]byte("ch")})
+	assert.Must(len(replies) == 1 && len(p.subs) == 2)
+	waitPubSub(func() bool {
+		return s1.Subscribers("ch") == 1 && s2.Subscribers("ch") == 1
+	})
+	s1.Publish("ch", "m1")
+	assert.Must(popPubSubMessage(tasks) == "m1")
+
+	//slot迁移到新的master之后重新订阅，旧的master的连接被关闭
+	assert.MustNoError(d.FillSlot(&models.Slot{Id: 1, BackendAddr: s3.Addr()}))
+	p.resync()
+	waitPubSub(func() bool {
+		return s2.Subscribers("ch") == 0 && s3.Subscribers("ch") == 1
+	})
+	s3.Publish("ch", "m2")
+	assert.Must(popPubSubMessage(tasks) == "m2")
+
+	//连接断开之后重新连接并订阅
+	s1.Kick()
+	waitPubSub(func() bool {
+		return s1.Subscribers("ch") == 0
+	})
+	waitPubSub(func() bool {
+		p.resync()
+		return s1.Subscribers("ch") == 1
+	})
+	s1.Publish("ch", "m3")
+	assert.Must(popPubSubMessage(tasks) == "m3")
+
+	p.Unsubscribe(false, nil)
+	assert.Must(p.Count() == 0)
+	waitPubSub(func() bool {
+		return s1.Subscribers("ch") == 0 && s3.Subscribers("ch") == 0
+	})
+}
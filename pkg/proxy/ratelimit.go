@@ -0,0 +1,299 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+	"github.com/thesunnysky/codis/pkg/utils/timesize"
+)
+
+//限流的实现：
+//1. 每个限制包含每秒请求数和每秒请求字节数两个令牌桶，桶的容量是一秒的令牌数；
+//2. 一个请求需要同时满足整个proxy、来源CIDR（最长前缀匹配，同一个CIDR下的所有session共享）、登录用户
+//   （没有登录ACL用户的session属于default用户）以及session自己的限制；
+//3. 令牌不足的时候，如果等待时间不超过max_delay，session的loopReader会等待之后再处理这个请求，
+//   相当于推迟了返回，同时也不再读取客户端后续的请求；否则直接返回错误，不消耗令牌；
+//4. 初始的限制来自配置文件，运行时可以通过admin api修改，修改之后所有的令牌桶都会重新创建。
+
+type RateLimit struct {
+	Ops   int64 `json:"ops,omitempty"`
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+func (l RateLimit) IsEmpty() bool {
+	return l.Ops <= 0 && l.Bytes <= 0
+}
+
+type RateLimits struct {
+	Proxy   RateLimit            `json:"proxy"`
+	Session RateLimit            `json:"session"`
+	CIDRs   map[string]RateLimit `json:"cidrs,omitempty"`
+	Users   map[string]RateLimit `json:"users,omitempty"`
+
+	MaxDelay timesize.Duration `json:"max_delay"`
+}
+
+func newRateLimits(config *Config) *RateLimits {
+	return &RateLimits{
+		Proxy: RateLimit{
+			Ops:   config.ProxyRateLimitOps,
+			Bytes: config.ProxyRateLimitBytes.Int64(),
+		},
+		Session: RateLimit{
+			Ops:   config.ProxyRateLimitSessionOps,
+			Bytes: config.ProxyRateLimitSessionBytes.Int64(),
+		},
+		MaxDelay: config.ProxyRateLimitMaxDelay,
+	}
+}
+
+func (p *RateLimits) Validate() error {
+	var limits = []RateLimit{p.Proxy, p.Session}
+	for cidr, l := range p.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.Errorf("invalid cidr = %s", cidr)
+		}
+		limits = append(limits, l)
+	}
+	for user, l := range p.Users {
+		if user == "" {
+			return errors.New("invalid user name")
+		}
+		limits = append(limits, l)
+	}
+	for _, l := range limits {
+		if l.Ops < 0 || l.Bytes < 0 {
+			return errors.New("invalid rate limit")
+		}
+	}
+	if p.MaxDelay < 0 {
+		return errors.New("invalid max_delay")
+	}
+	return nil
+}
+
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   int64
+}
+
+func newTokenBucket(rate int64, now int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+func (b *tokenBucket) refill(now int64) {
+	if now > b.last {
+		b.tokens += float64(now-b.last) * b.rate / float64(time.Second)
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+}
+
+//超过桶容量的请求在桶满的时候就可以执行，之后的请求需要等待欠下的令牌
+func (b *tokenBucket) delay(n float64) time.Duration {
+	if n > b.rate {
+		n = b.rate
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	mu sync.Mutex
+
+	ops   *tokenBucket
+	bytes *tokenBucket
+}
+
+func newRateLimiter(l RateLimit) *rateLimiter {
+	if l.IsEmpty() {
+		return nil
+	}
+	var now = time.Now().UnixNano()
+	return &rateLimiter{
+		ops:   newTokenBucket(l.Ops, now),
+		bytes: newTokenBucket(l.Bytes, now),
+	}
+}
+
+//返回请求需要等待的时间，等待时间超过maxDelay的时候不消耗令牌，返回false
+func (l *rateLimiter) reserve(nbytes int64, now int64, maxDelay time.Duration) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var delay time.Duration
+	if b := l.ops; b != nil {
+		b.refill(now)
+		delay = b.delay(1)
+	}
+	if b := l.bytes; b != nil {
+		b.refill(now)
+		if d := b.delay(float64(nbytes)); d > delay {
+			delay = d
+		}
+	}
+	if delay > maxDelay {
+		return 0, false
+	}
+	if b := l.ops; b != nil {
+		b.tokens--
+	}
+	if b := l.bytes; b != nil {
+		b.tokens -= float64(nbytes)
+	}
+	return delay, true
+}
+
+func (l *rateLimiter) cancel(nbytes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b := l.ops; b != nil {
+		b.tokens++
+	}
+	if b := l.bytes; b != nil {
+		b.tokens += float64(nbytes)
+	}
+}
+
+type cidrRateLimiter struct {
+	ipnet *net.IPNet
+	*rateLimiter
+}
+
+func (s *Router) SetRateLimits(limits *RateLimits) {
+	var cidrs []*cidrRateLimiter
+	for cidr, l := range limits.CIDRs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.WarnErrorf(err, "parse cidr %s failed", cidr)
+			continue
+		}
+		if x := newRateLimiter(l); x != nil {
+			cidrs = append(cidrs, &cidrRateLimiter{ipnet, x})
+		}
+	}
+	var users = make(map[string]*rateLimiter)
+	for user, l := range limits.Users {
+		if x := newRateLimiter(l); x != nil {
+			users[user] = x
+		}
+	}
+	s.ratelimit.Lock()
+	defer s.ratelimit.Unlock()
+	s.ratelimit.limits = limits
+	s.ratelimit.proxy = newRateLimiter(limits.Proxy)
+	s.ratelimit.cidrs = cidrs
+	s.ratelimit.users = users
+	s.ratelimit.epoch++
+}
+
+func (s *Router) GetRateLimits() *RateLimits {
+	s.ratelimit.RLock()
+	defer s.ratelimit.RUnlock()
+	return s.ratelimit.limits
+}
+
+//最长前缀匹配
+func (s *Router) getCIDRRateLimiter(ip net.IP) *rateLimiter {
+	var limiter *rateLimiter
+	var longest = -1
+	for _, x := range s.ratelimit.cidrs {
+		if !x.ipnet.Contains(ip) {
+			continue
+		}
+		if n, _ := x.ipnet.Mask.Size(); n > longest {
+			limiter, longest = x.rateLimiter, n
+		}
+	}
+	return limiter
+}
+
+var ratelimits struct {
+	delayed  atomic2.Int64
+	rejected atomic2.Int64
+}
+
+func RateLimitDelayed() int64 {
+	return ratelimits.delayed.Int64()
+}
+
+func RateLimitRejected() int64 {
+	return ratelimits.rejected.Int64()
+}
+
+func (s *Session) getRateLimiters(d *Router) []*rateLimiter {
+	d.ratelimit.RLock()
+	defer d.ratelimit.RUnlock()
+	if s.ratelimit.epoch != d.ratelimit.epoch {
+		s.ratelimit.epoch = d.ratelimit.epoch
+		s.ratelimit.session = newRateLimiter(d.ratelimit.limits.Session)
+		s.ratelimit.cidr = nil
+		if host, _, err := net.SplitHostPort(s.Conn.RemoteAddr()); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				s.ratelimit.cidr = d.getCIDRRateLimiter(ip)
+			}
+		}
+		s.ratelimit.maxDelay = d.ratelimit.limits.MaxDelay.Duration()
+	}
+	var user = s.user
+	if user == "" {
+		user = models.ACLDefaultUser
+	}
+	var limiters []*rateLimiter
+	for _, x := range []*rateLimiter{
+		d.ratelimit.proxy, s.ratelimit.cidr, d.ratelimit.users[user], s.ratelimit.session,
+	} {
+		if x != nil {
+			limiters = append(limiters, x)
+		}
+	}
+	return limiters
+}
+
+//在session的loopReader中执行，等待的时候不会继续读取客户端的请求
+func (s *Session) checkRateLimit(r *Request, d *Router) *redis.Resp {
+	var limiters = s.getRateLimiters(d)
+	if len(limiters) == 0 {
+		return nil
+	}
+	var nbytes int64
+	for _, arg := range r.Multi {
+		nbytes += int64(len(arg.Value))
+	}
+	var now = time.Now().UnixNano()
+	var delay time.Duration
+	for i, x := range limiters {
+		wait, ok := x.reserve(nbytes, now, s.ratelimit.maxDelay)
+		if !ok {
+			for _, x := range limiters[:i] {
+				x.cancel(nbytes)
+			}
+			ratelimits.rejected.Incr()
+			return redis.NewErrorf("ERR max request rate exceeded")
+		}
+		if wait > delay {
+			delay = wait
+		}
+	}
+	if delay != 0 {
+		ratelimits.delayed.Incr()
+		time.Sleep(delay)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestRateLimiterOps(t *testing.T) {
+	l := newRateLimiter(RateLimit{Ops: 10})
+	var now = l.ops.last
+	for i := 0; i < 10; i++ {
+		d, ok := l.reserve(0, now, 0)
+		assert.Must(ok && d == 0)
+	}
+	_, ok := l.reserve(0, now, 0)
+	assert.Must(!ok)
+
+	d, ok := l.reserve(0, now, time.Second)
+	assert.Must(ok && d == time.Millisecond*100)
+	d, ok = l.reserve(0, now, time.Second)
+	assert.Must(ok && d == time.Millisecond*200)
+
+	now += int64(time.Millisecond * 300)
+	d, ok = l.reserve(0, now, 0)
+	assert.Must(ok && d == 0)
+	_, ok = l.reserve(0, now, 0)
+	assert.Must(!ok)
+}
+
+func TestRateLimiterBytes(t *testing.T) {
+	l := newRateLimiter(RateLimit{Bytes: 100})
+	assert.Must(l.ops == nil)
+	var now = l.bytes.last
+
+	d, ok := l.reserve(1000, now, 0)
+	assert.Must(ok && d == 0)
+	_, ok = l.reserve(50, now, time.Millisecond*100)
+	assert.Must(!ok)
+
+	l.cancel(1000)
+	d, ok = l.reserve(50, now, 0)
+	assert.Must(ok && d == 0)
+}
+
+func TestRateLimitsValidate(t *testing.T) {
+	assert.Must(newRateLimiter(RateLimit{}) == nil)
+
+	limits := &RateLimits{CIDRs: map[string]RateLimit{"10.0.0.0/8": {Ops: 1}}}
+	assert.MustNoError(limits.Validate())
+	limits.CIDRs["10.0.0.1"] = RateLimit{Ops: 1}
+	assert.Must(limits.Validate() != nil)
+
+	limits = &RateLimits{Users: map[string]RateLimit{"alice": {Bytes: -1}}}
+	assert.Must(limits.Validate() != nil)
+}
+
+func TestRateLimitCIDR(t *testing.T) {
+	config := NewDefaultConfig()
+	s := NewRouter(config)
+	s.SetRateLimits(&RateLimits{
+		CIDRs: map[string]RateLimit{
+			"10.0.0.0/8":  {Ops: 1},
+			"10.1.0.0/16": {Ops: 2},
+			"10.1.2.0/24": {Ops: 0},
+		},
+	})
+	var ops = func(ip string) int64 {
+		l := s.getCIDRRateLimiter(net.ParseIP(ip))
+		if l == nil {
+			return 0
+		}
+		return int64(l.ops.rate)
+	}
+	assert.Must(ops("10.2.0.1") == 1)
+	assert.Must(ops("10.1.2.1") == 2)
+	assert.Must(ops("10.1.3.1") == 2)
+	assert.Must(ops("192.168.0.1") == 0)
+}
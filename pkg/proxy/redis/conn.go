@@ -4,6 +4,7 @@
 package redis
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -16,6 +17,9 @@ import (
 type Conn struct {
 	Sock net.Conn
 
+	//TLS连接下面的原始连接，用于设置TCP的选项
+	raw net.Conn
+
 	*Decoder
 	*Encoder
 
@@ -33,14 +37,50 @@ func DialTimeout(addr string, timeout time.Duration, rbuf, wbuf int) (*Conn, err
 	return NewConn(c, rbuf, wbuf), nil
 }
 
+func DialTimeoutTLS(addr string, timeout time.Duration, rbuf, wbuf int, config *tls.Config) (*Conn, error) {
+	if config == nil {
+		return DialTimeout(addr, timeout, rbuf, wbuf)
+	}
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			c.Close()
+			return nil, errors.Trace(err)
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	t := tls.Client(c, config)
+	if timeout != 0 {
+		t.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := t.Handshake(); err != nil {
+		c.Close()
+		return nil, errors.Trace(err)
+	}
+	t.SetDeadline(time.Time{})
+	return NewTLSConn(t, c, rbuf, wbuf), nil
+}
+
 //redis.Conn的Decoder和Encoder是如何和net.Socket的Reader和Writer结合在一起的？
 func NewConn(sock net.Conn, rbuf, wbuf int) *Conn {
-	conn := &Conn{Sock: sock}
+	conn := &Conn{Sock: sock, raw: sock}
 	conn.Decoder = newConnDecoder(conn, rbuf)
 	conn.Encoder = newConnEncoder(conn, wbuf)
 	return conn
 }
 
+//raw是sock下面的原始连接
+func NewTLSConn(sock *tls.Conn, raw net.Conn, rbuf, wbuf int) *Conn {
+	conn := NewConn(sock, rbuf, wbuf)
+	conn.raw = raw
+	return conn
+}
+
 func (c *Conn) LocalAddr() string {
 	return c.Sock.LocalAddr().String()
 }
@@ -53,15 +93,20 @@ func (c *Conn) Close() error {
 	return c.Sock.Close()
 }
 
+func (c *Conn) tcpConn() (*net.TCPConn, bool) {
+	t, ok := c.raw.(*net.TCPConn)
+	return t, ok
+}
+
 func (c *Conn) CloseReader() error {
-	if t, ok := c.Sock.(*net.TCPConn); ok {
+	if t, ok := c.tcpConn(); ok {
 		return t.CloseRead()
 	}
 	return c.Close()
 }
 
 func (c *Conn) SetKeepAlivePeriod(d time.Duration) error {
-	if t, ok := c.Sock.(*net.TCPConn); ok {
+	if t, ok := c.tcpConn(); ok {
 		if err := t.SetKeepAlive(d != 0); err != nil {
 			return errors.Trace(err)
 		}
@@ -9,6 +9,7 @@ package redis
 // 批量的响应解析出来对应客户端具体的某一次请求，
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"strconv"
 
@@ -25,6 +26,9 @@ var (
 	ErrBadBulkBytesLen        = errors.New("bad bulk bytes len")
 	ErrBadBulkBytesLenTooLong = errors.New("bad bulk bytes len, too long")
 
+	ErrBadMapLen = errors.New("bad map len")
+	ErrBadNull   = errors.New("bad null, should be empty")
+
 	ErrBadMultiBulkLen     = errors.New("bad multi-bulk len")
 	ErrBadMultiBulkContent = errors.New("bad multi-bulk content, should be bulkbytes")
 )
@@ -69,6 +73,31 @@ type Decoder struct {
 	br *bufio2.Reader
 
 	Err error
+
+	//每次Decode或者DecodeMultiBulk允许的bulk总字节数以及数组的最大元素个数，0表示不限制；
+	//超过限制之后剩下的内容会被直接跳过而不是读到内存中，返回*LimitError，decoder仍然可以继续使用
+	MaxBytes    int64
+	MaxArrayLen int64
+
+	limit struct {
+		size     int64
+		count    int64
+		exceeded bool
+	}
+}
+
+type LimitError struct {
+	Size  int64
+	Count int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("exceeds limits, size = %d, count = %d", e.Size, e.Count)
+}
+
+func IsLimitError(err error) bool {
+	_, ok := err.(*LimitError)
+	return ok
 }
 
 var ErrFailedDecoder = errors.New("use of failed decoder")
@@ -89,24 +118,58 @@ func (d *Decoder) Decode() (*Resp, error) {
 	if d.Err != nil {
 		return nil, errors.Trace(ErrFailedDecoder)
 	}
+	d.resetLimit()
 	r, err := d.decodeResp()
 	if err != nil {
 		d.Err = err
+	} else if err := d.limitError(); err != nil {
+		return nil, err
 	}
 	return r, d.Err
 }
 
+//超过限制的时候，返回限制之前已经完整读取的参数（通常包括命令和key）
 func (d *Decoder) DecodeMultiBulk() ([]*Resp, error) {
 	if d.Err != nil {
 		return nil, errors.Trace(ErrFailedDecoder)
 	}
+	d.resetLimit()
 	m, err := d.decodeMultiBulk()
 	if err != nil {
 		d.Err = err
+	} else if err := d.limitError(); err != nil {
+		return m, err
 	}
 	return m, err
 }
 
+func (d *Decoder) resetLimit() {
+	d.limit.size, d.limit.count, d.limit.exceeded = 0, 0, false
+}
+
+func (d *Decoder) limitError() error {
+	if !d.limit.exceeded {
+		return nil
+	}
+	return &LimitError{Size: d.limit.size, Count: d.limit.count}
+}
+
+func (d *Decoder) addSize(n int64) {
+	d.limit.size += n
+	if d.MaxBytes > 0 && d.limit.size > d.MaxBytes {
+		d.limit.exceeded = true
+	}
+}
+
+func (d *Decoder) addCount(n int64) {
+	if n > d.limit.count {
+		d.limit.count = n
+	}
+	if d.MaxArrayLen > 0 && n > d.MaxArrayLen {
+		d.limit.exceeded = true
+	}
+}
+
 func Decode(r io.Reader) (*Resp, error) {
 	return NewDecoder(r).Decode()
 }
@@ -133,8 +196,29 @@ func (d *Decoder) decodeResp() (*Resp, error) {
 		r.Value, err = d.decodeTextBytes()
 	case TypeBulkBytes:
 		r.Value, err = d.decodeBulkBytes()
-	case TypeArray:
+	case TypeArray, TypeSet, TypePush:
 		r.Array, err = d.decodeArray()
+	case TypeNull:
+		var b []byte
+		if b, err = d.decodeTextBytes(); err == nil && len(b) != 0 {
+			err = errors.Trace(ErrBadNull)
+		}
+	case TypeBoolean, TypeDouble, TypeBigNumber:
+		r.Value, err = d.decodeTextBytes()
+	case TypeBlobError, TypeVerbatim:
+		r.Value, err = d.decodeBulkBytes()
+	case TypeMap:
+		r.Array, err = d.decodeMap()
+	case TypeAttribute:
+		//attribute不是一个独立的返回结果，需要和紧随其后的结果一起返回
+		var attr []*Resp
+		if attr, err = d.decodeMap(); err != nil {
+			return nil, err
+		}
+		if r, err = d.decodeResp(); err != nil {
+			return nil, err
+		}
+		r.Attribute = attr
 	}
 	return r, err
 }
@@ -176,6 +260,12 @@ func (d *Decoder) decodeBulkBytes() ([]byte, error) {
 	case n == -1:
 		return nil, nil
 	}
+	if d.addSize(n); d.limit.exceeded {
+		if _, err := d.br.Discard(int(n) + 2); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return nil, nil
+	}
 	b, err := d.br.ReadFull(int(n) + 2)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -199,6 +289,19 @@ func (d *Decoder) decodeArray() ([]*Resp, error) {
 	case n == -1:
 		return nil, nil
 	}
+	return d.decodeElements(n)
+}
+
+//超过限制之后仍然需要解析剩下的元素来跳过它们，但是不再保留
+func (d *Decoder) decodeElements(n int64) ([]*Resp, error) {
+	if d.addCount(n); d.limit.exceeded {
+		for i := int64(0); i < n; i++ {
+			if _, err := d.decodeResp(); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
 	array := make([]*Resp, n)
 	for i := range array {
 		r, err := d.decodeResp()
@@ -207,9 +310,28 @@ func (d *Decoder) decodeArray() ([]*Resp, error) {
 		}
 		array[i] = r
 	}
+	if d.limit.exceeded {
+		return nil, nil
+	}
 	return array, nil
 }
 
+func (d *Decoder) decodeMap() ([]*Resp, error) {
+	n, err := d.decodeInt()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case n < -1:
+		return nil, errors.Trace(ErrBadMapLen)
+	case n > MaxArrayLen/2:
+		return nil, errors.Trace(ErrBadArrayLenTooLong)
+	case n == -1:
+		return nil, nil
+	}
+	return d.decodeElements(n * 2)
+}
+
 func (d *Decoder) decodeSingleLineMultiBulk() ([]*Resp, error) {
 	b, err := d.decodeTextBytes()
 	if err != nil {
@@ -265,8 +387,16 @@ func (d *Decoder) decodeMultiBulk() ([]*Resp, error) {
 	case n > MaxArrayLen:
 		return nil, errors.Trace(ErrBadArrayLenTooLong)
 	}
-	multi := make([]*Resp, n)
-	for i := range multi {
+	//参数个数超过限制的时候，保留前两个参数用来记录命令和key
+	var capacity = n
+	if d.MaxArrayLen > 0 && n > d.MaxArrayLen {
+		d.limit.count, capacity = n, 2
+	}
+	multi := make([]*Resp, 0, capacity)
+	for i := int64(0); i < n; i++ {
+		if i == capacity {
+			d.limit.exceeded = true
+		}
 		r, err := d.decodeResp()
 		if err != nil {
 			return nil, err
@@ -274,7 +404,9 @@ func (d *Decoder) decodeMultiBulk() ([]*Resp, error) {
 		if r.Type != TypeBulkBytes {
 			return nil, errors.Trace(ErrBadMultiBulkContent)
 		}
-		multi[i] = r
+		if !d.limit.exceeded {
+			multi = append(multi, r)
+		}
 	}
 	return multi, nil
 }
@@ -108,6 +108,47 @@ func TestDecoder(t *testing.T) {
 	}
 }
 
+func TestDecodeResp3(t *testing.T) {
+	test := []string{
+		"_\r\n",
+		"#t\r\n",
+		",1.23\r\n",
+		",inf\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"!21\r\nSYNTAX invalid syntax\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n",
+		"~5\r\n+orange\r\n+apple\r\n#t\r\n:100\r\n:999\r\n",
+		">3\r\n$7\r\nmessage\r\n$7\r\nchannel\r\n$5\r\nhello\r\n",
+		"|1\r\n+ttl\r\n:3600\r\n*2\r\n:2039123\r\n:9543892\r\n",
+	}
+	for _, s := range test {
+		resp, err := DecodeFromBytes([]byte(s))
+		assert.MustNoError(err)
+		b, err := EncodeToBytes(resp)
+		assert.MustNoError(err)
+		assert.Must(string(b) == s)
+	}
+
+	resp, err := DecodeFromBytes([]byte("%1\r\n+key\r\n*1\r\n:1\r\n"))
+	assert.MustNoError(err)
+	assert.Must(resp.IsMap() && len(resp.Array) == 2)
+	assert.Must(string(resp.Array[0].Value) == "key" && resp.Array[1].IsArray())
+
+	resp, err = DecodeFromBytes([]byte("|1\r\n+ttl\r\n:3600\r\n:1\r\n"))
+	assert.MustNoError(err)
+	assert.Must(resp.IsInt() && len(resp.Attribute) == 2)
+
+	resp, err = DecodeFromBytes([]byte("!5\r\nERROR\r\n"))
+	assert.MustNoError(err)
+	assert.Must(resp.IsError())
+
+	for _, s := range []string{"_x\r\n", "%-2\r\n", "%1\r\n+key\r\n"} {
+		_, err := DecodeFromBytes([]byte(s))
+		assert.Must(err != nil)
+	}
+}
+
 type loopReader struct {
 	buf []byte
 	pos int
@@ -154,3 +195,47 @@ func BenchmarkDecode4K(b *testing.B)   { benchmarkDecode(b, 1024*4) }
 func BenchmarkDecode16K(b *testing.B)  { benchmarkDecode(b, 1024*16) }
 func BenchmarkDecode32K(b *testing.B)  { benchmarkDecode(b, 1024*32) }
 func BenchmarkDecode128K(b *testing.B) { benchmarkDecode(b, 1024*128) }
+
+func TestDecodeLimits(t *testing.T) {
+	var input = "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$10\r\n0123456789\r\n" +
+		"*4\r\n$5\r\nRPUSH\r\n$3\r\nkey\r\n$1\r\na\r\n$1\r\nb\r\n" +
+		"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"
+	d := NewDecoderSize(bytes.NewReader([]byte(input)), 8)
+	d.MaxBytes, d.MaxArrayLen = 12, 3
+
+	multi, err := d.DecodeMultiBulk()
+	assert.Must(IsLimitError(err))
+	assert.Must(err.(*LimitError).Size == 16)
+	assert.Must(len(multi) == 2 && string(multi[1].Value) == "key")
+
+	multi, err = d.DecodeMultiBulk()
+	assert.Must(IsLimitError(err))
+	assert.Must(err.(*LimitError).Count == 4)
+	assert.Must(len(multi) == 2 && string(multi[0].Value) == "RPUSH")
+
+	multi, err = d.DecodeMultiBulk()
+	assert.MustNoError(err)
+	assert.Must(len(multi) == 2 && string(multi[0].Value) == "GET")
+
+	input = "*2\r\n$8\r\n01234567\r\n$8\r\n01234567\r\n*1\r\n$8\r\n01234567\r\n+OK\r\n"
+	d = NewDecoderSize(bytes.NewReader([]byte(input)), 8)
+	d.MaxBytes = 12
+	_, err = d.Decode()
+	assert.Must(IsLimitError(err))
+	resp, err := d.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsArray() && len(resp.Array) == 1)
+	resp, err = d.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsString())
+
+	input = "*2\r\n*3\r\n:1\r\n:2\r\n:3\r\n:4\r\n*2\r\n:1\r\n:2\r\n"
+	d = NewDecoderSize(bytes.NewReader([]byte(input)), 8)
+	d.MaxArrayLen = 2
+	_, err = d.Decode()
+	assert.Must(IsLimitError(err))
+	assert.Must(err.(*LimitError).Count == 3)
+	resp, err = d.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsArray() && len(resp.Array) == 2)
+}
@@ -111,6 +111,14 @@ func EncodeToBytes(r *Resp) ([]byte, error) {
 }
 
 func (e *Encoder) encodeResp(r *Resp) error {
+	if r.Attribute != nil {
+		if err := e.bw.WriteByte(byte(TypeAttribute)); err != nil {
+			return errors.Trace(err)
+		}
+		if err := e.encodeMap(r.Attribute); err != nil {
+			return err
+		}
+	}
 	if err := e.bw.WriteByte(byte(r.Type)); err != nil {
 		return errors.Trace(err)
 	}
@@ -121,8 +129,16 @@ func (e *Encoder) encodeResp(r *Resp) error {
 		return e.encodeTextBytes(r.Value)
 	case TypeBulkBytes:
 		return e.encodeBulkBytes(r.Value)
-	case TypeArray:
+	case TypeArray, TypeSet, TypePush:
 		return e.encodeArray(r.Array)
+	case TypeNull:
+		return e.encodeTextBytes(nil)
+	case TypeBoolean, TypeDouble, TypeBigNumber:
+		return e.encodeTextBytes(r.Value)
+	case TypeBlobError, TypeVerbatim:
+		return e.encodeBulkBytes(r.Value)
+	case TypeMap:
+		return e.encodeMap(r.Array)
 	}
 }
 
@@ -168,6 +184,21 @@ func (e *Encoder) encodeBulkBytes(b []byte) error {
 	}
 }
 
+func (e *Encoder) encodeMap(pairs []*Resp) error {
+	if len(pairs)%2 != 0 {
+		return errors.Errorf("bad map len %d, should be even", len(pairs))
+	}
+	if err := e.encodeInt(int64(len(pairs) / 2)); err != nil {
+		return err
+	}
+	for _, r := range pairs {
+		if err := e.encodeResp(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (e *Encoder) encodeArray(array []*Resp) error {
 	if array == nil {
 		return e.encodeInt(-1)
@@ -77,6 +77,35 @@ func TestEncodeArray(t *testing.T) {
 	testEncodeAndCheck(t, resp, []byte("*3\r\n:0\r\n$-1\r\n$4\r\ntest\r\n"))
 }
 
+func TestEncodeResp3(t *testing.T) {
+	testEncodeAndCheck(t, NewNull(), []byte("_\r\n"))
+	testEncodeAndCheck(t, NewBoolean(true), []byte("#t\r\n"))
+	testEncodeAndCheck(t, NewBoolean(false), []byte("#f\r\n"))
+	testEncodeAndCheck(t, NewDouble([]byte("3.14")), []byte(",3.14\r\n"))
+	testEncodeAndCheck(t, NewBigNumber([]byte("3492890328409238509324850943850943825024385")),
+		[]byte("(3492890328409238509324850943850943825024385\r\n"))
+	testEncodeAndCheck(t, NewVerbatim("txt", []byte("Some string")), []byte("=15\r\ntxt:Some string\r\n"))
+	testEncodeAndCheck(t, NewMap([]*Resp{
+		NewString([]byte("first")), NewInt([]byte("1")),
+		NewString([]byte("second")), NewInt([]byte("2")),
+	}), []byte("%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n"))
+	testEncodeAndCheck(t, NewSet([]*Resp{
+		NewString([]byte("orange")), NewInt([]byte("100")),
+	}), []byte("~2\r\n+orange\r\n:100\r\n"))
+	testEncodeAndCheck(t, NewPush([]*Resp{
+		NewBulkBytes([]byte("message")),
+	}), []byte(">1\r\n$7\r\nmessage\r\n"))
+
+	resp := NewArray([]*Resp{NewInt([]byte("2039123")), NewInt([]byte("9543892"))})
+	resp.Attribute = []*Resp{
+		NewString([]byte("ttl")), NewInt([]byte("3600")),
+	}
+	testEncodeAndCheck(t, resp, []byte("|1\r\n+ttl\r\n:3600\r\n*2\r\n:2039123\r\n:9543892\r\n"))
+
+	_, err := EncodeToBytes(NewMap([]*Resp{NewNull()}))
+	assert.Must(err != nil)
+}
+
 func testEncodeAndCheck(t *testing.T, resp *Resp, expect []byte) {
 	b, err := EncodeToBytes(resp)
 	assert.MustNoError(err)
@@ -13,6 +13,18 @@ const (
 	TypeInt       RespType = ':'
 	TypeBulkBytes RespType = '$'
 	TypeArray     RespType = '*'
+
+	//RESP3
+	TypeNull      RespType = '_'
+	TypeBoolean   RespType = '#'
+	TypeDouble    RespType = ','
+	TypeBigNumber RespType = '('
+	TypeBlobError RespType = '!'
+	TypeVerbatim  RespType = '='
+	TypeMap       RespType = '%'
+	TypeSet       RespType = '~'
+	TypePush      RespType = '>'
+	TypeAttribute RespType = '|'
 )
 
 func (t RespType) String() string {
@@ -27,17 +39,41 @@ func (t RespType) String() string {
 		return "<bulkbytes>"
 	case TypeArray:
 		return "<array>"
+	case TypeNull:
+		return "<null>"
+	case TypeBoolean:
+		return "<boolean>"
+	case TypeDouble:
+		return "<double>"
+	case TypeBigNumber:
+		return "<bignumber>"
+	case TypeBlobError:
+		return "<bloberror>"
+	case TypeVerbatim:
+		return "<verbatim>"
+	case TypeMap:
+		return "<map>"
+	case TypeSet:
+		return "<set>"
+	case TypePush:
+		return "<push>"
+	case TypeAttribute:
+		return "<attribute>"
 	default:
 		return fmt.Sprintf("<unknown-0x%02x>", byte(t))
 	}
 }
 
 // 用来封装proxy -> codis-server 的Request和Response
+//RESP3的map和attribute在Array中按照k1,v1,k2,v2...的顺序平铺存放
 type Resp struct {
 	Type RespType
 
 	Value []byte
 	Array []*Resp
+
+	//RESP3的attribute，附加在紧随其后的返回结果上
+	Attribute []*Resp
 }
 
 func (r *Resp) IsString() bool {
@@ -45,7 +81,7 @@ func (r *Resp) IsString() bool {
 }
 
 func (r *Resp) IsError() bool {
-	return r.Type == TypeError
+	return r.Type == TypeError || r.Type == TypeBlobError
 }
 
 func (r *Resp) IsInt() bool {
@@ -60,6 +96,22 @@ func (r *Resp) IsArray() bool {
 	return r.Type == TypeArray
 }
 
+func (r *Resp) IsNull() bool {
+	return r.Type == TypeNull
+}
+
+func (r *Resp) IsMap() bool {
+	return r.Type == TypeMap
+}
+
+func (r *Resp) IsSet() bool {
+	return r.Type == TypeSet
+}
+
+func (r *Resp) IsPush() bool {
+	return r.Type == TypePush
+}
+
 func NewString(value []byte) *Resp {
 	r := &Resp{}
 	r.Type = TypeString
@@ -98,3 +150,62 @@ func NewArray(array []*Resp) *Resp {
 	r.Array = array
 	return r
 }
+
+func NewNull() *Resp {
+	r := &Resp{}
+	r.Type = TypeNull
+	return r
+}
+
+func NewBoolean(value bool) *Resp {
+	r := &Resp{}
+	r.Type = TypeBoolean
+	if value {
+		r.Value = []byte("t")
+	} else {
+		r.Value = []byte("f")
+	}
+	return r
+}
+
+func NewDouble(value []byte) *Resp {
+	r := &Resp{}
+	r.Type = TypeDouble
+	r.Value = value
+	return r
+}
+
+func NewBigNumber(value []byte) *Resp {
+	r := &Resp{}
+	r.Type = TypeBigNumber
+	r.Value = value
+	return r
+}
+
+func NewVerbatim(format string, value []byte) *Resp {
+	r := &Resp{}
+	r.Type = TypeVerbatim
+	r.Value = append([]byte(format+":"), value...)
+	return r
+}
+
+func NewMap(pairs []*Resp) *Resp {
+	r := &Resp{}
+	r.Type = TypeMap
+	r.Array = pairs
+	return r
+}
+
+func NewSet(array []*Resp) *Resp {
+	r := &Resp{}
+	r.Type = TypeSet
+	r.Array = array
+	return r
+}
+
+func NewPush(array []*Resp) *Resp {
+	r := &Resp{}
+	r.Type = TypePush
+	r.Array = array
+	return r
+}
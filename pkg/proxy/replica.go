@@ -0,0 +1,255 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
+)
+
+//replica选择的实现：
+//1. replica group之间的顺序由dashboard根据proxy_datacenter决定（同一台机器、同一个机房、其他），
+//   proxy只负责在同一个group内部选择replica；
+//2. 每个backend_ping_period向replica连接池中的每个地址发送一次INFO replication作为探测，
+//   探测的往返时间按照指数加权平均记录为这个replica的延迟，探测还没有返回的时候等待的时间也算作延迟；
+//3. master_link_status为down、正在全量同步或者master_last_io_seconds_ago超过backend_replica_max_lag的replica不会被选择；
+//4. 在健康的replica中选择延迟最低的，延迟和最低值相差不超过backend_replica_latency_tolerance的replica按照请求的seed分担；
+//5. 一个group中没有可用的replica的时候尝试下一个group，都没有的时候发往master。
+
+type replicaHealth struct {
+	addr string
+
+	maxLag    int64
+	tolerance int64
+
+	//探测的往返时间的加权平均，0表示还没有探测结果
+	rtt atomic2.Int64
+	//master_last_io_seconds_ago，单位是秒
+	lag      atomic2.Int64
+	linkDown atomic2.Bool
+
+	//正在等待返回的探测的发送时间，同时只有一个探测
+	probing atomic2.Int64
+
+	//引用的sharedBackendConn的数量，由replicas的锁保护
+	refs int
+}
+
+func newReplicaHealth(addr string, config *Config) *replicaHealth {
+	return &replicaHealth{
+		addr:      addr,
+		maxLag:    int64(config.BackendReplicaMaxLag.Duration()),
+		tolerance: int64(config.BackendReplicaLatencyTolerance.Duration()),
+	}
+}
+
+func (h *replicaHealth) IsHealthy() bool {
+	if h == nil {
+		return true
+	}
+	if h.linkDown.IsTrue() {
+		return false
+	}
+	return h.maxLag <= 0 || h.lag.Int64()*int64(time.Second) <= h.maxLag
+}
+
+//没有探测结果的replica排在最后
+func (h *replicaHealth) RTT(now int64) int64 {
+	if h == nil {
+		return math.MaxInt64
+	}
+	var rtt = h.rtt.Int64()
+	if rtt == 0 {
+		return math.MaxInt64
+	}
+	if send := h.probing.Int64(); send != 0 && now-send > rtt {
+		return now - send
+	}
+	return rtt
+}
+
+func (h *replicaHealth) probe(s *sharedBackendConn) {
+	if h == nil || len(s.conns) == 0 || h.probing.Int64() != 0 {
+		return
+	}
+	bc := s.conns[0][0]
+	if len(bc.input) >= cap(bc.input)/2 {
+		return
+	}
+	m := &Request{}
+	m.Multi = []*redis.Resp{
+		redis.NewBulkBytes([]byte("INFO")),
+		redis.NewBulkBytes([]byte("replication")),
+	}
+	m.Batch = &sync.WaitGroup{}
+	h.probing.Set(time.Now().UnixNano())
+	bc.PushBack(m)
+
+	go func() {
+		m.Batch.Wait()
+		defer h.probing.Set(0)
+		if m.Err != nil || m.Resp == nil || !m.Resp.IsBulkBytes() || m.SendNano == 0 {
+			return
+		}
+		h.update(m.RecvNano-m.SendNano, parseInfo(m.Resp.Value))
+	}()
+}
+
+func (h *replicaHealth) update(rtt int64, info map[string]string) {
+	if rtt <= 0 {
+		rtt = 1
+	}
+	if last := h.rtt.Int64(); last != 0 {
+		rtt = last + (rtt-last)/4
+	}
+	h.rtt.Set(rtt)
+
+	var down bool
+	switch {
+	case info["master_link_status"] == "down":
+		down = true
+	case info["master_sync_in_progress"] == "1":
+		down = true
+	}
+	if h.linkDown.Swap(down) != down {
+		if down {
+			log.Warnf("replica %s is unhealthy, master link is down", h.addr)
+		} else {
+			log.Warnf("replica %s is healthy, master link is up", h.addr)
+		}
+	}
+	var lag int64
+	if s, ok := info["master_last_io_seconds_ago"]; ok {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+			lag = n
+		}
+	}
+	h.lag.Set(lag)
+}
+
+func parseInfo(text []byte) map[string]string {
+	var info = make(map[string]string)
+	for _, line := range strings.Split(string(text), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if key := strings.TrimSpace(kv[0]); key != "" {
+			info[key] = strings.TrimSpace(kv[1])
+		}
+	}
+	return info
+}
+
+var replicas struct {
+	sync.RWMutex
+
+	m map[string]*replicaHealth
+}
+
+func init() {
+	replicas.m = make(map[string]*replicaHealth)
+}
+
+func getReplicaHealth(addr string, config *Config) *replicaHealth {
+	replicas.Lock()
+	defer replicas.Unlock()
+	h := replicas.m[addr]
+	if h == nil {
+		h = newReplicaHealth(addr, config)
+		replicas.m[addr] = h
+	}
+	h.refs++
+	return h
+}
+
+func putReplicaHealth(h *replicaHealth) {
+	if h == nil {
+		return
+	}
+	replicas.Lock()
+	defer replicas.Unlock()
+	if h.refs--; h.refs == 0 && replicas.m[h.addr] == h {
+		delete(replicas.m, h.addr)
+	}
+}
+
+//在一个replica group中选择延迟最低的健康的replica，没有可用的replica的时候返回nil
+func pickReplica(group []*sharedBackendConn, database int32, seed uint, now int64) *BackendConn {
+	var candidates = make([]*sharedBackendConn, 0, len(group))
+	var best, limit int64 = math.MaxInt64, math.MaxInt64
+	var i = seed
+	for range group {
+		i = (i + 1) % uint(len(group))
+		s := group[i]
+		if !s.health.IsHealthy() {
+			continue
+		}
+		if s.BackendConn(database, seed, false) == nil {
+			continue
+		}
+		if rtt := s.health.RTT(now); rtt < best {
+			best, limit = rtt, rtt+s.health.tolerance
+		}
+		candidates = append(candidates, s)
+	}
+	//先在延迟接近最低值的replica中选择，熔断器拒绝的时候再尝试其他的replica；
+	//熔断器打开的replica同样要经过Allow，open_period之后才能进入半开状态放过探测的请求
+	for _, near := range []bool{true, false} {
+		for _, s := range candidates {
+			if (s.health.RTT(now) <= limit) != near {
+				continue
+			}
+			if bc := s.BackendConn(database, seed, false); bc != nil && s.breaker.Allow() {
+				return bc
+			}
+		}
+	}
+	return nil
+}
+
+type ReplicaStats struct {
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+	RTT     int64  `json:"rtt_us"`
+	Lag     int64  `json:"lag"`
+}
+
+type sliceReplicaStats []*ReplicaStats
+
+func (s sliceReplicaStats) Len() int {
+	return len(s)
+}
+
+func (s sliceReplicaStats) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s sliceReplicaStats) Less(i, j int) bool {
+	return s[i].Addr < s[j].Addr
+}
+
+func GetReplicaStatsAll() []*ReplicaStats {
+	var all = make([]*ReplicaStats, 0, 16)
+	replicas.RLock()
+	for addr, h := range replicas.m {
+		all = append(all, &ReplicaStats{
+			Addr:    addr,
+			Healthy: h.IsHealthy(),
+			RTT:     h.rtt.Int64() / 1e3,
+			Lag:     h.lag.Int64(),
+		})
+	}
+	replicas.RUnlock()
+	sort.Sort(sliceReplicaStats(all))
+	return all
+}
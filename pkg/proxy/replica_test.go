@@ -0,0 +1,110 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func newTestReplica(addr string, rtt time.Duration) *sharedBackendConn {
+	config := NewDefaultConfig()
+	bc := &BackendConn{addr: addr}
+	bc.state.Set(stateConnected)
+	s := &sharedBackendConn{addr: addr, single: []*BackendConn{bc}}
+	s.health = newReplicaHealth(addr, config)
+	if rtt != 0 {
+		s.health.update(int64(rtt), map[string]string{"master_link_status": "up"})
+	}
+	return s
+}
+
+func TestReplicaHealth(t *testing.T) {
+	h := newTestReplica("127.0.0.1:6379", time.Millisecond).health
+	assert.Must(h.IsHealthy())
+	assert.Must(h.RTT(time.Now().UnixNano()) == int64(time.Millisecond))
+
+	h.update(int64(time.Millisecond*5), map[string]string{"master_link_status": "up"})
+	assert.Must(h.rtt.Int64() == int64(time.Millisecond*2))
+
+	h.update(int64(time.Millisecond), map[string]string{"master_link_status": "down", "master_last_io_seconds_ago": "-1"})
+	assert.Must(!h.IsHealthy())
+
+	h.update(int64(time.Millisecond), map[string]string{"master_link_status": "up", "master_last_io_seconds_ago": "60"})
+	assert.Must(!h.IsHealthy() && h.lag.Int64() == 60)
+
+	h.update(int64(time.Millisecond), map[string]string{"role": "master"})
+	assert.Must(h.IsHealthy())
+
+	//探测一直没有返回的时候，等待的时间算作延迟
+	var now = time.Now().UnixNano()
+	h.probing.Set(now - int64(time.Second))
+	assert.Must(h.RTT(now) == int64(time.Second))
+}
+
+func TestPickReplica(t *testing.T) {
+	var now = time.Now().UnixNano()
+	a := newTestReplica("127.0.0.1:6379", time.Millisecond*10)
+	b := newTestReplica("127.0.0.1:6380", time.Microsecond*200)
+	c := newTestReplica("127.0.0.1:6381", time.Microsecond*500)
+	d := newTestReplica("127.0.0.1:6382", 0)
+
+	var group = []*sharedBackendConn{a, b, c, d}
+	var picked = make(map[string]int)
+	for seed := uint(0); seed < 100; seed++ {
+		picked[pickReplica(group, 0, seed, now).addr]++
+	}
+	assert.Must(len(picked) == 2 && picked[b.addr] != 0 && picked[c.addr] != 0)
+
+	b.health.update(int64(time.Millisecond), map[string]string{"master_link_status": "down"})
+	c.single[0].state.Set(stateDataStale)
+	for seed := uint(0); seed < 100; seed++ {
+		assert.Must(pickReplica(group, 0, seed, now).addr == a.addr)
+	}
+
+	a.health.update(int64(time.Millisecond), map[string]string{"master_sync_in_progress": "1"})
+	for seed := uint(0); seed < 100; seed++ {
+		assert.Must(pickReplica(group, 0, seed, now).addr == d.addr)
+	}
+
+	d.health.update(int64(time.Millisecond), map[string]string{"master_link_status": "down"})
+	assert.Must(pickReplica(group, 0, 0, now) == nil)
+}
+
+func TestPickReplicaBreaker(t *testing.T) {
+	a := newTestReplica("127.0.0.1:6379", time.Millisecond)
+	a.breaker = newTestBreaker()
+	a.breaker.open(time.Now().UnixNano(), "test")
+	b := newTestReplica("127.0.0.1:6380", time.Millisecond*10)
+
+	var group = []*sharedBackendConn{a, b}
+	assert.Must(pickReplica(group, 0, 0, time.Now().UnixNano()).addr == b.addr)
+
+	//open_period之后放过一个探测的请求，成功之后熔断器关闭
+	time.Sleep(time.Millisecond * 100)
+	assert.Must(pickReplica(group, 0, 0, time.Now().UnixNano()).addr == a.addr)
+	assert.Must(pickReplica(group, 0, 0, time.Now().UnixNano()).addr == b.addr)
+	a.breaker.Record(false, time.Now().UnixNano())
+	assert.Must(pickReplica(group, 0, 0, time.Now().UnixNano()).addr == a.addr)
+}
+
+func TestReplicaHealthRelease(t *testing.T) {
+	const addr = "127.0.0.1:16379"
+	config := NewDefaultConfig()
+	h1 := getReplicaHealth(addr, config)
+	h2 := getReplicaHealth(addr, config)
+	assert.Must(h1 == h2)
+	b1 := getCircuitBreaker(addr, config)
+	b2 := getCircuitBreaker(addr, config)
+	assert.Must(b1 == b2)
+
+	putReplicaHealth(h1)
+	putCircuitBreaker(b1)
+	assert.Must(replicas.m[addr] == h2 && breakers.m[addr] == b2)
+	putReplicaHealth(h2)
+	putCircuitBreaker(b2)
+	assert.Must(replicas.m[addr] == nil && breakers.m[addr] == nil)
+}
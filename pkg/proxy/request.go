@@ -15,6 +15,8 @@ import (
 type Request struct {
 	//用来存放multi request的 multi response
 	Multi []*redis.Resp
+	//在Multi之前写入同一个backend连接的命令（比如MULTI/EXEC事务中排队的命令），它们不会被其他请求插队，返回结果会被丢弃
+	Pipeline [][]*redis.Resp
 	//这个Batch用于检测redis请求是否完成（完成的标志是BackendConn调用了setResponse）
 	Batch *sync.WaitGroup
 	Group *sync.WaitGroup
@@ -27,6 +29,24 @@ type Request struct {
 	Database int32
 	UnixNano int64
 
+	//请求写入backend以及收到backend返回的时间，和处理请求的backend地址，slowlog用来区分排队和backend处理的时间
+	SendNano int64
+	RecvNano int64
+	Backend  string
+
+	//MGET/MSET等命令拆分出来的子请求
+	Subs []Request
+
+	//需要镜像的请求由这个worker发送到镜像的目标，EXEC请求的是事务中排队的需要镜像的命令
+	Mirror      *mirrorWorker
+	MirrorQueue []*mirrorQueued
+
+	//读缓存的请求收到返回之后写入缓存，写请求收到返回之后再删除一次缓存的key
+	Cache *cacheTicket
+
+	//客户端通过HELLO 3切换到RESP3之后，返回结果需要转换成RESP3的形式
+	Resp3 bool
+
 	//用来存放request的response
 	*redis.Resp
 	Err error
@@ -49,6 +69,7 @@ func (r *Request) MakeSubRequest(n int) []Request {
 		x.Database = r.Database
 		x.UnixNano = r.UnixNano
 	}
+	r.Subs = sub
 	return sub
 }
 
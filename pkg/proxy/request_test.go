@@ -130,3 +130,14 @@ func BenchmarkRequestChan256(b *testing.B)  { benchmarkRequestChanN(b, 256) }
 func BenchmarkRequestChan512(b *testing.B)  { benchmarkRequestChanN(b, 512) }
 func BenchmarkRequestChan1024(b *testing.B) { benchmarkRequestChanN(b, 1024) }
 func BenchmarkRequestChan2048(b *testing.B) { benchmarkRequestChanN(b, 2048) }
+
+func newTestRequest(args ...string) *Request {
+	r := &Request{}
+	for _, arg := range args {
+		r.Multi = append(r.Multi, redis.NewBulkBytes([]byte(arg)))
+	}
+	opstr, flag, err := getOpInfo(r.Multi)
+	assert.MustNoError(err)
+	r.OpStr, r.OpFlag = opstr, flag
+	return r
+}
@@ -0,0 +1,159 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"strings"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils"
+)
+
+//RESP3的实现：
+//1. 客户端通过HELLO 3切换到RESP3，backend始终使用RESP2；
+//2. 每个请求记录了它被读取时session使用的协议，loopWriter返回结果之前，将RESP2的结果按照命令转换成RESP3的形式：
+//   nil转换成null，HGETALL转换成map，SMEMBERS等转换成set，ZSCORE等转换成double，Pub/Sub的消息转换成push。
+
+type resp3Shape int
+
+const (
+	resp3ShapeMap resp3Shape = iota + 1
+	resp3ShapeSet
+	resp3ShapeDouble
+	resp3ShapePush
+)
+
+var resp3Shapes = map[string]resp3Shape{
+	"HGETALL": resp3ShapeMap,
+
+	"SMEMBERS": resp3ShapeSet,
+	"SINTER":   resp3ShapeSet,
+	"SUNION":   resp3ShapeSet,
+	"SDIFF":    resp3ShapeSet,
+
+	"ZSCORE":  resp3ShapeDouble,
+	"ZINCRBY": resp3ShapeDouble,
+
+	"SUBSCRIBE":    resp3ShapePush,
+	"PSUBSCRIBE":   resp3ShapePush,
+	"UNSUBSCRIBE":  resp3ShapePush,
+	"PUNSUBSCRIBE": resp3ShapePush,
+}
+
+func upgradeResp3(r *Request, resp *redis.Resp) *redis.Resp {
+	if resp == nil {
+		return nil
+	}
+	var shape = resp3Shapes[r.OpStr]
+	if r.OpStr == "" {
+		//pubsub推送给客户端的消息
+		shape = resp3ShapePush
+	}
+	switch {
+	case resp.IsBulkBytes() && resp.Value == nil:
+		return redis.NewNull()
+	case resp.IsArray() && resp.Array == nil:
+		return redis.NewNull()
+	case resp.IsBulkBytes() && shape == resp3ShapeDouble:
+		return redis.NewDouble(resp.Value)
+	case resp.IsArray():
+		var array = make([]*redis.Resp, len(resp.Array))
+		for i, x := range resp.Array {
+			array[i] = upgradeResp3Null(x)
+		}
+		switch {
+		case shape == resp3ShapeMap && len(array)%2 == 0:
+			return redis.NewMap(array)
+		case shape == resp3ShapeSet:
+			return redis.NewSet(array)
+		case shape == resp3ShapePush:
+			return redis.NewPush(array)
+		}
+		return redis.NewArray(array)
+	}
+	return resp
+}
+
+func upgradeResp3Null(resp *redis.Resp) *redis.Resp {
+	switch {
+	case resp == nil:
+		return nil
+	case resp.IsBulkBytes() && resp.Value == nil:
+		return redis.NewNull()
+	case resp.IsArray() && resp.Array == nil:
+		return redis.NewNull()
+	case resp.IsArray():
+		var array = make([]*redis.Resp, len(resp.Array))
+		for i, x := range resp.Array {
+			array[i] = upgradeResp3Null(x)
+		}
+		return redis.NewArray(array)
+	}
+	return resp
+}
+
+func (s *Session) handleHello(r *Request, d *Router) error {
+	var resp3 = s.resp3
+	var args = r.Multi[1:]
+	if len(args) != 0 {
+		switch ver, err := redis.Btoi64(args[0].Value); {
+		case err != nil:
+			r.Resp = redis.NewErrorf("ERR Protocol version is not an integer or out of range")
+			return nil
+		case ver != 2 && ver != 3:
+			r.Resp = redis.NewErrorf("NOPROTO unsupported protocol version")
+			return nil
+		default:
+			resp3 = ver == 3
+		}
+		args = args[1:]
+	}
+	var authorized = s.authorized || s.config.SessionAuth == ""
+	var user = s.user
+	for len(args) != 0 {
+		switch opt := strings.ToUpper(string(args[0].Value)); {
+		case opt == "AUTH" && len(args) >= 3:
+			var username, password = string(args[1].Value), string(args[2].Value)
+			if !s.authenticate(d, username, password) {
+				r.Resp = redis.NewErrorf("WRONGPASS invalid username-password pair")
+				return nil
+			}
+			authorized, user = true, ""
+			if username != models.ACLDefaultUser {
+				user = username
+			}
+			args = args[3:]
+		case opt == "SETNAME" && len(args) >= 2:
+			args = args[2:]
+		default:
+			r.Resp = redis.NewErrorf("ERR Syntax error in HELLO option '%s'", args[0].Value)
+			return nil
+		}
+	}
+	if !authorized {
+		r.Resp = redis.NewErrorf("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return nil
+	}
+	s.authorized, s.resp3, s.user = true, resp3, user
+
+	var proto = "2"
+	if resp3 {
+		proto = "3"
+	}
+	var pairs = []*redis.Resp{
+		redis.NewBulkBytes([]byte("server")), redis.NewBulkBytes([]byte("codis-proxy")),
+		redis.NewBulkBytes([]byte("version")), redis.NewBulkBytes([]byte(utils.Version)),
+		redis.NewBulkBytes([]byte("proto")), redis.NewInt([]byte(proto)),
+		redis.NewBulkBytes([]byte("mode")), redis.NewBulkBytes([]byte("standalone")),
+		redis.NewBulkBytes([]byte("role")), redis.NewBulkBytes([]byte("master")),
+		redis.NewBulkBytes([]byte("modules")), redis.NewArray([]*redis.Resp{}),
+	}
+	if resp3 {
+		r.Resp = redis.NewMap(pairs)
+	} else {
+		r.Resp = redis.NewArray(pairs)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestUpgradeResp3(t *testing.T) {
+	var upgrade = func(opstr string, resp *redis.Resp) *redis.Resp {
+		return upgradeResp3(&Request{OpStr: opstr}, resp)
+	}
+	assert.Must(upgrade("GET", redis.NewBulkBytes(nil)).IsNull())
+	assert.Must(upgrade("BLPOP", redis.NewArray(nil)).IsNull())
+	assert.Must(upgrade("GET", redis.NewBulkBytes([]byte("v"))).IsBulkBytes())
+
+	resp := upgrade("HGETALL", redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes([]byte("f")), redis.NewBulkBytes([]byte("v")),
+	}))
+	assert.Must(resp.IsMap() && len(resp.Array) == 2)
+
+	resp = upgrade("SMEMBERS", redis.NewArray([]*redis.Resp{}))
+	assert.Must(resp.IsSet() && len(resp.Array) == 0)
+
+	resp = upgrade("ZSCORE", redis.NewBulkBytes([]byte("1.5")))
+	assert.Must(resp.Type == redis.TypeDouble && string(resp.Value) == "1.5")
+	assert.Must(upgrade("ZSCORE", redis.NewBulkBytes(nil)).IsNull())
+
+	resp = upgrade("MGET", redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes([]byte("v")), redis.NewBulkBytes(nil),
+	}))
+	assert.Must(resp.IsArray() && resp.Array[0].IsBulkBytes() && resp.Array[1].IsNull())
+
+	resp = upgrade("", redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes([]byte("message")),
+	}))
+	assert.Must(resp.IsPush())
+
+	resp = upgrade("GET", redis.NewErrorf("ERR"))
+	assert.Must(resp.IsError())
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/thesunnysky/codis/pkg/utils/errors"
 	"github.com/thesunnysky/codis/pkg/utils/log"
 	"github.com/thesunnysky/codis/pkg/utils/redis"
+	"github.com/thesunnysky/codis/pkg/utils/sync2/atomic2"
 )
 
 const MaxSlotNum = models.MaxSlotNum
@@ -28,18 +29,74 @@ type Router struct {
 	config *Config
 	online bool
 	closed bool
+
+	//backend_tls的证书，由Proxy加载和重新加载
+	tls *tlsConfigs
+
+	//每次fillSlot都会增加epoch，用来通知独占backend连接的session（比如Pub/Sub）slot的backend可能发生了变化
+	epoch atomic2.Int64
+
+	//ACL用户，由dashboard推送，session在每个请求之前都会重新获取一次
+	acl struct {
+		sync.RWMutex
+		users map[string]*aclUser
+	}
+
+	//限流的规则以及整个proxy、CIDR、用户共享的令牌桶，epoch用来通知session重新创建自己的令牌桶
+	ratelimit struct {
+		sync.RWMutex
+		limits *RateLimits
+		epoch  int64
+
+		proxy *rateLimiter
+		cidrs []*cidrRateLimiter
+		users map[string]*rateLimiter
+	}
+
+	//读缓存，没有配置的时候为nil
+	cache *readCache
+	//EVAL和SCRIPT LOAD执行过的脚本，用于EVALSHA返回NOSCRIPT的时候重新执行
+	scripts *scriptCache
+	//热点key的统计，没有配置的时候为nil
+	hotkeys *hotKeyTracker
+	//慢查询日志，没有配置的时候为nil
+	slowlog *slowLogger
+	//大key的事件日志，没有配置的时候为nil
+	bigkeys *bigKeyLogger
+
+	//流量镜像的配置以及发送镜像请求的worker
+	mirror struct {
+		sync.RWMutex
+		config *Mirror
+		target *mirrorTarget
+	}
 }
 
 //proxy创建Router
 //始化了Router中的两个sharedBackendConnPool的结构，
 func NewRouter(config *Config) *Router {
-	s := &Router{config: config}
+	return newRouter(config, nil)
+}
+
+//tls为nil的时候使用明文连接backend
+func newRouter(config *Config, tls *tlsConfigs) *Router {
+	s := &Router{config: config, tls: tls}
+	s.bigkeys = newBigKeyLoggerFromConfig(config)
 	s.pool.primary = newSharedBackendConnPool(config, config.BackendPrimaryParallel)
+	s.pool.primary.tls, s.pool.primary.bigkeys = tls, s.bigkeys
 	s.pool.replica = newSharedBackendConnPool(config, config.BackendReplicaParallel)
+	s.pool.replica.tls, s.pool.replica.bigkeys = tls, s.bigkeys
+	s.pool.replica.probe = true
 	for i := range s.slots {
 		s.slots[i].id = i
 		s.slots[i].method = &forwardSync{}
 	}
+	s.SetRateLimits(newRateLimits(config))
+	s.SetMirror(newMirror(config))
+	s.cache = newReadCache(config)
+	s.scripts = newScriptCache()
+	s.hotkeys = newHotKeyTrackerFromConfig(config)
+	s.slowlog = newSlowLoggerFromConfig(config)
 	return s
 }
 
@@ -60,6 +117,8 @@ func (s *Router) Close() {
 	}
 	s.closed = true
 
+	s.closeMirror()
+
 	for i := range s.slots {
 		s.fillSlot(&models.Slot{Id: i}, false, nil)
 	}
@@ -85,6 +144,23 @@ func (s *Router) GetSlot(id int) *models.Slot {
 	return slot.snapshot()
 }
 
+func (s *Router) Epoch() int64 {
+	return s.epoch.Int64()
+}
+
+//返回所有slot的backend（也就是每个group的master）地址
+func (s *Router) getBackendAddrs() map[string]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var addrs = make(map[string]bool)
+	for i := range s.slots {
+		if addr := s.slots[i].backend.bc.Addr(); addr != "" {
+			addrs[addr] = true
+		}
+	}
+	return addrs
+}
+
 func (s *Router) HasSwitched() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -176,10 +252,54 @@ func (s *Router) dispatchAddr(r *Request, addr string) bool {
 	return false
 }
 
+//method 4. 将request转发到session独占的BackendConn，该BackendConn必须是由newPinnedConn根据同一个slot创建的
+func (s *Router) dispatchPinned(r *Request, id int, keys [][]byte, bc *BackendConn) error {
+	if id < 0 || id >= MaxSlotNum {
+		return ErrInvalidSlotId
+	}
+	slot := &s.slots[id]
+	return slot.method.ForwardPinned(slot, r, keys, bc)
+}
+
+//为session创建一个连接到slot当前master的独占BackendConn，使用完之后由session负责Close
+func (s *Router) newPinnedConn(id int, database int32) (*BackendConn, error) {
+	if id < 0 || id >= MaxSlotNum {
+		return nil, ErrInvalidSlotId
+	}
+	slot := &s.slots[id]
+	slot.lock.RLock()
+	defer slot.lock.RUnlock()
+	if slot.backend.bc == nil {
+		return nil, ErrSlotIsNotReady
+	}
+	return newBackendConn(slot.backend.bc.Addr(), int(database), s.config, s.tls, s.bigkeys, true), nil
+}
+
+//从blocking pool中为session取一个连接到slot当前master的独占BackendConn，使用完之后由putBlockingConn归还
+func (s *Router) getBlockingConn(id int, database int32) (*BackendConn, error) {
+	if id < 0 || id >= MaxSlotNum {
+		return nil, ErrInvalidSlotId
+	}
+	slot := &s.slots[id]
+	slot.lock.RLock()
+	defer slot.lock.RUnlock()
+	if slot.backend.bc == nil {
+		return nil, ErrSlotIsNotReady
+	}
+	return s.pool.primary.GetBlocking(slot.backend.bc.Addr(), database), nil
+}
+
+func (s *Router) putBlockingConn(bc *BackendConn) {
+	s.pool.primary.PutBlocking(bc)
+}
+
 //Important
 func (s *Router) fillSlot(m *models.Slot, switched bool, method forwardMethod) {
 	slot := &s.slots[m.Id]
 	slot.blockAndWait()
+	defer s.epoch.Incr()
+
+	var from = slot.backend.bc.Addr()
 
 	//清空models.Slot里面的backendConn
 	slot.backend.bc.Release()
@@ -214,7 +334,8 @@ func (s *Router) fillSlot(m *models.Slot, switched bool, method forwardMethod) {
 		slot.migrate.bc = s.pool.primary.Retain(from)
 		slot.migrate.id = m.MigrateFromGroupId
 	}
-	if !s.config.BackendPrimaryOnly {
+	slot.primaryOnly = s.config.BackendPrimaryOnly
+	if !slot.primaryOnly || s.config.BackendBreakerErrorRatio > 0 {
 		for i := range m.ReplicaGroups {
 			var group []*sharedBackendConn
 			for _, addr := range m.ReplicaGroups[i] {
@@ -230,6 +351,10 @@ func (s *Router) fillSlot(m *models.Slot, switched bool, method forwardMethod) {
 		slot.method = method
 	}
 
+	if slot.backend.bc.Addr() != from {
+		s.cache.evictSlot(slot.id)
+	}
+
 	if !m.Locked {
 		slot.unblock()
 	}
@@ -0,0 +1,382 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+)
+
+//SCAN/KEYS的实现：
+//1. proxy按照slot的顺序依次在每个slot的master上执行SLOTSSCAN，SCAN的cursor = slot内的cursor * MaxSlotNum + slot id；
+//2. SLOTSSCAN不支持MATCH/TYPE，由proxy自己过滤，TYPE需要对每个key额外执行一次TYPE命令；
+//3. 正在迁移的slot，key可能同时分布在两个group上，所以不会被扫描：proxy会等待一段时间重试，
+//   SCAN仍然不能完成的时候返回指向这个slot当前位置的cursor，让客户端稍后继续；KEYS则返回错误；
+//4. cursor = 0表示SCAN已经结束，slot-0内的cursor 0用scanRestartCursor代替。
+
+const (
+	scanDefaultCount  = 10
+	scanRetryInterval = time.Millisecond * 50
+	scanRetryMaxTimes = 10
+	keysSlotScanCount = 1000
+	scanMaxSlotCursor = (1<<64 - 1) / MaxSlotNum
+
+	//SLOTSSCAN的cursor不会超过slot的hash table的大小，不可能返回这个值
+	scanRestartCursor = scanMaxSlotCursor
+)
+
+type scanArgs struct {
+	slot   int
+	cursor uint64
+	count  int
+	match  []byte
+	rtype  []byte
+}
+
+func parseScanArgs(multi []*redis.Resp) (*scanArgs, error) {
+	if len(multi) < 2 {
+		return nil, fmt.Errorf("ERR wrong number of arguments for 'SCAN' command")
+	}
+	cursor, err := strconv.ParseUint(string(multi[1].Value), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ERR invalid cursor")
+	}
+	var args = &scanArgs{
+		slot: int(cursor % MaxSlotNum), cursor: cursor / MaxSlotNum,
+		count: scanDefaultCount,
+	}
+	if args.cursor == scanRestartCursor {
+		args.cursor = 0
+	}
+	for i := 2; i < len(multi); i += 2 {
+		if i+1 >= len(multi) {
+			return nil, fmt.Errorf("ERR syntax error")
+		}
+		var value = multi[i+1].Value
+		switch strings.ToUpper(string(multi[i].Value)) {
+		case "COUNT":
+			n, err := redis.Btoi64(value)
+			switch {
+			case err != nil:
+				return nil, fmt.Errorf("ERR value is not an integer or out of range")
+			case n < 1:
+				return nil, fmt.Errorf("ERR syntax error")
+			}
+			args.count = int(n)
+		case "MATCH":
+			args.match = value
+		case "TYPE":
+			args.rtype = bytes.ToLower(value)
+		default:
+			return nil, fmt.Errorf("ERR syntax error")
+		}
+	}
+	return args, nil
+}
+
+func (s *Session) handleRequestScan(r *Request, d *Router) error {
+	args, err := parseScanArgs(r.Multi)
+	if err != nil {
+		r.Resp = redis.NewErrorf("%s", err)
+		return nil
+	}
+	r.Batch.Add(1)
+	go func() {
+		defer r.Batch.Done()
+		r.Resp, r.Err = s.scanSlots(r, d, args)
+	}()
+	return nil
+}
+
+func (s *Session) scanSlots(r *Request, d *Router, args *scanArgs) (*redis.Resp, error) {
+	var slots = d.GetSlots()
+	var keys [][]byte
+	var slot, cursor = args.slot, args.cursor
+	for scanned := 0; slot < MaxSlotNum && scanned < args.count; {
+		next, array, err := s.scanSlotWithRetry(r, d, slots[slot], cursor, args.count-scanned)
+		if err == errSlotIsMigrating {
+			//让客户端稍后从这个slot当前的cursor继续
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		scanned += len(array)
+		for _, key := range array {
+			if args.match == nil || matchPattern(args.match, key) {
+				keys = append(keys, key)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			slot++
+		}
+	}
+	if args.rtype != nil && len(keys) != 0 {
+		filtered, err := s.filterKeysByType(r, d, keys, args.rtype)
+		if err != nil {
+			return nil, err
+		}
+		keys = filtered
+	}
+	return newScanReply(slot, cursor, keys), nil
+}
+
+//slot >= MaxSlotNum表示所有的slot都已经扫描完成
+func newScanReply(slot int, cursor uint64, keys [][]byte) *redis.Resp {
+	var array = make([]*redis.Resp, len(keys))
+	for i, key := range keys {
+		array[i] = redis.NewBulkBytes(key)
+	}
+	var next uint64
+	switch {
+	case slot >= MaxSlotNum:
+		next = 0
+	case slot == 0 && cursor == 0:
+		next = scanRestartCursor * MaxSlotNum
+	default:
+		next = cursor*MaxSlotNum + uint64(slot)
+	}
+	return redis.NewArray([]*redis.Resp{
+		redis.NewBulkBytes(strconv.AppendUint(nil, next, 10)),
+		redis.NewArray(array),
+	})
+}
+
+func (s *Session) handleRequestKeys(r *Request, d *Router) error {
+	if len(r.Multi) != 2 {
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for 'KEYS' command")
+		return nil
+	}
+	var pattern = r.Multi[1].Value
+	r.Batch.Add(1)
+	go func() {
+		defer r.Batch.Done()
+		r.Resp, r.Err = s.scanAllKeys(r, d, pattern)
+	}()
+	return nil
+}
+
+func (s *Session) scanAllKeys(r *Request, d *Router, pattern []byte) (*redis.Resp, error) {
+	var array = []*redis.Resp{}
+	for _, m := range d.GetSlots() {
+		var cursor uint64
+		for {
+			next, keys, err := s.scanSlotWithRetry(r, d, m, cursor, keysSlotScanCount)
+			switch {
+			case err == errSlotIsMigrating:
+				return redis.NewErrorf("ERR slot-%04d is migrating, try again later", m.Id), nil
+			case err != nil:
+				return nil, err
+			}
+			for _, key := range keys {
+				if matchPattern(pattern, key) {
+					array = append(array, redis.NewBulkBytes(key))
+				}
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+	}
+	return redis.NewArray(array), nil
+}
+
+var errSlotIsMigrating = errors.New("slot is migrating")
+
+func (s *Session) scanSlotWithRetry(r *Request, d *Router, m *models.Slot, cursor uint64, count int) (uint64, [][]byte, error) {
+	for i := 0; ; i++ {
+		if m.MigrateFrom == "" {
+			next, keys, err := s.scanSlot(r, d, m.Id, cursor, count)
+			if err != nil {
+				return 0, nil, err
+			}
+			//扫描过程中slot开始迁移，结果可能不完整，需要重新扫描
+			after := d.GetSlot(m.Id)
+			if after.MigrateFrom == "" && after.BackendAddr == m.BackendAddr {
+				return next, keys, nil
+			}
+			m = after
+		}
+		if i >= scanRetryMaxTimes || r.IsBroken() {
+			return 0, nil, errSlotIsMigrating
+		}
+		time.Sleep(scanRetryInterval)
+		m = d.GetSlot(m.Id)
+	}
+}
+
+func (s *Session) scanSlot(r *Request, d *Router, slot int, cursor uint64, count int) (uint64, [][]byte, error) {
+	if cursor > scanMaxSlotCursor {
+		return 0, nil, fmt.Errorf("slotsscan cursor %d of slot-%04d is too large", cursor, slot)
+	}
+	m := &Request{}
+	m.Multi = []*redis.Resp{
+		redis.NewBulkBytes([]byte("SLOTSSCAN")),
+		redis.NewBulkBytes([]byte(strconv.Itoa(slot))),
+		redis.NewBulkBytes(strconv.AppendUint(nil, cursor, 10)),
+		redis.NewBulkBytes([]byte("COUNT")),
+		redis.NewBulkBytes([]byte(strconv.Itoa(count))),
+	}
+	m.Batch = &sync.WaitGroup{}
+	m.OpStr, m.OpFlag = "SLOTSSCAN", FlagMasterOnly
+	m.Database = r.Database
+	m.UnixNano = r.UnixNano
+	m.Broken = r.Broken
+
+	if err := d.dispatchSlot(m, slot); err != nil {
+		return 0, nil, err
+	}
+	m.Batch.Wait()
+
+	if err := m.Err; err != nil {
+		return 0, nil, err
+	}
+	switch resp := m.Resp; {
+	case resp == nil:
+		return 0, nil, ErrRespIsRequired
+	case resp.IsError():
+		return 0, nil, fmt.Errorf("bad slotsscan resp: %s", resp.Value)
+	case resp.IsArray() && len(resp.Array) == 2 && resp.Array[1].IsArray():
+		next, err := strconv.ParseUint(string(resp.Array[0].Value), 10, 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("bad slotsscan resp: cursor = '%s'", resp.Array[0].Value)
+		}
+		var keys = make([][]byte, len(resp.Array[1].Array))
+		for i, key := range resp.Array[1].Array {
+			keys[i] = key.Value
+		}
+		return next, keys, nil
+	default:
+		return 0, nil, fmt.Errorf("bad slotsscan resp: %s", resp.Type)
+	}
+}
+
+func (s *Session) filterKeysByType(r *Request, d *Router, keys [][]byte, rtype []byte) ([][]byte, error) {
+	var sub = make([]Request, len(keys))
+	var batch = &sync.WaitGroup{}
+	for i, key := range keys {
+		sub[i].Multi = []*redis.Resp{
+			redis.NewBulkBytes([]byte("TYPE")),
+			redis.NewBulkBytes(key),
+		}
+		sub[i].Batch = batch
+		sub[i].OpStr, sub[i].OpFlag = "TYPE", FlagMasterOnly
+		sub[i].Database = r.Database
+		sub[i].UnixNano = r.UnixNano
+		sub[i].Broken = r.Broken
+		if err := d.dispatch(&sub[i]); err != nil {
+			return nil, err
+		}
+	}
+	batch.Wait()
+
+	var filtered [][]byte
+	for i := range sub {
+		if err := sub[i].Err; err != nil {
+			return nil, err
+		}
+		switch resp := sub[i].Resp; {
+		case resp == nil:
+			return nil, ErrRespIsRequired
+		case resp.IsString():
+			if bytes.Equal(resp.Value, rtype) {
+				filtered = append(filtered, keys[i])
+			}
+		default:
+			return nil, fmt.Errorf("bad type resp: %s", resp.Type)
+		}
+	}
+	return filtered, nil
+}
+
+// 和redis的stringmatchlen一致的glob匹配，支持*、?、[...]以及\转义
+func matchPattern(pattern, str []byte) bool {
+	for len(pattern) != 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if matchPattern(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			var not = len(pattern) != 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			var match bool
+			for {
+				if len(pattern) == 0 {
+					break
+				}
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					var start, end = pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[0] >= start && str[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == str[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				//没有闭合的']'，和redis一样当作已经结束
+				pattern = []byte{']'}
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestMatchPattern(t *testing.T) {
+	var tests = []struct {
+		pattern, str string
+		match        bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*", "abc", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abcd", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"{user}:*", "{user}:1000", true},
+		{"user:*:name", "user:1000:age", false},
+	}
+	for _, e := range tests {
+		assert.Must(matchPattern([]byte(e.pattern), []byte(e.str)) == e.match)
+	}
+}
+
+func TestScanCursor(t *testing.T) {
+	var multi = func(args ...string) []*redis.Resp {
+		var multi []*redis.Resp
+		for _, arg := range args {
+			multi = append(multi, redis.NewBulkBytes([]byte(arg)))
+		}
+		return multi
+	}
+	resp := newScanReply(1000, 12345, nil)
+	assert.Must(resp.IsArray() && len(resp.Array) == 2)
+
+	args, err := parseScanArgs(multi("SCAN", string(resp.Array[0].Value), "match", "a*", "COUNT", "100", "type", "LIST"))
+	assert.MustNoError(err)
+	assert.Must(args.slot == 1000 && args.cursor == 12345)
+	assert.Must(args.count == 100)
+	assert.Must(string(args.match) == "a*" && string(args.rtype) == "list")
+
+	args, err = parseScanArgs(multi("SCAN", "0"))
+	assert.MustNoError(err)
+	assert.Must(args.slot == 0 && args.cursor == 0 && args.count == scanDefaultCount)
+
+	//slot-0内的cursor 0不能返回0，否则客户端认为SCAN已经结束
+	resp = newScanReply(0, 0, nil)
+	assert.Must(string(resp.Array[0].Value) != "0")
+	args, err = parseScanArgs(multi("SCAN", string(resp.Array[0].Value)))
+	assert.MustNoError(err)
+	assert.Must(args.slot == 0 && args.cursor == 0)
+	assert.Must(string(newScanReply(MaxSlotNum, 0, nil).Array[0].Value) == "0")
+
+	for _, bad := range [][]*redis.Resp{
+		multi("SCAN"),
+		multi("SCAN", "-1"),
+		multi("SCAN", "0", "COUNT"),
+		multi("SCAN", "0", "COUNT", "0"),
+		multi("SCAN", "0", "LIMIT", "10"),
+	} {
+		_, err := parseScanArgs(bad)
+		assert.Must(err != nil)
+	}
+}
+
+func TestScanMigratingSlot(t *testing.T) {
+	d := NewRouter(NewDefaultConfig())
+	defer d.Close()
+	assert.MustNoError(d.FillSlot(&models.Slot{Id: 0, BackendAddr: "127.0.0.1:1", MigrateFrom: "127.0.0.1:2"}))
+
+	//slot-0一直在迁移，返回的cursor仍然指向slot-0，而不是表示结束的0
+	s := &Session{config: d.config}
+	for _, cursor := range []uint64{0, 42} {
+		resp, err := s.scanSlots(&Request{}, d, &scanArgs{slot: 0, cursor: cursor, count: scanDefaultCount})
+		assert.MustNoError(err)
+		assert.Must(len(resp.Array[1].Array) == 0)
+		next := string(resp.Array[0].Value)
+		assert.Must(next != "0")
+		args, err := parseScanArgs([]*redis.Resp{redis.NewBulkBytes([]byte("SCAN")), redis.NewBulkBytes([]byte(next))})
+		assert.MustNoError(err)
+		assert.Must(args.slot == 0 && args.cursor == cursor)
+	}
+}
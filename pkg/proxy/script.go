@@ -0,0 +1,218 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+)
+
+//EVAL/EVALSHA/SCRIPT的实现：
+//1. EVAL/EVALSHA的numkeys和所有的keys都会被检查，keys必须属于同一个slot，按照slot转发到对应的master；
+//2. Router缓存了所有经过它的脚本（sha1 -> script），EVALSHA返回NOSCRIPT的时候（比如slot迁移到了新的group），
+//   会使用缓存的脚本改为EVAL重新执行一次，同时也就把脚本加载到了新的backend上；
+//3. SCRIPT LOAD/EXISTS/FLUSH会被发往所有group的master，EXISTS只有在所有master上都存在的时候才返回1。
+
+const maxCachedScripts = 4096
+
+type scriptCache struct {
+	sync.RWMutex
+	cache map[string][]byte
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{cache: make(map[string][]byte)}
+}
+
+func (c *scriptCache) Get(sha []byte) []byte {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cache[string(bytes.ToLower(sha))]
+}
+
+func (c *scriptCache) Put(script []byte) string {
+	var sum = sha1.Sum(script)
+	var sha = hex.EncodeToString(sum[:])
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.cache[sha]; ok {
+		return sha
+	}
+	if len(c.cache) >= maxCachedScripts {
+		for key := range c.cache {
+			delete(c.cache, key)
+			break
+		}
+	}
+	c.cache[sha] = append([]byte(nil), script...)
+	return sha
+}
+
+func (c *scriptCache) Flush() {
+	c.Lock()
+	defer c.Unlock()
+	c.cache = make(map[string][]byte)
+}
+
+var errRespNoScript = []byte("NOSCRIPT")
+
+func (s *Session) handleRequestEval(r *Request, d *Router) error {
+	var nblks = len(r.Multi) - 1
+	switch {
+	case nblks < 2:
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for '%s' command", strings.ToLower(r.OpStr))
+		return nil
+	}
+	switch numkeys, err := redis.Btoi64(r.Multi[2].Value); {
+	case err != nil:
+		r.Resp = redis.NewErrorf("ERR value is not an integer or out of range")
+		return nil
+	case numkeys < 0:
+		r.Resp = redis.NewErrorf("ERR Number of keys can't be negative")
+		return nil
+	case numkeys > int64(nblks-2):
+		r.Resp = redis.NewErrorf("ERR Number of keys can't be greater than number of args")
+		return nil
+	}
+	if _, ok := getHashSlot(getHashKeys(r.Multi, r.OpStr)); !ok {
+		r.Resp = RespCrossSlot
+		return nil
+	}
+	if r.OpStr == "EVAL" {
+		d.scripts.Put(r.Multi[1].Value)
+		return d.dispatch(r)
+	}
+
+	var sub = r.MakeSubRequest(1)
+	sub[0].Multi = r.Multi
+	sub[0].Batch = &sync.WaitGroup{}
+	if err := d.dispatch(&sub[0]); err != nil {
+		return err
+	}
+	r.Batch.Add(1)
+	go func() {
+		defer r.Batch.Done()
+		m := &sub[0]
+		m.Batch.Wait()
+		if resp := m.Resp; m.Err == nil && resp != nil && resp.IsError() && bytes.HasPrefix(resp.Value, errRespNoScript) {
+			if script := d.scripts.Get(r.Multi[1].Value); script != nil {
+				m = s.reloadScript(r, d, script)
+			}
+		}
+		r.Resp, r.Err = m.Resp, m.Err
+	}()
+	return nil
+}
+
+//将EVALSHA改为EVAL重新执行，脚本会同时被加载到backend上
+func (s *Session) reloadScript(r *Request, d *Router, script []byte) *Request {
+	m := &Request{}
+	m.Multi = make([]*redis.Resp, len(r.Multi))
+	copy(m.Multi, r.Multi)
+	m.Multi[0] = redis.NewBulkBytes([]byte("EVAL"))
+	m.Multi[1] = redis.NewBulkBytes(script)
+	m.Batch = &sync.WaitGroup{}
+	m.OpStr, m.OpFlag = "EVAL", r.OpFlag
+	m.Database = r.Database
+	m.UnixNano = r.UnixNano
+	m.Broken = r.Broken
+
+	if err := d.dispatch(m); err != nil {
+		m.Err = err
+		return m
+	}
+	m.Batch.Wait()
+	return m
+}
+
+func (s *Session) handleRequestScript(r *Request, d *Router) error {
+	var nblks = len(r.Multi) - 1
+	if nblks == 0 {
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for 'script' command")
+		return nil
+	}
+	var subcmd = strings.ToUpper(string(r.Multi[1].Value))
+	switch {
+	case subcmd == "LOAD" && nblks == 2:
+		d.scripts.Put(r.Multi[2].Value)
+	case subcmd == "EXISTS" && nblks >= 2:
+	case subcmd == "FLUSH" && nblks == 1:
+		d.scripts.Flush()
+	case subcmd == "LOAD" || subcmd == "EXISTS" || subcmd == "FLUSH":
+		r.Resp = redis.NewErrorf("ERR Unknown SCRIPT subcommand or wrong # of args.")
+		return nil
+	default:
+		r.Resp = redis.NewErrorf("ERR SCRIPT %s is not supported by proxy", subcmd)
+		return nil
+	}
+
+	var addrs []string
+	for addr := range d.getBackendAddrs() {
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return ErrSlotIsNotReady
+	}
+	sort.Strings(addrs)
+
+	var sub = r.MakeSubRequest(len(addrs))
+	for i, addr := range addrs {
+		sub[i].Multi = r.Multi
+		if !d.dispatchAddr(&sub[i], addr) {
+			return fmt.Errorf("backend server '%s' not found", addr)
+		}
+	}
+	r.Coalesce = func() error {
+		var exists []bool
+		for i := range sub {
+			if err := sub[i].Err; err != nil {
+				return err
+			}
+			switch resp := sub[i].Resp; {
+			case resp == nil:
+				return ErrRespIsRequired
+			case resp.IsError():
+				r.Resp = resp
+				return nil
+			case subcmd == "EXISTS":
+				if !resp.IsArray() || len(resp.Array) != nblks-1 {
+					return fmt.Errorf("bad script exists resp: %s", resp.Type)
+				}
+				if exists == nil {
+					exists = make([]bool, len(resp.Array))
+					for j := range exists {
+						exists[j] = true
+					}
+				}
+				for j, x := range resp.Array {
+					if !x.IsInt() || len(x.Value) != 1 || x.Value[0] != '1' {
+						exists[j] = false
+					}
+				}
+			default:
+				r.Resp = resp
+			}
+		}
+		if subcmd == "EXISTS" {
+			var array = make([]*redis.Resp, len(exists))
+			for j, ok := range exists {
+				if ok {
+					array[j] = redis.NewInt([]byte("1"))
+				} else {
+					array[j] = redis.NewInt([]byte("0"))
+				}
+			}
+			r.Resp = redis.NewArray(array)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestScriptCache(t *testing.T) {
+	c := newScriptCache()
+
+	sha := c.Put([]byte("return 'hi'"))
+	assert.Must(sha == "2f31ba2bb6d6a0f42cc159d2e2dad55440778de3")
+	assert.Must(string(c.Get([]byte(sha))) == "return 'hi'")
+	assert.Must(string(c.Get([]byte("2F31BA2BB6D6A0F42CC159D2E2DAD55440778DE3"))) == "return 'hi'")
+
+	//每个Router有自己的脚本缓存
+	assert.Must(newScriptCache().Get([]byte(sha)) == nil)
+
+	c.Flush()
+	assert.Must(c.Get([]byte(sha)) == nil)
+}
+
+func TestScriptEvalArgs(t *testing.T) {
+	server := newMemServer()
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	d := newMemRouter(config, server.Addr())
+	defer d.Close()
+	s := newMemSession(config)
+	defer s.Conn.Close()
+
+	for _, x := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"EVAL", "return 1"}, "ERR wrong number of arguments for 'eval' command"},
+		{[]string{"EVAL", "return 1", "x"}, "ERR value is not an integer or out of range"},
+		{[]string{"EVAL", "return 1", "-1"}, "ERR Number of keys can't be negative"},
+		{[]string{"EVALSHA", "abc", "2", "a"}, "ERR Number of keys can't be greater than number of args"},
+		{[]string{"EVAL", "return 1", "2", "a", "b"}, string(RespCrossSlot.Value)},
+	} {
+		resp := runMemRequest(s, d, x.args...)
+		assert.Must(resp.IsError() && string(resp.Value) == x.err)
+	}
+	assert.Must(len(server.Commands()) == 0)
+
+	//keys属于同一个slot，numkeys之后的参数不是key
+	resp := runMemRequest(s, d, "EVAL", "return 1", "2", "{t}a", "{t}b", "c")
+	assert.Must(resp.IsBulkBytes() && string(resp.Value) == "return 1")
+	cmds := server.Commands()
+	assert.Must(len(cmds) == 1 && cmds[0] == "EVAL return 1 2 {t}a {t}b c")
+	assert.Must(d.scripts.Get([]byte("e0e1f9fabfc9d4800c877a703b823ac0578ff8db")) != nil)
+}
+
+func TestScriptNoScript(t *testing.T) {
+	server := newMemServer()
+	defer server.Close()
+
+	config := NewDefaultConfig()
+	d := newMemRouter(config, server.Addr())
+	defer d.Close()
+	s := newMemSession(config)
+	defer s.Conn.Close()
+
+	resp := runMemRequest(s, d, "EVAL", "return 2", "1", "k")
+	assert.Must(resp.IsBulkBytes() && string(resp.Value) == "return 2")
+	var sha = "7f923f79fe76194c868d7e1d0820de36700eb649"
+	server.Commands()
+
+	resp = runMemRequest(s, d, "EVALSHA", sha, "1", "k")
+	assert.Must(resp.IsBulkBytes() && string(resp.Value) == "return 2")
+	assert.Must(len(server.Commands()) == 1)
+
+	//backend上没有脚本的时候（比如slot迁移到了新的group），改为EVAL重新执行一次
+	server.FlushScripts()
+	resp = runMemRequest(s, d, "EVALSHA", sha, "1", "k")
+	assert.Must(resp.IsBulkBytes() && string(resp.Value) == "return 2")
+	cmds := server.Commands()
+	assert.Must(len(cmds) == 2 && cmds[0] == "EVALSHA "+sha+" 1 k" && cmds[1] == "EVAL return 2 1 k")
+
+	resp = runMemRequest(s, d, "EVALSHA", sha, "1", "k")
+	assert.Must(resp.IsBulkBytes() && len(server.Commands()) == 1)
+
+	//SCRIPT LOAD同样会缓存脚本
+	resp = runMemRequest(s, d, "SCRIPT", "LOAD", "return 3")
+	assert.Must(resp.IsBulkBytes() && d.scripts.Get(resp.Value) != nil)
+	server.Commands()
+
+	//没有经过这个Router的脚本，NOSCRIPT原样返回
+	server.FlushScripts()
+	d2 := newMemRouter(config, server.Addr())
+	defer d2.Close()
+	resp = runMemRequest(s, d2, "EVALSHA", sha, "1", "k")
+	assert.Must(resp.IsError() && string(resp.Value) == "NOSCRIPT No matching script. Please use EVAL.")
+	assert.Must(len(server.Commands()) == 1)
+}
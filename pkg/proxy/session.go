@@ -52,6 +52,28 @@ type Session struct {
 	config *Config
 
 	authorized bool
+	resp3      bool
+
+	//通过AUTH <user> <password>登录的ACL用户
+	user string
+
+	//session自己的令牌桶以及匹配到的CIDR的令牌桶，限流规则修改之后重新创建
+	ratelimit struct {
+		epoch    int64
+		session  *rateLimiter
+		cidr     *rateLimiter
+		maxDelay time.Duration
+	}
+
+	tx transaction
+
+	//read-your-writes模式以及每个slot最近一次写入之后只读master的截止时间
+	consistency consistency
+
+	tasks  *RequestChan
+	pubsub *pubsub
+
+	blocking blocking
 }
 
 func (s *Session) String() string {
@@ -73,15 +95,23 @@ func NewSession(sock net.Conn, config *Config) *Session {
 		config.SessionRecvBufsize.AsInt(),
 		config.SessionSendBufsize.AsInt(),
 	)
+	return newSession(c, config)
+}
+
+func newSession(c *redis.Conn, config *Config) *Session {
 	c.ReaderTimeout = config.SessionRecvTimeout.Duration()
 	c.WriterTimeout = config.SessionSendTimeout.Duration()
 	c.SetKeepAlivePeriod(config.SessionKeepAlivePeriod.Duration())
+	c.MaxBytes = config.ProxyMaxRequestBytes.Int64()
+	c.MaxArrayLen = int64(config.ProxyMaxRequestElements)
 
 	s := &Session{
 		Conn: c, config: config,
 		CreateUnix: time.Now().Unix(),
 	}
 	s.stats.opmap = make(map[string]*opStats, 16)
+	s.tx.slot = -1
+	s.consistency.readYourWrites = config.SessionReadYourWrites
 	log.Infof("session [%p] create: %s", s, s)
 	return s
 }
@@ -143,10 +173,11 @@ func (s *Session) Start(d *Router) {
 		}
 
 		tasks := NewRequestChanBuffer(1024)
+		s.tasks = tasks
 
 		go func() {
 			//合并请求结果，返回给客户端
-			s.loopWriter(tasks)
+			s.loopWriter(tasks, d)
 			//active session -1
 			decrSessions()
 		}()
@@ -163,6 +194,9 @@ func (s *Session) Start(d *Router) {
 func (s *Session) loopReader(tasks *RequestChan, d *Router) (err error) {
 	defer func() {
 		s.CloseReaderWithError(err)
+		s.resetTransaction()
+		s.closePubSub()
+		s.blocking.Close()
 	}()
 
 	var (
@@ -173,10 +207,11 @@ func (s *Session) loopReader(tasks *RequestChan, d *Router) (err error) {
 	//session只要没有退出，就一直从conn中取请求，直到请求取完就return，然后会关闭tasks这个requestChan
 	for !s.quit {
 		multi, err := s.Conn.DecodeMultiBulk()
-		if err != nil {
+		limit, _ := err.(*redis.LimitError)
+		if err != nil && limit == nil {
 			return err
 		}
-		if len(multi) == 0 {
+		if len(multi) == 0 && limit == nil {
 			continue
 		}
 		s.incrOpTotal()
@@ -200,8 +235,19 @@ func (s *Session) loopReader(tasks *RequestChan, d *Router) (err error) {
 		r.Database = s.database
 		r.UnixNano = start.UnixNano()
 
+		if limit != nil {
+			s.handleBigRequest(r, d, limit)
+			tasks.PushBack(r)
+			continue
+		}
+
+		//阻塞的命令返回之前不处理后面的请求，保证pipeline中的命令按照顺序执行
+		s.blocking.wait.Wait()
+
 		//将请求取出，然后根据不同的redis请求调用不同的方法，被调用的就是codis-server
-		if err := s.handleRequest(r, d); err != nil {
+		err = s.handleRequest(r, d)
+		r.Resp3 = s.resp3
+		if err != nil {
 			r.Resp = redis.NewErrorf("ERR handle request, %s", err)
 			tasks.PushBack(r)
 			if breakOnFailure {
@@ -217,7 +263,7 @@ func (s *Session) loopReader(tasks *RequestChan, d *Router) (err error) {
 
 //LoopWriter的作用就是合并请求的处理结果并返回给客户端。请求结果由BackendConn处理好之后，放在Request这个struct的*redis.Resp
 //属性中，这里只需要把结果取出。可以看到，codis将请求与结果关联起来的方式，就是把结果当做request的一个属性
-func (s *Session) loopWriter(tasks *RequestChan) (err error) {
+func (s *Session) loopWriter(tasks *RequestChan, d *Router) (err error) {
 	defer func() {
 		s.CloseWithError(err)
 		tasks.PopFrontAllVoid(func(r *Request) {
@@ -239,12 +285,21 @@ func (s *Session) loopWriter(tasks *RequestChan) (err error) {
 	//如果当前session的requestChan为空，就调用cond.wait让goroutine等待，直到调用pushback又放入请求为止
 	return tasks.PopFrontAll(func(r *Request) error {
 		resp, err := s.handleResponse(r)
+		if r.Cache != nil {
+			r.Cache.done(resp)
+		}
+		if err == nil && r.Mirror != nil {
+			r.Mirror.push(r, resp)
+		}
+		pushMirrorQueue(r.MirrorQueue, resp, err)
 		if err != nil {
 			resp = redis.NewErrorf("ERR handle response, %s", err)
 			if breakOnFailure {
 				s.Conn.Encode(resp, true)
 				return s.incrOpFails(r, err)
 			}
+		} else if r.Resp3 {
+			resp = upgradeResp3(r, resp)
 		}
 		if err := p.Encode(resp); err != nil {
 			return s.incrOpFails(r, err)
@@ -254,6 +309,7 @@ func (s *Session) loopWriter(tasks *RequestChan) (err error) {
 			return s.incrOpFails(r, err)
 		} else {
 			s.incrOpStats(r, resp.Type)
+			s.recordSlowLog(r, d)
 		}
 		if fflush {
 			s.flushOpStats(false)
@@ -294,6 +350,9 @@ func (s *Session) handleRequest(r *Request, d *Router) error {
 
 	//有些命令不支持，就会返回错误
 	if flag.IsNotAllowed() {
+		if s.tx.multi {
+			s.tx.abort = RespExecAbort
+		}
 		return fmt.Errorf("command '%s' is not allowed", opstr)
 	}
 
@@ -301,7 +360,9 @@ func (s *Session) handleRequest(r *Request, d *Router) error {
 	case "QUIT":
 		return s.handleQuit(r)
 	case "AUTH":
-		return s.handleAuth(r)
+		return s.handleAuth(r, d)
+	case "HELLO":
+		return s.handleHello(r, d)
 	}
 
 	if !s.authorized {
@@ -312,6 +373,43 @@ func (s *Session) handleRequest(r *Request, d *Router) error {
 		s.authorized = true
 	}
 
+	if resp := s.checkACL(r, d); resp != nil {
+		if s.tx.multi {
+			s.tx.abort = RespExecAbort
+		}
+		r.Resp = resp
+		return nil
+	}
+
+	if resp := s.checkRateLimit(r, d); resp != nil {
+		if s.tx.multi {
+			s.tx.abort = RespExecAbort
+		}
+		r.Resp = resp
+		return nil
+	}
+
+	s.sampleHotKeys(r, d)
+
+	if s.pubsub != nil {
+		return s.handlePubSub(r, d)
+	}
+
+	s.checkConsistency(r)
+
+	if s.tx.isActive() || isTransactionOp(opstr) {
+		return s.handleTransaction(r, d)
+	}
+
+	r.Mirror = d.pickMirror(r)
+
+	resp, ticket := d.cache.lookup(r)
+	if resp != nil {
+		r.Resp = resp
+		return nil
+	}
+	r.Cache = ticket
+
 	switch opstr {
 	case "SELECT":
 		//select db命令
@@ -324,6 +422,10 @@ func (s *Session) handleRequest(r *Request, d *Router) error {
 		return s.handleRequestMGet(r, d)
 	case "MSET":
 		return s.handleRequestMSet(r, d)
+	case "MSETNX":
+		return s.handleRequestMSetNX(r, d)
+	case "RENAME", "RENAMENX":
+		return s.handleRequestRename(r, d)
 	case "DEL":
 		return s.handleRequestDel(r, d)
 	case "EXISTS":
@@ -334,6 +436,22 @@ func (s *Session) handleRequest(r *Request, d *Router) error {
 		return s.handleRequestSlotsScan(r, d)
 	case "SLOTSMAPPING":
 		return s.handleRequestSlotsMapping(r, d)
+	case "SLOWLOG":
+		return s.handleRequestSlowLog(r, d)
+	case "CODIS.CONSISTENCY":
+		return s.handleConsistency(r)
+	case "EVAL", "EVALSHA":
+		return s.handleRequestEval(r, d)
+	case "SCRIPT":
+		return s.handleRequestScript(r, d)
+	case "SCAN":
+		return s.handleRequestScan(r, d)
+	case "KEYS":
+		return s.handleRequestKeys(r, d)
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return s.handlePubSub(r, d)
+	case "BLPOP", "BRPOP", "BRPOPLPUSH":
+		return s.handleRequestBlocking(r, d)
 	default:
 		return d.dispatch(r)
 	}
@@ -345,19 +463,34 @@ func (s *Session) handleQuit(r *Request) error {
 	return nil
 }
 
-func (s *Session) handleAuth(r *Request) error {
-	if len(r.Multi) != 2 {
+func (s *Session) handleAuth(r *Request, d *Router) error {
+	switch len(r.Multi) {
+	case 2:
+	case 3:
+		var username, password = string(r.Multi[1].Value), string(r.Multi[2].Value)
+		if !s.authenticate(d, username, password) {
+			s.authorized, s.user = false, ""
+			r.Resp = redis.NewErrorf("WRONGPASS invalid username-password pair")
+			return nil
+		}
+		s.authorized, s.user = true, ""
+		if username != models.ACLDefaultUser {
+			s.user = username
+		}
+		r.Resp = RespOK
+		return nil
+	default:
 		r.Resp = redis.NewErrorf("ERR wrong number of arguments for 'AUTH' command")
 		return nil
 	}
 	switch {
 	case s.config.SessionAuth == "":
 		r.Resp = redis.NewErrorf("ERR Client sent AUTH, but no password is set")
-	case s.config.SessionAuth != string(r.Multi[1].Value):
-		s.authorized = false
+	case !s.authenticate(d, models.ACLDefaultUser, string(r.Multi[1].Value)):
+		s.authorized, s.user = false, ""
 		r.Resp = redis.NewErrorf("ERR invalid password")
 	default:
-		s.authorized = true
+		s.authorized, s.user = true, ""
 		r.Resp = RespOK
 	}
 	return nil
@@ -679,10 +812,21 @@ func (s *Session) getOpStats(opstr string) *opStats {
 	return e
 }
 
+//pipeline一直不为空的时候不会定期汇总，未汇总的耗时超过这个数量之后立即汇总
+const maxOpStatsSamples = 1024
+
 func (s *Session) incrOpStats(r *Request, t redis.RespType) {
+	if r.OpStr == "" {
+		return
+	}
 	e := s.getOpStats(r.OpStr)
 	e.calls.Incr()
-	e.nsecs.Add(time.Now().UnixNano() - r.UnixNano)
+	nsecs := time.Now().UnixNano() - r.UnixNano
+	e.nsecs.Add(nsecs)
+	e.samples = append(e.samples, nsecs/1e3)
+	if len(e.samples) >= maxOpStatsSamples {
+		incrOpStats(e)
+	}
 	switch t {
 	case redis.TypeError:
 		e.redis.errors.Incr()
@@ -690,11 +834,12 @@ func (s *Session) incrOpStats(r *Request, t redis.RespType) {
 }
 
 func (s *Session) incrOpFails(r *Request, err error) error {
-	if r != nil {
+	switch {
+	case r == nil:
+		s.stats.fails.Incr()
+	case r.OpStr != "":
 		e := s.getOpStats(r.OpStr)
 		e.fails.Incr()
-	} else {
-		s.stats.fails.Incr()
 	}
 	return err
 }
@@ -24,6 +24,8 @@ type Slot struct {
 		bc *sharedBackendConn
 	}
 	replicaGroups [][]*sharedBackendConn
+	//backend_primary_only的时候replica group只在master的熔断器打开的时候使用
+	primaryOnly bool
 
 	//slot的
 	method forwardMethod
@@ -0,0 +1,217 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+)
+
+//slowlog的实现：
+//1. 请求返回给客户端之后，从Request.UnixNano开始计算耗时，超过proxy_slowlog_slower_than的请求记录在一个环形缓冲区中；
+//2. BackendConn记录了请求写入backend和收到返回的时间，耗时分为排队时间（proxy内部以及等待发送）和backend处理时间，
+//   MGET/MSET等被拆分的请求使用最慢的子请求；
+//3. 和redis一样，每条记录最多保留32个参数，每个参数最多128个字节；
+//4. 客户端可以通过SLOWLOG GET/LEN/RESET查询，返回的每条记录在redis的格式之后追加了backend、slot以及排队和backend的耗时；
+//5. 每个Router有自己的slowlog；BLPOP等阻塞的命令的耗时主要是等待的时间，不记录在slowlog中。
+
+const (
+	slowLogMaxArgc   = 32
+	slowLogMaxString = 128
+)
+
+type SlowLogEntry struct {
+	Id       int64 `json:"id"`
+	UnixTime int64 `json:"unixtime"`
+
+	Duration int64 `json:"duration_us"`
+	Queued   int64 `json:"queued_us"`
+	Backend  int64 `json:"backend_us"`
+
+	Args        []string `json:"args"`
+	RemoteAddr  string   `json:"remote_addr"`
+	BackendAddr string   `json:"backend_addr,omitempty"`
+	Slot        int      `json:"slot"`
+}
+
+type slowLogger struct {
+	mu sync.Mutex
+
+	slowerThan int64
+	maxLen     int
+
+	id      int64
+	entries []*SlowLogEntry
+	next    int
+}
+
+func newSlowLogger(slowerThan time.Duration, maxLen int) *slowLogger {
+	return &slowLogger{
+		slowerThan: int64(slowerThan),
+		maxLen:     maxLen,
+	}
+}
+
+func (l *slowLogger) push(e *SlowLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Id, l.id = l.id, l.id+1
+	if len(l.entries) < l.maxLen {
+		l.entries = append(l.entries, e)
+	} else {
+		l.entries[l.next] = e
+	}
+	l.next = (l.next + 1) % l.maxLen
+}
+
+//按照从新到旧的顺序返回最近的n条记录，n小于0的时候返回所有记录
+func (l *slowLogger) Get(n int) []*SlowLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n < 0 || n > len(l.entries) {
+		n = len(l.entries)
+	}
+	var entries = make([]*SlowLogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return entries
+}
+
+func (l *slowLogger) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+func (l *slowLogger) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries, l.next = nil, 0
+}
+
+//没有配置的时候返回nil
+func newSlowLoggerFromConfig(config *Config) *slowLogger {
+	if config.ProxySlowLogSlowerThan <= 0 || config.ProxySlowLogMaxLen <= 0 {
+		return nil
+	}
+	return newSlowLogger(config.ProxySlowLogSlowerThan.Duration(), config.ProxySlowLogMaxLen)
+}
+
+func newSlowLogEntry(r *Request, nsecs int64) *SlowLogEntry {
+	e := &SlowLogEntry{
+		UnixTime: r.UnixNano / int64(time.Second),
+		Duration: nsecs / int64(time.Microsecond),
+		Slot:     -1,
+	}
+	for i, arg := range r.Multi {
+		if i == slowLogMaxArgc-1 && len(r.Multi) > slowLogMaxArgc {
+			e.Args = append(e.Args, fmt.Sprintf("... (%d more arguments)", len(r.Multi)-i))
+			break
+		}
+		if len(arg.Value) > slowLogMaxString {
+			e.Args = append(e.Args, fmt.Sprintf("%s... (%d more bytes)",
+				arg.Value[:slowLogMaxString], len(arg.Value)-slowLogMaxString))
+		} else {
+			e.Args = append(e.Args, string(arg.Value))
+		}
+	}
+
+	//被拆分的请求使用最慢的子请求计算backend的耗时
+	var x = r
+	for i := range r.Subs {
+		sub := &r.Subs[i]
+		if sub.SendNano != 0 && (x.SendNano == 0 || sub.RecvNano-sub.SendNano > x.RecvNano-x.SendNano) {
+			x = sub
+		}
+	}
+	if x.SendNano != 0 {
+		e.Queued = (x.SendNano - r.UnixNano) / int64(time.Microsecond)
+		e.Backend = (x.RecvNano - x.SendNano) / int64(time.Microsecond)
+		e.BackendAddr = x.Backend
+		if slot, ok := getHashSlot(getHashKeys(x.Multi, x.OpStr)); ok {
+			e.Slot = slot
+		}
+	}
+	return e
+}
+
+func (s *Session) recordSlowLog(r *Request, d *Router) {
+	l := d.slowlog
+	if l == nil || r.OpFlag.IsBlocking() {
+		return
+	}
+	var nsecs = time.Now().UnixNano() - r.UnixNano
+	if nsecs < l.slowerThan {
+		return
+	}
+	e := newSlowLogEntry(r, nsecs)
+	e.RemoteAddr = s.Conn.RemoteAddr()
+	l.push(e)
+}
+
+func (s *Session) handleRequestSlowLog(r *Request, d *Router) error {
+	if len(r.Multi) < 2 {
+		r.Resp = redis.NewErrorf("ERR wrong number of arguments for 'SLOWLOG' command")
+		return nil
+	}
+	var l = d.slowlog
+	switch sub := strings.ToUpper(string(r.Multi[1].Value)); {
+	case sub == "GET" && len(r.Multi) <= 3:
+		var n = 10
+		if len(r.Multi) == 3 {
+			v, err := redis.Btoi64(r.Multi[2].Value)
+			if err != nil {
+				r.Resp = redis.NewErrorf("ERR value is not an integer or out of range")
+				return nil
+			}
+			n = int(v)
+		}
+		var array = []*redis.Resp{}
+		if l != nil {
+			for _, e := range l.Get(n) {
+				array = append(array, e.toResp())
+			}
+		}
+		r.Resp = redis.NewArray(array)
+	case sub == "LEN" && len(r.Multi) == 2:
+		var n int
+		if l != nil {
+			n = l.Len()
+		}
+		r.Resp = redis.NewInt(strconv.AppendInt(nil, int64(n), 10))
+	case sub == "RESET" && len(r.Multi) == 2:
+		if l != nil {
+			l.Reset()
+		}
+		r.Resp = RespOK
+	default:
+		r.Resp = redis.NewErrorf("ERR Unknown SLOWLOG subcommand or wrong number of arguments for '%s'", r.Multi[1].Value)
+	}
+	return nil
+}
+
+func (e *SlowLogEntry) toResp() *redis.Resp {
+	var args = make([]*redis.Resp, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = redis.NewBulkBytes([]byte(arg))
+	}
+	return redis.NewArray([]*redis.Resp{
+		redis.NewInt(strconv.AppendInt(nil, e.Id, 10)),
+		redis.NewInt(strconv.AppendInt(nil, e.UnixTime, 10)),
+		redis.NewInt(strconv.AppendInt(nil, e.Duration, 10)),
+		redis.NewArray(args),
+		redis.NewBulkBytes([]byte(e.RemoteAddr)),
+		redis.NewBulkBytes([]byte("")),
+		redis.NewBulkBytes([]byte(e.BackendAddr)),
+		redis.NewInt(strconv.AppendInt(nil, int64(e.Slot), 10)),
+		redis.NewInt(strconv.AppendInt(nil, e.Queued, 10)),
+		redis.NewInt(strconv.AppendInt(nil, e.Backend, 10)),
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+	"github.com/thesunnysky/codis/pkg/utils/timesize"
+)
+
+func TestSlowLogRing(t *testing.T) {
+	l := newSlowLogger(time.Millisecond, 3)
+	for i := 0; i < 5; i++ {
+		l.push(&SlowLogEntry{})
+	}
+	assert.Must(l.Len() == 3)
+
+	entries := l.Get(-1)
+	assert.Must(len(entries) == 3)
+	for i, e := range entries {
+		assert.Must(e.Id == int64(4-i))
+	}
+	entries = l.Get(1)
+	assert.Must(len(entries) == 1 && entries[0].Id == 4)
+
+	l.Reset()
+	assert.Must(l.Len() == 0)
+	l.push(&SlowLogEntry{})
+	entries = l.Get(10)
+	assert.Must(len(entries) == 1 && entries[0].Id == 5)
+}
+
+func TestSlowLogEntry(t *testing.T) {
+	var args = [][]byte{[]byte("MSET")}
+	for i := 0; i < 20; i++ {
+		args = append(args, []byte("key"), bytes.Repeat([]byte("v"), 200))
+	}
+	r := &Request{Multi: newMultiBulk(args...), OpStr: "MSET", UnixNano: 1000}
+	r.Subs = r.MakeSubRequest(2)
+	r.Subs[0].Multi, r.Subs[0].SendNano, r.Subs[0].RecvNano = newMultiBulk([]byte("MSET"), []byte("a"), []byte("1")), 2000, 3000
+	r.Subs[1].Multi, r.Subs[1].SendNano, r.Subs[1].RecvNano = newMultiBulk([]byte("MSET"), []byte("b"), []byte("1")), 2000, 9000
+	r.Subs[1].Backend = "127.0.0.1:6379"
+
+	e := newSlowLogEntry(r, 10000)
+	assert.Must(len(e.Args) == slowLogMaxArgc)
+	assert.Must(e.Args[0] == "MSET" && e.Args[1] == "key")
+	assert.Must(strings.HasSuffix(e.Args[2], "... (72 more bytes)"))
+	assert.Must(e.Args[slowLogMaxArgc-1] == "... (10 more arguments)")
+	assert.Must(e.Duration == 10)
+	assert.Must(e.Queued == 1 && e.Backend == 7)
+	assert.Must(e.BackendAddr == "127.0.0.1:6379")
+	assert.Must(e.Slot == int(Hash([]byte("b"))%MaxSlotNum))
+}
+
+func TestSlowLogRecord(t *testing.T) {
+	config := NewDefaultConfig()
+	config.ProxySlowLogSlowerThan = timesize.Duration(time.Millisecond)
+	config.ProxySlowLogMaxLen = 8
+	d := NewRouter(config)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	s := &Session{Conn: redis.NewConn(c1, 1024, 1024)}
+
+	//阻塞的命令不记录
+	r := newTestRequest("BLPOP", "list", "1")
+	r.UnixNano = time.Now().Add(-time.Second).UnixNano()
+	s.recordSlowLog(r, d)
+	assert.Must(d.slowlog.Len() == 0)
+
+	r = newTestRequest("GET", "key")
+	r.UnixNano = time.Now().Add(-time.Second).UnixNano()
+	s.recordSlowLog(r, d)
+	assert.Must(d.slowlog.Len() == 1)
+
+	config.ProxySlowLogMaxLen = 0
+	assert.Must(NewRouter(config).slowlog == nil)
+}
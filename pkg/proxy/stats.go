@@ -22,6 +22,10 @@ type opStats struct {
 	redis struct {
 		errors atomic2.Int64
 	}
+
+	//session中记录尚未汇总的耗时（微秒），汇总的时候计入全局的直方图
+	samples []int64
+	latency *histogram
 }
 
 func (s *opStats) OpStats() *OpStats {
@@ -35,6 +39,9 @@ func (s *opStats) OpStats() *OpStats {
 		o.UsecsPercall = o.Usecs / o.Calls
 	}
 	o.RedisErrType = s.redis.errors.Int64()
+	if s.latency != nil {
+		o.Latency = s.latency.Histogram()
+	}
 	return o
 }
 
@@ -45,6 +52,8 @@ type OpStats struct {
 	UsecsPercall int64  `json:"usecs_percall"`
 	Fails        int64  `json:"fails"`
 	RedisErrType int64  `json:"redis_errtype"`
+
+	Latency *Histogram `json:"latency,omitempty"`
 }
 
 var cmdstats struct {
@@ -102,7 +111,7 @@ func getOpStats(opstr string, create bool) *opStats {
 	cmdstats.Lock()
 	s = cmdstats.opmap[opstr]
 	if s == nil {
-		s = &opStats{opstr: opstr}
+		s = &opStats{opstr: opstr, latency: &histogram{}}
 		cmdstats.opmap[opstr] = s
 	}
 	cmdstats.Unlock()
@@ -143,6 +152,8 @@ func ResetStats() {
 	cmdstats.fails.Set(0)
 	cmdstats.redis.errors.Set(0)
 	sessions.total.Set(sessions.alive.Int64())
+
+	resetBackendStats()
 }
 
 func incrOpTotal(n int64) {
@@ -157,6 +168,10 @@ func incrOpStats(e *opStats) {
 	s := getOpStats(e.opstr, true)
 	s.calls.Add(e.calls.Swap(0))
 	s.nsecs.Add(e.nsecs.Swap(0))
+	for _, usecs := range e.samples {
+		s.latency.Record(usecs)
+	}
+	e.samples = e.samples[:0]
 	if n := e.fails.Swap(0); n != 0 {
 		s.fails.Add(n)
 		cmdstats.fails.Add(n)
@@ -167,6 +182,86 @@ func incrOpStats(e *opStats) {
 	}
 }
 
+//BackendConn在创建的时候获取backend对应的直方图，所以重置统计的时候只清空计数，不会替换直方图；
+//所有的BackendConn都关闭之后删除这个backend的直方图
+var backendstats struct {
+	sync.RWMutex
+
+	latency map[string]*backendLatency
+}
+
+type backendLatency struct {
+	histogram
+
+	refs int
+}
+
+func init() {
+	backendstats.latency = make(map[string]*backendLatency)
+}
+
+func getBackendLatency(addr string) *histogram {
+	backendstats.Lock()
+	defer backendstats.Unlock()
+	h := backendstats.latency[addr]
+	if h == nil {
+		h = &backendLatency{}
+		backendstats.latency[addr] = h
+	}
+	h.refs++
+	return &h.histogram
+}
+
+func putBackendLatency(addr string) {
+	backendstats.Lock()
+	defer backendstats.Unlock()
+	if h := backendstats.latency[addr]; h != nil {
+		if h.refs--; h.refs == 0 {
+			delete(backendstats.latency, addr)
+		}
+	}
+}
+
+type BackendStats struct {
+	Addr    string     `json:"addr"`
+	Latency *Histogram `json:"latency"`
+}
+
+type sliceBackendStats []*BackendStats
+
+func (s sliceBackendStats) Len() int {
+	return len(s)
+}
+
+func (s sliceBackendStats) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s sliceBackendStats) Less(i, j int) bool {
+	return s[i].Addr < s[j].Addr
+}
+
+func GetBackendStatsAll() []*BackendStats {
+	var all = make([]*BackendStats, 0, 16)
+	backendstats.RLock()
+	for addr, h := range backendstats.latency {
+		if x := h.Histogram(); x.Count != 0 {
+			all = append(all, &BackendStats{Addr: addr, Latency: x})
+		}
+	}
+	backendstats.RUnlock()
+	sort.Sort(sliceBackendStats(all))
+	return all
+}
+
+func resetBackendStats() {
+	backendstats.RLock()
+	defer backendstats.RUnlock()
+	for _, h := range backendstats.latency {
+		h.Reset()
+	}
+}
+
 var sessions struct {
 	total atomic2.Int64
 	alive atomic2.Int64
@@ -0,0 +1,165 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+)
+
+//TLS的实现：
+//1. proxy_tls_addr上单独监听一个TLS端口，proxy_addr上的明文端口保持不变，方便客户端逐步切换；
+//2. 配置了proxy_tls_client_ca之后要求客户端提供证书（mutual TLS），打开proxy_tls_client_cert_user的时候，
+//   证书的CommonName作为session登录的ACL用户，不需要再执行AUTH，CommonName为空或者不是ACL中的用户的时候仍然需要AUTH；
+//3. backend_tls打开之后，BackendConn以及Pub/Sub使用TLS连接codis-server；
+//4. 证书在收到SIGHUP的时候重新加载，只对新建立的连接生效。
+
+const tlsHandshakeTimeout = time.Second * 10
+
+type tlsConfigs struct {
+	mu      sync.RWMutex
+	server  *tls.Config
+	backend *tls.Config
+}
+
+func newTLSConfigs(config *Config) (*tlsConfigs, error) {
+	p := &tlsConfigs{}
+	if err := p.Reload(config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *tlsConfigs) Reload(config *Config) error {
+	var server, backend *tls.Config
+	if config.ProxyTLSAddr != "" {
+		cert, err := tls.LoadX509KeyPair(config.ProxyTLSCert, config.ProxyTLSKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		server = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if config.ProxyTLSClientCA != "" {
+			pool, err := loadCertPool(config.ProxyTLSClientCA)
+			if err != nil {
+				return err
+			}
+			server.ClientCAs = pool
+			server.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if config.BackendTLS {
+		backend = &tls.Config{InsecureSkipVerify: config.BackendTLSSkipVerify}
+		if config.BackendTLSCA != "" {
+			pool, err := loadCertPool(config.BackendTLSCA)
+			if err != nil {
+				return err
+			}
+			backend.RootCAs = pool
+		}
+		if config.BackendTLSCert != "" {
+			cert, err := tls.LoadX509KeyPair(config.BackendTLSCert, config.BackendTLSKey)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			backend.Certificates = []tls.Certificate{cert}
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.server, p.backend = server, backend
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.Errorf("no valid certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+func (p *tlsConfigs) Server() *tls.Config {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.server
+}
+
+func (p *tlsConfigs) Backend() *tls.Config {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.backend
+}
+
+func dialBackend(addr string, config *Config, tls *tlsConfigs) (*redis.Conn, error) {
+	return redis.DialTimeoutTLS(addr, time.Second*5,
+		config.BackendRecvBufsize.AsInt(),
+		config.BackendSendBufsize.AsInt(),
+		tls.Backend())
+}
+
+//完成TLS握手之后才能拿到客户端的证书，握手在单独的goroutine中进行，不会阻塞accept
+func (s *Proxy) newTLSSession(c net.Conn) {
+	conn := tls.Server(c, s.tls.Server())
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		log.WarnErrorf(err, "[%p] tls handshake with %s failed", s, c.RemoteAddr())
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	session := newSession(redis.NewTLSConn(conn, c,
+		s.config.SessionRecvBufsize.AsInt(),
+		s.config.SessionSendBufsize.AsInt(),
+	), s.config)
+	if s.config.ProxyTLSClientCertUser {
+		if user := s.router.getCertUser(conn.ConnectionState().PeerCertificates); user != "" {
+			session.authorized, session.user = true, user
+		}
+	}
+	session.Start(s.router)
+}
+
+//证书的CommonName必须是ACL中存在的用户，否则返回空字符串，session仍然需要AUTH
+func (s *Router) getCertUser(certs []*x509.Certificate) string {
+	if len(certs) == 0 {
+		return ""
+	}
+	var name = certs[0].Subject.CommonName
+	if name == "" || s.getACLUser(name) == nil {
+		return ""
+	}
+	return name
+}
+
+func (s *Proxy) ReloadTLS() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosedProxy
+	}
+	if err := s.tls.Reload(s.config); err != nil {
+		return err
+	}
+	log.Warnf("[%p] reload tls certificates", s)
+	return nil
+}
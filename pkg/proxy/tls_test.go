@@ -0,0 +1,154 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+//生成127.0.0.1的自签名证书，返回证书和私钥的文件路径
+func newTestCert(dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.MustNoError(err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "codis"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.MustNoError(err)
+	b, err := x509.MarshalECPrivateKey(key)
+	assert.MustNoError(err)
+
+	var cert, pkey = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.MustNoError(ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.MustNoError(ioutil.WriteFile(pkey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600))
+	return cert, pkey
+}
+
+func TestTLSProxy(x *testing.T) {
+	dir, err := ioutil.TempDir("", "codis-tls")
+	assert.MustNoError(err)
+	defer os.RemoveAll(dir)
+	cert, key := newTestCert(dir)
+
+	config := newProxyConfig()
+	config.ProxyTLSAddr = "127.0.0.1:0"
+	config.ProxyTLSCert = cert
+	config.ProxyTLSKey = key
+	s, err := New(config)
+	assert.MustNoError(err)
+	defer s.Close()
+
+	pool, err := loadCertPool(cert)
+	assert.MustNoError(err)
+
+	//证书不受信任的时候握手失败
+	_, err = tls.Dial("tcp", s.ltls.Addr().String(), &tls.Config{})
+	assert.Must(err != nil)
+
+	c, err := redis.DialTimeoutTLS(s.ltls.Addr().String(), time.Second, 1024, 1024, &tls.Config{RootCAs: pool})
+	assert.MustNoError(err)
+	defer c.Close()
+	assert.MustNoError(c.SetKeepAlivePeriod(time.Second))
+
+	assert.MustNoError(c.EncodeMultiBulk([]*redis.Resp{redis.NewBulkBytes([]byte("PING"))}, true))
+	resp, err := c.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsError() && string(resp.Value) == "ERR router is not online")
+}
+
+func TestTLSBackend(x *testing.T) {
+	dir, err := ioutil.TempDir("", "codis-tls")
+	assert.MustNoError(err)
+	defer os.RemoveAll(dir)
+	cert, key := newTestCert(dir)
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	assert.MustNoError(err)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pair}})
+	assert.MustNoError(err)
+	defer l.Close()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c *redis.Conn) {
+				defer c.Close()
+				for {
+					if _, err := c.Decode(); err != nil {
+						return
+					}
+					if err := c.Encode(redis.NewString([]byte("PONG")), true); err != nil {
+						return
+					}
+				}
+			}(redis.NewConn(c, 1024, 1024))
+		}
+	}()
+
+	config := NewDefaultConfig()
+	config.BackendTLS = true
+	p, err := newTLSConfigs(config)
+	assert.MustNoError(err)
+
+	//没有配置CA的时候无法验证自签名证书
+	_, err = dialBackend(l.Addr().String(), config, p)
+	assert.Must(err != nil)
+
+	config.BackendTLSCA = cert
+	assert.MustNoError(p.Reload(config))
+
+	c, err := dialBackend(l.Addr().String(), config, p)
+	assert.MustNoError(err)
+	defer c.Close()
+	assert.MustNoError(c.SetKeepAlivePeriod(time.Second))
+
+	assert.MustNoError(c.EncodeMultiBulk([]*redis.Resp{redis.NewBulkBytes([]byte("PING"))}, true))
+	resp, err := c.Decode()
+	assert.MustNoError(err)
+	assert.Must(resp.IsString() && string(resp.Value) == "PONG")
+}
+
+func TestTLSCertUser(x *testing.T) {
+	d := NewRouter(NewDefaultConfig())
+	defer d.Close()
+	d.SetACL(&models.ACL{Users: []*models.ACLUser{{Name: "alice"}}})
+
+	var newCert = func(name string) []*x509.Certificate {
+		return []*x509.Certificate{{Subject: pkix.Name{CommonName: name}}}
+	}
+	assert.Must(d.getCertUser(newCert("alice")) == "alice")
+
+	//CommonName为空或者不是ACL中的用户的时候不能绕过session_auth
+	assert.Must(d.getCertUser(newCert("")) == "")
+	assert.Must(d.getCertUser(newCert("bob")) == "")
+	assert.Must(d.getCertUser(nil) == "")
+}
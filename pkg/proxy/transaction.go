@@ -0,0 +1,215 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package proxy
+
+import (
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/proxy/redis"
+)
+
+//MULTI/EXEC事务的实现：
+//1. 事务中第一个带key的命令（或者WATCH）决定了事务所在的slot，session会为这个slot的master创建一个独占的BackendConn；
+//2. MULTI之后的命令只在proxy中排队并返回QUEUED，每个命令的keys都必须和事务属于同一个slot，否则EXEC会返回CROSSSLOT错误；
+//3. EXEC时将MULTI、排队的命令和EXEC作为一个请求写入独占的BackendConn，保证中间不会因为重连而被拆开执行；
+//4. EXEC/DISCARD/UNWATCH之后关闭独占的BackendConn，backend上WATCH的状态也随之释放；
+//5. 独占的BackendConn断开之后不会重连（重连之后WATCH的状态已经丢失），之后的EXEC返回EXECABORT。
+
+var (
+	RespQueued = redis.NewString([]byte("QUEUED"))
+
+	RespCrossSlot      = redis.NewErrorf("CROSSSLOT Keys in request don't hash to the same slot")
+	RespCrossSlotAbort = redis.NewErrorf("CROSSSLOT Transaction discarded because keys don't hash to the same slot")
+	RespExecAbort      = redis.NewErrorf("EXECABORT Transaction discarded because of previous errors.")
+)
+
+type transaction struct {
+	multi bool
+	slot  int
+
+	bc   *BackendConn
+	last *Request
+
+	keys  [][]byte
+	queue [][]*redis.Resp
+	abort *redis.Resp
+
+	//排队的命令中需要镜像的命令
+	mirrors []*mirrorQueued
+	//排队的写命令涉及的缓存的key，EXEC返回之后再删除一次
+	cache *cacheTicket
+}
+
+func (t *transaction) isActive() bool {
+	return t.multi || t.bc != nil
+}
+
+func isTransactionOp(opstr string) bool {
+	switch opstr {
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+		return true
+	}
+	return false
+}
+
+func (s *Session) handleTransaction(r *Request, d *Router) error {
+	switch r.OpStr {
+	case "MULTI":
+		if s.tx.multi {
+			r.Resp = redis.NewErrorf("ERR MULTI calls can not be nested")
+			return nil
+		}
+		s.tx.multi = true
+		r.Resp = RespOK
+		return nil
+	case "DISCARD":
+		if !s.tx.multi {
+			r.Resp = redis.NewErrorf("ERR DISCARD without MULTI")
+			return nil
+		}
+		s.resetTransaction()
+		r.Resp = RespOK
+		return nil
+	case "EXEC":
+		return s.handleTransactionExec(r, d)
+	case "WATCH":
+		switch {
+		case s.tx.multi:
+			r.Resp = redis.NewErrorf("ERR WATCH inside MULTI is not allowed")
+			return nil
+		case len(r.Multi) < 2:
+			r.Resp = redis.NewErrorf("ERR wrong number of arguments for 'WATCH' command")
+			return nil
+		}
+	case "UNWATCH":
+		if !s.tx.multi {
+			s.resetTransaction()
+			r.Resp = RespOK
+			return nil
+		}
+	case "SELECT", "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		if s.tx.multi {
+			s.tx.abort = RespExecAbort
+		}
+		r.Resp = redis.NewErrorf("ERR %s is not allowed in transaction", r.OpStr)
+		return nil
+	}
+	if s.tx.multi {
+		return s.queueTransaction(r, d)
+	}
+	return s.forwardTransaction(r, d)
+}
+
+func (s *Session) queueTransaction(r *Request, d *Router) error {
+	var keys = getHashKeys(r.Multi, r.OpStr)
+	switch slot, ok := getHashSlot(keys); {
+	case !ok || (slot >= 0 && s.tx.slot >= 0 && slot != s.tx.slot):
+		s.tx.abort = RespCrossSlotAbort
+		r.Resp = RespCrossSlot
+		return nil
+	case slot >= 0 && s.tx.bc == nil:
+		if err := s.pinTransaction(d, slot); err != nil {
+			s.tx.abort = RespExecAbort
+			return err
+		}
+	}
+	if w := d.pickMirror(r); w != nil {
+		s.tx.mirrors = append(s.tx.mirrors, &mirrorQueued{worker: w, index: len(s.tx.queue), r: r})
+	}
+	if !r.IsReadOnly() {
+		_, ticket := d.cache.lookup(r)
+		s.tx.cache = s.tx.cache.merge(ticket)
+	}
+	s.tx.keys = append(s.tx.keys, keys...)
+	s.tx.queue = append(s.tx.queue, r.Multi)
+	r.Resp = RespQueued
+	return nil
+}
+
+func (s *Session) forwardTransaction(r *Request, d *Router) error {
+	var keys = getHashKeys(r.Multi, r.OpStr)
+	switch slot, ok := getHashSlot(keys); {
+	case !ok || (slot >= 0 && s.tx.bc != nil && slot != s.tx.slot):
+		r.Resp = RespCrossSlot
+		return nil
+	case s.tx.bc == nil:
+		if slot < 0 {
+			return d.dispatch(r)
+		}
+		if err := s.pinTransaction(d, slot); err != nil {
+			return err
+		}
+	}
+	r.Mirror = d.pickMirror(r)
+
+	//WATCH之后的读请求需要读到最新的数据，不使用缓存
+	if !r.IsReadOnly() {
+		_, r.Cache = d.cache.lookup(r)
+	}
+
+	if err := d.dispatchPinned(r, s.tx.slot, keys, s.tx.bc); err != nil {
+		s.resetTransaction()
+		return err
+	}
+	s.tx.last = r
+	return nil
+}
+
+func (s *Session) handleTransactionExec(r *Request, d *Router) error {
+	if !s.tx.multi {
+		r.Resp = redis.NewErrorf("ERR EXEC without MULTI")
+		return nil
+	}
+	defer s.resetTransaction()
+
+	switch {
+	case s.tx.abort != nil:
+		r.Resp = s.tx.abort
+		return nil
+	case s.tx.bc != nil && s.tx.bc.IsBroken():
+		r.Resp = RespPinnedConnBroken
+		return nil
+	}
+	if s.tx.bc == nil {
+		slot := uint32(time.Now().Nanosecond()) % MaxSlotNum
+		if err := s.pinTransaction(d, int(slot)); err != nil {
+			return err
+		}
+	}
+	r.Pipeline = make([][]*redis.Resp, 0, len(s.tx.queue)+1)
+	r.Pipeline = append(r.Pipeline, []*redis.Resp{
+		redis.NewBulkBytes([]byte("MULTI")),
+	})
+	r.Pipeline = append(r.Pipeline, s.tx.queue...)
+	r.MirrorQueue = s.tx.mirrors
+	r.Cache = s.tx.cache
+
+	if err := d.dispatchPinned(r, s.tx.slot, s.tx.keys, s.tx.bc); err != nil {
+		return err
+	}
+	s.tx.last = r
+	return nil
+}
+
+func (s *Session) pinTransaction(d *Router, slot int) error {
+	bc, err := d.newPinnedConn(slot, s.database)
+	if err != nil {
+		return err
+	}
+	s.tx.bc, s.tx.slot = bc, slot
+	return nil
+}
+
+func (s *Session) resetTransaction() {
+	if bc, last := s.tx.bc, s.tx.last; bc != nil {
+		//独占的BackendConn上的请求都是按顺序处理的，等最后一个请求返回之后再关闭
+		go func() {
+			if last != nil {
+				last.Batch.Wait()
+			}
+			bc.Close()
+		}()
+	}
+	s.tx = transaction{slot: -1}
+}
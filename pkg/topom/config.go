@@ -46,6 +46,25 @@ migration_async_maxbytes = "32mb"
 migration_async_numkeys = 500
 migration_timeout = "30s"
 
+# Set daily windows (local time) for data migration, e.g. "01:00-06:00,22:30-23:59".
+# Slot actions only progress inside the windows, empty means no limit.
+migration_windows = ""
+# Set bandwidth (bytes per second) for semi-async migration of all parallel slots, 0 means no limit.
+migration_max_bandwidth = "0"
+# Pause data migration while latency or cpu usage (percent) of the source or target redis
+# exceeds the threshold, 0 means never pause.
+migration_pause_latency = "0ms"
+migration_pause_cpu = 0
+
+# Verify key counts (SLOTSINFO) of a slot on source and target after migration, the slot is held
+# in 'verifying-failed' state on mismatch. Set samples > 0 to also record DUMP digests of sampled keys
+# on source before migration and compare them with target afterwards. Tolerance is the ratio of
+# differences allowed for keys written or expired during migration, the default 0.0 fails on any
+# of them, so set it above zero (e.g. 0.01) for slots under live traffic.
+migration_verify = false
+migration_verify_tolerance = 0.0
+migration_verify_samples = 0
+
 # Set configs for redis sentinel.
 sentinel_client_timeout = "10s"
 sentinel_quorum = 2
@@ -68,12 +87,19 @@ type Config struct {
 	ProductName string `toml:"product_name" json:"product_name"`
 	ProductAuth string `toml:"product_auth" json:"-"`
 
-	MigrationMethod        string            `toml:"migration_method" json:"migration_method"`
-	MigrationParallelSlots int               `toml:"migration_parallel_slots" json:"migration_parallel_slots"`
-	MigrationAsyncMaxBulks int               `toml:"migration_async_maxbulks" json:"migration_async_maxbulks"`
-	MigrationAsyncMaxBytes bytesize.Int64    `toml:"migration_async_maxbytes" json:"migration_async_maxbytes"`
-	MigrationAsyncNumKeys  int               `toml:"migration_async_numkeys" json:"migration_async_numkeys"`
-	MigrationTimeout       timesize.Duration `toml:"migration_timeout" json:"migration_timeout"`
+	MigrationMethod          string            `toml:"migration_method" json:"migration_method"`
+	MigrationParallelSlots   int               `toml:"migration_parallel_slots" json:"migration_parallel_slots"`
+	MigrationAsyncMaxBulks   int               `toml:"migration_async_maxbulks" json:"migration_async_maxbulks"`
+	MigrationAsyncMaxBytes   bytesize.Int64    `toml:"migration_async_maxbytes" json:"migration_async_maxbytes"`
+	MigrationAsyncNumKeys    int               `toml:"migration_async_numkeys" json:"migration_async_numkeys"`
+	MigrationTimeout         timesize.Duration `toml:"migration_timeout" json:"migration_timeout"`
+	MigrationWindows         string            `toml:"migration_windows" json:"migration_windows"`
+	MigrationMaxBandwidth    bytesize.Int64    `toml:"migration_max_bandwidth" json:"migration_max_bandwidth"`
+	MigrationPauseLatency    timesize.Duration `toml:"migration_pause_latency" json:"migration_pause_latency"`
+	MigrationPauseCPU        int               `toml:"migration_pause_cpu" json:"migration_pause_cpu"`
+	MigrationVerify          bool              `toml:"migration_verify" json:"migration_verify"`
+	MigrationVerifyTolerance float64           `toml:"migration_verify_tolerance" json:"migration_verify_tolerance"`
+	MigrationVerifySamples   int               `toml:"migration_verify_samples" json:"migration_verify_samples"`
 
 	SentinelClientTimeout        timesize.Duration `toml:"sentinel_client_timeout" json:"sentinel_client_timeout"`
 	SentinelQuorum               int               `toml:"sentinel_quorum" json:"sentinel_quorum"`
@@ -142,6 +168,24 @@ func (c *Config) Validate() error {
 	if c.MigrationTimeout <= 0 {
 		return errors.New("invalid migration_timeout")
 	}
+	if _, err := parseMigrationWindows(c.MigrationWindows); err != nil {
+		return errors.New("invalid migration_windows")
+	}
+	if c.MigrationMaxBandwidth < 0 {
+		return errors.New("invalid migration_max_bandwidth")
+	}
+	if c.MigrationPauseLatency < 0 {
+		return errors.New("invalid migration_pause_latency")
+	}
+	if c.MigrationPauseCPU < 0 {
+		return errors.New("invalid migration_pause_cpu")
+	}
+	if c.MigrationVerifyTolerance < 0 || c.MigrationVerifyTolerance >= 1 {
+		return errors.New("invalid migration_verify_tolerance")
+	}
+	if c.MigrationVerifySamples < 0 {
+		return errors.New("invalid migration_verify_samples")
+	}
 	if c.SentinelClientTimeout <= 0 {
 		return errors.New("invalid sentinel_client_timeout")
 	}
@@ -25,6 +25,8 @@ type context struct {
 
 	sentinel *models.Sentinel
 
+	acl *models.ACL
+
 	hosts struct {
 		sync.Mutex
 		m map[string]net.IP
@@ -69,7 +71,7 @@ func (ctx *context) isSlotLocked(m *models.SlotMapping) bool {
 		return ctx.isGroupLocked(m.GroupId)
 	case models.ActionPrepared:
 		return true
-	case models.ActionMigrating:
+	case models.ActionMigrating, models.ActionVerifyFailed:
 		return ctx.isGroupLocked(m.GroupId) || ctx.isGroupLocked(m.Action.TargetId)
 	case models.ActionFinished:
 		return ctx.isGroupLocked(m.Action.TargetId)
@@ -96,7 +98,7 @@ func (ctx *context) toSlot(m *models.SlotMapping, p *models.Proxy) *models.Slot
 		slot.BackendAddrGroupId = m.GroupId
 	case models.ActionPrepared:
 		fallthrough
-	case models.ActionMigrating:
+	case models.ActionMigrating, models.ActionVerifyFailed:
 		slot.BackendAddr = ctx.getGroupMaster(m.Action.TargetId)
 		slot.BackendAddrGroupId = m.Action.TargetId
 		slot.MigrateFrom = ctx.getGroupMaster(m.GroupId)
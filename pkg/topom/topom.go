@@ -46,6 +46,8 @@ type Topom struct {
 		proxy map[string]*models.Proxy
 
 		sentinel *models.Sentinel
+
+		acl *models.ACL
 	}
 
 	exit struct {
@@ -72,10 +74,23 @@ type Topom struct {
 
 		progress struct {
 			status atomic.Value
+			//每个slot以及整个计划的迁移进度，见topom_progress.go
+			tracker *migrationProgress
 		}
 
 		//一个计数器，有一个slot等待迁移，就加一；执行一个slot的迁移，就减一
 		executor atomic2.Int64
+
+		//迁移的时间段、带宽限制以及暂停的原因，见topom_schedule.go
+		windows []migrationWindow
+		limiter *migrationLimiter
+		paused  atomic.Value
+
+		//迁移之后的校验，见topom_verify.go
+		verifier *migrationVerifier
+
+		//每个slot被回滚的次数，用来发现迁移的方向已经改变，受s.mu保护
+		rollbacks map[int]int
 	}
 
 	//存储集群中redis和proxy详细信息，goroutine每次刷新redis和proxy之后，都会将结果存在这里
@@ -85,6 +100,8 @@ type Topom struct {
 
 		servers map[string]*RedisStats
 		proxies map[string]*ProxyStats
+		hotkeys []*HotKeyStats
+		latency *LatencyStats
 	}
 
 	//这个在使用哨兵的时候会用到，存储在fe中配置的哨兵以及哨兵所监控的redis主服务器
@@ -111,6 +128,12 @@ func New(client models.Client, config *Config) (*Topom, error) {
 	//新建redis pool
 	s.action.redisp = redis.NewPool(config.ProductAuth, config.MigrationTimeout.Duration())
 	s.action.progress.status.Store("")
+	s.action.progress.tracker = newMigrationProgress()
+	s.action.windows, _ = parseMigrationWindows(config.MigrationWindows)
+	s.action.limiter = newMigrationLimiter(config.MigrationMaxBandwidth.Int64())
+	s.action.paused.Store("")
+	s.action.verifier = newMigrationVerifier()
+	s.action.rollbacks = make(map[int]int)
 
 	s.ha.redisp = redis.NewPool("", time.Second*5)
 
@@ -296,6 +319,7 @@ func (s *Topom) newContext() (*context, error) {
 			ctx.group = s.cache.group
 			ctx.proxy = s.cache.proxy
 			ctx.sentinel = s.cache.sentinel
+			ctx.acl = s.cache.acl
 			ctx.hosts.m = make(map[string]net.IP)
 			ctx.method, _ = models.ParseForwardMethod(s.config.MigrationMethod)
 			return ctx, nil
@@ -330,11 +354,24 @@ func (s *Topom) Stats() (*Stats, error) {
 
 	stats.Proxy.Models = models.SortProxy(ctx.proxy)
 	stats.Proxy.Stats = s.stats.proxies
+	stats.Proxy.HotKeys = s.stats.hotkeys
+	stats.Proxy.Latency = s.stats.latency
 
 	stats.SlotAction.Interval = s.action.interval.Int64()
 	stats.SlotAction.Disabled = s.action.disabled.Bool()
 	stats.SlotAction.Progress.Status = s.action.progress.status.Load().(string)
+	stats.SlotAction.Progress.Plan = s.action.progress.tracker.Plan(ctx.slots)
 	stats.SlotAction.Executor = s.action.executor.Int64()
+	stats.SlotAction.Schedule.Windows = s.config.MigrationWindows
+	stats.SlotAction.Schedule.InWindow = inMigrationWindows(s.action.windows, time.Now())
+	stats.SlotAction.Schedule.Bandwidth = s.config.MigrationMaxBandwidth.Int64()
+	stats.SlotAction.Schedule.Migrated = s.action.limiter.Migrated()
+	for _, m := range ctx.slots {
+		if m.Action.State != models.ActionNothing {
+			stats.SlotAction.Schedule.Paused = s.action.paused.Load().(string)
+			break
+		}
+	}
 
 	stats.HA.Model = ctx.sentinel
 	stats.HA.Stats = map[string]*RedisStats{}
@@ -363,8 +400,10 @@ type Stats struct {
 	} `json:"group"`
 
 	Proxy struct {
-		Models []*models.Proxy        `json:"models"`
-		Stats  map[string]*ProxyStats `json:"stats"`
+		Models  []*models.Proxy        `json:"models"`
+		Stats   map[string]*ProxyStats `json:"stats"`
+		HotKeys []*HotKeyStats         `json:"hotkeys,omitempty"`
+		Latency *LatencyStats          `json:"latency,omitempty"`
 	} `json:"proxy"`
 
 	SlotAction struct {
@@ -372,10 +411,19 @@ type Stats struct {
 		Disabled bool  `json:"disabled"`
 
 		Progress struct {
-			Status string `json:"status"`
+			Status string        `json:"status"`
+			Plan   *PlanProgress `json:"plan,omitempty"`
 		} `json:"progress"`
 
 		Executor int64 `json:"executor"`
+
+		Schedule struct {
+			Windows   string `json:"windows,omitempty"`
+			InWindow  bool   `json:"in_window"`
+			Bandwidth int64  `json:"bandwidth"`
+			Migrated  int64  `json:"migrated"`
+			Paused    string `json:"paused,omitempty"`
+		} `json:"schedule"`
 	} `json:"slot_action"`
 
 	HA struct {
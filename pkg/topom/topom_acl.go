@@ -0,0 +1,88 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/sync2"
+)
+
+func (s *Topom) ACL() (*models.ACL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, err := s.newContext()
+	if err != nil {
+		return nil, err
+	}
+	return ctx.acl.Redact(), nil
+}
+
+//更新ACL规则，并推送到所有的proxy上，已经登录的session在下一个请求就会使用新的规则；
+//明文的密码替换成摘要之后再保存，没有给出密码的用户沿用之前的密码
+func (s *Topom) UpdateACL(acl *models.ACL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, err := s.newContext()
+	if err != nil {
+		return err
+	}
+
+	if err := acl.Validate(); err != nil {
+		return err
+	}
+	for _, u := range acl.Users {
+		switch {
+		case u.Password != "":
+			u.PasswordHash = models.HashACLPassword(u.Password)
+		case u.PasswordHash == "":
+			if old := ctx.acl.GetUser(u.Name); old != nil {
+				u.PasswordHash = old.PasswordHash
+			}
+		}
+		if u.Password = ""; u.PasswordHash == "" {
+			return errors.Errorf("acl user-[%s] has no password", u.Name)
+		}
+	}
+	defer s.dirtyACLCache()
+
+	if err := s.storeUpdateACL(acl); err != nil {
+		return err
+	}
+	return s.resyncACL(ctx, acl)
+}
+
+func (s *Topom) ResyncACL() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, err := s.newContext()
+	if err != nil {
+		return err
+	}
+	return s.resyncACL(ctx, ctx.acl)
+}
+
+func (s *Topom) resyncACL(ctx *context, acl *models.ACL) error {
+	var fut sync2.Future
+	for _, p := range ctx.proxy {
+		fut.Add()
+		go func(p *models.Proxy) {
+			err := s.newProxyClient(p).SetACL(acl)
+			if err != nil {
+				log.ErrorErrorf(err, "proxy-[%s] resync acl failed", p.Token)
+			}
+			fut.Done(p.Token, err)
+		}(p)
+	}
+	for t, v := range fut.Wait() {
+		switch err := v.(type) {
+		case error:
+			if err != nil {
+				return errors.Errorf("proxy-[%s] resync acl failed", t)
+			}
+		}
+	}
+	return nil
+}
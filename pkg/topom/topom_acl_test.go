@@ -0,0 +1,39 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestUpdateACL(x *testing.T) {
+	t := openTopom()
+	defer t.Close()
+
+	var acl = &models.ACL{Users: []*models.ACLUser{{Name: "u1", Password: "p1"}}}
+	assert.MustNoError(t.UpdateACL(acl))
+
+	//保存的只有摘要，查询的时候摘要也不返回
+	ctx, err := t.newContext()
+	assert.MustNoError(err)
+	u := ctx.acl.GetUser("u1")
+	assert.Must(u.Password == "" && models.CheckACLPassword(u.PasswordHash, "p1"))
+	list, err := t.ACL()
+	assert.MustNoError(err)
+	assert.Must(len(list.Users) == 1 && list.Users[0].Password == "" && list.Users[0].PasswordHash == "")
+
+	//没有给出密码的用户沿用之前的密码
+	list.Users = append(list.Users, &models.ACLUser{Name: "u2", Password: "p2", ReadOnly: true})
+	assert.MustNoError(t.UpdateACL(list))
+	ctx, err = t.newContext()
+	assert.MustNoError(err)
+	assert.Must(models.CheckACLPassword(ctx.acl.GetUser("u1").PasswordHash, "p1"))
+	assert.Must(models.CheckACLPassword(ctx.acl.GetUser("u2").PasswordHash, "p2"))
+
+	acl = &models.ACL{Users: []*models.ACLUser{{Name: "u3"}}}
+	assert.Must(t.UpdateACL(acl) != nil)
+}
@@ -79,12 +79,14 @@ func (s *Topom) ProcessSlotAction() error {
 
 func (s *Topom) processSlotAction(sid int) error {
 	var db int = 0
+	var rollbacks = s.getSlotActionRollbacks(sid)
 	for s.IsOnline() {
 		//返回的exec就是具体的slot操作执行函数
 		if exec, err := s.newSlotActionExecutor(sid); err != nil {
 			return err
 		} else if exec == nil {
 			time.Sleep(time.Second)
+			s.action.progress.tracker.Pause(sid, time.Now())
 		} else {
 			n, nextdb, err := exec(db)
 			if err != nil {
@@ -93,7 +95,24 @@ func (s *Topom) processSlotAction(sid int) error {
 			log.Debugf("slot-[%d] action executor %d", sid, n)
 
 			if n == 0 && nextdb == -1 {
-				return s.SlotActionComplete(sid)
+				err := s.verifySlotAction(sid, rollbacks)
+				if err == nil {
+					err = s.completeSlotAction(sid, rollbacks)
+				}
+				if err == nil {
+					s.action.progress.tracker.Finish(sid)
+					s.action.verifier.Forget(sid)
+					return nil
+				}
+				if err != errSlotActionRollback {
+					return err
+				}
+			}
+			//slot被回滚之后迁移的方向改变了，从第一个db重新开始
+			if x := s.getSlotActionRollbacks(sid); x != rollbacks {
+				log.Warnf("slot-[%d] action rolled back, restart from db-[0]", sid)
+				rollbacks, db = x, 0
+				continue
 			}
 			status := fmt.Sprintf("[OK] Slot[%04d]@DB[%d]=%d", sid, db, n)
 			s.action.progress.status.Store(status)
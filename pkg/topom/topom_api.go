@@ -20,6 +20,7 @@ import (
 	"github.com/thesunnysky/codis/pkg/models"
 	"github.com/thesunnysky/codis/pkg/utils/errors"
 	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/prometheus"
 	"github.com/thesunnysky/codis/pkg/utils/redis"
 	"github.com/thesunnysky/codis/pkg/utils/rpc"
 )
@@ -63,6 +64,8 @@ func newApiServer(t *Topom) http.Handler {
 		http.DefaultServeMux.ServeHTTP(w, req)
 	})
 
+	r.Get("/metrics", api.Metrics)
+
 	r.Group("/topom", func(r martini.Router) {
 		r.Get("", api.Overview)
 		r.Get("/model", api.Model)
@@ -106,12 +109,16 @@ func newApiServer(t *Topom) http.Handler {
 				r.Put("/create-some/:xauth/:src/:dst/:num", api.SlotCreateActionSome)
 				r.Put("/create-range/:xauth/:beg/:end/:gid", api.SlotCreateActionRange)
 				r.Put("/remove/:xauth/:sid", api.SlotRemoveAction)
+				r.Put("/verify/:xauth/:sid", api.SlotActionVerify)
+				r.Put("/resume/:xauth/:sid/:force", api.SlotActionResume)
+				r.Put("/rollback/:xauth/:sid", api.SlotRollbackAction)
 				r.Put("/interval/:xauth/:value", api.SetSlotActionInterval)
 				r.Put("/disabled/:xauth/:value", api.SetSlotActionDisabled)
 			})
 			r.Put("/assign/:xauth", binding.Json([]*models.SlotMapping{}), api.SlotsAssignGroup)
 			r.Put("/assign/:xauth/offline", binding.Json([]*models.SlotMapping{}), api.SlotsAssignOffline)
 			r.Put("/rebalance/:xauth/:confirm", api.SlotsRebalance)
+			r.Put("/rebalance-weighted/:xauth/:confirm", binding.Json(RebalanceOptions{}), api.SlotsRebalanceWeighted)
 		})
 		r.Group("/sentinels", func(r martini.Router) {
 			r.Put("/add/:xauth/:addr", api.AddSentinel)
@@ -120,6 +127,11 @@ func newApiServer(t *Topom) http.Handler {
 			r.Get("/info/:addr", api.InfoSentinel)
 			r.Get("/info/:addr/monitored", api.InfoSentinelMonitored)
 		})
+		r.Group("/acl", func(r martini.Router) {
+			r.Get("/:xauth", api.ACL)
+			r.Put("/update/:xauth", binding.Json(models.ACL{}), api.UpdateACL)
+			r.Put("/resync-all/:xauth", api.ResyncACL)
+		})
 	})
 
 	m.MapTo(r, (*martini.Routes)(nil))
@@ -150,6 +162,15 @@ func (s *apiServer) Overview() (int, string) {
 	}
 }
 
+func (s *apiServer) Metrics(w http.ResponseWriter) (int, string) {
+	b, err := s.topom.Metrics()
+	if err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	w.Header().Set("Content-Type", prometheus.ContentType)
+	return http.StatusOK, string(b)
+}
+
 func (s *apiServer) Model() (int, string) {
 	return rpc.ApiResponseJson(s.topom.Model())
 }
@@ -504,6 +525,39 @@ func (s *apiServer) ResyncSentinels(params martini.Params) (int, string) {
 	}
 }
 
+func (s *apiServer) ACL(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if acl, err := s.topom.ACL(); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson(acl)
+	}
+}
+
+func (s *apiServer) UpdateACL(acl models.ACL, params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if err := s.topom.UpdateACL(&acl); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson("OK")
+	}
+}
+
+func (s *apiServer) ResyncACL(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if err := s.topom.ResyncACL(); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson("OK")
+	}
+}
+
 func (s *apiServer) InfoServer(params martini.Params) (int, string) {
 	addr, err := s.parseAddr(params)
 	if err != nil {
@@ -663,6 +717,55 @@ func (s *apiServer) SlotRemoveAction(params martini.Params) (int, string) {
 	}
 }
 
+func (s *apiServer) SlotRollbackAction(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	sid, err := s.parseInteger(params, "sid")
+	if err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if err := s.topom.SlotRollbackAction(sid); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson("OK")
+	}
+}
+
+func (s *apiServer) SlotActionVerify(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	sid, err := s.parseInteger(params, "sid")
+	if err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if report, err := s.topom.SlotActionVerify(sid); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson(report)
+	}
+}
+
+func (s *apiServer) SlotActionResume(params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	sid, err := s.parseInteger(params, "sid")
+	if err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	force, err := s.parseInteger(params, "force")
+	if err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if err := s.topom.SlotActionResume(sid, force != 0); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson("OK")
+	}
+}
+
 func (s *apiServer) LogLevel(params martini.Params) (int, string) {
 	if err := s.verifyXAuth(params); err != nil {
 		return rpc.ApiResponseError(err)
@@ -755,6 +858,21 @@ func (s *apiServer) SlotsRebalance(params martini.Params) (int, string) {
 	}
 }
 
+func (s *apiServer) SlotsRebalanceWeighted(opts RebalanceOptions, params martini.Params) (int, string) {
+	if err := s.verifyXAuth(params); err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	confirm, err := s.parseInteger(params, "confirm")
+	if err != nil {
+		return rpc.ApiResponseError(err)
+	}
+	if report, err := s.topom.SlotsRebalanceWeighted(&opts, confirm != 0); err != nil {
+		return rpc.ApiResponseError(err)
+	} else {
+		return rpc.ApiResponseJson(report)
+	}
+}
+
 type ApiClient struct {
 	addr  string
 	xauth string
@@ -929,6 +1047,25 @@ func (c *ApiClient) ResyncSentinels() error {
 	return rpc.ApiPutJson(url, nil, nil)
 }
 
+func (c *ApiClient) ACL() (*models.ACL, error) {
+	url := c.encodeURL("/api/topom/acl/%s", c.xauth)
+	acl := &models.ACL{}
+	if err := rpc.ApiGetJson(url, acl); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func (c *ApiClient) UpdateACL(acl *models.ACL) error {
+	url := c.encodeURL("/api/topom/acl/update/%s", c.xauth)
+	return rpc.ApiPutJson(url, acl, nil)
+}
+
+func (c *ApiClient) ResyncACL() error {
+	url := c.encodeURL("/api/topom/acl/resync-all/%s", c.xauth)
+	return rpc.ApiPutJson(url, nil, nil)
+}
+
 func (c *ApiClient) SyncCreateAction(addr string) error {
 	url := c.encodeURL("/api/topom/group/action/create/%s/%s", c.xauth, addr)
 	return rpc.ApiPutJson(url, nil, nil)
@@ -959,6 +1096,29 @@ func (c *ApiClient) SlotRemoveAction(sid int) error {
 	return rpc.ApiPutJson(url, nil, nil)
 }
 
+func (c *ApiClient) SlotRollbackAction(sid int) error {
+	url := c.encodeURL("/api/topom/slots/action/rollback/%s/%d", c.xauth, sid)
+	return rpc.ApiPutJson(url, nil, nil)
+}
+
+func (c *ApiClient) SlotActionVerify(sid int) (*SlotVerifyReport, error) {
+	url := c.encodeURL("/api/topom/slots/action/verify/%s/%d", c.xauth, sid)
+	report := &SlotVerifyReport{}
+	if err := rpc.ApiPutJson(url, nil, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (c *ApiClient) SlotActionResume(sid int, force bool) error {
+	var value int
+	if force {
+		value = 1
+	}
+	url := c.encodeURL("/api/topom/slots/action/resume/%s/%d/%d", c.xauth, sid, value)
+	return rpc.ApiPutJson(url, nil, nil)
+}
+
 func (c *ApiClient) SetSlotActionInterval(usecs int) error {
 	url := c.encodeURL("/api/topom/slots/action/interval/%s/%d", c.xauth, usecs)
 	return rpc.ApiPutJson(url, nil, nil)
@@ -1004,3 +1164,16 @@ func (c *ApiClient) SlotsRebalance(confirm bool) (map[int]int, error) {
 		return m, nil
 	}
 }
+
+func (c *ApiClient) SlotsRebalanceWeighted(opts *RebalanceOptions, confirm bool) (*RebalanceReport, error) {
+	var value int
+	if confirm {
+		value = 1
+	}
+	url := c.encodeURL("/api/topom/slots/rebalance-weighted/%s/%d", c.xauth, value)
+	report := &RebalanceReport{}
+	if err := rpc.ApiPutJson(url, opts, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
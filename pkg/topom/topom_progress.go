@@ -0,0 +1,203 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+)
+
+//迁移进度的实现：
+//1. slot action的executor每执行一次就记录这个slot迁移的key的数量、估算的字节数以及当前db剩余的key的数量；
+//2. slot的速度是开始迁移以来的平均速度，暂停迁移的时间不计入，剩余的key除以速度得到这个slot的预计完成时间；
+//3. 所有还有action的slot组成一个计划，已经完成的slot计入计划的统计，等待中的slot按照已知slot的平均key数量估算，
+//   再除以所有正在迁移的slot的速度之和得到整个计划的预计完成时间；
+//4. 所有slot的action都完成之后计划的统计清零，进度通过overview中的slot_action.plan展示。
+
+type SlotProgress struct {
+	Id      int   `json:"id"`
+	DB      int   `json:"db"`
+	Moved   int64 `json:"moved"`
+	Bytes   int64 `json:"bytes"`
+	Remains int64 `json:"remains"`
+
+	//每秒迁移的key的数量和字节数
+	Rate      float64 `json:"rate"`
+	BytesRate float64 `json:"bytes_rate"`
+
+	StartTime  int64 `json:"start_time"`
+	UpdateTime int64 `json:"update_time"`
+
+	//预计还需要的秒数，-1表示还无法估算
+	ETA int64 `json:"eta"`
+
+	start int64
+
+	//上一次更新的时间以及累计的迁移时间
+	last    int64
+	elapsed int64
+}
+
+type PlanProgress struct {
+	Total     int `json:"total"`
+	Finished  int `json:"finished"`
+	Migrating int `json:"migrating"`
+	Pending   int `json:"pending"`
+	Failed    int `json:"failed"`
+
+	Moved   int64 `json:"moved"`
+	Bytes   int64 `json:"bytes"`
+	Remains int64 `json:"remains"`
+
+	Rate      float64 `json:"rate"`
+	BytesRate float64 `json:"bytes_rate"`
+
+	StartTime int64 `json:"start_time"`
+	ETA       int64 `json:"eta"`
+
+	Slots []*SlotProgress `json:"slots,omitempty"`
+}
+
+type migrationProgress struct {
+	mu sync.Mutex
+
+	slots map[int]*SlotProgress
+
+	//已经完成的slot的统计
+	finished int
+	moved    int64
+	bytes    int64
+	start    int64
+}
+
+func newMigrationProgress() *migrationProgress {
+	return &migrationProgress{slots: make(map[int]*SlotProgress)}
+}
+
+func (p *migrationProgress) Update(sid, db, moved int, bytes int64, remains int, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var x = p.slots[sid]
+	if x == nil {
+		x = &SlotProgress{Id: sid, StartTime: now.Unix(), start: now.UnixNano(), last: now.UnixNano()}
+		p.slots[sid] = x
+	}
+	x.elapsed += now.UnixNano() - x.last
+	x.last = now.UnixNano()
+	if p.start == 0 {
+		p.start = x.start
+	}
+	x.DB = db
+	x.Moved += int64(moved)
+	x.Bytes += bytes
+	x.Remains = int64(remains)
+	x.UpdateTime = now.Unix()
+	x.Rate, x.BytesRate, x.ETA = 0, 0, -1
+	if seconds := float64(x.elapsed) / float64(time.Second); seconds > 0 {
+		x.Rate = float64(x.Moved) / seconds
+		x.BytesRate = float64(x.Bytes) / seconds
+	}
+	switch {
+	case x.Remains == 0:
+		x.ETA = 0
+	case x.Rate > 0:
+		x.ETA = int64(float64(x.Remains)/x.Rate) + 1
+	}
+}
+
+//slot暂停迁移，到now为止的时间不计入速度
+func (p *migrationProgress) Pause(sid int, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if x := p.slots[sid]; x != nil {
+		x.last = now.UnixNano()
+	}
+}
+
+func (p *migrationProgress) Finish(sid int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if x := p.slots[sid]; x != nil {
+		p.moved += x.Moved
+		p.bytes += x.Bytes
+		delete(p.slots, sid)
+	}
+	p.finished++
+}
+
+//回滚的slot重新开始计算进度
+func (p *migrationProgress) Forget(sid int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.slots, sid)
+}
+
+//根据当前slot的action生成整个计划的进度，没有action的时候返回nil并清空统计
+func (p *migrationProgress) Plan(slots []*models.SlotMapping) *PlanProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var plan = &PlanProgress{}
+	var active = make(map[int]bool)
+	for _, m := range slots {
+		switch m.Action.State {
+		case models.ActionNothing:
+			continue
+		case models.ActionPending, models.ActionPreparing, models.ActionPrepared:
+			plan.Pending++
+		case models.ActionVerifyFailed:
+			plan.Failed++
+		default:
+			plan.Migrating++
+			active[m.Id] = true
+		}
+	}
+	if plan.Pending == 0 && plan.Migrating == 0 && plan.Failed == 0 {
+		p.slots = make(map[int]*SlotProgress)
+		p.finished, p.moved, p.bytes, p.start = 0, 0, 0, 0
+		return nil
+	}
+
+	plan.Finished = p.finished
+	plan.Total = plan.Finished + plan.Migrating + plan.Pending + plan.Failed
+	plan.Moved, plan.Bytes = p.moved, p.bytes
+	if p.start != 0 {
+		plan.StartTime = p.start / int64(time.Second)
+	}
+	for _, x := range p.slots {
+		//已经完成但是还没有调用Finish的slot
+		if !active[x.Id] {
+			continue
+		}
+		plan.Moved += x.Moved
+		plan.Bytes += x.Bytes
+		plan.Remains += x.Remains
+		plan.Rate += x.Rate
+		plan.BytesRate += x.BytesRate
+		v := *x
+		plan.Slots = append(plan.Slots, &v)
+	}
+	sort.Slice(plan.Slots, func(i, j int) bool {
+		return plan.Slots[i].Id < plan.Slots[j].Id
+	})
+
+	//还没有开始迁移的slot按照已知的slot的平均大小估算
+	var remains = float64(plan.Remains)
+	if n := plan.Finished + len(plan.Slots); n != 0 {
+		var average = float64(plan.Moved+plan.Remains) / float64(n)
+		remains += average * float64(plan.Total-n)
+	}
+	switch {
+	case remains == 0 && plan.Pending == 0:
+		plan.ETA = 0
+	case plan.Rate > 0:
+		plan.ETA = int64(remains/plan.Rate) + 1
+	default:
+		plan.ETA = -1
+	}
+	return plan
+}
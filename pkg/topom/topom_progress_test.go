@@ -0,0 +1,61 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestMigrationProgress(x *testing.T) {
+	var slots = make([]*models.SlotMapping, 4)
+	for i := range slots {
+		slots[i] = &models.SlotMapping{Id: i, GroupId: 1}
+		slots[i].Action.State = models.ActionPending
+		slots[i].Action.TargetId = 2
+	}
+	p := newMigrationProgress()
+
+	plan := p.Plan(slots)
+	assert.Must(plan.Total == 4 && plan.Pending == 4 && plan.ETA == -1)
+
+	var now = time.Now()
+	slots[0].Action.State = models.ActionMigrating
+	p.Update(0, 0, 0, 0, 1000, now)
+	p.Update(0, 0, 100, 1000, 900, now.Add(time.Second))
+	p.Update(0, 0, 100, 1000, 800, now.Add(time.Second*2))
+
+	plan = p.Plan(slots)
+	assert.Must(plan.Total == 4 && plan.Migrating == 1 && plan.Pending == 3)
+	assert.Must(len(plan.Slots) == 1)
+	s := plan.Slots[0]
+	assert.Must(s.Moved == 200 && s.Bytes == 2000 && s.Remains == 800)
+	assert.Must(s.Rate == 100 && s.BytesRate == 1000 && s.ETA == 9)
+	//每个slot估算为1000个key，剩余800+3000
+	assert.Must(plan.Remains == 800 && plan.ETA == 39)
+
+	//暂停的10秒不计入速度
+	p.Pause(0, now.Add(time.Second*12))
+	p.Update(0, 0, 100, 1000, 700, now.Add(time.Second*13))
+	s = p.Plan(slots).Slots[0]
+	assert.Must(s.Rate == 100 && s.BytesRate == 1000 && s.ETA == 8)
+
+	p.Update(0, 0, 700, 7000, 0, now.Add(time.Second*20))
+	p.Finish(0)
+	slots[0].Action.State = models.ActionNothing
+	slots[1].Action.State = models.ActionMigrating
+
+	plan = p.Plan(slots)
+	assert.Must(plan.Total == 4 && plan.Finished == 1 && plan.Migrating == 1 && plan.Pending == 2)
+	assert.Must(plan.Moved == 1000 && plan.Bytes == 10000 && len(plan.Slots) == 0 && plan.ETA == -1)
+
+	for i := range slots {
+		slots[i].Action.State = models.ActionNothing
+	}
+	assert.Must(p.Plan(slots) == nil)
+	assert.Must(p.finished == 0 && p.moved == 0 && len(p.slots) == 0)
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"strconv"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils"
+	"github.com/thesunnysky/codis/pkg/utils/prometheus"
+)
+
+//从redis的INFO中导出的字段
+var redisInfoMetrics = []struct {
+	key, name, help string
+}{
+	{"used_memory", "codis_server_used_memory_bytes", "Memory used by codis-server."},
+	{"connected_clients", "codis_server_connected_clients", "Number of clients connected to codis-server."},
+	{"instantaneous_ops_per_sec", "codis_server_ops_per_sec", "Operations per second of codis-server."},
+	{"total_commands_processed", "codis_server_commands_processed_total", "Total number of commands processed by codis-server."},
+}
+
+//prometheus的/metrics接口，数据来自dashboard定期刷新的stats，所有的metric都带有product这个label
+func (s *Topom) Metrics() ([]byte, error) {
+	stats, err := s.Stats()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		model = s.Model()
+		w     = prometheus.NewWriter()
+	)
+	var labels = func(pairs ...string) []string {
+		return append([]string{"product", model.ProductName}, pairs...)
+	}
+
+	w.Gauge("codis_dashboard_info", "Information of codis-dashboard, the value is always 1.").Add(1,
+		labels("admin_addr", model.AdminAddr, "version", utils.Version)...)
+	w.Gauge("codis_dashboard_closed", "Whether dashboard is closed.").Add(prometheus.Bool(stats.Closed), labels()...)
+
+	var groupSlots = make(map[int]int)
+	var actions = make(map[string]int)
+	for _, m := range stats.Slots {
+		groupSlots[m.GroupId]++
+		if m.Action.State != models.ActionNothing {
+			actions[m.Action.State]++
+		}
+	}
+	for _, state := range []string{
+		models.ActionPending, models.ActionPreparing, models.ActionPrepared,
+		models.ActionMigrating, models.ActionFinished, models.ActionSyncing, models.ActionVerifyFailed,
+	} {
+		w.Gauge("codis_dashboard_slot_actions", "Number of slot actions by state.").Add(float64(actions[state]), labels("state", state)...)
+	}
+	w.Gauge("codis_dashboard_slot_action_disabled", "Whether slot actions are disabled.").Add(prometheus.Bool(stats.SlotAction.Disabled), labels()...)
+	w.Gauge("codis_dashboard_slot_action_executor", "Number of slot actions being executed.").Add(float64(stats.SlotAction.Executor), labels()...)
+
+	for _, g := range stats.Group.Models {
+		var gid = strconv.Itoa(g.Id)
+		w.Gauge("codis_dashboard_group_slots", "Number of slots served by group.").Add(float64(groupSlots[g.Id]), labels("group_id", gid)...)
+		w.Gauge("codis_dashboard_group_servers", "Number of servers in group.").Add(float64(len(g.Servers)), labels("group_id", gid)...)
+		w.Gauge("codis_dashboard_group_promoting", "Whether group is promoting a replica.").Add(prometheus.Bool(g.Promoting.State != models.ActionNothing), labels("group_id", gid)...)
+		w.Gauge("codis_dashboard_group_out_of_sync", "Whether group is out of sync.").Add(prometheus.Bool(g.OutOfSync), labels("group_id", gid)...)
+
+		for i, x := range g.Servers {
+			var role = "replica"
+			if i == 0 {
+				role = "master"
+			}
+			var pairs = labels("group_id", gid, "addr", x.Addr, "role", role)
+			var v = stats.Group.Stats[x.Addr]
+			var up = v != nil && v.Error == nil && !v.Timeout
+			w.Gauge("codis_server_up", "Whether codis-server is reachable.").Add(prometheus.Bool(up), pairs...)
+			if !up {
+				continue
+			}
+			for _, m := range redisInfoMetrics {
+				if n, err := strconv.ParseFloat(v.Stats[m.key], 64); err == nil {
+					w.Gauge(m.name, m.help).Add(n, pairs...)
+				}
+			}
+			if role == "replica" {
+				w.Gauge("codis_server_master_link_up", "Whether replica is connected to its master.").Add(prometheus.Bool(v.Stats["master_link_status"] == "up"), pairs...)
+			}
+		}
+	}
+
+	for _, p := range stats.Proxy.Models {
+		var pairs = labels("token", p.Token, "proxy_addr", p.ProxyAddr, "admin_addr", p.AdminAddr)
+		var v = stats.Proxy.Stats[p.Token]
+		var up = v != nil && v.Stats != nil && !v.Timeout
+		w.Gauge("codis_dashboard_proxy_up", "Whether codis-proxy is reachable and online.").Add(prometheus.Bool(up && v.Stats.Online), pairs...)
+		if !up {
+			continue
+		}
+		w.Counter("codis_dashboard_proxy_ops_total", "Total number of requests of proxy.").Add(float64(v.Stats.Ops.Total), pairs...)
+		w.Counter("codis_dashboard_proxy_ops_fails_total", "Total number of failed requests of proxy.").Add(float64(v.Stats.Ops.Fails), pairs...)
+		w.Gauge("codis_dashboard_proxy_ops_qps", "Requests per second of proxy.").Add(float64(v.Stats.Ops.QPS), pairs...)
+		w.Gauge("codis_dashboard_proxy_sessions_alive", "Number of alive sessions of proxy.").Add(float64(v.Stats.Sessions.Alive), pairs...)
+	}
+
+	if stats.HA.Model != nil {
+		for _, addr := range stats.HA.Model.Servers {
+			var v = stats.HA.Stats[addr]
+			var up = v != nil && v.Error == nil && !v.Timeout
+			w.Gauge("codis_sentinel_up", "Whether sentinel is reachable.").Add(prometheus.Bool(up), labels("addr", addr)...)
+		}
+	}
+	for gid, addr := range stats.HA.Masters {
+		w.Gauge("codis_sentinel_master", "Masters reported by sentinels, the value is always 1.").Add(1, labels("group_id", gid, "addr", addr)...)
+	}
+	return w.Bytes(), nil
+}
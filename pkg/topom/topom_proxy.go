@@ -147,6 +147,10 @@ func (s *Topom) reinitProxy(ctx *context, p *models.Proxy, c *proxy.ApiClient) e
 		log.ErrorErrorf(err, "proxy-[%s] set sentinels failed", p.Token)
 		return errors.Errorf("proxy-[%s] set sentinels failed", p.Token)
 	}
+	if err := c.SetACL(ctx.acl); err != nil {
+		log.ErrorErrorf(err, "proxy-[%s] set acl failed", p.Token)
+		return errors.Errorf("proxy-[%s] set acl failed", p.Token)
+	}
 	return nil
 }
 
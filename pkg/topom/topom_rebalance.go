@@ -0,0 +1,447 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+)
+
+//按容量加权的rebalance的实现：
+//1. 每个group的权重来自指定的容量或者group master的maxmemory，每个group的目标大小是总大小按照权重分配的份额；
+//2. slot的大小根据所有master上SLOTSINFO返回的key数量估算，每个key的大小是这个master的used_memory除以key的总数，
+//   有master拿不到used_memory的时候直接使用key的数量；
+//3. 没有分配的slot以及权重为0的group中的slot先分配给其他group：非空的slot给离目标最远的group，空的slot按照权重分配数量；
+//4. 之后每次从超出目标最多的group向低于目标最多的group迁移一个slot，优先选择不会越过目标的最大的slot，
+//   直到所有group都在目标的±band之内，或者再迁移也不能让差距变小，这样迁移的数据量尽量少；
+//5. 正在迁移的slot属于目标group，不会被再次迁移；confirm之后和SlotsRebalance一样创建slot action。
+
+const (
+	RebalanceByCapacity  = "capacity"
+	RebalanceByMaxMemory = "maxmemory"
+)
+
+const DefaultRebalanceBand = 0.1
+
+type RebalanceOptions struct {
+	Mode    string        `json:"mode"`
+	Weights map[int]int64 `json:"weights,omitempty"`
+	Band    float64       `json:"band"`
+}
+
+func (o *RebalanceOptions) Validate() error {
+	switch o.Mode {
+	case RebalanceByCapacity:
+		if len(o.Weights) == 0 {
+			return errors.New("missing weights of groups")
+		}
+		var total int64
+		for gid, w := range o.Weights {
+			if w < 0 {
+				return errors.Errorf("invalid weight of group-[%d]", gid)
+			}
+			total += w
+		}
+		if total == 0 {
+			return errors.New("invalid weights, all weights are zero")
+		}
+	case RebalanceByMaxMemory:
+	default:
+		return errors.Errorf("invalid rebalance mode = %s", o.Mode)
+	}
+	if o.Band < 0 || o.Band >= 1 {
+		return errors.Errorf("invalid rebalance band = %v", o.Band)
+	}
+	return nil
+}
+
+type RebalanceGroup struct {
+	Id     int   `json:"id"`
+	Weight int64 `json:"weight"`
+
+	Slots          int `json:"slots"`
+	ProjectedSlots int `json:"projected_slots"`
+	SlotsIn        int `json:"slots_in"`
+	SlotsOut       int `json:"slots_out"`
+
+	Size          int64   `json:"size"`
+	ProjectedSize int64   `json:"projected_size"`
+	TargetSize    int64   `json:"target_size"`
+	Utilization   float64 `json:"utilization"`
+}
+
+type RebalanceReport struct {
+	Mode string  `json:"mode"`
+	Band float64 `json:"band"`
+	//size的单位，bytes或者keys
+	Unit string `json:"unit"`
+
+	Plans  map[int]int       `json:"plans"`
+	Groups []*RebalanceGroup `json:"groups"`
+	Moved  int64             `json:"moved"`
+}
+
+//group master上的数据，用来估算slot的大小
+type rebalanceServer struct {
+	slots     map[int]int
+	usedMem   int64
+	maxMemory int64
+}
+
+func (s *Topom) loadRebalanceServers() (map[int]*rebalanceServer, error) {
+	s.mu.Lock()
+	ctx, err := s.newContext()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var servers = make(map[int]*rebalanceServer)
+	for gid, addr := range ctx.getGroupMasters() {
+		info, err := s.stats.redisp.InfoFull(addr)
+		if err != nil {
+			return nil, errors.Errorf("load info of group-[%d] failed, %s", gid, err)
+		}
+		c, err := s.stats.redisp.GetClient(addr)
+		if err != nil {
+			return nil, errors.Errorf("load slotsinfo of group-[%d] failed, %s", gid, err)
+		}
+		slots, err := c.SlotsInfo()
+		s.stats.redisp.PutClient(c)
+		if err != nil {
+			return nil, errors.Errorf("load slotsinfo of group-[%d] failed, %s", gid, err)
+		}
+		x := &rebalanceServer{slots: slots, usedMem: -1}
+		if v, err := strconv.ParseInt(info["used_memory"], 10, 64); err == nil {
+			x.usedMem = v
+		}
+		if v, err := strconv.ParseInt(info["maxmemory"], 10, 64); err == nil {
+			x.maxMemory = v
+		}
+		servers[gid] = x
+	}
+	return servers, nil
+}
+
+//SLOTSINFO和INFO在加锁之前获取，生成计划的时候重新读取slot的状态
+func (s *Topom) SlotsRebalanceWeighted(opts *RebalanceOptions, confirm bool) (*RebalanceReport, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	servers, err := s.loadRebalanceServers()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, err := s.newContext()
+	if err != nil {
+		return nil, err
+	}
+
+	var weights = make(map[int]int64)
+	for gid := range ctx.getGroupMasters() {
+		x := servers[gid]
+		switch {
+		case x == nil:
+			return nil, errors.Errorf("group-[%d] has been changed, please retry", gid)
+		case opts.Mode == RebalanceByMaxMemory:
+			if x.maxMemory <= 0 {
+				return nil, errors.Errorf("maxmemory of group-[%d] is not set", gid)
+			}
+			weights[gid] = x.maxMemory
+		default:
+			w, ok := opts.Weights[gid]
+			if !ok {
+				return nil, errors.Errorf("missing weight of group-[%d]", gid)
+			}
+			weights[gid] = w
+		}
+	}
+	if len(weights) == 0 {
+		return nil, errors.Errorf("no valid group could be found")
+	}
+	for gid := range opts.Weights {
+		if _, ok := weights[gid]; !ok && opts.Mode == RebalanceByCapacity {
+			return nil, errors.Errorf("group-[%d] doesn't exist or has no server", gid)
+		}
+	}
+
+	var unit = "bytes"
+	var sizes [MaxSlotNum]int64
+	for _, x := range servers {
+		var keys int64
+		for _, n := range x.slots {
+			keys += int64(n)
+		}
+		if keys != 0 && x.usedMem < 0 {
+			unit = "keys"
+		}
+	}
+	for _, x := range servers {
+		var keys int64
+		for _, n := range x.slots {
+			keys += int64(n)
+		}
+		for sid, n := range x.slots {
+			if sid < 0 || sid >= MaxSlotNum || n <= 0 {
+				continue
+			}
+			if unit == "keys" {
+				sizes[sid] += int64(n)
+			} else {
+				sizes[sid] += int64(math.Ceil(float64(x.usedMem) * float64(n) / float64(keys)))
+			}
+		}
+	}
+
+	p := &rebalancePlanner{band: opts.Band, weights: weights}
+	if p.band == 0 {
+		p.band = DefaultRebalanceBand
+	}
+	for _, m := range ctx.slots {
+		x := &rebalanceSlot{id: m.Id, owner: m.GroupId, size: sizes[m.Id], movable: true}
+		if m.Action.State != models.ActionNothing {
+			x.owner, x.movable = m.Action.TargetId, false
+		}
+		p.slots = append(p.slots, x)
+	}
+	report := p.plan()
+	report.Mode, report.Unit = opts.Mode, unit
+	if opts.Mode == RebalanceByMaxMemory && unit == "bytes" {
+		for _, g := range report.Groups {
+			g.Utilization = float64(g.ProjectedSize) / float64(g.Weight)
+		}
+	}
+
+	if !confirm {
+		return report, nil
+	}
+	if err := s.createRebalanceActions(ctx, report.Plans); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+type rebalanceSlot struct {
+	id      int
+	owner   int
+	size    int64
+	movable bool
+}
+
+type rebalancePlanner struct {
+	band    float64
+	weights map[int]int64
+	slots   []*rebalanceSlot
+
+	groups []int
+	target map[int]float64
+	size   map[int]int64
+	count  map[int]int
+}
+
+func (p *rebalancePlanner) deviation(gid int) float64 {
+	return float64(p.size[gid]) - p.target[gid]
+}
+
+func (p *rebalancePlanner) move(plans map[int]int, x *rebalanceSlot, dest int) {
+	if x.owner != 0 {
+		p.size[x.owner] -= x.size
+		p.count[x.owner]--
+	}
+	p.size[dest] += x.size
+	p.count[dest]++
+	x.owner, x.movable = dest, false
+	plans[x.id] = dest
+}
+
+//非空的slot给离目标最远的group，空的slot给slot数量相对权重最少的group
+func (p *rebalancePlanner) pickDest(x *rebalanceSlot) int {
+	var dest = -1
+	for _, gid := range p.groups {
+		if p.weights[gid] == 0 {
+			continue
+		}
+		if dest < 0 {
+			dest = gid
+			continue
+		}
+		if x.size != 0 {
+			if p.deviation(gid) < p.deviation(dest) {
+				dest = gid
+			}
+		} else {
+			var a = float64(p.count[gid]) / float64(p.weights[gid])
+			var b = float64(p.count[dest]) / float64(p.weights[dest])
+			if a < b {
+				dest = gid
+			}
+		}
+	}
+	return dest
+}
+
+func (p *rebalancePlanner) plan() *RebalanceReport {
+	var total, weights int64
+	p.size = make(map[int]int64)
+	p.count = make(map[int]int)
+	p.target = make(map[int]float64)
+	for gid, w := range p.weights {
+		p.groups = append(p.groups, gid)
+		weights += w
+	}
+	sort.Ints(p.groups)
+
+	var origin = make(map[int]int)
+	for _, x := range p.slots {
+		total += x.size
+		origin[x.id] = x.owner
+		if _, ok := p.weights[x.owner]; ok {
+			p.size[x.owner] += x.size
+			p.count[x.owner]++
+		} else {
+			x.owner = 0
+		}
+	}
+	for _, gid := range p.groups {
+		p.target[gid] = float64(total) * float64(p.weights[gid]) / float64(weights)
+	}
+	var report = &RebalanceReport{Band: p.band, Plans: make(map[int]int)}
+	for _, gid := range p.groups {
+		report.Groups = append(report.Groups, &RebalanceGroup{
+			Id: gid, Weight: p.weights[gid],
+			Slots: p.count[gid], Size: p.size[gid],
+			TargetSize: int64(p.target[gid]),
+		})
+	}
+
+	//没有分配的slot和权重为0的group中的slot，按照大小从大到小分配
+	var pendings []*rebalanceSlot
+	for _, x := range p.slots {
+		if x.owner == 0 || (x.movable && p.weights[x.owner] == 0) {
+			pendings = append(pendings, x)
+		}
+	}
+	sort.SliceStable(pendings, func(i, j int) bool {
+		return pendings[i].size > pendings[j].size
+	})
+	for _, x := range pendings {
+		if dest := p.pickDest(x); dest >= 0 {
+			p.move(report.Plans, x, dest)
+		}
+	}
+
+	var inBand = func(gid int) bool {
+		return math.Abs(p.deviation(gid)) <= p.target[gid]*p.band
+	}
+	for i := 0; i < len(p.slots) && total != 0; i++ {
+		var from, dest = p.groups[0], p.groups[0]
+		var balanced = true
+		for _, gid := range p.groups {
+			if !inBand(gid) {
+				balanced = false
+			}
+			if p.deviation(gid) > p.deviation(from) {
+				from = gid
+			}
+			if p.deviation(gid) < p.deviation(dest) {
+				dest = gid
+			}
+		}
+		if balanced || from == dest {
+			break
+		}
+		var limit = math.Min(p.deviation(from), -p.deviation(dest))
+
+		//优先选择不会越过目标的最大的slot，没有的话选择最小的slot
+		var best, smallest *rebalanceSlot
+		for _, x := range p.slots {
+			if x.owner != from || !x.movable || x.size == 0 {
+				continue
+			}
+			if float64(x.size) <= limit {
+				if best == nil || x.size > best.size {
+					best = x
+				}
+			} else if smallest == nil || x.size < smallest.size {
+				smallest = x
+			}
+		}
+		if best == nil {
+			best = smallest
+		}
+		if best == nil {
+			break
+		}
+		var before = math.Max(p.deviation(from), -p.deviation(dest))
+		var after = math.Max(
+			math.Abs(p.deviation(from)-float64(best.size)),
+			math.Abs(p.deviation(dest)+float64(best.size)))
+		if after >= before {
+			break
+		}
+		p.move(report.Plans, best, dest)
+	}
+
+	for sid, gid := range report.Plans {
+		if origin[sid] == gid {
+			delete(report.Plans, sid)
+		}
+	}
+	for _, g := range report.Groups {
+		g.ProjectedSlots, g.ProjectedSize = p.count[g.Id], p.size[g.Id]
+		if p.target[g.Id] != 0 {
+			g.Utilization = float64(g.ProjectedSize) / p.target[g.Id]
+		}
+	}
+	for _, x := range p.slots {
+		if _, ok := report.Plans[x.id]; !ok {
+			continue
+		}
+		for _, g := range report.Groups {
+			switch g.Id {
+			case x.owner:
+				g.SlotsIn++
+			case origin[x.id]:
+				g.SlotsOut++
+			}
+		}
+		if origin[x.id] != 0 {
+			report.Moved += x.size
+		}
+	}
+	return report
+}
+
+func (s *Topom) createRebalanceActions(ctx *context, plans map[int]int) error {
+	var slotIds []int
+	for sid := range plans {
+		slotIds = append(slotIds, sid)
+	}
+	sort.Ints(slotIds)
+
+	for _, sid := range slotIds {
+		m, err := ctx.getSlotMapping(sid)
+		if err != nil {
+			return err
+		}
+		if m.Action.State != models.ActionNothing {
+			return errors.Errorf("slot-[%d] action already exists", sid)
+		}
+		defer s.dirtySlotsCache(m.Id)
+
+		m.Action.State = models.ActionPending
+		m.Action.Index = ctx.maxSlotActionIndex() + 1
+		m.Action.TargetId = plans[sid]
+		if err := s.storeUpdateSlotMapping(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"math"
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func newTestPlanner(weights map[int]int64, owner func(sid int) int, size func(sid int) int64) *rebalancePlanner {
+	p := &rebalancePlanner{band: DefaultRebalanceBand, weights: weights}
+	for i := 0; i < MaxSlotNum; i++ {
+		p.slots = append(p.slots, &rebalanceSlot{id: i, owner: owner(i), size: size(i), movable: true})
+	}
+	return p
+}
+
+func checkRebalanceReport(report *RebalanceReport) {
+	var slots int
+	for _, g := range report.Groups {
+		slots += g.ProjectedSlots
+		assert.Must(g.ProjectedSlots == g.Slots+g.SlotsIn-g.SlotsOut)
+		if g.TargetSize != 0 {
+			assert.Must(math.Abs(float64(g.ProjectedSize-g.TargetSize)) <= float64(g.TargetSize)*report.Band)
+		}
+	}
+	assert.Must(slots == MaxSlotNum)
+}
+
+func TestRebalanceOptions(x *testing.T) {
+	assert.Must((&RebalanceOptions{Mode: "slots"}).Validate() != nil)
+	assert.Must((&RebalanceOptions{Mode: RebalanceByCapacity}).Validate() != nil)
+	assert.Must((&RebalanceOptions{Mode: RebalanceByCapacity, Weights: map[int]int64{1: 0}}).Validate() != nil)
+	assert.Must((&RebalanceOptions{Mode: RebalanceByCapacity, Weights: map[int]int64{1: -1, 2: 2}}).Validate() != nil)
+	assert.Must((&RebalanceOptions{Mode: RebalanceByMaxMemory, Band: 1}).Validate() != nil)
+	assert.MustNoError((&RebalanceOptions{Mode: RebalanceByCapacity, Weights: map[int]int64{1: 0, 2: 2}}).Validate())
+	assert.MustNoError((&RebalanceOptions{Mode: RebalanceByMaxMemory, Band: 0.2}).Validate())
+}
+
+func TestRebalanceWeighted(x *testing.T) {
+	//所有的slot都没有分配，也没有数据，按照权重分配slot的数量
+	p := newTestPlanner(map[int]int64{1: 1, 2: 3},
+		func(sid int) int { return 0 },
+		func(sid int) int64 { return 0 })
+	report := p.plan()
+	assert.Must(len(report.Plans) == MaxSlotNum && report.Moved == 0)
+	assert.Must(report.Groups[0].ProjectedSlots == MaxSlotNum/4)
+	assert.Must(report.Groups[1].ProjectedSlots == MaxSlotNum/4*3)
+
+	//group-1的容量是group-2的两倍，所有的数据都在group-2上
+	p = newTestPlanner(map[int]int64{1: 2, 2: 1},
+		func(sid int) int { return 2 },
+		func(sid int) int64 { return int64(sid%8 + 1) })
+	report = p.plan()
+	checkRebalanceReport(report)
+	for sid, gid := range report.Plans {
+		assert.Must(gid == 1 && sid >= 0 && sid < MaxSlotNum)
+	}
+	assert.Must(report.Moved == report.Groups[0].ProjectedSize)
+
+	//已经在范围之内的时候不迁移
+	var before = len(report.Plans)
+	for sid, gid := range report.Plans {
+		p.slots[sid].owner, p.slots[sid].movable = gid, true
+	}
+	for _, x := range p.slots {
+		x.movable = true
+	}
+	p = &rebalancePlanner{band: p.band, weights: p.weights, slots: p.slots}
+	report = p.plan()
+	assert.Must(before != 0 && len(report.Plans) == 0)
+
+	//一个很大的slot，少量的迁移就可以达到平衡
+	p = newTestPlanner(map[int]int64{1: 1, 2: 1},
+		func(sid int) int { return sid%2 + 1 },
+		func(sid int) int64 {
+			if sid == 0 {
+				return 1000
+			}
+			return 1
+		})
+	report = p.plan()
+	checkRebalanceReport(report)
+	assert.Must(report.Groups[0].SlotsOut != 0 && report.Groups[1].SlotsOut == 0)
+	assert.Must(report.Moved == int64(report.Groups[1].SlotsIn))
+	_, ok := report.Plans[0]
+	assert.Must(!ok)
+
+	//权重为0的group中的slot全部迁出，正在迁移的slot不会被移动
+	p = newTestPlanner(map[int]int64{1: 1, 2: 1, 3: 0},
+		func(sid int) int { return sid%3 + 1 },
+		func(sid int) int64 { return 10 })
+	p.slots[2].movable = false
+	report = p.plan()
+	assert.Must(report.Groups[2].ProjectedSlots == 1)
+	_, ok = report.Plans[2]
+	assert.Must(!ok)
+}
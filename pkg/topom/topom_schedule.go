@@ -0,0 +1,218 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+)
+
+//迁移调度的实现：
+//1. migration_windows配置每天允许迁移的时间段，时间段之外不会开始新的slot action，正在迁移的slot停在当前的位置，
+//   进入时间段之后自动继续；
+//2. migration_max_bandwidth是所有并行迁移的slot共享的带宽预算，每次SLOTSMGRTTAGSLOT-ASYNC之前等待预算不为负数，
+//   之后按照迁移的key的数量乘以源redis的平均key大小扣除预算；
+//3. RefreshRedisStats得到的源或者目标redis的延迟、cpu使用率超过阈值的时候同样暂停迁移；
+//4. 暂停的原因、时间段以及带宽的使用情况通过overview中的slot_action.schedule展示。
+
+//没有办法估算key的大小的时候使用的默认值
+const defaultMigrationKeySize = 1024
+
+//一天之中的时间段，单位是分钟，end小于begin的时候表示跨过了零点
+type migrationWindow struct {
+	begin, end int
+}
+
+func (w migrationWindow) contains(t time.Time) bool {
+	var m = t.Hour()*60 + t.Minute()
+	if w.begin < w.end {
+		return m >= w.begin && m < w.end
+	}
+	return m >= w.begin || m < w.end
+}
+
+func parseMinuteOfDay(s string) (int, error) {
+	var p = strings.Split(strings.TrimSpace(s), ":")
+	if len(p) != 2 {
+		return 0, errors.Errorf("invalid time = %s", s)
+	}
+	h, err1 := strconv.Atoi(p[0])
+	m, err2 := strconv.Atoi(p[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m >= 60 || (h == 24 && m != 0) {
+		return 0, errors.Errorf("invalid time = %s", s)
+	}
+	return h*60 + m, nil
+}
+
+//格式为"HH:MM-HH:MM"，多个时间段之间用逗号分隔
+func parseMigrationWindows(s string) ([]migrationWindow, error) {
+	var windows []migrationWindow
+	for _, text := range strings.Split(s, ",") {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		var p = strings.Split(text, "-")
+		if len(p) != 2 {
+			return nil, errors.Errorf("invalid window = %s", text)
+		}
+		begin, err := parseMinuteOfDay(p[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseMinuteOfDay(p[1])
+		if err != nil {
+			return nil, err
+		}
+		if begin%(24*60) == end%(24*60) {
+			return nil, errors.Errorf("invalid window = %s", text)
+		}
+		windows = append(windows, migrationWindow{begin % (24 * 60), end % (24 * 60)})
+	}
+	return windows, nil
+}
+
+func inMigrationWindows(windows []migrationWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+//所有迁移的slot共享的令牌桶，预算可以被透支，透支之后等待补足再继续
+type migrationLimiter struct {
+	mu sync.Mutex
+
+	rate   int64
+	budget int64
+	last   int64
+
+	migrated int64
+}
+
+func newMigrationLimiter(rate int64) *migrationLimiter {
+	return &migrationLimiter{rate: rate, budget: rate}
+}
+
+func (l *migrationLimiter) refill(now int64) {
+	if l.last != 0 && now > l.last {
+		l.budget += (now - l.last) * l.rate / int64(time.Second)
+		if l.budget > l.rate {
+			l.budget = l.rate
+		}
+	}
+	l.last = now
+}
+
+//返回需要等待的时间，预算不为负数的时候返回0
+func (l *migrationLimiter) delay(now int64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	l.refill(now)
+	if l.budget >= 0 {
+		return 0
+	}
+	return time.Duration(-l.budget * int64(time.Second) / l.rate)
+}
+
+func (l *migrationLimiter) Wait() {
+	for {
+		d := l.delay(time.Now().UnixNano())
+		if d == 0 {
+			return
+		}
+		time.Sleep(d)
+	}
+}
+
+func (l *migrationLimiter) Consume(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.migrated += n
+	if l.rate > 0 {
+		l.refill(time.Now().UnixNano())
+		l.budget -= n
+	}
+}
+
+func (l *migrationLimiter) Migrated() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.migrated
+}
+
+//根据used_memory和keyspace中key的数量估算平均每个key的大小
+func (stats *RedisStats) averageKeySize() int64 {
+	if stats == nil || stats.Stats == nil {
+		return defaultMigrationKeySize
+	}
+	usedMem, err := strconv.ParseInt(stats.Stats["used_memory"], 10, 64)
+	if err != nil {
+		return defaultMigrationKeySize
+	}
+	var keys int64
+	for key, value := range stats.Stats {
+		if !strings.HasPrefix(key, "db") {
+			continue
+		}
+		for _, kv := range strings.Split(value, ",") {
+			if strings.HasPrefix(kv, "keys=") {
+				n, _ := strconv.ParseInt(kv[5:], 10, 64)
+				keys += n
+			}
+		}
+	}
+	if keys == 0 || usedMem <= 0 {
+		return defaultMigrationKeySize
+	}
+	return usedMem/keys + 1
+}
+
+//检查时间段以及源和目标redis的状态，返回暂停迁移的原因，可以迁移的时候返回空字符串
+func (s *Topom) checkMigrationSchedule(ctx *context, m *models.SlotMapping, now time.Time) string {
+	if !inMigrationWindows(s.action.windows, now) {
+		return fmt.Sprintf("outside migration windows [%s]", s.config.MigrationWindows)
+	}
+	for _, addr := range []string{ctx.getGroupMaster(m.GroupId), ctx.getGroupMaster(m.Action.TargetId)} {
+		var stats = s.stats.servers[addr]
+		if addr == "" || stats == nil {
+			continue
+		}
+		if limit := s.config.MigrationPauseLatency.Duration(); limit != 0 {
+			if stats.Timeout || time.Duration(stats.Latency)*time.Microsecond > limit {
+				return fmt.Sprintf("server [%s] latency = %dus", addr, stats.Latency)
+			}
+		}
+		if limit := s.config.MigrationPauseCPU; limit != 0 {
+			if stats.CPU > float64(limit) {
+				return fmt.Sprintf("server [%s] cpu = %.2f%%", addr, stats.CPU)
+			}
+		}
+	}
+	return ""
+}
+
+//调用的时候需要持有s.mu，暂停的时候返回true
+func (s *Topom) pauseSlotAction(ctx *context, m *models.SlotMapping) bool {
+	var reason = s.checkMigrationSchedule(ctx, m, time.Now())
+	if last, _ := s.action.paused.Load().(string); last != reason && reason != "" {
+		log.Warnf("slot-[%d] action paused: %s", m.Id, reason)
+	}
+	s.action.paused.Store(reason)
+	return reason != ""
+}
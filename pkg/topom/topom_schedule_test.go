@@ -0,0 +1,108 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestMigrationWindows(x *testing.T) {
+	var at = func(hour, min int) time.Time {
+		return time.Date(2016, 1, 1, hour, min, 0, 0, time.Local)
+	}
+	windows, err := parseMigrationWindows("")
+	assert.MustNoError(err)
+	assert.Must(len(windows) == 0 && inMigrationWindows(windows, at(12, 0)))
+
+	windows, err = parseMigrationWindows(" 01:00-06:00, 22:30-24:00 ")
+	assert.MustNoError(err)
+	assert.Must(len(windows) == 2)
+	assert.Must(inMigrationWindows(windows, at(1, 0)))
+	assert.Must(inMigrationWindows(windows, at(5, 59)))
+	assert.Must(!inMigrationWindows(windows, at(6, 0)))
+	assert.Must(!inMigrationWindows(windows, at(22, 29)))
+	assert.Must(inMigrationWindows(windows, at(23, 59)))
+	assert.Must(!inMigrationWindows(windows, at(0, 0)))
+
+	windows, err = parseMigrationWindows("23:00-02:00")
+	assert.MustNoError(err)
+	assert.Must(inMigrationWindows(windows, at(23, 30)))
+	assert.Must(inMigrationWindows(windows, at(0, 0)))
+	assert.Must(!inMigrationWindows(windows, at(2, 0)))
+
+	for _, s := range []string{"1:00", "01:00-01:00", "00:00-24:00", "25:00-01:00", "01:60-02:00", "a-b", "01:00-02:00-03:00"} {
+		_, err := parseMigrationWindows(s)
+		assert.Must(err != nil)
+	}
+}
+
+func TestMigrationLimiter(x *testing.T) {
+	l := newMigrationLimiter(0)
+	l.Consume(1 << 30)
+	assert.Must(l.delay(time.Now().UnixNano()) == 0 && l.Migrated() == 1<<30)
+
+	var now = time.Now().UnixNano()
+	l = newMigrationLimiter(1000)
+	l.last = now
+	assert.Must(l.delay(now) == 0)
+	l.budget -= 3000
+	assert.Must(l.delay(now) == time.Second*2)
+	assert.Must(l.delay(now+int64(time.Second)) == time.Second)
+	assert.Must(l.delay(now+int64(time.Second*2)) == 0)
+	assert.Must(l.delay(now+int64(time.Second*10)) == 0 && l.budget == 1000)
+}
+
+func TestRedisStatsCPU(x *testing.T) {
+	var now = time.Now().UnixNano()
+	last := &RedisStats{Stats: map[string]string{"used_cpu_sys": "10.00", "used_cpu_user": "20.00"}, unixNano: now}
+	stats := &RedisStats{Stats: map[string]string{"used_cpu_sys": "10.25", "used_cpu_user": "20.50"}, unixNano: now + int64(time.Second)}
+	stats.updateCPU(last)
+	assert.Must(stats.CPU == 75)
+
+	stats = &RedisStats{Timeout: true, unixNano: now + int64(time.Second)}
+	stats.updateCPU(last)
+	assert.Must(stats.CPU == 0)
+
+	stats = &RedisStats{Stats: map[string]string{"used_memory": "1000", "db0": "keys=6,expires=0,avg_ttl=0", "db1": "keys=4,expires=0"}}
+	assert.Must(stats.averageKeySize() == 101)
+	assert.Must((*RedisStats)(nil).averageKeySize() == defaultMigrationKeySize)
+}
+
+func TestMigrationSchedule(x *testing.T) {
+	t := &Topom{config: NewDefaultConfig()}
+	t.config.MigrationPauseLatency.Set(time.Millisecond * 10)
+	t.config.MigrationPauseCPU = 80
+
+	ctx := &context{group: map[int]*models.Group{
+		1: {Id: 1, Servers: []*models.GroupServer{{Addr: "server1"}}},
+		2: {Id: 2, Servers: []*models.GroupServer{{Addr: "server2"}}},
+	}}
+	m := &models.SlotMapping{Id: 0, GroupId: 1}
+	m.Action.TargetId = 2
+
+	var now = time.Date(2016, 1, 1, 12, 0, 0, 0, time.Local)
+	t.stats.servers = map[string]*RedisStats{
+		"server1": {Latency: 1000, CPU: 10},
+		"server2": {Latency: 1000, CPU: 10},
+	}
+	assert.Must(t.checkMigrationSchedule(ctx, m, now) == "")
+
+	t.stats.servers["server2"].CPU = 90
+	assert.Must(t.checkMigrationSchedule(ctx, m, now) != "")
+	t.stats.servers["server2"].CPU = 10
+
+	t.stats.servers["server1"].Latency = 20000
+	assert.Must(t.checkMigrationSchedule(ctx, m, now) != "")
+	t.stats.servers["server1"] = &RedisStats{Timeout: true}
+	assert.Must(t.checkMigrationSchedule(ctx, m, now) != "")
+	t.stats.servers["server1"] = &RedisStats{}
+
+	t.action.windows, _ = parseMigrationWindows("01:00-06:00")
+	assert.Must(t.checkMigrationSchedule(ctx, m, now) != "")
+	assert.Must(t.checkMigrationSchedule(ctx, m, now.Add(-time.Hour*8)) == "")
+}
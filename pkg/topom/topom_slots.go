@@ -185,6 +185,86 @@ func (s *Topom) SlotRemoveAction(sid int) error {
 	return s.storeUpdateSlotMapping(m)
 }
 
+//回滚迁移中的slot：交换源和目标之后按照相反的方向继续迁移，已经迁移的key会被executor和proxy迁回原来的group。
+//和正常的迁移一样，先通过prepared状态锁住所有proxy上的slot，再切换到新的迁移方向，
+//避免有的proxy按照原来的方向而有的proxy按照相反的方向迁移同一个key
+func (s *Topom) SlotRollbackAction(sid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, err := s.newContext()
+	if err != nil {
+		return err
+	}
+
+	m, err := ctx.getSlotMapping(sid)
+	if err != nil {
+		return err
+	}
+	switch m.Action.State {
+	case models.ActionNothing:
+		return errors.Errorf("slot-[%d] action doesn't exist", sid)
+	case models.ActionPending:
+		return errors.Errorf("slot-[%d] action is pending, remove it instead", sid)
+	case models.ActionMigrating, models.ActionVerifyFailed:
+	default:
+		return errors.Errorf("slot-[%d] action isn't migrating", sid)
+	}
+	if m.GroupId == 0 {
+		return errors.Errorf("slot-[%d] is offline before migration, can't rollback", sid)
+	}
+	if ctx.isGroupPromoting(m.GroupId) {
+		return errors.Errorf("slot-[%d] group-[%d] is promoting", sid, m.GroupId)
+	}
+	if ctx.isGroupPromoting(m.Action.TargetId) {
+		return errors.Errorf("slot-[%d] group-[%d] is promoting", sid, m.Action.TargetId)
+	}
+	defer s.dirtySlotsCache(m.Id)
+
+	log.Warnf("slot-[%d] action rollback:\n%s", m.Id, m.Encode())
+
+	var x = &models.SlotMapping{Id: m.Id, GroupId: m.Action.TargetId}
+	x.Action.Index = m.Action.Index
+	x.Action.TargetId = m.GroupId
+
+	log.Warnf("slot-[%d] resync to prepared for rollback", m.Id)
+
+	x.Action.State = models.ActionPrepared
+	if err := s.resyncSlotMappings(ctx, x); err != nil {
+		log.Warnf("slot-[%d] resync-rollback to %s", m.Id, m.Action.State)
+		s.resyncSlotMappings(ctx, m)
+		return err
+	}
+	if err := s.storeUpdateSlotMapping(x); err != nil {
+		log.Warnf("slot-[%d] resync-rollback to %s", m.Id, m.Action.State)
+		s.resyncSlotMappings(ctx, m)
+		return err
+	}
+	s.action.rollbacks[m.Id]++
+	s.action.progress.tracker.Forget(m.Id)
+	s.action.verifier.Forget(m.Id)
+
+	//失败的时候slot停在prepared状态，由ProcessSlotAction继续切换到migrating
+	log.Warnf("slot-[%d] resync to migrating for rollback", m.Id)
+
+	x.Action.State = models.ActionMigrating
+	if err := s.resyncSlotMappings(ctx, x); err != nil {
+		log.Warnf("slot-[%d] resync to migrating for rollback failed", m.Id)
+		return err
+	}
+	if err := s.storeUpdateSlotMapping(x); err != nil {
+		return err
+	}
+	return nil
+}
+
+var errSlotActionRollback = errors.New("slot action has been rolled back")
+
+func (s *Topom) getSlotActionRollbacks(sid int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.action.rollbacks[sid]
+}
+
 func (s *Topom) SlotActionPrepare() (int, bool, error) {
 	return s.SlotActionPrepareFilter(nil, nil)
 }
@@ -215,8 +295,9 @@ func (s *Topom) SlotActionPrepareFilter(accept, update func(m *models.SlotMappin
 	}
 
 	var m = func() *models.SlotMapping {
+		//校验失败的slot需要人工处理，不再调度
 		var picked = minActionIndex(func(m *models.SlotMapping) bool {
-			return m.Action.State != models.ActionPending
+			return m.Action.State != models.ActionPending && m.Action.State != models.ActionVerifyFailed
 		})
 		if picked != nil {
 			return picked
@@ -225,7 +306,7 @@ func (s *Topom) SlotActionPrepareFilter(accept, update func(m *models.SlotMappin
 			return nil
 		}
 		return minActionIndex(func(m *models.SlotMapping) bool {
-			return m.Action.State == models.ActionPending
+			return m.Action.State == models.ActionPending && !s.pauseSlotAction(ctx, m)
 		})
 	}()
 
@@ -305,6 +386,11 @@ func (s *Topom) SlotActionPrepareFilter(accept, update func(m *models.SlotMappin
 }
 
 func (s *Topom) SlotActionComplete(sid int) error {
+	return s.completeSlotAction(sid, -1)
+}
+
+//rollbacks不小于0的时候，如果slot在executor开始之后被回滚过则返回errSlotActionRollback
+func (s *Topom) completeSlotAction(sid int, rollbacks int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	ctx, err := s.newContext()
@@ -316,6 +402,9 @@ func (s *Topom) SlotActionComplete(sid int) error {
 	if err != nil {
 		return err
 	}
+	if rollbacks >= 0 && s.action.rollbacks[sid] != rollbacks {
+		return errSlotActionRollback
+	}
 
 	log.Warnf("slot-[%d] action complete:\n%s", m.Id, m.Encode())
 
@@ -334,6 +423,9 @@ func (s *Topom) SlotActionComplete(sid int) error {
 
 	case models.ActionFinished:
 
+		//finished之后不能再回滚
+		delete(s.action.rollbacks, m.Id)
+
 		log.Warnf("slot-[%d] resync to finished", m.Id)
 
 		if err := s.resyncSlotMappings(ctx, m); err != nil {
@@ -382,9 +474,13 @@ func (s *Topom) newSlotActionExecutor(sid int) (func(db int) (remains int, nextd
 		if ctx.isGroupPromoting(m.Action.TargetId) {
 			return nil, nil
 		}
+		if s.pauseSlotAction(ctx, m) {
+			return nil, nil
+		}
 
 		from := ctx.getGroupMaster(m.GroupId)
 		dest := ctx.getGroupMaster(m.Action.TargetId)
+		size := s.stats.servers[from].averageKeySize()
 
 		s.action.executor.Incr()
 
@@ -402,12 +498,15 @@ func (s *Topom) newSlotActionExecutor(sid int) (func(db int) (remains int, nextd
 			if err := c.Select(db); err != nil {
 				return 0, -1, err
 			}
-			var do func() (int, error)
+			if err := s.recordVerifyBaseline(c, sid, db, dest); err != nil {
+				return 0, -1, err
+			}
+			var do func() (int, int, error)
 
 			method, _ := models.ParseForwardMethod(s.config.MigrationMethod)
 			switch method {
 			case models.ForwardSync:
-				do = func() (int, error) {
+				do = func() (int, int, error) {
 					return c.MigrateSlot(sid, dest)
 				}
 			case models.ForwardSemiAsync:
@@ -418,17 +517,22 @@ func (s *Topom) newSlotActionExecutor(sid int) (func(db int) (remains int, nextd
 					Timeout: math2.MinDuration(time.Second*5,
 						s.config.MigrationTimeout.Duration()),
 				}
-				do = func() (int, error) {
-					return c.MigrateSlotAsync(sid, dest, option)
+				do = func() (int, int, error) {
+					s.action.limiter.Wait()
+					moved, remains, err := c.MigrateSlotAsync(sid, dest, option)
+					s.action.limiter.Consume(int64(moved) * size)
+					return moved, remains, err
 				}
 			default:
 				log.Panicf("unknown forward method %d", int(method))
 			}
 
-			n, err := do()
+			moved, n, err := do()
 			if err != nil {
 				return 0, -1, err
-			} else if n != 0 {
+			}
+			s.action.progress.tracker.Update(sid, db, moved, int64(moved)*size, n, time.Now())
+			if n != 0 {
 				return n, db, nil
 			}
 
@@ -712,25 +816,8 @@ func (s *Topom) SlotsRebalance(confirm bool) (map[int]int, error) {
 	}
 
 	//只有弹窗点击OK，方法才会走到这里。现在开始执行plan中的规划
-	var slotIds []int
-	for sid, _ := range plans {
-		slotIds = append(slotIds, sid)
-	}
-	sort.Ints(slotIds)
-
-	for _, sid := range slotIds {
-		m, err := ctx.getSlotMapping(sid)
-		if err != nil {
-			return nil, err
-		}
-		defer s.dirtySlotsCache(m.Id)
-
-		m.Action.State = models.ActionPending
-		m.Action.Index = ctx.maxSlotActionIndex() + 1
-		m.Action.TargetId = plans[sid]
-		if err := s.storeUpdateSlotMapping(m); err != nil {
-			return nil, err
-		}
+	if err := s.createRebalanceActions(ctx, plans); err != nil {
+		return nil, err
 	}
 	return plans, nil
 }
@@ -424,6 +424,95 @@ func TestSlotActionFinished(x *testing.T) {
 	assert.Must(s.BackendAddr == server2 && s.MigrateFrom == "")
 }
 
+func TestSlotRollbackAction(x *testing.T) {
+	t := openTopom()
+	defer t.Close()
+
+	const gid1, gid2 = 200, 300
+	const server1 = "server1:port"
+	const server2 = "server2:port"
+
+	g1 := &models.Group{Id: gid1}
+	g1.Servers = []*models.GroupServer{
+		&models.GroupServer{Addr: server1},
+	}
+	contextCreateGroup(t, g1)
+	g2 := &models.Group{Id: gid2}
+	g2.Servers = []*models.GroupServer{
+		&models.GroupServer{Addr: server2},
+	}
+	contextCreateGroup(t, g2)
+
+	const sid = 100
+
+	reset := func(state string) {
+		m := &models.SlotMapping{Id: sid, GroupId: gid1}
+		m.Action.Index = 10
+		m.Action.State = state
+		m.Action.TargetId = gid2
+		contextUpdateSlotMapping(t, m)
+	}
+
+	for _, state := range []string{
+		models.ActionPending,
+		models.ActionPreparing,
+		models.ActionPrepared,
+		models.ActionFinished,
+	} {
+		reset(state)
+		assert.Must(t.SlotRollbackAction(sid) != nil)
+	}
+
+	p1, c1 := openProxy()
+	defer c1.Shutdown()
+
+	p2, c2 := openProxy()
+	defer c2.Shutdown()
+
+	contextCreateProxy(t, p1)
+	contextCreateProxy(t, p2)
+
+	for _, state := range []string{
+		models.ActionMigrating,
+		models.ActionVerifyFailed,
+	} {
+		reset(state)
+		var rollbacks = t.getSlotActionRollbacks(sid)
+
+		assert.MustNoError(t.SlotRollbackAction(sid))
+		m1 := getSlotMapping(t, sid)
+		assert.Must(m1.GroupId == gid2 && m1.Action.TargetId == gid1)
+		assert.Must(m1.Action.State == models.ActionMigrating && m1.Action.Index == 10)
+		checkSlots(t, c1)
+		checkSlots(t, c2)
+
+		slots, err := c1.Slots()
+		assert.MustNoError(err)
+		assert.Must(slots[sid].BackendAddr == server1 && slots[sid].MigrateFrom == server2)
+
+		//回滚之前开始的executor不能完成slot的迁移
+		assert.Must(t.completeSlotAction(sid, rollbacks) == errSlotActionRollback)
+
+		m2 := completeSlotAction(t, sid, true)
+		assert.Must(m2.GroupId == gid1 && m2.Action.State == models.ActionNothing)
+		assert.Must(t.getSlotActionRollbacks(sid) == 0)
+	}
+
+	//proxy不可用的时候保持原来的迁移方向
+	assert.MustNoError(c1.Shutdown())
+
+	reset(models.ActionMigrating)
+	assert.Must(t.SlotRollbackAction(sid) != nil)
+	m3 := getSlotMapping(t, sid)
+	assert.Must(m3.GroupId == gid1 && m3.Action.TargetId == gid2)
+	assert.Must(m3.Action.State == models.ActionMigrating)
+
+	slots, err := c2.Slots()
+	assert.MustNoError(err)
+	assert.Must(slots[sid].Locked == false)
+	assert.Must(slots[sid].BackendAddr == server2 && slots[sid].MigrateFrom == server1)
+}
+
 func TestSlotsAssignGroup(x *testing.T) {
 	t := openTopom()
 	defer t.Close()
@@ -4,6 +4,9 @@
 package topom
 
 import (
+	"math"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/thesunnysky/codis/pkg/models"
@@ -20,8 +23,40 @@ type RedisStats struct {
 
 	Sentinel map[string]*redis.SentinelGroup `json:"sentinel,omitempty"`
 
+	//INFO的耗时（微秒），以及两次刷新之间redis的cpu使用率（百分比），用于迁移的自动暂停
+	Latency int64   `json:"latency,omitempty"`
+	CPU     float64 `json:"cpu,omitempty"`
+
 	UnixTime int64 `json:"unixtime"`
 	Timeout  bool  `json:"timeout,omitempty"`
+
+	unixNano int64
+}
+
+//used_cpu_sys和used_cpu_user是redis启动以来累计的cpu时间（秒）
+func (stats *RedisStats) usedCPU() (float64, bool) {
+	if stats.Stats == nil {
+		return 0, false
+	}
+	sys, err1 := strconv.ParseFloat(stats.Stats["used_cpu_sys"], 64)
+	user, err2 := strconv.ParseFloat(stats.Stats["used_cpu_user"], 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	return sys + user, true
+}
+
+func (stats *RedisStats) updateCPU(last *RedisStats) {
+	if last == nil || last.unixNano == 0 || stats.unixNano <= last.unixNano {
+		return
+	}
+	used, ok1 := stats.usedCPU()
+	prev, ok2 := last.usedCPU()
+	if !ok1 || !ok2 || used < prev {
+		return
+	}
+	var seconds = float64(stats.unixNano-last.unixNano) / float64(time.Second)
+	stats.CPU = math.Floor((used-prev)/seconds*10000) / 100
 }
 
 func (s *Topom) newRedisStats(addr string, timeout time.Duration, do func(addr string) (*RedisStats, error)) *RedisStats {
@@ -57,8 +92,12 @@ func (s *Topom) RefreshRedisStats(timeout time.Duration) (*sync2.Future, error)
 	goStats := func(addr string, do func(addr string) (*RedisStats, error)) {
 		fut.Add()
 		go func() {
+			start := time.Now()
 			stats := s.newRedisStats(addr, timeout, do)
-			stats.UnixTime = time.Now().Unix()
+			now := time.Now()
+			stats.Latency = int64(now.Sub(start) / time.Microsecond)
+			stats.UnixTime = now.Unix()
+			stats.unixNano = now.UnixNano()
 			fut.Done(addr, stats)
 		}()
 	}
@@ -99,6 +138,9 @@ func (s *Topom) RefreshRedisStats(timeout time.Duration) (*sync2.Future, error)
 		}
 		s.mu.Lock()
 		defer s.mu.Unlock()
+		for addr, x := range stats {
+			x.updateCPU(s.stats.servers[addr])
+		}
 		s.stats.servers = stats
 	}()
 	return &fut, nil
@@ -118,7 +160,7 @@ func (s *Topom) newProxyStats(p *models.Proxy, timeout time.Duration) *ProxyStat
 
 	go func() {
 		defer close(ch)
-		x, err := s.newProxyClient(p).StatsSimple()
+		x, err := s.newProxyClient(p).Stats(proxy.StatsHotKeys | proxy.StatsCmds)
 		if err != nil {
 			stats.Error = rpc.NewRemoteError(err)
 		} else {
@@ -144,6 +186,11 @@ func (s *Topom) RefreshProxyStats(timeout time.Duration) (*sync2.Future, error)
 	if err != nil {
 		return nil, err
 	}
+	//热点key按照slot当前所属的group汇总
+	var groups = make([]int, len(ctx.slots))
+	for i, m := range ctx.slots {
+		groups[i] = m.GroupId
+	}
 	var fut sync2.Future
 	//由于我们刚才添加了proxy，这里ctx.proxy已经不为空了
 	for _, p := range ctx.proxy {
@@ -177,6 +224,104 @@ func (s *Topom) RefreshProxyStats(timeout time.Duration) (*sync2.Future, error)
 		defer s.mu.Unlock()
 		//Topom的stats结构中的proxies属性，存储了完整的stats信息，回想我们之前介绍的，Topom存储着集群中的所有配置和节点信息
 		s.stats.proxies = stats
+		s.stats.hotkeys = aggregateHotKeys(stats, groups)
+		s.stats.latency = aggregateLatency(stats)
 	}()
 	return &fut, nil
 }
+
+const maxHotKeys = 64
+
+type HotKeyStats struct {
+	Key     string `json:"key"`
+	Slot    int    `json:"slot"`
+	GroupId int    `json:"group_id"`
+	Count   int64  `json:"count"`
+	Proxies int    `json:"proxies"`
+}
+
+//将所有proxy上报的热点key按照key汇总，只保留次数最多的maxHotKeys个
+func aggregateHotKeys(stats map[string]*ProxyStats, groups []int) []*HotKeyStats {
+	var m = make(map[string]*HotKeyStats)
+	for _, x := range stats {
+		if x.Stats == nil {
+			continue
+		}
+		for _, k := range x.Stats.Ops.HotKeys {
+			h := m[k.Key]
+			if h == nil {
+				h = &HotKeyStats{Key: k.Key, Slot: k.Slot, GroupId: k.GroupId}
+				if k.Slot >= 0 && k.Slot < len(groups) {
+					h.GroupId = groups[k.Slot]
+				}
+				m[k.Key] = h
+			}
+			h.Count += k.Count
+			h.Proxies++
+		}
+	}
+	var keys = make([]*HotKeyStats, 0, len(m))
+	for _, h := range m {
+		keys = append(keys, h)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > maxHotKeys {
+		keys = keys[:maxHotKeys]
+	}
+	return keys
+}
+
+type LatencyStats struct {
+	Cmd     map[string]*proxy.Histogram `json:"cmd,omitempty"`
+	Backend map[string]*proxy.Histogram `json:"backend,omitempty"`
+}
+
+//合并所有proxy的直方图计算整个集群的百分位，合并之后去掉各个桶的计数，避免stats过大
+func aggregateLatency(stats map[string]*ProxyStats) *LatencyStats {
+	var cmds = make(map[string][]*proxy.Histogram)
+	var backends = make(map[string][]*proxy.Histogram)
+	for _, x := range stats {
+		if x.Stats == nil {
+			continue
+		}
+		for _, cmd := range x.Stats.Ops.Cmd {
+			if cmd.Latency != nil {
+				cmds[cmd.OpStr] = append(cmds[cmd.OpStr], cmd.Latency)
+			}
+		}
+		for _, backend := range x.Stats.Backend.Latency {
+			backends[backend.Addr] = append(backends[backend.Addr], backend.Latency)
+		}
+	}
+	var latency = &LatencyStats{
+		Cmd:     make(map[string]*proxy.Histogram),
+		Backend: make(map[string]*proxy.Histogram),
+	}
+	for opstr, list := range cmds {
+		latency.Cmd[opstr] = proxy.MergeHistograms(list...)
+		latency.Cmd[opstr].Buckets = nil
+	}
+	for addr, list := range backends {
+		latency.Backend[addr] = proxy.MergeHistograms(list...)
+		latency.Backend[addr].Buckets = nil
+	}
+	for _, x := range stats {
+		if x.Stats == nil {
+			continue
+		}
+		for _, cmd := range x.Stats.Ops.Cmd {
+			if cmd.Latency != nil {
+				cmd.Latency.Buckets = nil
+			}
+		}
+		for _, backend := range x.Stats.Backend.Latency {
+			backend.Latency.Buckets = nil
+		}
+	}
+	return latency
+}
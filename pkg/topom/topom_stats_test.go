@@ -217,3 +217,30 @@ func (s *fakeServer) Serve(c net.Conn) {
 		assert.MustNoError(enc.Encode(resp, true))
 	}
 }
+
+func TestMetrics(x *testing.T) {
+	t := openTopom()
+	defer t.Close()
+
+	p, c := openProxy()
+	defer c.Shutdown()
+
+	contextCreateProxy(t, p)
+	w, err := t.RefreshProxyStats(time.Second * 5)
+	assert.MustNoError(err)
+	w.Wait()
+
+	//stats在Wait返回之后才会被异步地保存到topom中
+	var up = `codis_dashboard_proxy_ops_total{product="` + t.Model().ProductName + `",token="` + p.Token + `"`
+	var b []byte
+	for i := 0; i < 50; i++ {
+		b, err = t.Metrics()
+		assert.MustNoError(err)
+		if strings.Contains(string(b), up) {
+			break
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	assert.Must(strings.Contains(string(b), "# TYPE codis_dashboard_proxy_up gauge\n"))
+	assert.Must(strings.Contains(string(b), up))
+}
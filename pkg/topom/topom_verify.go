@@ -0,0 +1,430 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils/errors"
+	"github.com/thesunnysky/codis/pkg/utils/log"
+	"github.com/thesunnysky/codis/pkg/utils/redis"
+)
+
+//slot迁移校验的实现：
+//1. 开启migration_verify之后，executor第一次处理slot的某个db的时候记录源和目标上这个slot的key的数量之和作为基准，
+//   migration_verify_samples大于0的时候，同时在迁移之前用SLOTSSCAN从源上抽样，记录这些key的DUMP的摘要；
+//2. executor完成之后，在SlotActionComplete之前用SLOTSINFO检查每个db：源上不能还有key，目标上的key的数量
+//   和基准的差距不能超过migration_verify_tolerance；
+//3. 再检查抽样的key：同一个key不能同时存在于源和目标，迁移完成的key在目标上的DUMP的摘要要和迁移之前相同，
+//   不一致或者丢失的key的比例同样不能超过migration_verify_tolerance；
+//   tolerance默认是0，迁移过程中有写入或者过期的key都会导致校验失败，有线上流量的时候需要设置一个大于0的值；
+//4. 校验失败的slot进入verifying-failed状态，路由和迁移中一样，不会再被调度，需要通过SlotActionResume重新迁移
+//   或者跳过校验完成迁移；SlotActionVerify可以在任何时候对迁移中的slot做一次不改变状态的校验（dry-run）。
+
+type SlotVerifyDB struct {
+	DB       int `json:"db"`
+	Source   int `json:"source"`
+	Target   int `json:"target"`
+	Expected int `json:"expected"`
+}
+
+type SlotVerifyReport struct {
+	Id     int    `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+
+	DBs     []*SlotVerifyDB `json:"dbs"`
+	Sampled int             `json:"sampled"`
+
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+func (r *SlotVerifyReport) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+func (r *SlotVerifyReport) mismatch(format string, args ...interface{}) {
+	r.Mismatches = append(r.Mismatches, fmt.Sprintf(format, args...))
+}
+
+type migrationVerifier struct {
+	mu sync.Mutex
+
+	//slot -> db -> 迁移开始的时候源和目标上key的数量之和
+	baseline map[int]map[int]int
+	//slot -> db -> 迁移开始之前源上抽样的key的DUMP的摘要
+	samples map[int]map[int]map[string]string
+	//下一次校验的时候跳过的slot
+	skip map[int]bool
+}
+
+func newMigrationVerifier() *migrationVerifier {
+	return &migrationVerifier{
+		baseline: make(map[int]map[int]int),
+		samples:  make(map[int]map[int]map[string]string),
+		skip:     make(map[int]bool),
+	}
+}
+
+func (v *migrationVerifier) Baseline(sid, db int) (int, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	n, ok := v.baseline[sid][db]
+	return n, ok
+}
+
+func (v *migrationVerifier) SetBaseline(sid, db, n int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.baseline[sid] == nil {
+		v.baseline[sid] = make(map[int]int)
+	}
+	if _, ok := v.baseline[sid][db]; !ok {
+		v.baseline[sid][db] = n
+	}
+}
+
+//返回记录了基准的db
+func (v *migrationVerifier) Databases(sid int) []int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var dbs []int
+	for db := range v.baseline[sid] {
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+func (v *migrationVerifier) Samples(sid, db int) map[string]string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.samples[sid][db]
+}
+
+func (v *migrationVerifier) SetSamples(sid, db int, samples map[string]string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.samples[sid] == nil {
+		v.samples[sid] = make(map[int]map[string]string)
+	}
+	if _, ok := v.samples[sid][db]; !ok {
+		v.samples[sid][db] = samples
+	}
+}
+
+func (v *migrationVerifier) SetSkip(sid int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.skip[sid] = true
+}
+
+//返回是否跳过校验，同时清除跳过的标记
+func (v *migrationVerifier) TakeSkip(sid int) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var skip = v.skip[sid]
+	delete(v.skip, sid)
+	return skip
+}
+
+func (v *migrationVerifier) Forget(sid int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.baseline, sid)
+	delete(v.samples, sid)
+	delete(v.skip, sid)
+}
+
+//在executor中调用，c是已经select到db的源redis的连接
+func (s *Topom) recordVerifyBaseline(c *redis.Client, sid, db int, dest string) error {
+	if !s.config.MigrationVerify || dest == "" {
+		return nil
+	}
+	if _, ok := s.action.verifier.Baseline(sid, db); ok {
+		return nil
+	}
+	n, err := c.SlotSize(sid)
+	if err != nil {
+		return err
+	}
+	t, err := s.action.redisp.GetClient(dest)
+	if err != nil {
+		return err
+	}
+	defer s.action.redisp.PutClient(t)
+	if err := t.Select(db); err != nil {
+		return err
+	}
+	m, err := t.SlotSize(sid)
+	if err != nil {
+		return err
+	}
+	samples, err := sampleDigests(c, sid, s.config.MigrationVerifySamples)
+	if err != nil {
+		return err
+	}
+	s.action.verifier.SetSamples(sid, db, samples)
+	s.action.verifier.SetBaseline(sid, db, n+m)
+	return nil
+}
+
+//从源上抽样最多samples个key，返回key -> DUMP的摘要
+func sampleDigests(c *redis.Client, sid int, samples int) (map[string]string, error) {
+	if samples <= 0 {
+		return nil, nil
+	}
+	var digests = make(map[string]string)
+	for cursor := 0; len(digests) < samples; {
+		next, keys, err := c.SlotScan(sid, cursor, samples)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if len(digests) >= samples {
+				break
+			}
+			b, err := c.Dump(key)
+			if err != nil {
+				return nil, err
+			}
+			if b != nil {
+				digests[key] = digest(b)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	return digests, nil
+}
+
+func digest(b []byte) string {
+	return fmt.Sprintf("%x", sha1.Sum(b))
+}
+
+func keyDatabases(c *redis.Client) (map[int]bool, error) {
+	m, err := c.InfoKeySpace()
+	if err != nil {
+		return nil, err
+	}
+	var dbs = make(map[int]bool)
+	for db := range m {
+		dbs[db] = true
+	}
+	return dbs, nil
+}
+
+func (s *Topom) verifySlot(sid int, from, dest string, tolerance float64) (*SlotVerifyReport, error) {
+	var report = &SlotVerifyReport{Id: sid, Source: from, Target: dest}
+
+	src, err := s.action.redisp.GetClient(from)
+	if err != nil {
+		return nil, err
+	}
+	defer s.action.redisp.PutClient(src)
+	dst, err := s.action.redisp.GetClient(dest)
+	if err != nil {
+		return nil, err
+	}
+	defer s.action.redisp.PutClient(dst)
+
+	dbs, err := keyDatabases(src)
+	if err != nil {
+		return nil, err
+	}
+	more, err := keyDatabases(dst)
+	if err != nil {
+		return nil, err
+	}
+	for db := range more {
+		dbs[db] = true
+	}
+	for _, db := range s.action.verifier.Databases(sid) {
+		dbs[db] = true
+	}
+	var list []int
+	for db := range dbs {
+		list = append(list, db)
+	}
+	sort.Ints(list)
+
+	for _, db := range list {
+		if err := src.Select(db); err != nil {
+			return nil, err
+		}
+		if err := dst.Select(db); err != nil {
+			return nil, err
+		}
+		x := &SlotVerifyDB{DB: db, Expected: -1}
+		if x.Source, err = src.SlotSize(sid); err != nil {
+			return nil, err
+		}
+		if x.Target, err = dst.SlotSize(sid); err != nil {
+			return nil, err
+		}
+		if n, ok := s.action.verifier.Baseline(sid, db); ok {
+			x.Expected = n
+		}
+		if x.Source == 0 && x.Target == 0 && x.Expected <= 0 {
+			continue
+		}
+		report.DBs = append(report.DBs, x)
+
+		if x.Source != 0 {
+			report.mismatch("db-[%d] source has %d keys left", db, x.Source)
+		}
+		if x.Expected >= 0 {
+			var diff = math.Abs(float64(x.Target - x.Expected))
+			if diff > float64(x.Expected)*tolerance {
+				report.mismatch("db-[%d] target has %d keys, expected %d", db, x.Target, x.Expected)
+			}
+		}
+		var samples = s.action.verifier.Samples(sid, db)
+		if len(samples) == 0 {
+			continue
+		}
+		var keys []string
+		for key := range samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var failed []string
+		for _, key := range keys {
+			b1, err := dst.Dump(key)
+			if err != nil {
+				return nil, err
+			}
+			b2, err := src.Dump(key)
+			if err != nil {
+				return nil, err
+			}
+			report.Sampled++
+			switch {
+			case b1 != nil && b2 != nil:
+				failed = append(failed, fmt.Sprintf("db-[%d] key %q exists on both source and target", db, key))
+			case b2 != nil:
+				//还没有迁移的key
+			case b1 == nil:
+				failed = append(failed, fmt.Sprintf("db-[%d] key %q is lost, source = %s", db, key, samples[key]))
+			case digest(b1) != samples[key]:
+				failed = append(failed, fmt.Sprintf("db-[%d] key %q differs, target = %s, source = %s", db, key, digest(b1), samples[key]))
+			}
+		}
+		if float64(len(failed)) > float64(len(keys))*tolerance {
+			report.Mismatches = append(report.Mismatches, failed...)
+		}
+	}
+	return report, nil
+}
+
+//不改变slot状态的校验，可以用于迁移中或者校验失败的slot
+func (s *Topom) SlotActionVerify(sid int) (*SlotVerifyReport, error) {
+	s.mu.Lock()
+	ctx, err := s.newContext()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	m, err := ctx.getSlotMapping(sid)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	var from = ctx.getGroupMaster(m.GroupId)
+	var dest = ctx.getGroupMaster(m.Action.TargetId)
+	s.mu.Unlock()
+
+	switch m.Action.State {
+	case models.ActionMigrating, models.ActionVerifyFailed:
+	default:
+		return nil, errors.Errorf("slot-[%d] action isn't migrating", sid)
+	}
+	if dest == "" {
+		return nil, errors.Errorf("slot-[%d] target group master doesn't exist", sid)
+	}
+	//没有分配的slot不需要迁移数据
+	if from == "" {
+		return &SlotVerifyReport{Id: sid, Target: dest}, nil
+	}
+	return s.verifySlot(sid, from, dest, s.config.MigrationVerifyTolerance)
+}
+
+//executor完成之后调用，校验失败的时候slot进入verifying-failed状态并返回错误
+func (s *Topom) verifySlotAction(sid int, rollbacks int) error {
+	if !s.config.MigrationVerify {
+		return nil
+	}
+	if s.action.verifier.TakeSkip(sid) {
+		log.Warnf("slot-[%d] verification skipped", sid)
+		return nil
+	}
+	report, err := s.SlotActionVerify(sid)
+	if err != nil {
+		return err
+	}
+	if report.OK() {
+		log.Warnf("slot-[%d] verification passed, sampled = %d", sid, report.Sampled)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, err := s.newContext()
+	if err != nil {
+		return err
+	}
+	m, err := ctx.getSlotMapping(sid)
+	if err != nil {
+		return err
+	}
+	if s.action.rollbacks[sid] != rollbacks {
+		return errSlotActionRollback
+	}
+	if m.Action.State != models.ActionMigrating {
+		return errors.Errorf("slot-[%d] action isn't migrating", sid)
+	}
+	defer s.dirtySlotsCache(m.Id)
+
+	m.Action.State = models.ActionVerifyFailed
+	if err := s.storeUpdateSlotMapping(m); err != nil {
+		return err
+	}
+	log.Warnf("slot-[%d] verification failed:\n%s", sid, strings.Join(report.Mismatches, "\n"))
+	return errors.Errorf("slot-[%d] verification failed, %s", sid, report.Mismatches[0])
+}
+
+//重新迁移校验失败的slot，force的时候下一次跳过校验
+func (s *Topom) SlotActionResume(sid int, force bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, err := s.newContext()
+	if err != nil {
+		return err
+	}
+	m, err := ctx.getSlotMapping(sid)
+	if err != nil {
+		return err
+	}
+	if m.Action.State != models.ActionVerifyFailed {
+		return errors.Errorf("slot-[%d] action isn't %s", sid, models.ActionVerifyFailed)
+	}
+	defer s.dirtySlotsCache(m.Id)
+
+	m.Action.State = models.ActionMigrating
+	if err := s.storeUpdateSlotMapping(m); err != nil {
+		return err
+	}
+	if force {
+		s.action.verifier.SetSkip(sid)
+	}
+	log.Warnf("slot-[%d] action resumed, force = %t", sid, force)
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package topom
+
+import (
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/models"
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestMigrationVerifier(x *testing.T) {
+	v := newMigrationVerifier()
+	_, ok := v.Baseline(1, 0)
+	assert.Must(!ok)
+	v.SetBaseline(1, 0, 100)
+	v.SetBaseline(1, 0, 10)
+	n, ok := v.Baseline(1, 0)
+	assert.Must(ok && n == 100)
+
+	assert.Must(len(v.Databases(1)) == 1 && v.Samples(1, 0) == nil)
+	v.SetSamples(1, 0, map[string]string{"key": "digest"})
+	v.SetSamples(1, 0, nil)
+	assert.Must(v.Samples(1, 0)["key"] == "digest")
+
+	v.SetSkip(1)
+	assert.Must(v.TakeSkip(1) && !v.TakeSkip(1))
+
+	v.Forget(1)
+	_, ok = v.Baseline(1, 0)
+	assert.Must(!ok && v.Samples(1, 0) == nil)
+}
+
+func TestSlotActionVerifyFailed(x *testing.T) {
+	t := openTopom()
+	defer t.Close()
+
+	const sid = 100
+	const gid = 200
+
+	g := &models.Group{Id: gid, Servers: []*models.GroupServer{{Addr: "server"}}}
+	contextUpdateGroup(t, g)
+
+	m := &models.SlotMapping{Id: sid}
+	m.Action.Index = 1
+	m.Action.State = models.ActionVerifyFailed
+	m.Action.TargetId = gid
+	contextUpdateSlotMapping(t, m)
+
+	//校验失败的slot和迁移中的slot的路由相同，不会再被调度
+	ctx, err := t.newContext()
+	assert.MustNoError(err)
+	slot := ctx.toSlot(getSlotMapping(t, sid), nil)
+	assert.Must(slot.BackendAddr == "server" && slot.BackendAddrGroupId == gid)
+
+	_, ok, err := t.SlotActionPrepare()
+	assert.MustNoError(err)
+	assert.Must(!ok)
+
+	//源group没有master，没有需要校验的数据
+	report, err := t.SlotActionVerify(sid)
+	assert.MustNoError(err)
+	assert.Must(report.OK() && len(report.DBs) == 0)
+
+	assert.Must(t.SlotRemoveAction(sid) != nil)
+	assert.MustNoError(t.SlotActionResume(sid, true))
+	assert.Must(t.SlotActionResume(sid, true) != nil)
+	assert.Must(getSlotMapping(t, sid).Action.State == models.ActionMigrating)
+	assert.Must(t.action.verifier.TakeSkip(sid))
+
+	sid2, ok, err := t.SlotActionPrepare()
+	assert.MustNoError(err)
+	assert.Must(ok && sid2 == sid)
+}
@@ -172,6 +172,28 @@ func (b *Reader) ReadFull(n int) ([]byte, error) {
 	return buf, nil
 }
 
+//跳过n个字节，不需要分配内存
+func (b *Reader) Discard(n int) (int, error) {
+	var discarded int
+	for discarded < n {
+		if b.err != nil {
+			return discarded, b.err
+		}
+		if b.buffered() == 0 {
+			if b.fill() != nil {
+				return discarded, b.err
+			}
+		}
+		skip := n - discarded
+		if skip > b.buffered() {
+			skip = b.buffered()
+		}
+		b.rpos += skip
+		discarded += skip
+	}
+	return discarded, nil
+}
+
 type Writer struct {
 	err error
 	buf []byte
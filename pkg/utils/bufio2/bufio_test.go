@@ -81,6 +81,21 @@ func TestReadFull(t *testing.T) {
 	}
 }
 
+func TestDiscard(t *testing.T) {
+	var input = "hello world, hello codis"
+	for n := 1; n < len(input); n++ {
+		r := newReader(n, input)
+		c, err := r.Discard(13)
+		assert.MustNoError(err)
+		assert.Must(c == 13)
+		b, err := r.ReadFull(len(input) - 13)
+		assert.MustNoError(err)
+		assert.Must(string(b) == "hello codis")
+		_, err = r.Discard(1)
+		assert.Must(err == io.EOF)
+	}
+}
+
 func newWriter(n int, b *bytes.Buffer) *Writer {
 	return &Writer{wr: b, buf: make([]byte, n)}
 }
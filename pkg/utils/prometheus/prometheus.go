@@ -0,0 +1,136 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package prometheus
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/thesunnysky/codis/pkg/utils/log"
+)
+
+//按照prometheus的text格式（0.0.4）输出metrics，同一个metric的所有sample必须连续输出，
+//所以先按照名字分组，最后再统一编码
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type Writer struct {
+	families []*Family
+	index    map[string]*Family
+}
+
+type Family struct {
+	name string
+	help string
+	kind string
+
+	samples []sample
+}
+
+type sample struct {
+	suffix string
+	labels []string
+	value  float64
+}
+
+func NewWriter() *Writer {
+	return &Writer{index: make(map[string]*Family)}
+}
+
+func (w *Writer) family(name, kind, help string) *Family {
+	if f := w.index[name]; f != nil {
+		return f
+	}
+	f := &Family{name: name, kind: kind, help: help}
+	w.families = append(w.families, f)
+	w.index[name] = f
+	return f
+}
+
+func (w *Writer) Gauge(name, help string) *Family {
+	return w.family(name, "gauge", help)
+}
+
+func (w *Writer) Counter(name, help string) *Family {
+	return w.family(name, "counter", help)
+}
+
+func (w *Writer) Summary(name, help string) *Family {
+	return w.family(name, "summary", help)
+}
+
+//labels是key、value交替的列表
+func (f *Family) Add(value float64, labels ...string) {
+	f.AddSuffix("", value, labels...)
+}
+
+//summary的_sum、_count使用suffix
+func (f *Family) AddSuffix(suffix string, value float64, labels ...string) {
+	if len(labels)%2 != 0 {
+		log.Panicf("invalid labels of metric %s: %v", f.name, labels)
+	}
+	f.samples = append(f.samples, sample{suffix: suffix, labels: labels, value: value})
+}
+
+func (w *Writer) Bytes() []byte {
+	var b bytes.Buffer
+	for _, f := range w.families {
+		if len(f.samples) == 0 {
+			continue
+		}
+		if f.help != "" {
+			b.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		}
+		b.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+		for _, s := range f.samples {
+			b.WriteString(f.name + s.suffix)
+			if len(s.labels) != 0 {
+				b.WriteByte('{')
+				for i := 0; i < len(s.labels); i += 2 {
+					if i != 0 {
+						b.WriteByte(',')
+					}
+					b.WriteString(s.labels[i] + "=\"" + escapeLabel(s.labels[i+1]) + "\"")
+				}
+				b.WriteByte('}')
+			}
+			b.WriteString(" " + formatValue(s.value) + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+var (
+	helpReplacer  = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+	labelReplacer = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"")
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 CodisLabs. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package prometheus
+
+import (
+	"math"
+	"testing"
+
+	"github.com/thesunnysky/codis/pkg/utils/assert"
+)
+
+func TestWriter(t *testing.T) {
+	w := NewWriter()
+	w.Counter("codis_ops_total", "Total ops.").Add(10, "product", "demo")
+	w.Gauge("codis_up", "").Add(1)
+	w.Gauge("codis_empty", "No samples.")
+	w.Counter("codis_ops_total", "").Add(20, "product", "x\"y\\z\n")
+	s := w.Summary("codis_latency", "Latency.")
+	s.Add(5, "quantile", "0.5")
+	s.AddSuffix("_count", 3)
+	w.Gauge("codis_inf", "").Add(math.Inf(1))
+
+	var expect = "# HELP codis_ops_total Total ops.\n" +
+		"# TYPE codis_ops_total counter\n" +
+		"codis_ops_total{product=\"demo\"} 10\n" +
+		"codis_ops_total{product=\"x\\\"y\\\\z\\n\"} 20\n" +
+		"# TYPE codis_up gauge\n" +
+		"codis_up 1\n" +
+		"# HELP codis_latency Latency.\n" +
+		"# TYPE codis_latency summary\n" +
+		"codis_latency{quantile=\"0.5\"} 5\n" +
+		"codis_latency_count 3\n" +
+		"# TYPE codis_inf gauge\n" +
+		"codis_inf +Inf\n"
+	assert.Must(string(w.Bytes()) == expect)
+}
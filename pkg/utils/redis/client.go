@@ -230,20 +230,21 @@ func (c *Client) SetMaster(master string) error {
 	return nil
 }
 
-func (c *Client) MigrateSlot(slot int, target string) (int, error) {
+//返回本次迁移的key的数量以及slot中剩余的key的数量
+func (c *Client) MigrateSlot(slot int, target string) (int, int, error) {
 	host, port, err := net.SplitHostPort(target)
 	if err != nil {
-		return 0, errors.Trace(err)
+		return 0, 0, errors.Trace(err)
 	}
 	mseconds := int(c.Timeout / time.Millisecond)
 	if reply, err := c.Do("SLOTSMGRTTAGSLOT", host, port, mseconds, slot); err != nil {
-		return 0, errors.Trace(err)
+		return 0, 0, errors.Trace(err)
 	} else {
 		p, err := redigo.Ints(redigo.Values(reply, nil))
 		if err != nil || len(p) != 2 {
-			return 0, errors.Errorf("invalid response = %v", reply)
+			return 0, 0, errors.Errorf("invalid response = %v", reply)
 		}
-		return p[1], nil
+		return p[0], p[1], nil
 	}
 }
 
@@ -254,20 +255,20 @@ type MigrateSlotAsyncOption struct {
 	Timeout  time.Duration
 }
 
-func (c *Client) MigrateSlotAsync(slot int, target string, option *MigrateSlotAsyncOption) (int, error) {
+func (c *Client) MigrateSlotAsync(slot int, target string, option *MigrateSlotAsyncOption) (int, int, error) {
 	host, port, err := net.SplitHostPort(target)
 	if err != nil {
-		return 0, errors.Trace(err)
+		return 0, 0, errors.Trace(err)
 	}
 	if reply, err := c.Do("SLOTSMGRTTAGSLOT-ASYNC", host, port, int(option.Timeout/time.Millisecond),
 		option.MaxBulks, option.MaxBytes, slot, option.NumKeys); err != nil {
-		return 0, errors.Trace(err)
+		return 0, 0, errors.Trace(err)
 	} else {
 		p, err := redigo.Ints(redigo.Values(reply, nil))
 		if err != nil || len(p) != 2 {
-			return 0, errors.Errorf("invalid response = %v", reply)
+			return 0, 0, errors.Errorf("invalid response = %v", reply)
 		}
-		return p[1], nil
+		return p[0], p[1], nil
 	}
 }
 
@@ -292,6 +293,61 @@ func (c *Client) SlotsInfo() (map[int]int, error) {
 	}
 }
 
+//返回当前db中slot的key的数量
+func (c *Client) SlotSize(slot int) (int, error) {
+	if reply, err := c.Do("SLOTSINFO", slot, 1); err != nil {
+		return 0, errors.Trace(err)
+	} else {
+		infos, err := redigo.Values(reply, nil)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		for i, info := range infos {
+			p, err := redigo.Ints(info, nil)
+			if err != nil || len(p) != 2 {
+				return 0, errors.Errorf("invalid response[%d] = %v", i, info)
+			}
+			if p[0] == slot {
+				return p[1], nil
+			}
+		}
+		return 0, nil
+	}
+}
+
+//SLOTSSCAN slot cursor COUNT count，返回下一个cursor和这次扫描到的key
+func (c *Client) SlotScan(slot int, cursor int, count int) (int, []string, error) {
+	if reply, err := c.Do("SLOTSSCAN", slot, cursor, "COUNT", count); err != nil {
+		return 0, nil, errors.Trace(err)
+	} else {
+		p, err := redigo.Values(reply, nil)
+		if err != nil || len(p) != 2 {
+			return 0, nil, errors.Errorf("invalid response = %v", reply)
+		}
+		next, err := redigo.Int(p[0], nil)
+		if err != nil {
+			return 0, nil, errors.Errorf("invalid response = %v", reply)
+		}
+		keys, err := redigo.Strings(p[1], nil)
+		if err != nil {
+			return 0, nil, errors.Errorf("invalid response = %v", reply)
+		}
+		return next, keys, nil
+	}
+}
+
+//DUMP的结果，key不存在的时候返回nil
+func (c *Client) Dump(key string) ([]byte, error) {
+	b, err := redigo.Bytes(c.Do("DUMP", key))
+	if err == redigo.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return b, nil
+}
+
 func (c *Client) Role() (string, error) {
 	if reply, err := c.Do("ROLE"); err != nil {
 		return "", err